@@ -0,0 +1,88 @@
+package apierror
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/middleware"
+)
+
+const defaultDocsURL = "https://github.com/vaidikcode/minipay/blob/main/docs/errors.md"
+
+type Error struct {
+	Type      string `json:"type"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Param     string `json:"param,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	DocURL    string `json:"doc_url"`
+
+	Status int `json:"-"`
+}
+
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func New(code, message string) *Error {
+	def, ok := catalog[code]
+	if !ok {
+		def = catalog[CodeInternal]
+	}
+	return &Error{
+		Type:    def.Type,
+		Code:    code,
+		Message: message,
+		DocURL:  DocURL(code),
+		Status:  def.Status,
+	}
+}
+
+func (e *Error) WithParam(param string) *Error {
+	e.Param = param
+	return e
+}
+
+func (e *Error) WithStatus(status int) *Error {
+	e.Status = status
+	return e
+}
+
+func Missing(param string) *Error {
+	return New(CodeParameterMissing, "Missing required param: "+param+".").WithParam(param)
+}
+
+func Invalid(param, message string) *Error {
+	return New(CodeParameterInvalid, message).WithParam(param)
+}
+
+func NotFound(resource, param, id string) *Error {
+	return New(CodeResourceMissing, "No such "+resource+": '"+id+"'").WithParam(param)
+}
+
+func Internal(message string) *Error {
+	return New(CodeInternal, message)
+}
+
+func Respond(c *gin.Context, err *Error) {
+	err.RequestID = middleware.GetRequestID(c)
+	status := err.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	c.AbortWithStatusJSON(status, Envelope{Error: err})
+}
+
+// DocURL links to the section of docs/errors.md with the anchor code.
+func DocURL(code string) string {
+	base := os.Getenv("ERROR_DOCS_URL")
+	if base == "" {
+		base = defaultDocsURL
+	}
+	return base + "#" + code
+}
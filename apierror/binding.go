@@ -0,0 +1,127 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, tag := range []string{"json", "form"} {
+				name := strings.SplitN(f.Tag.Get(tag), ",", 2)[0]
+				if name == "-" {
+					return ""
+				}
+				if name != "" {
+					return name
+				}
+			}
+			return f.Name
+		})
+	}
+}
+
+// FromBinding converts an error returned by gin's ShouldBind* helpers into an
+// API error naming the offending parameter.
+func FromBinding(err error) *Error {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) && len(verrs) > 0 {
+		return fromFieldError(verrs[0])
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		param := paramName(typeErr.Field)
+		return Invalid(param, fmt.Sprintf("Invalid %s for %s: expected %s.", typeErr.Value, param, typeErr.Type.Kind()))
+	}
+
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return New(CodeParameterInvalid, fmt.Sprintf("Invalid number: %q.", numErr.Num))
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return New(CodeInvalidJSON, fmt.Sprintf("Invalid JSON in request body at offset %d.", syntaxErr.Offset))
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return New(CodeInvalidJSON, "Request body is empty or truncated.")
+	}
+
+	return New(CodeParameterInvalid, "Invalid request parameters.")
+}
+
+func fromFieldError(fe validator.FieldError) *Error {
+	// Namespace is "ChargeRequest.card.number". Go identifiers (the request
+	// struct and any embedded structs) are not part of the API param name.
+	var path []string
+	for _, part := range strings.Split(fe.Namespace(), ".") {
+		if part != "" && (part[0] < 'A' || part[0] > 'Z') {
+			path = append(path, part)
+		}
+	}
+	param := paramName(strings.Join(path, "."))
+
+	if fe.Tag() == "required" {
+		return Missing(param)
+	}
+	return Invalid(param, fmt.Sprintf("Invalid %s: %s.", param, describeTag(fe)))
+}
+
+// paramName renders a dotted path such as "card.number" in the bracketed form
+// used by the API, "card[number]".
+func paramName(path string) string {
+	parts := strings.Split(path, ".")
+	name := parts[0]
+	for _, p := range parts[1:] {
+		name += "[" + p + "]"
+	}
+	return name
+}
+
+func describeTag(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	case "lte":
+		return "must be at most " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		return "must be at most " + fe.Param()
+	case "len":
+		return "must be exactly " + fe.Param() + " characters"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "numeric":
+		return "must contain only digits"
+	case "alpha":
+		return "must contain only letters"
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	default:
+		return "failed " + fe.Tag() + " validation"
+	}
+}
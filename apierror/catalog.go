@@ -0,0 +1,80 @@
+package apierror
+
+import "net/http"
+
+const (
+	TypeInvalidRequest = "invalid_request_error"
+	TypeAPI            = "api_error"
+	TypeCard           = "card_error"
+	TypeIdempotency    = "idempotency_error"
+)
+
+const (
+	CodeParameterMissing      = "parameter_missing"
+	CodeParameterInvalid      = "parameter_invalid"
+	CodeInvalidJSON           = "invalid_json"
+	CodeResourceMissing       = "resource_missing"
+	CodeResourceExists        = "resource_already_exists"
+	CodeURLInvalid            = "url_invalid"
+	CodeChargeAlreadyRefunded = "charge_already_refunded"
+	CodeChargeNotRefundable   = "charge_not_refundable"
+	CodeChargeDisputed        = "charge_disputed"
+	CodeDisputeClosed         = "dispute_closed"
+	CodeBalanceInsufficient   = "balance_insufficient"
+	CodePayoutNotCancelable   = "payout_not_cancelable"
+	CodeReserveReleased       = "reserve_released"
+	CodePaymentFailed         = "payment_failed"
+	CodeSubscriptionEnded     = "subscription_ended"
+	CodeInvoiceNotEditable    = "invoice_not_editable"
+	CodeInvoiceNotOpen        = "invoice_not_open"
+	CodeCouponNotRedeemable   = "coupon_not_redeemable"
+	CodeFXRateUnavailable     = "fx_rate_unavailable"
+	CodeWalletLimitExceeded   = "wallet_limit_exceeded"
+	CodeReviewClosed          = "review_closed"
+	CodeVelocityLimitExceeded = "velocity_limit_exceeded"
+	CodeAuthenticationDone    = "authentication_completed"
+	CodeIdempotencyConflict   = "idempotency_key_in_use"
+	CodeInternal              = "internal_error"
+)
+
+type definition struct {
+	Type   string
+	Status int
+}
+
+var catalog = map[string]definition{
+	CodeParameterMissing:      {TypeInvalidRequest, http.StatusBadRequest},
+	CodeParameterInvalid:      {TypeInvalidRequest, http.StatusBadRequest},
+	CodeInvalidJSON:           {TypeInvalidRequest, http.StatusBadRequest},
+	CodeResourceMissing:       {TypeInvalidRequest, http.StatusNotFound},
+	CodeResourceExists:        {TypeInvalidRequest, http.StatusConflict},
+	CodeURLInvalid:            {TypeInvalidRequest, http.StatusNotFound},
+	CodeChargeAlreadyRefunded: {TypeInvalidRequest, http.StatusConflict},
+	CodeChargeNotRefundable:   {TypeInvalidRequest, http.StatusConflict},
+	CodeChargeDisputed:        {TypeInvalidRequest, http.StatusConflict},
+	CodeDisputeClosed:         {TypeInvalidRequest, http.StatusConflict},
+	CodeBalanceInsufficient:   {TypeInvalidRequest, http.StatusPaymentRequired},
+	CodePayoutNotCancelable:   {TypeInvalidRequest, http.StatusConflict},
+	CodeReserveReleased:       {TypeInvalidRequest, http.StatusConflict},
+	CodePaymentFailed:         {TypeCard, http.StatusPaymentRequired},
+	CodeSubscriptionEnded:     {TypeInvalidRequest, http.StatusConflict},
+	CodeInvoiceNotEditable:    {TypeInvalidRequest, http.StatusConflict},
+	CodeInvoiceNotOpen:        {TypeInvalidRequest, http.StatusConflict},
+	CodeCouponNotRedeemable:   {TypeInvalidRequest, http.StatusBadRequest},
+	CodeFXRateUnavailable:     {TypeInvalidRequest, http.StatusBadRequest},
+	CodeWalletLimitExceeded:   {TypeInvalidRequest, http.StatusBadRequest},
+	CodeReviewClosed:          {TypeInvalidRequest, http.StatusConflict},
+	CodeVelocityLimitExceeded: {TypeInvalidRequest, http.StatusTooManyRequests},
+	CodeAuthenticationDone:    {TypeInvalidRequest, http.StatusConflict},
+	CodeIdempotencyConflict:   {TypeIdempotency, http.StatusConflict},
+	CodeInternal:              {TypeAPI, http.StatusInternalServerError},
+}
+
+// Codes returns every code in the catalog.
+func Codes() []string {
+	codes := make([]string, 0, len(catalog))
+	for code := range catalog {
+		codes = append(codes, code)
+	}
+	return codes
+}
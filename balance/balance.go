@@ -0,0 +1,455 @@
+// Package balance records balance transactions: the merchant-facing history
+// of every movement of funds, each backed by a ledger journal.
+package balance
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+)
+
+const (
+	TypeCharge           = "charge"
+	TypeRefund           = "refund"
+	TypeFee              = "fee"
+	TypePayout           = "payout"
+	TypePayoutCancel     = "payout_cancel"
+	TypePayoutFailure    = "payout_failure"
+	TypeDispute          = "dispute"
+	TypeDisputeReversal  = "dispute_reversal"
+	TypeAdjustment       = "adjustment"
+	TypeReserveHold      = "reserve_hold"
+	TypeReserveRelease   = "reserve_release"
+	TypeDiscount         = "discount"
+	TypeWalletPayment    = "wallet_payment"
+	TypeTransfer         = "transfer"
+	TypeTransferReversal = "transfer_reversal"
+)
+
+// A balance transaction is pending until its funds can be paid out.
+const (
+	StatusPending   = "pending"
+	StatusAvailable = "available"
+)
+
+var Types = []string{
+	TypeCharge, TypeRefund, TypeFee, TypePayout, TypePayoutCancel,
+	TypePayoutFailure, TypeDispute, TypeDisputeReversal, TypeAdjustment,
+	TypeReserveHold, TypeReserveRelease, TypeDiscount, TypeWalletPayment,
+	TypeTransfer, TypeTransferReversal,
+}
+
+func ValidType(typ string) bool {
+	for _, t := range Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordCharge books a succeeded charge: the processor owes the gross, the
+// merchant is owed the net and MiniPay keeps the fee. The net stays pending
+// until availableOn.
+//
+// A discounted charge is booked at its list price, with the discount waived
+// by the discounts account, followed by a discount balance transaction that
+// takes the discount back off the merchant.
+//
+// A converted charge is booked in the merchant's settlement currency: the
+// processor owes the presentment amount, which fx_conversion buys for the
+// settlement amount plus the FX markup.
+func RecordCharge(tx *gorm.DB, txn *models.Transaction, fee pricing.Fee, availableOn time.Time) (*models.BalanceTransaction, error) {
+	currency, amount, discount := txn.Currency, txn.Amount, txn.DiscountAmount
+	lines := []ledger.Line{{Account: ledger.AccountProcessorClearing, Currency: currency, Amount: -amount}}
+	if fx.Converted(*txn) {
+		currency, amount = txn.SettlementCurrency, txn.SettlementAmount
+		if discount > 0 {
+			discount = fx.Convert(txn.Amount+txn.DiscountAmount, txn.ExchangeRate, txn.Currency, currency) - amount
+		}
+		lines = conversionLines(*txn, -txn.Amount, -amount, -(amount + txn.FXMarkup), ledger.AccountFXRevenue)
+	}
+
+	bt := &models.BalanceTransaction{
+		MerchantID:  txn.MerchantID,
+		Type:        TypeCharge,
+		SourceID:    txn.ID,
+		Amount:      amount + discount,
+		Fee:         fee.Amount,
+		Currency:    currency,
+		FeeDetails:  fee.Details,
+		Description: "Charge " + txn.ID,
+		AvailableOn: availableOn,
+	}
+	lines = append(lines,
+		ledger.Line{Account: ledger.AccountDiscounts, Currency: currency, Amount: -discount},
+		ledger.Line{Account: ledger.AccountFeeRevenue, Currency: currency, Amount: bt.Fee},
+	)
+	if err := recordLines(tx, bt, "charge", "charge succeeded", lines); err != nil {
+		return nil, err
+	}
+	if discount == 0 {
+		return bt, nil
+	}
+
+	description := "Discount on " + txn.ID + " (coupon " + txn.CouponID + ")"
+	d := &models.BalanceTransaction{
+		MerchantID:  txn.MerchantID,
+		Type:        TypeDiscount,
+		SourceID:    txn.ID,
+		Amount:      -discount,
+		Currency:    currency,
+		Description: description,
+		AvailableOn: availableOn,
+	}
+	if err := record(tx, d, "discount", description, ledger.AccountDiscounts, ""); err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
+// RecordRefund books a refund of txn. Amount is negative; a negative fee is
+// a charge fee handed back to the merchant. The merchant of a converted
+// charge gives back what the refunded amount settled for; what buying the
+// presentment currency back costs today makes an FX gain or loss.
+func RecordRefund(tx *gorm.DB, refund *models.Refund, txn *models.Transaction, fee pricing.Fee) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID:  txn.MerchantID,
+		Type:        TypeRefund,
+		SourceID:    refund.ID,
+		Amount:      -refund.Amount,
+		Fee:         fee.Amount,
+		Currency:    refund.Currency,
+		FeeDetails:  fee.Details,
+		Description: "Refund of " + refund.TransactionID,
+	}
+	if !fx.Converted(*txn) {
+		return bt, record(tx, bt, "refund", "refund of "+refund.TransactionID, ledger.AccountProcessorClearing, ledger.AccountFeeRevenue)
+	}
+
+	bt.Currency = txn.SettlementCurrency
+	bt.Amount = -fx.Settled(*txn, refund.Amount)
+	lines, err := reconversionLines(tx, *txn, refund.Amount, -bt.Amount)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, ledger.Line{Account: ledger.AccountFeeRevenue, Currency: bt.Currency, Amount: bt.Fee})
+	return bt, recordLines(tx, bt, "refund", "refund of "+refund.TransactionID, lines)
+}
+
+// RecordPayout books funds leaving the balance for a payout, or coming back
+// to it when typ is TypePayoutCancel or TypePayoutFailure.
+func RecordPayout(tx *gorm.DB, p *models.Payout, merchantID, typ string) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID: merchantID,
+		Type:       typ,
+		SourceID:   p.ID,
+		Amount:     p.Amount,
+		Currency:   p.Currency,
+	}
+	switch typ {
+	case TypePayout:
+		bt.Amount = -p.Amount
+		bt.Description = "Payout " + p.ID
+	case TypePayoutCancel:
+		bt.Description = "Payout " + p.ID + " canceled"
+	default:
+		bt.Description = "Payout " + p.ID + " failed"
+	}
+	return bt, record(tx, bt, "payout", bt.Description, ledger.AccountPayoutsInTransit, "")
+}
+
+// RecordDispute books the disputed amount of txn leaving the balance, or
+// returning to it when reversal is set because the dispute was won. Disputes
+// of converted charges are booked like refunds.
+func RecordDispute(tx *gorm.DB, d *models.Dispute, txn *models.Transaction, reversal bool) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID:  txn.MerchantID,
+		Type:        TypeDispute,
+		SourceID:    d.ID,
+		Amount:      -d.Amount,
+		Currency:    d.Currency,
+		Description: "Dispute of " + d.TransactionID,
+	}
+	presented := d.Amount
+	if reversal {
+		bt.Type = TypeDisputeReversal
+		bt.Amount = d.Amount
+		bt.Description = "Dispute of " + d.TransactionID + " won"
+		presented = -d.Amount
+	}
+	if !fx.Converted(*txn) {
+		return bt, record(tx, bt, "dispute", bt.Description, ledger.AccountProcessorClearing, "")
+	}
+
+	bt.Currency = txn.SettlementCurrency
+	bt.Amount = -fx.Settled(*txn, presented)
+	lines, err := reconversionLines(tx, *txn, presented, -bt.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return bt, recordLines(tx, bt, "dispute", bt.Description, lines)
+}
+
+// RecordFee books a fee charged on its own rather than on a payment, such as
+// a dispute fee. account collects it.
+func RecordFee(tx *gorm.DB, merchantID, sourceType, sourceID, currency string, amount int64, account, description string) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID:  merchantID,
+		Type:        TypeFee,
+		SourceID:    sourceID,
+		Amount:      -amount,
+		Currency:    currency,
+		Description: description,
+	}
+	return bt, record(tx, bt, sourceType, description, account, "")
+}
+
+// RecordAdjustment books a manual correction of the balance. A positive
+// amount credits the merchant.
+func RecordAdjustment(tx *gorm.DB, merchantID, currency string, amount int64, description string) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		ID:          "bt_" + uuid.NewString(),
+		MerchantID:  merchantID,
+		Type:        TypeAdjustment,
+		Amount:      amount,
+		Currency:    currency,
+		Description: description,
+	}
+	bt.SourceID = bt.ID
+	return bt, record(tx, bt, "adjustment", description, ledger.AccountAdjustments, "")
+}
+
+// RecordWalletPayment books a customer paying the merchant from a wallet. The
+// funds come straight out of the wallet's account and are available at once.
+func RecordWalletPayment(tx *gorm.DB, merchantID string, wt *models.WalletTransaction) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID:  merchantID,
+		Type:        TypeWalletPayment,
+		SourceID:    wt.ID,
+		Amount:      -wt.Amount,
+		Currency:    wt.Currency,
+		Description: "Wallet payment " + wt.ID,
+	}
+	return bt, record(tx, bt, "wallet", bt.Description, ledger.WalletAccount(wt.WalletID), "")
+}
+
+// RecordTransfer books t moving funds from the platform to the connected
+// account, which has them when the charge's funds become available.
+func RecordTransfer(tx *gorm.DB, t *models.Transfer, availableOn time.Time) (*models.BalanceTransaction, error) {
+	description := "Transfer " + t.ID + " to " + t.DestinationID
+	out := &models.BalanceTransaction{
+		MerchantID:  t.PlatformID,
+		Type:        TypeTransfer,
+		SourceID:    t.ID,
+		Amount:      -t.Amount,
+		Currency:    t.Currency,
+		Description: description,
+		AvailableOn: availableOn,
+	}
+	if err := record(tx, out, "transfer", description, ledger.AccountTransfers, ""); err != nil {
+		return nil, err
+	}
+	in := &models.BalanceTransaction{
+		MerchantID:  t.DestinationID,
+		Type:        TypeTransfer,
+		SourceID:    t.ID,
+		Amount:      t.Amount,
+		Currency:    t.Currency,
+		Description: "Transfer " + t.ID + " from " + t.PlatformID,
+		AvailableOn: availableOn,
+	}
+	return in, record(tx, in, "transfer", description, ledger.AccountTransfers, "")
+}
+
+// RecordTransferReversal books r taking funds of t back from the connected
+// account to the platform.
+func RecordTransferReversal(tx *gorm.DB, t *models.Transfer, r *models.TransferReversal) (*models.BalanceTransaction, error) {
+	description := "Transfer " + t.ID + " reversed"
+	out := &models.BalanceTransaction{
+		MerchantID:  t.DestinationID,
+		Type:        TypeTransferReversal,
+		SourceID:    r.ID,
+		Amount:      -r.Amount,
+		Currency:    r.Currency,
+		Description: description,
+	}
+	if err := record(tx, out, "transfer_reversal", description, ledger.AccountTransfers, ""); err != nil {
+		return nil, err
+	}
+	in := &models.BalanceTransaction{
+		MerchantID:  t.PlatformID,
+		Type:        TypeTransferReversal,
+		SourceID:    r.ID,
+		Amount:      r.Amount,
+		Currency:    r.Currency,
+		Description: description,
+	}
+	return out, record(tx, in, "transfer_reversal", description, ledger.AccountTransfers, "")
+}
+
+// RecordReserve books funds moving into the reserved balance, or back out of
+// it when release is set.
+func RecordReserve(tx *gorm.DB, r *models.Reserve, release bool) (*models.BalanceTransaction, error) {
+	bt := &models.BalanceTransaction{
+		MerchantID:  r.MerchantID,
+		Type:        TypeReserveHold,
+		SourceID:    r.ID,
+		Amount:      -r.Amount,
+		Currency:    r.Currency,
+		Description: "Reserve " + r.ID + " held",
+	}
+	if release {
+		bt.Type = TypeReserveRelease
+		bt.Amount = r.Amount
+		bt.Description = "Reserve " + r.ID + " released"
+	}
+	reserved, err := Account(tx, r.MerchantID, ledger.AccountMerchantReserved)
+	if err != nil {
+		return nil, err
+	}
+	return bt, record(tx, bt, "reserve", bt.Description, reserved, "")
+}
+
+// Account is the ledger account holding merchantID's share of account, one
+// of the merchant accounts. Connected accounts have their own; the platform's
+// is account itself. Only connected accounts can be created besides the
+// platform, so no two merchants share one.
+func Account(db *gorm.DB, merchantID, account string) (string, error) {
+	var m models.Merchant
+	if err := db.Select("platform_id").Where("id = ?", merchantID).Limit(1).Find(&m).Error; err != nil {
+		return "", err
+	}
+	if m.PlatformID == "" {
+		return account, nil
+	}
+	return ledger.ConnectedAccount(account, merchantID), nil
+}
+
+// record stores bt and posts its journal: the net moves between the merchant
+// balance and counter, and any fee goes to feeAccount. A bt that becomes
+// available in the future credits the pending balance instead.
+func record(tx *gorm.DB, bt *models.BalanceTransaction, sourceType, description, counter, feeAccount string) error {
+	lines := []ledger.Line{{Account: counter, Currency: bt.Currency, Amount: -bt.Amount}}
+	if bt.Fee != 0 {
+		lines = append(lines, ledger.Line{Account: feeAccount, Currency: bt.Currency, Amount: bt.Fee})
+	}
+	return recordLines(tx, bt, sourceType, description, lines)
+}
+
+// recordLines stores bt and posts lines together with the merchant's net.
+func recordLines(tx *gorm.DB, bt *models.BalanceTransaction, sourceType, description string, lines []ledger.Line) error {
+	if bt.ID == "" {
+		bt.ID = "bt_" + uuid.NewString()
+	}
+	now := time.Now()
+	bt.Net = bt.Amount - bt.Fee
+	bt.Status = StatusAvailable
+	merchantAccount := ledger.AccountMerchantAvailable
+	if bt.AvailableOn.After(now) {
+		bt.Status = StatusPending
+		merchantAccount = ledger.AccountMerchantPending
+	} else {
+		bt.AvailableOn = now
+	}
+	merchantAccount, err := Account(tx, bt.MerchantID, merchantAccount)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(bt).Error; err != nil {
+		return err
+	}
+
+	lines = append(lines, ledger.Line{Account: merchantAccount, Currency: bt.Currency, Amount: bt.Net})
+	_, err = ledger.Post(tx, ledger.Journal{
+		SourceType:  sourceType,
+		SourceID:    bt.SourceID,
+		Description: description,
+		Lines:       lines,
+	})
+	return err
+}
+
+// conversionLines move presented, an amount in txn's presentment currency,
+// between the processor and fx_conversion, and value, its worth in the
+// settlement currency, between fx_conversion and the merchant's counter
+// amount. The difference between value and counter goes to diffAccount.
+func conversionLines(txn models.Transaction, presented, counter, value int64, diffAccount string) []ledger.Line {
+	return []ledger.Line{
+		{Account: ledger.AccountProcessorClearing, Currency: txn.Currency, Amount: presented},
+		{Account: ledger.AccountFXConversion, Currency: txn.Currency, Amount: -presented},
+		{Account: ledger.AccountFXConversion, Currency: txn.SettlementCurrency, Amount: value},
+		{Account: diffAccount, Currency: txn.SettlementCurrency, Amount: counter - value},
+	}
+}
+
+// reconversionLines convert presented back at today's mid-market rate, the
+// difference from what the merchant is debited or credited being an FX gain
+// or loss.
+func reconversionLines(tx *gorm.DB, txn models.Transaction, presented, counter int64) ([]ledger.Line, error) {
+	mid, err := fx.Rate(tx, txn.Currency, txn.SettlementCurrency)
+	if err != nil {
+		return nil, err
+	}
+	return conversionLines(txn, presented, counter, fx.Convert(presented, mid, txn.Currency, txn.SettlementCurrency), ledger.AccountFXGainsLosses), nil
+}
+
+// ReleaseHook runs in the same database transaction as the release of bt,
+// once its funds are available.
+type ReleaseHook func(tx *gorm.DB, bt models.BalanceTransaction) error
+
+// Release makes the funds of every pending balance transaction due by now
+// available. It returns the number of balance transactions released.
+func Release(db *gorm.DB, now time.Time, hooks ...ReleaseHook) (int, error) {
+	var due []models.BalanceTransaction
+	err := db.Where("status = ? AND available_on <= ?", StatusPending, now).
+		Order("available_on").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, bt := range due {
+		moved := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&models.BalanceTransaction{}).
+				Where("id = ? AND status = ?", bt.ID, StatusPending).
+				Update("status", StatusAvailable)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			moved = true
+			pending, err := Account(tx, bt.MerchantID, ledger.AccountMerchantPending)
+			if err != nil {
+				return err
+			}
+			available, err := Account(tx, bt.MerchantID, ledger.AccountMerchantAvailable)
+			if err != nil {
+				return err
+			}
+			_, err = ledger.Transfer(tx, "balance_transaction", bt.ID, "funds available", pending, available, bt.Currency, bt.Net)
+			if err != nil {
+				return err
+			}
+			for _, hook := range hooks {
+				if err := hook(tx, bt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return released, err
+		}
+		if moved {
+			released++
+		}
+	}
+	return released, nil
+}
@@ -0,0 +1,162 @@
+// Package billing runs recurring billing: it prices subscriptions, charges
+// renewals when their period ends, prorates plan changes and retries failed
+// renewals on the dunning schedule.
+package billing
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	SchemeFlat    = "flat"
+	SchemePerUnit = "per_unit"
+)
+
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+const (
+	StatusTrialing = "trialing"
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusUnpaid   = "unpaid"
+)
+
+// Dunning defaults: retry one, three and five days after the first failure,
+// then cancel.
+const (
+	DefaultRetryDays   = "1,3,5"
+	DefaultFinalAction = StatusCanceled
+)
+
+var (
+	ErrNoCard            = errors.New("billing: customer has no card on file")
+	ErrPaymentFailed     = errors.New("billing: payment failed")
+	ErrEnded             = errors.New("billing: subscription has ended")
+	ErrIncompatiblePrice = errors.New("billing: price has a different currency or interval")
+)
+
+// Amount is what one period of price costs for quantity.
+func Amount(price models.Price, quantity int64) int64 {
+	if price.BillingScheme == SchemePerUnit {
+		return price.UnitAmount * quantity
+	}
+	return price.UnitAmount
+}
+
+// AddInterval moves t forward by count intervals. Months and years keep the
+// day of the month, falling back to the last day of shorter months.
+func AddInterval(t time.Time, interval string, count int) time.Time {
+	switch interval {
+	case IntervalDay:
+		return t.AddDate(0, 0, count)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7*count)
+	case IntervalYear:
+		count *= 12
+	}
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	target := first.AddDate(0, count, 0)
+	day := t.Day()
+	if last := target.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return target.AddDate(0, 0, day-1)
+}
+
+func ValidInterval(interval string) bool {
+	switch interval {
+	case IntervalDay, IntervalWeek, IntervalMonth, IntervalYear:
+		return true
+	}
+	return false
+}
+
+// Ended reports whether s will never be billed again.
+func Ended(s models.Subscription) bool {
+	return s.Status == StatusCanceled || s.Status == StatusUnpaid
+}
+
+func Payload(s models.Subscription) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":                   s.ID,
+		"customer":             s.CustomerID,
+		"price":                s.PriceID,
+		"quantity":             s.Quantity,
+		"status":               s.Status,
+		"current_period_start": s.CurrentPeriodStart.Format(time.RFC3339),
+		"current_period_end":   s.CurrentPeriodEnd.Format(time.RFC3339),
+		"cancel_at_period_end": s.CancelAtPeriodEnd,
+	}
+	if s.LatestTransactionID != "" {
+		payload["latest_transaction"] = s.LatestTransactionID
+	}
+	if s.AmountDue > 0 {
+		payload["amount_due"] = s.AmountDue
+	}
+	if s.CouponID != "" {
+		payload["coupon"] = s.CouponID
+	}
+	return payload
+}
+
+// Dunning returns the dunning settings, creating the defaults on first use.
+func Dunning(db *gorm.DB) (*models.DunningSettings, error) {
+	d := models.DunningSettings{ID: 1}
+	err := db.Attrs(models.DunningSettings{RetryDays: DefaultRetryDays, FinalAction: DefaultFinalAction}).
+		FirstOrCreate(&d, models.DunningSettings{ID: 1}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RetryDays parses the comma separated retry schedule of d.
+func RetryDays(d models.DunningSettings) []int {
+	var days []int
+	for _, s := range strings.Split(d.RetryDays, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n > 0 {
+			days = append(days, n)
+		}
+	}
+	return days
+}
+
+// FormatRetryDays is the stored form of days, which must be increasing.
+func FormatRetryDays(days []int) string {
+	parts := make([]string, len(days))
+	for i, d := range days {
+		parts[i] = strconv.Itoa(d)
+	}
+	return strings.Join(parts, ",")
+}
+
+// prorate is the share of amount left between now and the end of the
+// period, rounded half up.
+func prorate(amount int64, start, end, now time.Time) int64 {
+	total := int64(end.Sub(start) / time.Second)
+	left := int64(end.Sub(now) / time.Second)
+	if total <= 0 || left <= 0 {
+		return 0
+	}
+	if left > total {
+		left = total
+	}
+	share := amount * left
+	if share < 0 {
+		return -((-share + total/2) / total)
+	}
+	return (share + total/2) / total
+}
@@ -0,0 +1,431 @@
+package billing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+)
+
+// periodEnd is the first period boundary after from, counted from the
+// subscription's billing anchor.
+func periodEnd(s models.Subscription, price models.Price, from time.Time) time.Time {
+	for n := 1; ; n++ {
+		end := AddInterval(s.BillingAnchor, price.Interval, n*price.IntervalCount)
+		if end.After(from) {
+			return end
+		}
+	}
+}
+
+// Create subscribes cust to price, redeeming discount when there is one.
+// Unless there is a trial, the first period is charged straight away; when
+// that payment fails nothing is created and ErrPaymentFailed is returned with
+// the failed transaction. trialDays overrides the price's trial when set.
+func Create(db *gorm.DB, cust models.Customer, price models.Price, quantity int64, trialDays *int, d *discounts.Discount, merchantID string, now time.Time) (*models.Subscription, *models.Transaction, error) {
+	trial := price.TrialDays
+	if trialDays != nil {
+		trial = *trialDays
+	}
+	s := &models.Subscription{
+		ID:                 "sub_" + uuid.NewString(),
+		CustomerID:         cust.ID,
+		PriceID:            price.ID,
+		MerchantID:         merchantID,
+		Quantity:           quantity,
+		BillingAnchor:      now,
+		CurrentPeriodStart: now,
+	}
+
+	if trial > 0 {
+		trialEnd := now.AddDate(0, 0, trial)
+		s.TrialEnd = &trialEnd
+		s.BillingAnchor = trialEnd
+	}
+	if d != nil {
+		if err := discounts.Redeem(db, d); err != nil {
+			return nil, nil, err
+		}
+		// The discount runs from the first paid period.
+		s.CouponID = d.Coupon.ID
+		s.PromotionCodeID = d.PromotionCodeID()
+		s.DiscountEnd = discountEnd(*s, price, d.Coupon)
+	}
+
+	var txn *models.Transaction
+	if trial > 0 {
+		s.Status = StatusTrialing
+		s.CurrentPeriodEnd = *s.TrialEnd
+	} else {
+		base := Amount(price, quantity)
+		off, err := discount(db, *s, base, now)
+		if err == nil {
+			txn, err = charge(db, s, cust, price.Currency, base, off)
+		}
+		if err != nil {
+			if d != nil {
+				discounts.Unredeem(db, d)
+			}
+			return nil, txn, err
+		}
+		s.Status = StatusActive
+		s.CurrentPeriodEnd = periodEnd(*s, price, now)
+		if txn != nil {
+			s.LatestTransactionID = txn.ID
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		if err := events.Enqueue(tx, s.ID, "subscription.created", Payload(*s)); err != nil {
+			return err
+		}
+		if txn != nil {
+			return events.Enqueue(tx, s.ID, "subscription.payment_succeeded", Payload(*s))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, txn, err
+	}
+	return s, txn, nil
+}
+
+// discountEnd is when coupon stops applying to s: after its first paid
+// period for once, after DurationInMonths for repeating and never (nil) for
+// forever.
+func discountEnd(s models.Subscription, price models.Price, coupon models.Coupon) *time.Time {
+	var end time.Time
+	switch coupon.Duration {
+	case discounts.DurationOnce:
+		end = periodEnd(s, price, s.BillingAnchor)
+	case discounts.DurationRepeating:
+		end = AddInterval(s.BillingAnchor, IntervalMonth, coupon.DurationInMonths)
+	default:
+		return nil
+	}
+	return &end
+}
+
+// discount is what the coupon of s takes off amount for the period starting
+// at periodStart, or nil when s has no coupon or it has run out.
+func discount(db *gorm.DB, s models.Subscription, amount int64, periodStart time.Time) (*discounts.Applied, error) {
+	if s.CouponID == "" || (s.DiscountEnd != nil && !periodStart.Before(*s.DiscountEnd)) {
+		return nil, nil
+	}
+	var coupon models.Coupon
+	if err := db.First(&coupon, "id = ?", s.CouponID).Error; err != nil {
+		return nil, err
+	}
+	return &discounts.Applied{CouponID: s.CouponID, PromotionCodeID: s.PromotionCodeID, Amount: discounts.Off(coupon, amount)}, nil
+}
+
+// charge takes amount, less off when there is one, for s from the
+// customer's card on file. Nothing is charged when the discount covers it
+// all. A declined payment returns ErrPaymentFailed along with the
+// transaction.
+func charge(db *gorm.DB, s *models.Subscription, cust models.Customer, currency string, amount int64, off *discounts.Applied) (*models.Transaction, error) {
+	if off != nil && off.Amount >= amount {
+		return nil, nil
+	}
+	if cust.CardToken == "" {
+		return nil, ErrNoCard
+	}
+	if off != nil && off.Amount == 0 {
+		off = nil
+	}
+	txn, err := payments.Charge(db, payments.Params{
+		Amount:     amount,
+		Currency:   currency,
+		Customer:   cust.ID,
+		MerchantID: s.MerchantID,
+		Card:       payments.CardOnFile(cust),
+		Metadata:   models.Metadata{"subscription": s.ID},
+		Discount:   off,
+		OffSession: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if txn.Status != "succeeded" {
+		return txn, ErrPaymentFailed
+	}
+	return txn, nil
+}
+
+// Renew bills every subscription whose period has ended by now and retries
+// the past_due ones that are due. It returns the number of subscriptions
+// billed.
+func Renew(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.Subscription
+	err := db.Where("(status IN ? AND current_period_end <= ?) OR (status = ? AND next_attempt_at <= ?)",
+		[]string{StatusTrialing, StatusActive}, now, StatusPastDue, now).
+		Order("current_period_end").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	dunning, err := Dunning(db)
+	if err != nil {
+		return 0, err
+	}
+	billed := 0
+	for i := range due {
+		s := &due[i]
+		if s.Status == StatusPastDue {
+			err = retry(db, s, *dunning, now)
+		} else {
+			err = renew(db, s, *dunning, now)
+		}
+		if err != nil {
+			return billed, err
+		}
+		billed++
+	}
+	return billed, nil
+}
+
+func renew(db *gorm.DB, s *models.Subscription, dunning models.DunningSettings, now time.Time) error {
+	if s.CancelAtPeriodEnd {
+		return db.Transaction(func(tx *gorm.DB) error {
+			return cancel(tx, s, s.CurrentPeriodEnd)
+		})
+	}
+
+	var price models.Price
+	if err := db.First(&price, "id = ?", s.PriceID).Error; err != nil {
+		return err
+	}
+	var cust models.Customer
+	if err := db.First(&cust, "id = ?", s.CustomerID).Error; err != nil {
+		return err
+	}
+
+	wasTrial := s.Status == StatusTrialing
+	from := s.CurrentPeriodEnd
+	updates := map[string]interface{}{
+		"current_period_start": from,
+		"current_period_end":   periodEnd(*s, price, from),
+		"proration_amount":     int64(0),
+	}
+
+	base := Amount(price, s.Quantity)
+	off, err := discount(db, *s, base, from)
+	if err != nil {
+		return err
+	}
+	if off != nil {
+		base -= off.Amount
+	}
+
+	// A credit larger than the period carries over to the next one.
+	amount := base + s.ProrationAmount
+	if amount < 0 {
+		updates["proration_amount"] = amount
+		amount = 0
+	}
+
+	var txn *models.Transaction
+	if amount > 0 {
+		// The discount is itemized on top of what is left to charge.
+		list := amount
+		if off != nil {
+			list += off.Amount
+		}
+		txn, err = charge(db, s, cust, price.Currency, list, off)
+		if err != nil && !errors.Is(err, ErrPaymentFailed) && !errors.Is(err, ErrNoCard) {
+			return err
+		}
+	}
+	if txn != nil {
+		updates["latest_transaction_id"] = txn.ID
+	}
+
+	failed := err != nil
+	if failed {
+		updates["status"] = StatusPastDue
+		updates["amount_due"] = amount
+		updates["attempts"] = 1
+	} else {
+		updates["status"] = StatusActive
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := update(tx, s, updates); err != nil {
+			return err
+		}
+		if wasTrial {
+			if err := events.Enqueue(tx, s.ID, "subscription.trial_ended", Payload(*s)); err != nil {
+				return err
+			}
+		}
+		if failed {
+			return paymentFailed(tx, s, dunning, now)
+		}
+		if err := events.Enqueue(tx, s.ID, "subscription.renewed", Payload(*s)); err != nil {
+			return err
+		}
+		if txn != nil {
+			return events.Enqueue(tx, s.ID, "subscription.payment_succeeded", Payload(*s))
+		}
+		return nil
+	})
+}
+
+// retry charges what a past_due subscription owes.
+func retry(db *gorm.DB, s *models.Subscription, dunning models.DunningSettings, now time.Time) error {
+	var price models.Price
+	if err := db.First(&price, "id = ?", s.PriceID).Error; err != nil {
+		return err
+	}
+	var cust models.Customer
+	if err := db.First(&cust, "id = ?", s.CustomerID).Error; err != nil {
+		return err
+	}
+
+	// The discount on the period still applies to what it owes.
+	off, err := discount(db, *s, Amount(price, s.Quantity), s.CurrentPeriodStart)
+	if err != nil {
+		return err
+	}
+	amount := s.AmountDue
+	if off != nil {
+		amount += off.Amount
+	}
+	txn, err := charge(db, s, cust, price.Currency, amount, off)
+	if err != nil && !errors.Is(err, ErrPaymentFailed) && !errors.Is(err, ErrNoCard) {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{}
+		if txn != nil {
+			updates["latest_transaction_id"] = txn.ID
+		}
+		if err != nil {
+			updates["attempts"] = s.Attempts + 1
+			if err := update(tx, s, updates); err != nil {
+				return err
+			}
+			return paymentFailed(tx, s, dunning, now)
+		}
+
+		updates["status"] = StatusActive
+		updates["amount_due"] = int64(0)
+		updates["attempts"] = 0
+		updates["next_attempt_at"] = nil
+		if err := update(tx, s, updates); err != nil {
+			return err
+		}
+		if err := events.Enqueue(tx, s.ID, "subscription.payment_succeeded", Payload(*s)); err != nil {
+			return err
+		}
+		return events.Enqueue(tx, s.ID, "subscription.updated", Payload(*s))
+	})
+}
+
+// paymentFailed schedules the next retry of s, whose Attempts payments have
+// failed, or applies the dunning final action once retries run out.
+func paymentFailed(tx *gorm.DB, s *models.Subscription, dunning models.DunningSettings, now time.Time) error {
+	if err := events.Enqueue(tx, s.ID, "subscription.payment_failed", Payload(*s)); err != nil {
+		return err
+	}
+
+	days := RetryDays(dunning)
+	if s.Attempts > len(days) {
+		if dunning.FinalAction == StatusCanceled {
+			return cancel(tx, s, now)
+		}
+		if err := update(tx, s, map[string]interface{}{"status": StatusUnpaid, "next_attempt_at": nil}); err != nil {
+			return err
+		}
+		return events.Enqueue(tx, s.ID, "subscription.updated", Payload(*s))
+	}
+
+	// Retry days count from the first failure.
+	wait := days[s.Attempts-1]
+	if s.Attempts > 1 {
+		wait -= days[s.Attempts-2]
+	}
+	if err := update(tx, s, map[string]interface{}{"next_attempt_at": now.AddDate(0, 0, wait)}); err != nil {
+		return err
+	}
+	return events.Enqueue(tx, s.ID, "subscription.updated", Payload(*s))
+}
+
+// ChangePrice moves s to price and quantity. With prorate, the unused part of
+// the current period is credited at the old price and charged at the new one
+// on the next renewal.
+func ChangePrice(tx *gorm.DB, s *models.Subscription, price models.Price, quantity int64, withProration bool, now time.Time) error {
+	if Ended(*s) {
+		return ErrEnded
+	}
+	var old models.Price
+	if err := tx.First(&old, "id = ?", s.PriceID).Error; err != nil {
+		return err
+	}
+	if old.Currency != price.Currency || old.Interval != price.Interval || old.IntervalCount != price.IntervalCount {
+		return ErrIncompatiblePrice
+	}
+
+	proration := int64(0)
+	if withProration && s.Status != StatusTrialing {
+		proration = prorate(Amount(price, quantity), s.CurrentPeriodStart, s.CurrentPeriodEnd, now) -
+			prorate(Amount(old, s.Quantity), s.CurrentPeriodStart, s.CurrentPeriodEnd, now)
+	}
+
+	err := update(tx, s, map[string]interface{}{
+		"price_id":         price.ID,
+		"quantity":         quantity,
+		"proration_amount": s.ProrationAmount + proration,
+	})
+	if err != nil {
+		return err
+	}
+	payload := Payload(*s)
+	payload["proration"] = proration
+	return events.Enqueue(tx, s.ID, "subscription.updated", payload)
+}
+
+// Cancel ends s now, or at the end of its period when atPeriodEnd is set.
+func Cancel(tx *gorm.DB, s *models.Subscription, atPeriodEnd bool, now time.Time) error {
+	if Ended(*s) {
+		return ErrEnded
+	}
+	if atPeriodEnd {
+		if err := update(tx, s, map[string]interface{}{"cancel_at_period_end": true}); err != nil {
+			return err
+		}
+		return events.Enqueue(tx, s.ID, "subscription.updated", Payload(*s))
+	}
+	return cancel(tx, s, now)
+}
+
+func cancel(tx *gorm.DB, s *models.Subscription, at time.Time) error {
+	err := update(tx, s, map[string]interface{}{
+		"status":          StatusCanceled,
+		"canceled_at":     at,
+		"next_attempt_at": nil,
+	})
+	if err != nil {
+		return err
+	}
+	return events.Enqueue(tx, s.ID, "subscription.canceled", Payload(*s))
+}
+
+// update writes updates to s and reloads it, so event payloads show the
+// new state.
+func update(tx *gorm.DB, s *models.Subscription, updates map[string]interface{}) error {
+	if err := tx.Model(&models.Subscription{}).Where("id = ?", s.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	return tx.First(s, "id = ?", s.ID).Error
+}
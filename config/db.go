@@ -7,6 +7,8 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
 )
 
 var DB *gorm.DB
@@ -16,9 +18,82 @@ func InitDB(path string) *gorm.DB {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := db.AutoMigrate(&models.Transaction{}, &models.WebhookEvent{}, &models.IdempotencyKey{}); err != nil {
+	if err := db.AutoMigrate(
+		&models.Transaction{},
+		&models.WebhookEvent{},
+		&models.IdempotencyKey{},
+		&models.Refund{},
+		&models.Customer{},
+		&models.MetadataEntry{},
+		&models.LedgerEntry{},
+		&models.LedgerLock{},
+		&models.Dispute{},
+		&models.File{},
+		&models.BankAccount{},
+		&models.Payout{},
+		&models.PayoutSchedule{},
+		&models.BankStatement{},
+		&models.BankStatementLine{},
+		&models.PricingPlan{},
+		&models.Merchant{},
+		&models.BalanceTransaction{},
+		&models.ReserveRule{},
+		&models.Reserve{},
+		&models.Product{},
+		&models.Price{},
+		&models.Subscription{},
+		&models.DunningSettings{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.InvoiceSequence{},
+		&models.Coupon{},
+		&models.PromotionCode{},
+		&models.TaxRate{},
+		&models.TaxLine{},
+		&models.FXRate{},
+		&models.Wallet{},
+		&models.WalletTransaction{},
+		&models.WalletTransfer{},
+		&models.Transfer{},
+		&models.TransferReversal{},
+		&models.RiskRule{},
+		&models.Review{},
+		&models.ReviewEvent{},
+		&models.Authentication{},
+		&models.BlocklistEntry{},
+		&models.VelocityRule{},
+		&models.VelocityEvent{},
+		&models.VelocityCounter{},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if err := tokenizeCardsOnFile(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := pricing.EnsureDefaults(db); err != nil {
 		log.Fatal(err)
 	}
 	DB = db
 	return db
 }
+
+// tokenizeCardsOnFile replaces the card numbers that customers kept on file
+// before cards were tokenized with their tokens, and drops the numbers.
+func tokenizeCardsOnFile(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Customer{}, "card_number") {
+		return nil
+	}
+	var cards []struct {
+		ID         string
+		CardNumber string
+	}
+	if err := db.Table("customers").Select("id, card_number").Where("COALESCE(card_number, '') <> ''").Scan(&cards).Error; err != nil {
+		return err
+	}
+	for _, card := range cards {
+		if err := db.Table("customers").Where("id = ?", card.ID).Update("card_token", processor.Token(card.CardNumber)).Error; err != nil {
+			return err
+		}
+	}
+	return db.Migrator().DropColumn(&models.Customer{}, "card_number")
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"gorm.io/gorm"
+)
+
+type ChallengeRequest struct {
+	Result string `form:"result" binding:"required,oneof=authenticated failed"`
+}
+
+// challengePage is the simulated 3-D Secure page of a charge: the
+// cardholder passes or fails the challenge while it waits, and sees how
+// the charge went afterwards.
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>3-D Secure</title></head>
+<body>
+<h1>3-D Secure</h1>
+<p>{{.Amount}} {{.Currency}}{{if .Card}} on the card ending {{.Card}}{{end}}</p>
+{{if .Pending}}<form method="post" action="{{.Action}}">
+<p>This is a test authentication page. Complete or fail the challenge.</p>
+<button type="submit" name="result" value="authenticated">Complete authentication</button>
+<button type="submit" name="result" value="failed">Fail authentication</button>
+</form>{{else}}<p>The payment {{.Status}}.</p>{{end}}
+</body>
+</html>
+`))
+
+// ChallengePage shows the 3-D Secure challenge of a charge waiting for it,
+// or the charge's status once the challenge is done.
+func ChallengePage(c *gin.Context) {
+	txn, ok := loadChallenge(c)
+	if !ok {
+		return
+	}
+	renderChallenge(c, txn)
+}
+
+// CompleteChallenge passes or fails the 3-D Secure challenge of a charge
+// and resumes it, then sends the cardholder to the charge's return URL.
+func CompleteChallenge(c *gin.Context) {
+	var req ChallengeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	txn, ok := loadChallenge(c)
+	if !ok {
+		return
+	}
+
+	a, err := payments.Authenticate(config.DB, &txn, req.Result == payments.ThreeDSecureAuthenticated)
+	if errors.Is(err, payments.ErrNotAwaitingAction) {
+		apierror.Respond(c, apierror.New(apierror.CodeAuthenticationDone, "The 3-D Secure challenge of charge "+txn.ID+" is already completed or expired.").WithParam("id"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to complete authentication."))
+		return
+	}
+	if txn.Status == "failed" && txn.CouponID != "" {
+		if d, err := discounts.Load(config.DB, txn.CouponID, txn.PromotionCodeID); err == nil {
+			discounts.Unredeem(config.DB, d)
+		}
+	}
+
+	if a.ReturnURL == "" {
+		renderChallenge(c, txn)
+		return
+	}
+	target, err := url.Parse(a.ReturnURL)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to complete authentication."))
+		return
+	}
+	query := target.Query()
+	query.Set("charge", txn.ID)
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusSeeOther, target.String())
+}
+
+func renderChallenge(c *gin.Context, txn models.Transaction) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	challengePage.Execute(c.Writer, map[string]interface{}{
+		"Amount":   txn.Amount,
+		"Currency": txn.Currency,
+		"Card":     txn.CardLast4,
+		"Pending":  txn.Status == payments.StatusRequiresAction && txn.ThreeDSecure == payments.ThreeDSecurePending,
+		"Status":   txn.Status,
+		"Action":   payments.ChallengeURL(txn),
+	})
+}
+
+func loadChallenge(c *gin.Context) (models.Transaction, bool) {
+	id := c.Param("id")
+
+	var txn models.Transaction
+	err := config.DB.First(&txn, "id = ? AND three_d_secure <> ?", id, "").Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("charge", "id", id))
+		return txn, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch charge."))
+		return txn, false
+	}
+	return txn, true
+}
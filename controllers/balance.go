@@ -2,42 +2,102 @@ package controllers
 
 import (
 	"net/http"
+	"sort"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/balance"
 	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
 	"github.com/vaidikcode/minipay/models"
 )
 
+type BalanceAmount struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Gross and Fees sum the charge and refund balance transactions per
+// currency, so refunded charges net out of them, and Balance is net of fees:
+// Gross - Fees. Available is what the ledger holds for the merchant per
+// currency, after refunds, disputes and payouts, and can be paid out; Pending
+// is charge funds still waiting for settlement and Reserved what risk holds
+// back.
 type BalanceResponse struct {
-	SuccessfulTransactions int64 `json:"successful_transactions"`
-	RefundedTransactions   int64 `json:"refunded_transactions"`
-	Balance                int64 `json:"balance"`
+	SuccessfulTransactions int64           `json:"successful_transactions"`
+	RefundedTransactions   int64           `json:"refunded_transactions"`
+	Gross                  []BalanceAmount `json:"gross"`
+	Fees                   []BalanceAmount `json:"fees"`
+	Balance                []BalanceAmount `json:"balance"`
+	Available              []BalanceAmount `json:"available"`
+	Pending                []BalanceAmount `json:"pending"`
+	Reserved               []BalanceAmount `json:"reserved"`
 }
 
 func Balance(c *gin.Context) {
-	var transactions []models.Transaction
-	if err := config.DB.Where("status = ?", "succeeded").Find(&transactions).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch balance"})
+	var successful, refunded int64
+	if err := config.DB.Model(&models.Transaction{}).Where("status = ? AND refunded = ?", "succeeded", false).Count(&successful).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+		return
+	}
+	if err := config.DB.Model(&models.Transaction{}).Where("refunded = ?", true).Count(&refunded).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
 		return
 	}
 
-	var successful int64
-	var refunded int64
-	balance := int64(0)
+	var totals []struct {
+		Currency string
+		Gross    int64
+		Fees     int64
+		Net      int64
+	}
+	err := config.DB.Model(&models.BalanceTransaction{}).
+		Where("type IN ?", []string{balance.TypeCharge, balance.TypeRefund}).
+		Select("currency, SUM(amount) AS gross, SUM(fee) AS fees, SUM(net) AS net").
+		Group("currency").
+		Scan(&totals).Error
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+		return
+	}
+	gross, fees, net := map[string]int64{}, map[string]int64{}, map[string]int64{}
+	for _, t := range totals {
+		gross[t.Currency], fees[t.Currency], net[t.Currency] = t.Gross, t.Fees, t.Net
+	}
 
-	for _, t := range transactions {
-		if t.Refunded {
-			refunded++
-			balance -= t.Amount
-		} else {
-			successful++
-			balance += t.Amount
-		}
+	available, err := ledger.Balances(config.DB, ledger.AccountMerchantAvailable)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+		return
+	}
+	pending, err := ledger.Balances(config.DB, ledger.AccountMerchantPending)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+		return
+	}
+	reserved, err := ledger.Balances(config.DB, ledger.AccountMerchantReserved)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+		return
 	}
 
 	c.JSON(http.StatusOK, BalanceResponse{
 		SuccessfulTransactions: successful,
 		RefundedTransactions:   refunded,
-		Balance:                balance,
+		Gross:                  balanceAmounts(gross),
+		Fees:                   balanceAmounts(fees),
+		Balance:                balanceAmounts(net),
+		Available:              balanceAmounts(available),
+		Pending:                balanceAmounts(pending),
+		Reserved:               balanceAmounts(reserved),
 	})
 }
+
+func balanceAmounts(balances map[string]int64) []BalanceAmount {
+	amounts := make([]BalanceAmount, 0, len(balances))
+	for currency, amount := range balances {
+		amounts = append(amounts, BalanceAmount{Amount: amount, Currency: currency})
+	}
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i].Currency < amounts[j].Currency })
+	return amounts
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"gorm.io/gorm"
+)
+
+type BalanceTransactionListParams struct {
+	ListParams
+	Type     string `form:"type"`
+	Source   string `form:"source"`
+	Currency string `form:"currency"`
+	Merchant string `form:"merchant"`
+}
+
+type BalanceAdjustmentRequest struct {
+	Amount      int64  `json:"amount" binding:"required"`
+	Currency    string `json:"currency" binding:"required,len=3,alpha"`
+	Merchant    string `json:"merchant" binding:"omitempty,max=64"`
+	Description string `json:"description" binding:"required,max=255"`
+}
+
+type BalanceTransactionResponse struct {
+	ID          string            `json:"id"`
+	Object      string            `json:"object"`
+	Merchant    string            `json:"merchant"`
+	Type        string            `json:"type"`
+	Source      string            `json:"source"`
+	Amount      int64             `json:"amount"`
+	Fee         int64             `json:"fee"`
+	Net         int64             `json:"net"`
+	Currency    string            `json:"currency"`
+	FeeDetails  models.FeeDetails `json:"fee_details"`
+	Description string            `json:"description"`
+	Status      string            `json:"status"`
+	AvailableOn string            `json:"available_on"`
+	CreatedAt   string            `json:"created_at"`
+}
+
+func newBalanceTransactionResponse(bt models.BalanceTransaction) BalanceTransactionResponse {
+	resp := BalanceTransactionResponse{
+		ID:          bt.ID,
+		Object:      "balance_transaction",
+		Merchant:    bt.MerchantID,
+		Type:        bt.Type,
+		Source:      bt.SourceID,
+		Amount:      bt.Amount,
+		Fee:         bt.Fee,
+		Net:         bt.Net,
+		Currency:    bt.Currency,
+		FeeDetails:  bt.FeeDetails,
+		Description: bt.Description,
+		Status:      bt.Status,
+		AvailableOn: bt.AvailableOn.Format(time.RFC3339),
+		CreatedAt:   bt.CreatedAt.Format(time.RFC3339),
+	}
+	if resp.FeeDetails == nil {
+		resp.FeeDetails = models.FeeDetails{}
+	}
+	return resp
+}
+
+func GetBalanceTransaction(c *gin.Context) {
+	id := c.Param("id")
+
+	var bt models.BalanceTransaction
+	err := config.DB.First(&bt, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("balance_transaction", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch balance transaction."))
+		return
+	}
+	c.JSON(http.StatusOK, newBalanceTransactionResponse(bt))
+}
+
+// ListBalanceTransactions returns every movement of the balance, newest
+// first. The nets of the listed entries add up to the available balance.
+func ListBalanceTransactions(c *gin.Context) {
+	var params BalanceTransactionListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if params.Type != "" && !balance.ValidType(params.Type) {
+		apierror.Respond(c, apierror.Invalid("type", "Unknown balance transaction type: "+params.Type+"."))
+		return
+	}
+
+	query := config.DB.Model(&models.BalanceTransaction{})
+	if params.Type != "" {
+		query = query.Where("type = ?", params.Type)
+	}
+	if params.Source != "" {
+		query = query.Where("source_id = ?", params.Source)
+	}
+	if params.Currency != "" {
+		query = query.Where("currency = ?", strings.ToLower(params.Currency))
+	}
+	if params.Merchant != "" {
+		query = query.Where("merchant_id = ?", params.Merchant)
+	}
+
+	list, hasMore, apiErr := paginate[models.BalanceTransaction](query, models.BalanceTransaction{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]BalanceTransactionResponse, 0, len(list))
+	for _, bt := range list {
+		data = append(data, newBalanceTransactionResponse(bt))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/balance_transactions",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// CreateBalanceAdjustment corrects a merchant's balance by hand. A negative
+// amount debits it.
+func CreateBalanceAdjustment(c *gin.Context) {
+	var req BalanceAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	merchantID := req.Merchant
+	if merchantID == "" {
+		merchantID = pricing.DefaultMerchantID
+	}
+	if _, _, err := pricing.Lookup(config.DB, merchantID); err != nil {
+		if errors.Is(err, pricing.ErrMerchantNotFound) {
+			apierror.Respond(c, apierror.NotFound("merchant", "merchant", merchantID))
+		} else {
+			apierror.Respond(c, apierror.Internal("Failed to fetch merchant."))
+		}
+		return
+	}
+
+	var bt *models.BalanceTransaction
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		bt, err = balance.RecordAdjustment(tx, merchantID, strings.ToLower(req.Currency), req.Amount, req.Description)
+		return err
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to record adjustment."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newBalanceTransactionResponse(*bt))
+}
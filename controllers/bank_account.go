@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payouts"
+	"gorm.io/gorm"
+)
+
+type BankAccountRequest struct {
+	Currency           string `json:"currency" binding:"required,len=3,alpha"`
+	Country            string `json:"country" binding:"required,len=2,alpha"`
+	AccountHolderName  string `json:"account_holder_name" binding:"required,max=255"`
+	AccountHolderType  string `json:"account_holder_type" binding:"omitempty,oneof=individual company"`
+	IBAN               string `json:"iban"`
+	BIC                string `json:"bic"`
+	RoutingNumber      string `json:"routing_number"`
+	AccountNumber      string `json:"account_number" binding:"omitempty,numeric,min=4,max=17"`
+	DefaultForCurrency bool   `json:"default_for_currency"`
+	// Customer makes this a customer's account for wallet withdrawals, and
+	// Merchant a connected account's for its payouts.
+	Customer string `json:"customer" binding:"omitempty,max=64"`
+	Merchant string `json:"merchant" binding:"omitempty,max=64"`
+}
+
+type BankAccountResponse struct {
+	ID                 string `json:"id"`
+	Currency           string `json:"currency"`
+	Country            string `json:"country"`
+	AccountHolderName  string `json:"account_holder_name"`
+	AccountHolderType  string `json:"account_holder_type"`
+	BIC                string `json:"bic,omitempty"`
+	RoutingNumber      string `json:"routing_number,omitempty"`
+	Last4              string `json:"last4"`
+	DefaultForCurrency bool   `json:"default_for_currency"`
+	Customer           string `json:"customer,omitempty"`
+	Merchant           string `json:"merchant,omitempty"`
+	CreatedAt          string `json:"created_at"`
+}
+
+type BankAccountListParams struct {
+	ListParams
+	Currency string `form:"currency"`
+	Customer string `form:"customer"`
+	Merchant string `form:"merchant"`
+}
+
+func newBankAccountResponse(ba models.BankAccount) BankAccountResponse {
+	return BankAccountResponse{
+		ID:                 ba.ID,
+		Currency:           ba.Currency,
+		Country:            ba.Country,
+		AccountHolderName:  ba.AccountHolderName,
+		AccountHolderType:  ba.AccountHolderType,
+		BIC:                ba.BIC,
+		RoutingNumber:      ba.RoutingNumber,
+		Last4:              ba.Last4,
+		DefaultForCurrency: ba.DefaultForCurrency,
+		Customer:           ba.CustomerID,
+		Merchant:           ba.MerchantID,
+		CreatedAt:          ba.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreateBankAccount registers an IBAN (with optional BIC) or a US routing and
+// account number pair. Full account numbers are never returned.
+func CreateBankAccount(c *gin.Context) {
+	var req BankAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	ba := models.BankAccount{
+		ID:                 "ba_" + uuid.NewString(),
+		Currency:           strings.ToLower(req.Currency),
+		Country:            strings.ToUpper(req.Country),
+		AccountHolderName:  req.AccountHolderName,
+		AccountHolderType:  req.AccountHolderType,
+		DefaultForCurrency: req.DefaultForCurrency,
+		CustomerID:         req.Customer,
+		MerchantID:         req.Merchant,
+	}
+	if ba.AccountHolderType == "" {
+		ba.AccountHolderType = "company"
+	}
+
+	switch {
+	case req.IBAN != "":
+		iban := payouts.NormalizeIBAN(req.IBAN)
+		if !payouts.ValidIBAN(iban) {
+			apierror.Respond(c, apierror.Invalid("iban", "The IBAN you provided is invalid."))
+			return
+		}
+		if iban[:2] != ba.Country {
+			apierror.Respond(c, apierror.Invalid("iban", "The IBAN country does not match country "+ba.Country+"."))
+			return
+		}
+		bic := strings.ToUpper(req.BIC)
+		if bic != "" && !payouts.ValidBIC(bic) {
+			apierror.Respond(c, apierror.Invalid("bic", "The BIC you provided is invalid."))
+			return
+		}
+		ba.IBAN = iban
+		ba.BIC = bic
+		ba.Last4 = iban[len(iban)-4:]
+	case req.RoutingNumber != "" || req.AccountNumber != "":
+		if req.RoutingNumber == "" {
+			apierror.Respond(c, apierror.Missing("routing_number"))
+			return
+		}
+		if req.AccountNumber == "" {
+			apierror.Respond(c, apierror.Missing("account_number"))
+			return
+		}
+		if !payouts.ValidABARouting(req.RoutingNumber) {
+			apierror.Respond(c, apierror.Invalid("routing_number", "The routing number you provided is invalid."))
+			return
+		}
+		ba.RoutingNumber = req.RoutingNumber
+		ba.AccountNumber = req.AccountNumber
+		ba.Last4 = req.AccountNumber[len(req.AccountNumber)-4:]
+	default:
+		apierror.Respond(c, apierror.Missing("iban"))
+		return
+	}
+	if ba.CustomerID != "" && ba.MerchantID != "" {
+		apierror.Respond(c, apierror.Invalid("merchant", "A bank account belongs to a customer or a merchant, not both."))
+		return
+	}
+	if ba.MerchantID != "" && !connectedAccount(c, ba.MerchantID, "merchant") {
+		return
+	}
+	if ba.CustomerID != "" {
+		var count int64
+		config.DB.Model(&models.Customer{}).Where("id = ?", ba.CustomerID).Count(&count)
+		if count == 0 {
+			apierror.Respond(c, apierror.NotFound("customer", "customer", ba.CustomerID))
+			return
+		}
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if ba.DefaultForCurrency {
+			if err := tx.Model(&models.BankAccount{}).Where("currency = ? AND COALESCE(customer_id, '') = ? AND COALESCE(merchant_id, '') = ?", ba.Currency, ba.CustomerID, ba.MerchantID).Update("default_for_currency", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&ba).Error
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create bank account."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newBankAccountResponse(ba))
+}
+
+func GetBankAccount(c *gin.Context) {
+	id := c.Param("id")
+
+	var ba models.BankAccount
+	err := config.DB.First(&ba, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("bank_account", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch bank account."))
+		return
+	}
+	c.JSON(http.StatusOK, newBankAccountResponse(ba))
+}
+
+func ListBankAccounts(c *gin.Context) {
+	var params BankAccountListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.BankAccount{})
+	if params.Currency != "" {
+		query = query.Where("currency = ?", strings.ToLower(params.Currency))
+	}
+	if params.Customer != "" {
+		query = query.Where("customer_id = ?", params.Customer)
+	}
+	if params.Merchant != "" {
+		query = query.Where("merchant_id = ?", params.Merchant)
+	}
+
+	accounts, hasMore, apiErr := paginate[models.BankAccount](query, models.BankAccount{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]BankAccountResponse, 0, len(accounts))
+	for _, ba := range accounts {
+		data = append(data, newBankAccountResponse(ba))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/bank_accounts",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
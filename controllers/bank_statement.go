@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/reconciliation"
+	"github.com/vaidikcode/minipay/statements"
+	"gorm.io/gorm"
+)
+
+const maxStatementFileSize = 10 << 20
+
+type StatementSummary struct {
+	Lines      int `json:"lines"`
+	Matched    int `json:"matched"`
+	Unmatched  int `json:"unmatched"`
+	Suspicious int `json:"suspicious"`
+	Missing    int `json:"missing,omitempty"`
+}
+
+type BankStatementResponse struct {
+	ID             string           `json:"id"`
+	Format         string           `json:"format"`
+	Reference      string           `json:"reference,omitempty"`
+	Account        string           `json:"account,omitempty"`
+	Currency       string           `json:"currency"`
+	OpeningBalance *int64           `json:"opening_balance,omitempty"`
+	ClosingBalance *int64           `json:"closing_balance,omitempty"`
+	PeriodStart    string           `json:"period_start"`
+	PeriodEnd      string           `json:"period_end"`
+	File           string           `json:"file"`
+	Summary        StatementSummary `json:"summary"`
+	CreatedAt      string           `json:"created_at"`
+}
+
+type StatementMatch struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type StatementLineResponse struct {
+	ID          string          `json:"id"`
+	BookingDate string          `json:"booking_date"`
+	ValueDate   string          `json:"value_date"`
+	Amount      int64           `json:"amount"`
+	Currency    string          `json:"currency"`
+	Reference   string          `json:"reference,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Status      string          `json:"status"`
+	Match       *StatementMatch `json:"match,omitempty"`
+	Note        string          `json:"note,omitempty"`
+}
+
+type ExpectedMovementResponse struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	ExpectedDate string `json:"expected_date"`
+}
+
+type ReconciliationReportResponse struct {
+	Object     string                     `json:"object"`
+	Statement  BankStatementResponse      `json:"statement"`
+	Summary    StatementSummary           `json:"summary"`
+	Matched    []StatementLineResponse    `json:"matched"`
+	Unmatched  []StatementLineResponse    `json:"unmatched"`
+	Suspicious []StatementLineResponse    `json:"suspicious"`
+	Missing    []ExpectedMovementResponse `json:"missing"`
+}
+
+type BankStatementListParams struct {
+	ListParams
+	Account string `form:"account"`
+}
+
+func newBankStatementResponse(s models.BankStatement, summary StatementSummary) BankStatementResponse {
+	resp := BankStatementResponse{
+		ID:          s.ID,
+		Format:      s.Format,
+		Reference:   s.Reference,
+		Account:     s.Account,
+		Currency:    s.Currency,
+		PeriodStart: s.PeriodStart.Format("2006-01-02"),
+		PeriodEnd:   s.PeriodEnd.Format("2006-01-02"),
+		File:        s.FileID,
+		Summary:     summary,
+		CreatedAt:   s.CreatedAt.Format(time.RFC3339),
+	}
+	if s.HasBalances {
+		opening, closing := s.OpeningBalance, s.ClosingBalance
+		resp.OpeningBalance = &opening
+		resp.ClosingBalance = &closing
+	}
+	return resp
+}
+
+func bankStatementResponse(s models.BankStatement) BankStatementResponse {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	config.DB.Model(&models.BankStatementLine{}).Select("status, count(*) as count").
+		Where("statement_id = ?", s.ID).Group("status").Scan(&rows)
+
+	var summary StatementSummary
+	for _, r := range rows {
+		summary.Lines += r.Count
+		switch r.Status {
+		case reconciliation.StatusMatched:
+			summary.Matched = r.Count
+		case reconciliation.StatusSuspicious:
+			summary.Suspicious = r.Count
+		default:
+			summary.Unmatched += r.Count
+		}
+	}
+	return newBankStatementResponse(s, summary)
+}
+
+func newStatementLineResponse(l models.BankStatementLine) StatementLineResponse {
+	resp := StatementLineResponse{
+		ID:          l.ID,
+		BookingDate: l.BookingDate.Format("2006-01-02"),
+		ValueDate:   l.ValueDate.Format("2006-01-02"),
+		Amount:      l.Amount,
+		Currency:    l.Currency,
+		Reference:   l.Reference,
+		Description: l.Description,
+		Status:      l.Status,
+		Note:        l.Note,
+	}
+	if l.MatchID != "" {
+		resp.Match = &StatementMatch{Type: l.MatchType, ID: l.MatchID}
+	}
+	return resp
+}
+
+func newStatementLineResponses(lines []models.BankStatementLine) []StatementLineResponse {
+	data := make([]StatementLineResponse, 0, len(lines))
+	for _, l := range lines {
+		data = append(data, newStatementLineResponse(l))
+	}
+	return data
+}
+
+func newReconciliationReportResponse(r *reconciliation.Report) ReconciliationReportResponse {
+	resp := ReconciliationReportResponse{
+		Object:     "reconciliation_report",
+		Matched:    newStatementLineResponses(r.Matched),
+		Unmatched:  newStatementLineResponses(r.Unmatched),
+		Suspicious: newStatementLineResponses(r.Suspicious),
+		Missing:    make([]ExpectedMovementResponse, 0, len(r.Missing)),
+		Summary: StatementSummary{
+			Lines:      len(r.Matched) + len(r.Unmatched) + len(r.Suspicious),
+			Matched:    len(r.Matched),
+			Unmatched:  len(r.Unmatched),
+			Suspicious: len(r.Suspicious),
+			Missing:    len(r.Missing),
+		},
+	}
+	for _, e := range r.Missing {
+		resp.Missing = append(resp.Missing, ExpectedMovementResponse{
+			Type:         e.Type,
+			ID:           e.ID,
+			Amount:       e.Amount,
+			Currency:     e.Currency,
+			ExpectedDate: e.Date.Format("2006-01-02"),
+		})
+	}
+	summary := resp.Summary
+	summary.Missing = 0
+	resp.Statement = newBankStatementResponse(r.Statement, summary)
+	return resp
+}
+
+// ImportBankStatement accepts a multipart upload of a CAMT.053, MT940 or CSV
+// statement in "file", with an optional "format" when detection guesses wrong.
+// A file can hold several statements; each is stored and reconciled.
+func ImportBankStatement(c *gin.Context) {
+	format := c.PostForm("format")
+	if format != "" && !validStatementFormat(format) {
+		apierror.Respond(c, apierror.Invalid("format", "Invalid format. Valid formats are camt053, mt940, csv."))
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		apierror.Respond(c, apierror.Missing("file"))
+		return
+	}
+	if header.Size > maxStatementFileSize {
+		apierror.Respond(c, apierror.Invalid("file", "Statement files can be at most 10 MB."))
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("file", "Failed to read "+header.Filename+"."))
+		return
+	}
+	raw, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("file", "Failed to read "+header.Filename+"."))
+		return
+	}
+
+	imported, err := reconciliation.Import(config.DB, format, header.Filename, raw)
+	var syntaxErr *statements.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		apierror.Respond(c, apierror.Invalid("file", "Could not parse statement: "+syntaxErr.Error()+"."))
+		return
+	case errors.Is(err, reconciliation.ErrUnbalanced):
+		apierror.Respond(c, apierror.Invalid("file", "The statement lines do not add up to the closing balance."))
+		return
+	case errors.Is(err, reconciliation.ErrAlreadyImported):
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "This statement file has already been imported.").WithParam("file"))
+		return
+	case err != nil:
+		apierror.Respond(c, apierror.Internal("Failed to import statement."))
+		return
+	}
+
+	data := make([]BankStatementResponse, 0, len(imported))
+	for _, s := range imported {
+		data = append(data, bankStatementResponse(s))
+	}
+	c.JSON(http.StatusCreated, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/bank_statements",
+		HasMore: false,
+		Data:    data,
+	})
+}
+
+func GetBankStatement(c *gin.Context) {
+	s, ok := loadBankStatement(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, bankStatementResponse(s))
+}
+
+func ListBankStatements(c *gin.Context) {
+	var params BankStatementListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.BankStatement{})
+	if params.Account != "" {
+		query = query.Where("account = ?", params.Account)
+	}
+
+	list, hasMore, apiErr := paginate[models.BankStatement](query, models.BankStatement{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]BankStatementResponse, 0, len(list))
+	for _, s := range list {
+		data = append(data, bankStatementResponse(s))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/bank_statements",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func GetReconciliationReport(c *gin.Context) {
+	s, ok := loadBankStatement(c)
+	if !ok {
+		return
+	}
+	respondReconciliationReport(c, s.ID)
+}
+
+// ReconcileBankStatement matches the statement's open lines again, for
+// instance after the payouts they belong to have been sent.
+func ReconcileBankStatement(c *gin.Context) {
+	s, ok := loadBankStatement(c)
+	if !ok {
+		return
+	}
+	if err := reconciliation.Reconcile(config.DB, s.ID); err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to reconcile statement."))
+		return
+	}
+	respondReconciliationReport(c, s.ID)
+}
+
+func respondReconciliationReport(c *gin.Context, statementID string) {
+	report, err := reconciliation.BuildReport(config.DB, statementID)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to build reconciliation report."))
+		return
+	}
+	c.JSON(http.StatusOK, newReconciliationReportResponse(report))
+}
+
+func loadBankStatement(c *gin.Context) (models.BankStatement, bool) {
+	id := c.Param("id")
+
+	var s models.BankStatement
+	err := config.DB.First(&s, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("bank_statement", "id", id))
+		return s, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch bank statement."))
+		return s, false
+	}
+	return s, true
+}
+
+func validStatementFormat(format string) bool {
+	for _, f := range statements.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
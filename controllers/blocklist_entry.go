@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/risk"
+	"gorm.io/gorm"
+)
+
+type BlocklistEntryRequest struct {
+	Type   string `json:"type" binding:"required,oneof=customer email card_fingerprint ip"`
+	Value  string `json:"value" binding:"required,max=255"`
+	Reason string `json:"reason" binding:"omitempty,max=255"`
+}
+
+type BlocklistEntryListParams struct {
+	ListParams
+	Type   string `form:"type"`
+	Value  string `form:"value"`
+	Active string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type BlocklistEntryResponse struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason,omitempty"`
+	Active    bool   `json:"active"`
+	CreatedAt string `json:"created_at"`
+}
+
+func newBlocklistEntryResponse(e models.BlocklistEntry) BlocklistEntryResponse {
+	return BlocklistEntryResponse{
+		ID:        e.ID,
+		Object:    "blocklist_entry",
+		Type:      e.Type,
+		Value:     e.Value,
+		Reason:    e.Reason,
+		Active:    e.Active,
+		CreatedAt: e.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreateBlocklistEntry blocklists a value, or enables its disabled entry
+// again.
+func CreateBlocklistEntry(c *gin.Context) {
+	var req BlocklistEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	value, ok := risk.NormalizeBlocklistValue(req.Type, req.Value)
+	if !ok {
+		apierror.Respond(c, apierror.Invalid("value", "The value is not a valid "+req.Type+"."))
+		return
+	}
+
+	var existing []models.BlocklistEntry
+	if err := config.DB.Where("type = ? AND value = ?", req.Type, value).Limit(1).Find(&existing).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create blocklist entry."))
+		return
+	}
+	if len(existing) == 1 {
+		entry := existing[0]
+		if entry.Active {
+			apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Blocklist entry "+entry.ID+" already blocks this value.").WithParam("value"))
+			return
+		}
+		entry.Active = true
+		entry.Reason = req.Reason
+		if err := config.DB.Save(&entry).Error; err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to create blocklist entry."))
+			return
+		}
+		c.JSON(http.StatusCreated, newBlocklistEntryResponse(entry))
+		return
+	}
+
+	entry := models.BlocklistEntry{
+		ID:     "bl_" + uuid.NewString(),
+		Type:   req.Type,
+		Value:  value,
+		Reason: req.Reason,
+		Active: true,
+	}
+	if err := config.DB.Create(&entry).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create blocklist entry."))
+		return
+	}
+	c.JSON(http.StatusCreated, newBlocklistEntryResponse(entry))
+}
+
+func GetBlocklistEntry(c *gin.Context) {
+	entry, ok := loadBlocklistEntry(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newBlocklistEntryResponse(entry))
+}
+
+func ListBlocklistEntries(c *gin.Context) {
+	var params BlocklistEntryListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.BlocklistEntry{})
+	if params.Type != "" {
+		query = query.Where("type = ?", params.Type)
+	}
+	if params.Value != "" {
+		value, _ := risk.NormalizeBlocklistValue(params.Type, params.Value)
+		query = query.Where("value = ?", value)
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.BlocklistEntry](query, models.BlocklistEntry{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]BlocklistEntryResponse, 0, len(list))
+	for _, e := range list {
+		data = append(data, newBlocklistEntryResponse(e))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/blocklist_entries",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func DisableBlocklistEntry(c *gin.Context) {
+	entry, ok := loadBlocklistEntry(c)
+	if !ok {
+		return
+	}
+	if err := config.DB.Model(&entry).Update("active", false).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to disable blocklist entry."))
+		return
+	}
+	c.JSON(http.StatusOK, newBlocklistEntryResponse(entry))
+}
+
+func loadBlocklistEntry(c *gin.Context) (models.BlocklistEntry, bool) {
+	id := c.Param("id")
+
+	var entry models.BlocklistEntry
+	err := config.DB.First(&entry, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("blocklist_entry", "id", id))
+		return entry, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch blocklist entry."))
+		return entry, false
+	}
+	return entry, true
+}
@@ -1,38 +1,204 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
 	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/middleware"
 	"github.com/vaidikcode/minipay/models"
-	"github.com/vaidikcode/minipay/utils"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/taxes"
+	"github.com/vaidikcode/minipay/transfers"
 )
 
+type CardRequest struct {
+	Number   string `json:"number" binding:"required,numeric,min=12,max=19"`
+	ExpMonth int    `json:"exp_month" binding:"required,min=1,max=12"`
+	ExpYear  int    `json:"exp_year" binding:"required"`
+	CVC      string `json:"cvc" binding:"omitempty,numeric,min=3,max=4"`
+}
+
 type ChargeRequest struct {
-	Amount   int64  `json:"amount" binding:"required,gt=0"`
-	Currency string `json:"currency" binding:"required"`
-	Customer string `json:"customer" binding:"required"`
+	Amount   int64             `json:"amount" binding:"required,gt=0"`
+	Currency string            `json:"currency" binding:"required"`
+	Customer string            `json:"customer" binding:"required"`
+	Country  string            `json:"country" binding:"omitempty,len=2,alpha"`
+	Merchant string            `json:"merchant" binding:"omitempty,max=64"`
+	Card     *CardRequest      `json:"card"`
+	Metadata map[string]string `json:"metadata"`
+	// Coupon or PromotionCode takes a discount off Amount.
+	Coupon        string      `json:"coupon" binding:"omitempty,max=64"`
+	PromotionCode string      `json:"promotion_code" binding:"omitempty,max=64"`
+	Tax           *TaxRequest `json:"tax"`
+	// TransferData sends the charge, less ApplicationFeeAmount, to a
+	// connected account of the merchant; Splits divide it among several.
+	TransferData         *TransferDataRequest `json:"transfer_data"`
+	ApplicationFeeAmount int64                `json:"application_fee_amount" binding:"omitempty,min=0"`
+	Splits               []SplitRequest       `json:"splits" binding:"omitempty,max=10,dive"`
+	// ReturnURL is where the cardholder is sent back to after a 3-D Secure
+	// challenge.
+	ReturnURL string `json:"return_url" binding:"omitempty,url,max=2048"`
+}
+
+type TransferDataRequest struct {
+	Destination string `json:"destination" binding:"required,max=64"`
+	// Amount, when set, is sent instead of the charge less the application
+	// fee.
+	Amount int64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+type SplitRequest struct {
+	Account string `json:"account" binding:"required,max=64"`
+	Amount  int64  `json:"amount" binding:"required,gt=0"`
+}
+
+// ChargeError is why a charge failed. A declined charge is returned with
+// 402 as the charge itself, not in the error envelope, so its error carries
+// the envelope's request_id and doc_url.
+type ChargeError struct {
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code,omitempty"`
+	Message     string `json:"message"`
+	RequestID   string `json:"request_id,omitempty"`
+	DocURL      string `json:"doc_url"`
+}
+
+// NextActionResponse tells the client to send the cardholder to URL to
+// complete a 3-D Secure challenge.
+type NextActionResponse struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
 }
 
 type ChargeResponse struct {
-	ID             string `json:"id"`
-	Amount         int64  `json:"amount"`
-	Currency       string `json:"currency"`
-	Customer       string `json:"customer"`
-	Status         string `json:"status"`
-	IdempotencyKey string `json:"idempotency_key"`
-	CreatedAt      string `json:"created_at"`
+	ID                   string              `json:"id"`
+	Amount               int64               `json:"amount"`
+	Currency             string              `json:"currency"`
+	Customer             string              `json:"customer"`
+	Merchant             string              `json:"merchant"`
+	Status               string              `json:"status"`
+	Fee                  int64               `json:"fee"`
+	BalanceTransaction   string              `json:"balance_transaction,omitempty"`
+	Processor            string              `json:"processor,omitempty"`
+	CardBrand            string              `json:"card_brand,omitempty"`
+	CardLast4            string              `json:"card_last4,omitempty"`
+	CardFingerprint      string              `json:"card_fingerprint,omitempty"`
+	Settlement           *SettlementResponse `json:"settlement,omitempty"`
+	Wallet               string              `json:"wallet,omitempty"`
+	ApplicationFeeAmount int64               `json:"application_fee_amount,omitempty"`
+	Discount             *DiscountResponse   `json:"discount,omitempty"`
+	Tax                  *ChargeTaxResponse  `json:"tax,omitempty"`
+	Risk                 *ChargeRiskResponse `json:"risk,omitempty"`
+	ThreeDSecure         string              `json:"three_d_secure,omitempty"`
+	NextAction           *NextActionResponse `json:"next_action,omitempty"`
+	Error                *ChargeError        `json:"error,omitempty"`
+	Metadata             models.Metadata     `json:"metadata"`
+	IdempotencyKey       string              `json:"idempotency_key,omitempty"`
+	CreatedAt            string              `json:"created_at"`
+}
+
+func newChargeResponse(txn models.Transaction, idemKey string) ChargeResponse {
+	resp := ChargeResponse{
+		ID:                   txn.ID,
+		Amount:               txn.Amount,
+		Currency:             txn.Currency,
+		Customer:             txn.Customer,
+		Merchant:             txn.MerchantID,
+		Status:               txn.Status,
+		Fee:                  txn.Fee,
+		BalanceTransaction:   txn.BalanceTransactionID,
+		Processor:            txn.Processor,
+		CardBrand:            txn.CardBrand,
+		CardLast4:            txn.CardLast4,
+		CardFingerprint:      txn.CardFingerprint,
+		Discount:             newDiscountResponse(txn.CouponID, txn.PromotionCodeID, txn.DiscountAmount),
+		Settlement:           newSettlementResponse(txn),
+		Wallet:               txn.WalletID,
+		ApplicationFeeAmount: txn.ApplicationFeeAmount,
+		Tax:                  newChargeTaxResponse(txn),
+		Risk:                 newChargeRiskResponse(txn),
+		ThreeDSecure:         txn.ThreeDSecure,
+		Metadata:             txn.Metadata,
+		IdempotencyKey:       idemKey,
+		CreatedAt:            txn.CreatedAt.Format(time.RFC3339),
+	}
+	if txn.Status == payments.StatusRequiresAction {
+		resp.NextAction = &NextActionResponse{Type: "redirect_to_url", URL: payments.ChallengeURL(txn)}
+	}
+	if txn.Status == "failed" {
+		resp.Error = &ChargeError{
+			Type:        txn.FailureType,
+			Code:        txn.FailureCode,
+			DeclineCode: txn.DeclineCode,
+			Message:     txn.FailureMessage,
+			DocURL:      apierror.DocURL(declinedChargesAnchor),
+		}
+	}
+	return resp
+}
+
+// declinedChargesAnchor is the section of docs/errors.md on declined
+// charges.
+const declinedChargesAnchor = "declined-charges"
+
+// respondCharge writes txn, with the request ID in its error if it failed.
+func respondCharge(c *gin.Context, txn models.Transaction, idemKey string, created bool) {
+	resp := newChargeResponse(txn, idemKey)
+	if resp.Error != nil {
+		resp.Error.RequestID = middleware.GetRequestID(c)
+	}
+	c.JSON(chargeStatusCode(txn, created), resp)
+}
+
+// requestHash identifies a charge request, so that its idempotency key
+// cannot be reused for another. The card goes in by its fingerprint and
+// without its CVC.
+func requestHash(req ChargeRequest) string {
+	if req.Card != nil {
+		card := *req.Card
+		card.Number = processor.Fingerprint(card.Number)
+		card.CVC = ""
+		req.Card = &card
+	}
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func chargeStatusCode(txn models.Transaction, created bool) int {
+	if txn.Status == "failed" {
+		return http.StatusPaymentRequired
+	}
+	if created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
 }
 
 func Charge(c *gin.Context) {
 	var req ChargeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
 		return
 	}
 
@@ -41,85 +207,143 @@ func Charge(c *gin.Context) {
 		idemKey = uuid.NewString()
 	}
 
+	hash := requestHash(req)
 	var existing models.IdempotencyKey
 	if err := config.DB.First(&existing, "id = ?", idemKey).Error; err == nil {
+		if existing.RequestHash != "" && existing.RequestHash != hash {
+			apierror.Respond(c, apierror.New(apierror.CodeIdempotencyConflict, "The Idempotency-Key was already used for a different request."))
+			return
+		}
 		var txn models.Transaction
 		if err := config.DB.First(&txn, "id = ?", existing.TransactionID).Error; err == nil {
-			c.JSON(http.StatusOK, ChargeResponse{
-				ID:             txn.ID,
-				Amount:         txn.Amount,
-				Currency:       txn.Currency,
-				Customer:       txn.Customer,
-				Status:         txn.Status,
-				IdempotencyKey: idemKey,
-				CreatedAt:      txn.CreatedAt.Format(time.RFC3339),
-			})
+			respondCharge(c, txn, idemKey, false)
 			return
 		}
 	}
 
-	txnID := "txn_" + uuid.NewString()
-	txn := models.Transaction{
-		ID:       txnID,
-		Amount:   req.Amount,
-		Currency: req.Currency,
-		Customer: req.Customer,
-		Status:   "pending",
+	var card processor.Card
+	if req.Card != nil {
+		card = processor.Card{
+			Number:   req.Card.Number,
+			ExpMonth: req.Card.ExpMonth,
+			ExpYear:  req.Card.ExpYear,
+			CVC:      req.Card.CVC,
+		}
 	}
 
-	if err := config.DB.Create(&txn).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create transaction"})
+	tax, ok := taxParams(c, req.Tax, "tax")
+	if !ok {
 		return
 	}
-
-	idemEntry := models.IdempotencyKey{
-		ID:            idemKey,
-		TransactionID: txnID,
+	splits, ok := chargeSplits(c, req)
+	if !ok {
+		return
 	}
-	config.DB.Create(&idemEntry)
-
-	config.DB.Model(&txn, "status = ?", "pending").Update("status", "succeeded")
-
-	utils.Metrics.IncCharges()
-
-	target := os.Getenv("WEBHOOK_TARGET")
-	if target == "" {
-		target = "http://localhost:8081/webhook"
+	discount, ok := lookupDiscount(c, req.Coupon, req.PromotionCode, req.Customer, req.Currency)
+	if !ok {
+		return
 	}
-
-	payload := map[string]interface{}{
-		"id":       txn.ID,
-		"amount":   txn.Amount,
-		"currency": txn.Currency,
-		"customer": txn.Customer,
-		"status":   "succeeded",
+	var applied *discounts.Applied
+	if discount != nil {
+		a := discount.Apply(req.Amount)
+		if a.Amount >= req.Amount {
+			apierror.Respond(c, apierror.Invalid("amount", "The discount leaves nothing to charge."))
+			return
+		}
+		if err := discounts.Redeem(config.DB, discount); err != nil {
+			respondDiscountError(c, err, req.Coupon, req.PromotionCode)
+			return
+		}
+		applied = &a
 	}
-	payloadBytes, _ := json.Marshal(payload)
 
-	event := models.WebhookEvent{
-		TransactionID: txn.ID,
-		EventType:     "payment.succeeded",
-		Payload:       string(payloadBytes),
-		TargetURL:     target,
-		Status:        "pending",
-		Attempts:      0,
-		NextRunAt:     time.Now(),
+	txn, err := payments.Charge(config.DB, payments.Params{
+		Amount:         req.Amount,
+		Currency:       req.Currency,
+		Customer:       req.Customer,
+		Country:        req.Country,
+		MerchantID:     req.Merchant,
+		Card:           card,
+		Metadata:       metadata.Clean(req.Metadata),
+		Discount:       applied,
+		Tax:            tax,
+		IdempotencyKey: idemKey,
+		RequestHash:    hash,
+		Splits:         splits,
+		ApplicationFee: req.ApplicationFeeAmount,
+		IP:             c.ClientIP(),
+		ReturnURL:      req.ReturnURL,
+	})
+	if discount != nil && (err != nil || txn.Status == "failed") {
+		discounts.Unredeem(config.DB, discount)
 	}
-
-	if err := config.DB.Create(&event).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue webhook"})
+	if errors.Is(err, pricing.ErrMerchantNotFound) {
+		apierror.Respond(c, apierror.NotFound("merchant", "merchant", req.Merchant))
+		return
+	}
+	if errors.Is(err, taxes.ErrInvalidTaxID) {
+		apierror.Respond(c, apierror.Invalid("tax[tax_id]", "The customer's VAT ID is invalid."))
+		return
+	}
+	if errors.Is(err, transfers.ErrApplicationFeeAmount) {
+		apierror.Respond(c, apierror.Invalid("application_fee_amount", "The application fee must be less than the charge and needs transfer_data without an amount."))
+		return
+	}
+	if errors.Is(err, transfers.ErrSplitsExceedAmount) {
+		apierror.Respond(c, apierror.Invalid("splits", "The splits add up to more than the charge."))
+		return
+	}
+	if errors.Is(err, fx.ErrNoRate) {
+		apierror.Respond(c, apierror.New(apierror.CodeFXRateUnavailable, "There is no exchange rate from "+strings.ToLower(req.Currency)+" to the merchant's settlement currency.").WithParam("currency"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to process charge."))
 		return
 	}
 
-	utils.Metrics.IncPendingWebhooks()
+	respondCharge(c, *txn, idemKey, true)
+}
 
-	c.JSON(http.StatusCreated, ChargeResponse{
-		ID:             txn.ID,
-		Amount:         txn.Amount,
-		Currency:       txn.Currency,
-		Customer:       txn.Customer,
-		Status:         txn.Status,
-		IdempotencyKey: idemKey,
-		CreatedAt:      txn.CreatedAt.Format(time.RFC3339),
-	})
+// chargeSplits turns transfer_data or splits into the transfers of the
+// charge, checking that each destination is connected to its merchant.
+func chargeSplits(c *gin.Context, req ChargeRequest) ([]transfers.Split, bool) {
+	if req.TransferData != nil && len(req.Splits) > 0 {
+		apierror.Respond(c, apierror.Invalid("splits", "Pass transfer_data or splits, not both."))
+		return nil, false
+	}
+	var splits []transfers.Split
+	var params []string
+	if req.TransferData != nil {
+		splits = append(splits, transfers.Split{Destination: req.TransferData.Destination, Amount: req.TransferData.Amount})
+		params = append(params, "transfer_data[destination]")
+	}
+	for i, s := range req.Splits {
+		splits = append(splits, transfers.Split{Destination: s.Account, Amount: s.Amount})
+		params = append(params, "splits["+strconv.Itoa(i)+"][account]")
+	}
+	if len(splits) == 0 {
+		if req.ApplicationFeeAmount > 0 {
+			apierror.Respond(c, apierror.Invalid("application_fee_amount", "An application fee needs transfer_data."))
+			return nil, false
+		}
+		return nil, true
+	}
+
+	merchantID := req.Merchant
+	if merchantID == "" {
+		merchantID = pricing.DefaultMerchantID
+	}
+	for i, s := range splits {
+		var m models.Merchant
+		if err := config.DB.First(&m, "id = ?", s.Destination).Error; err != nil {
+			apierror.Respond(c, apierror.NotFound("merchant", params[i], s.Destination))
+			return nil, false
+		}
+		if m.PlatformID != merchantID {
+			apierror.Respond(c, apierror.Invalid(params[i], "Merchant "+m.ID+" is not a connected account of "+merchantID+"."))
+			return nil, false
+		}
+	}
+	return splits, true
 }
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+)
+
+type ChargeListParams struct {
+	ListParams
+	Status     string `form:"status" binding:"omitempty,oneof=pending requires_action requires_capture succeeded failed refunded canceled"`
+	Customer   string `form:"customer"`
+	Currency   string `form:"currency"`
+	AmountGTE  *int64 `form:"amount[gte]"`
+	AmountLTE  *int64 `form:"amount[lte]"`
+	CreatedGTE *int64 `form:"created[gte]"`
+	CreatedLTE *int64 `form:"created[lte]"`
+}
+
+func GetCharge(c *gin.Context) {
+	id := c.Param("id")
+
+	var txn models.Transaction
+	err := config.DB.First(&txn, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("charge", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch charge."))
+		return
+	}
+
+	c.JSON(http.StatusOK, newChargeResponse(txn, idempotencyKeyFor(txn.ID)))
+}
+
+func ListCharges(c *gin.Context) {
+	var params ChargeListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Transaction{})
+	// status and customer are indexed; keep them as plain equality filters.
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.Customer != "" {
+		query = query.Where("customer = ?", params.Customer)
+	}
+	if params.Currency != "" {
+		query = query.Where("currency = ?", strings.ToLower(params.Currency))
+	}
+	if params.AmountGTE != nil {
+		query = query.Where("amount >= ?", *params.AmountGTE)
+	}
+	if params.AmountLTE != nil {
+		query = query.Where("amount <= ?", *params.AmountLTE)
+	}
+	if params.CreatedGTE != nil {
+		query = query.Where("created_at >= ?", time.Unix(*params.CreatedGTE, 0))
+	}
+	if params.CreatedLTE != nil {
+		query = query.Where("created_at <= ?", time.Unix(*params.CreatedLTE, 0))
+	}
+
+	query = metadata.Filter(query, metadata.ObjectCharge, c.QueryMap("metadata"))
+
+	txns, hasMore, apiErr := paginate[models.Transaction](query, models.Transaction{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]ChargeResponse, 0, len(txns))
+	for _, txn := range txns {
+		data = append(data, newChargeResponse(txn, ""))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/charges",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func idempotencyKeyFor(txnID string) string {
+	var key models.IdempotencyKey
+	if err := config.DB.First(&key, "transaction_id = ?", txnID).Error; err != nil {
+		return ""
+	}
+	return key.ID
+}
@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type CouponRequest struct {
+	ID               string     `json:"id" binding:"omitempty,max=64"`
+	Name             string     `json:"name" binding:"omitempty,max=255"`
+	PercentOffBps    int64      `json:"percent_off_bps" binding:"omitempty,min=1,max=10000"`
+	AmountOff        int64      `json:"amount_off" binding:"omitempty,gt=0"`
+	Currency         string     `json:"currency" binding:"omitempty,len=3,alpha"`
+	Duration         string     `json:"duration" binding:"required,oneof=once repeating forever"`
+	DurationInMonths int        `json:"duration_in_months" binding:"omitempty,min=1,max=120"`
+	MaxRedemptions   int64      `json:"max_redemptions" binding:"omitempty,min=1"`
+	RedeemBy         *time.Time `json:"redeem_by"`
+}
+
+type CouponResponse struct {
+	ID               string  `json:"id"`
+	Object           string  `json:"object"`
+	Name             string  `json:"name,omitempty"`
+	PercentOffBps    int64   `json:"percent_off_bps,omitempty"`
+	AmountOff        int64   `json:"amount_off,omitempty"`
+	Currency         string  `json:"currency,omitempty"`
+	Duration         string  `json:"duration"`
+	DurationInMonths int     `json:"duration_in_months,omitempty"`
+	MaxRedemptions   int64   `json:"max_redemptions,omitempty"`
+	TimesRedeemed    int64   `json:"times_redeemed"`
+	RedeemBy         *string `json:"redeem_by"`
+	Valid            bool    `json:"valid"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+type PromotionCodeRequest struct {
+	Coupon         string     `json:"coupon" binding:"required"`
+	Code           string     `json:"code" binding:"required,alphanum,max=64"`
+	Customer       string     `json:"customer" binding:"omitempty,max=64"`
+	MaxRedemptions int64      `json:"max_redemptions" binding:"omitempty,min=1"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+type PromotionCodeListParams struct {
+	ListParams
+	Coupon string `form:"coupon"`
+	Code   string `form:"code"`
+	Active string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type PromotionCodeResponse struct {
+	ID             string  `json:"id"`
+	Object         string  `json:"object"`
+	Code           string  `json:"code"`
+	Coupon         string  `json:"coupon"`
+	Customer       string  `json:"customer,omitempty"`
+	MaxRedemptions int64   `json:"max_redemptions,omitempty"`
+	TimesRedeemed  int64   `json:"times_redeemed"`
+	ExpiresAt      *string `json:"expires_at"`
+	Active         bool    `json:"active"`
+	CreatedAt      string  `json:"created_at"`
+}
+
+// DiscountResponse itemizes the discount taken off a charge, invoice or
+// subscription.
+type DiscountResponse struct {
+	Coupon        string `json:"coupon"`
+	PromotionCode string `json:"promotion_code,omitempty"`
+	Amount        int64  `json:"amount"`
+}
+
+func newCouponResponse(cp models.Coupon) CouponResponse {
+	return CouponResponse{
+		ID:               cp.ID,
+		Object:           "coupon",
+		Name:             cp.Name,
+		PercentOffBps:    cp.PercentOffBps,
+		AmountOff:        cp.AmountOff,
+		Currency:         cp.Currency,
+		Duration:         cp.Duration,
+		DurationInMonths: cp.DurationInMonths,
+		MaxRedemptions:   cp.MaxRedemptions,
+		TimesRedeemed:    cp.TimesRedeemed,
+		RedeemBy:         formatTimePtr(cp.RedeemBy),
+		Valid:            cp.Valid,
+		CreatedAt:        cp.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newPromotionCodeResponse(p models.PromotionCode) PromotionCodeResponse {
+	return PromotionCodeResponse{
+		ID:             p.ID,
+		Object:         "promotion_code",
+		Code:           p.Code,
+		Coupon:         p.CouponID,
+		Customer:       p.CustomerID,
+		MaxRedemptions: p.MaxRedemptions,
+		TimesRedeemed:  p.TimesRedeemed,
+		ExpiresAt:      formatTimePtr(p.ExpiresAt),
+		Active:         p.Active,
+		CreatedAt:      p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newDiscountResponse(couponID, promotionCodeID string, amount int64) *DiscountResponse {
+	if couponID == "" {
+		return nil
+	}
+	return &DiscountResponse{Coupon: couponID, PromotionCode: promotionCodeID, Amount: amount}
+}
+
+// CreateCoupon adds a coupon taking either percent_off_bps or amount_off of
+// currency off. Repeating coupons need duration_in_months.
+func CreateCoupon(c *gin.Context) {
+	var req CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	cp := models.Coupon{
+		ID:             req.ID,
+		Name:           req.Name,
+		Duration:       req.Duration,
+		MaxRedemptions: req.MaxRedemptions,
+		RedeemBy:       req.RedeemBy,
+		Valid:          true,
+	}
+	if cp.ID == "" {
+		cp.ID = "coupon_" + uuid.NewString()
+	}
+	switch {
+	case req.PercentOffBps > 0 && req.AmountOff > 0:
+		apierror.Respond(c, apierror.Invalid("amount_off", "Only one of percent_off_bps and amount_off can be set."))
+		return
+	case req.PercentOffBps > 0:
+		cp.PercentOffBps = req.PercentOffBps
+	case req.AmountOff > 0:
+		if req.Currency == "" {
+			apierror.Respond(c, apierror.Missing("currency"))
+			return
+		}
+		cp.AmountOff = req.AmountOff
+		cp.Currency = strings.ToLower(req.Currency)
+	default:
+		apierror.Respond(c, apierror.Missing("percent_off_bps"))
+		return
+	}
+	if req.Duration == discounts.DurationRepeating {
+		if req.DurationInMonths == 0 {
+			apierror.Respond(c, apierror.Missing("duration_in_months"))
+			return
+		}
+		cp.DurationInMonths = req.DurationInMonths
+	}
+
+	var existing int64
+	config.DB.Model(&models.Coupon{}).Where("id = ?", cp.ID).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Coupon "+cp.ID+" already exists.").WithParam("id"))
+		return
+	}
+	if err := config.DB.Create(&cp).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create coupon."))
+		return
+	}
+	c.JSON(http.StatusCreated, newCouponResponse(cp))
+}
+
+func GetCoupon(c *gin.Context) {
+	cp, ok := loadCoupon(c, c.Param("id"), "id")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newCouponResponse(cp))
+}
+
+func ListCoupons(c *gin.Context) {
+	var params ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	list, hasMore, apiErr := paginate[models.Coupon](config.DB.Model(&models.Coupon{}), models.Coupon{}.TableName(), params)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]CouponResponse, 0, len(list))
+	for _, cp := range list {
+		data = append(data, newCouponResponse(cp))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/coupons",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// DisableCoupon stops a coupon from being redeemed again. Subscriptions that
+// already have it keep their discount.
+func DisableCoupon(c *gin.Context) {
+	cp, ok := loadCoupon(c, c.Param("id"), "id")
+	if !ok {
+		return
+	}
+	if err := config.DB.Model(&cp).Update("valid", false).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to disable coupon."))
+		return
+	}
+	c.JSON(http.StatusOK, newCouponResponse(cp))
+}
+
+// CreatePromotionCode adds a customer-facing code for a coupon. Codes are
+// case-insensitive and stored upper-case.
+func CreatePromotionCode(c *gin.Context) {
+	var req PromotionCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	cp, ok := loadCoupon(c, req.Coupon, "coupon")
+	if !ok {
+		return
+	}
+
+	p := models.PromotionCode{
+		ID:             "promo_" + uuid.NewString(),
+		Code:           strings.ToUpper(req.Code),
+		CouponID:       cp.ID,
+		CustomerID:     req.Customer,
+		MaxRedemptions: req.MaxRedemptions,
+		ExpiresAt:      req.ExpiresAt,
+		Active:         true,
+	}
+
+	var existing int64
+	config.DB.Model(&models.PromotionCode{}).Where("code = ?", p.Code).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Promotion code "+p.Code+" already exists.").WithParam("code"))
+		return
+	}
+	if err := config.DB.Create(&p).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create promotion code."))
+		return
+	}
+	c.JSON(http.StatusCreated, newPromotionCodeResponse(p))
+}
+
+func GetPromotionCode(c *gin.Context) {
+	p, ok := loadPromotionCode(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newPromotionCodeResponse(p))
+}
+
+func ListPromotionCodes(c *gin.Context) {
+	var params PromotionCodeListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.PromotionCode{})
+	if params.Coupon != "" {
+		query = query.Where("coupon_id = ?", params.Coupon)
+	}
+	if params.Code != "" {
+		query = query.Where("code = ?", strings.ToUpper(params.Code))
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.PromotionCode](query, models.PromotionCode{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]PromotionCodeResponse, 0, len(list))
+	for _, p := range list {
+		data = append(data, newPromotionCodeResponse(p))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/promotion_codes",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func DisablePromotionCode(c *gin.Context) {
+	p, ok := loadPromotionCode(c)
+	if !ok {
+		return
+	}
+	if err := config.DB.Model(&p).Update("active", false).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to disable promotion code."))
+		return
+	}
+	c.JSON(http.StatusOK, newPromotionCodeResponse(p))
+}
+
+// lookupDiscount resolves the coupon or promotion code of a request, if it
+// has one, responding with the error when it cannot be redeemed.
+func lookupDiscount(c *gin.Context, couponID, code, customerID, currency string) (*discounts.Discount, bool) {
+	if couponID == "" && code == "" {
+		return nil, true
+	}
+	d, err := discounts.Lookup(config.DB, couponID, code, customerID, currency, time.Now())
+	if err != nil {
+		respondDiscountError(c, err, couponID, code)
+		return nil, false
+	}
+	return d, true
+}
+
+func respondDiscountError(c *gin.Context, err error, couponID, code string) {
+	var notRedeemable *discounts.NotRedeemableError
+	switch {
+	case errors.As(err, &notRedeemable):
+		apierror.Respond(c, apierror.New(apierror.CodeCouponNotRedeemable, notRedeemable.Message).WithParam(notRedeemable.Param))
+	case errors.Is(err, discounts.ErrCouponNotFound):
+		apierror.Respond(c, apierror.NotFound("coupon", "coupon", couponID))
+	case errors.Is(err, discounts.ErrPromotionCodeNotFound):
+		apierror.Respond(c, apierror.NotFound("promotion_code", "promotion_code", code))
+	default:
+		apierror.Respond(c, apierror.Internal("Failed to apply discount."))
+	}
+}
+
+func loadCoupon(c *gin.Context, id, param string) (models.Coupon, bool) {
+	var cp models.Coupon
+	err := config.DB.First(&cp, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("coupon", param, id))
+		return cp, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch coupon."))
+		return cp, false
+	}
+	return cp, true
+}
+
+func loadPromotionCode(c *gin.Context) (models.PromotionCode, bool) {
+	id := c.Param("id")
+
+	var p models.PromotionCode
+	err := config.DB.First(&p, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("promotion_code", "id", id))
+		return p, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch promotion code."))
+		return p, false
+	}
+	return p, true
+}
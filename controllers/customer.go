@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/taxes"
+	"github.com/vaidikcode/minipay/wallets"
+	"gorm.io/gorm"
+)
+
+type CustomerRequest struct {
+	ID         string            `json:"id" binding:"omitempty,max=64"`
+	Email      string            `json:"email" binding:"omitempty,email"`
+	Name       string            `json:"name" binding:"omitempty,max=255"`
+	Country    string            `json:"country" binding:"omitempty,len=2,alpha"`
+	Region     string            `json:"region" binding:"omitempty,max=16"`
+	PostalCode string            `json:"postal_code" binding:"omitempty,max=16"`
+	TaxID      string            `json:"tax_id" binding:"omitempty,max=32"`
+	KYCTier    string            `json:"kyc_tier" binding:"omitempty,oneof=none basic verified"`
+	Card       *CardRequest      `json:"card"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+type CustomerUpdateRequest struct {
+	Email      *string           `json:"email" binding:"omitempty,email"`
+	Name       *string           `json:"name" binding:"omitempty,max=255"`
+	Country    *string           `json:"country" binding:"omitempty,len=2,alpha"`
+	Region     *string           `json:"region" binding:"omitempty,max=16"`
+	PostalCode *string           `json:"postal_code" binding:"omitempty,max=16"`
+	TaxID      *string           `json:"tax_id" binding:"omitempty,max=32"`
+	KYCTier    *string           `json:"kyc_tier" binding:"omitempty,oneof=none basic verified"`
+	Card       *CardRequest      `json:"card"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+type CustomerCard struct {
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth int    `json:"exp_month"`
+	ExpYear  int    `json:"exp_year"`
+}
+
+type CustomerResponse struct {
+	ID         string          `json:"id"`
+	Email      string          `json:"email,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Country    string          `json:"country,omitempty"`
+	Region     string          `json:"region,omitempty"`
+	PostalCode string          `json:"postal_code,omitempty"`
+	TaxID      string          `json:"tax_id,omitempty"`
+	KYCTier    string          `json:"kyc_tier"`
+	Card       *CustomerCard   `json:"card"`
+	Metadata   models.Metadata `json:"metadata"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type CustomerListParams struct {
+	ListParams
+	Email string `form:"email"`
+}
+
+func newCustomerResponse(cust models.Customer) CustomerResponse {
+	resp := CustomerResponse{
+		ID:         cust.ID,
+		Email:      cust.Email,
+		Name:       cust.Name,
+		Country:    cust.Country,
+		Region:     cust.Region,
+		PostalCode: cust.PostalCode,
+		TaxID:      cust.TaxID,
+		KYCTier:    cust.KYCTier,
+		Metadata:   cust.Metadata,
+		CreatedAt:  cust.CreatedAt.Format(time.RFC3339),
+	}
+	if cust.CardToken != "" {
+		resp.Card = &CustomerCard{
+			Brand:    cust.CardBrand,
+			Last4:    cust.CardLast4,
+			ExpMonth: cust.CardExpMonth,
+			ExpYear:  cust.CardExpYear,
+		}
+	}
+	return resp
+}
+
+// setCustomerCard puts card on file for cust, keeping its token rather than
+// its number. The number must pass the Luhn check; whether it can be charged
+// is only known when it is.
+func setCustomerCard(c *gin.Context, cust *models.Customer, card *CardRequest) bool {
+	if !processor.ValidLuhn(card.Number) {
+		apierror.Respond(c, apierror.Invalid("card[number]", "Your card number is invalid."))
+		return false
+	}
+	cust.CardToken = processor.Token(card.Number)
+	cust.CardExpMonth = card.ExpMonth
+	cust.CardExpYear = card.ExpYear
+	cust.CardBrand = processor.Brand(card.Number)
+	cust.CardLast4 = processor.Last4(card.Number)
+	return true
+}
+
+// setCustomerTaxID stores the normalized form of a VAT ID after checking its
+// format. An empty id clears it.
+func setCustomerTaxID(c *gin.Context, cust *models.Customer, id string) bool {
+	if id == "" {
+		cust.TaxID = ""
+		return true
+	}
+	normalized, _, err := taxes.NormalizeTaxID(id)
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("tax_id", "Invalid VAT ID: "+id+"."))
+		return false
+	}
+	cust.TaxID = normalized
+	return true
+}
+
+func CreateCustomer(c *gin.Context) {
+	var req CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	id := req.ID
+	if id == "" {
+		id = "cus_" + uuid.NewString()
+	}
+
+	cust := models.Customer{
+		ID:         id,
+		Email:      req.Email,
+		Name:       req.Name,
+		Country:    strings.ToUpper(req.Country),
+		Region:     strings.ToUpper(req.Region),
+		PostalCode: req.PostalCode,
+		KYCTier:    req.KYCTier,
+		Metadata:   metadata.Clean(req.Metadata),
+	}
+	if cust.KYCTier == "" {
+		cust.KYCTier = wallets.TierNone
+	}
+	if !setCustomerTaxID(c, &cust, req.TaxID) {
+		return
+	}
+	if req.Card != nil && !setCustomerCard(c, &cust, req.Card) {
+		return
+	}
+
+	var existing int64
+	config.DB.Model(&models.Customer{}).Where("id = ?", id).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Customer "+id+" already exists.").WithParam("id"))
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&cust).Error; err != nil {
+			return err
+		}
+		return metadata.Sync(tx, metadata.ObjectCustomer, cust.ID, cust.Metadata)
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create customer."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newCustomerResponse(cust))
+}
+
+func GetCustomer(c *gin.Context) {
+	cust, ok := loadCustomer(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newCustomerResponse(cust))
+}
+
+func UpdateCustomer(c *gin.Context) {
+	var req CustomerUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	cust, ok := loadCustomer(c)
+	if !ok {
+		return
+	}
+
+	if req.Email != nil {
+		cust.Email = *req.Email
+	}
+	if req.Name != nil {
+		cust.Name = *req.Name
+	}
+	if req.Country != nil {
+		cust.Country = strings.ToUpper(*req.Country)
+	}
+	if req.Region != nil {
+		cust.Region = strings.ToUpper(*req.Region)
+	}
+	if req.PostalCode != nil {
+		cust.PostalCode = *req.PostalCode
+	}
+	if req.TaxID != nil && !setCustomerTaxID(c, &cust, *req.TaxID) {
+		return
+	}
+	if req.KYCTier != nil {
+		cust.KYCTier = *req.KYCTier
+	}
+	if req.Card != nil && !setCustomerCard(c, &cust, req.Card) {
+		return
+	}
+	if req.Metadata != nil {
+		cust.Metadata = metadata.Merge(cust.Metadata, req.Metadata)
+		if apiErr := metadata.Validate(cust.Metadata); apiErr != nil {
+			apierror.Respond(c, apiErr)
+			return
+		}
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&cust).Error; err != nil {
+			return err
+		}
+		return metadata.Sync(tx, metadata.ObjectCustomer, cust.ID, cust.Metadata)
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update customer."))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCustomerResponse(cust))
+}
+
+func ListCustomers(c *gin.Context) {
+	var params CustomerListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Customer{})
+	if params.Email != "" {
+		query = query.Where("email = ?", params.Email)
+	}
+	query = metadata.Filter(query, metadata.ObjectCustomer, c.QueryMap("metadata"))
+
+	customers, hasMore, apiErr := paginate[models.Customer](query, models.Customer{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]CustomerResponse, 0, len(customers))
+	for _, cust := range customers {
+		data = append(data, newCustomerResponse(cust))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/customers",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func loadCustomer(c *gin.Context) (models.Customer, bool) {
+	id := c.Param("id")
+
+	var cust models.Customer
+	err := config.DB.First(&cust, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("customer", "id", id))
+		return cust, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch customer."))
+		return cust, false
+	}
+	return cust, true
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/disputes"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	maxEvidenceFileSize  = 5 << 20
+	maxEvidenceFileCount = 10
+)
+
+var evidenceContentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+type DisputeResponse struct {
+	ID                  string          `json:"id"`
+	TransactionID       string          `json:"transaction_id"`
+	Amount              int64           `json:"amount"`
+	Fee                 int64           `json:"fee"`
+	Currency            string          `json:"currency"`
+	Reason              string          `json:"reason"`
+	Status              string          `json:"status"`
+	Evidence            models.Metadata `json:"evidence"`
+	EvidenceFiles       []FileResponse  `json:"evidence_files"`
+	EvidenceDueBy       string          `json:"evidence_due_by"`
+	EvidenceSubmittedAt string          `json:"evidence_submitted_at,omitempty"`
+	ClosedAt            string          `json:"closed_at,omitempty"`
+	CreatedAt           string          `json:"created_at"`
+}
+
+type DisputeListParams struct {
+	ListParams
+	Status        string `form:"status" binding:"omitempty,oneof=needs_response under_review won lost"`
+	TransactionID string `form:"transaction_id"`
+}
+
+type TestDisputeRequest struct {
+	Reason string `json:"reason"`
+	Amount int64  `json:"amount" binding:"omitempty,gt=0"`
+}
+
+type TestDisputeCloseRequest struct {
+	Status string `json:"status" binding:"required,oneof=won lost"`
+}
+
+func newDisputeResponse(d models.Dispute, files []models.File) DisputeResponse {
+	resp := DisputeResponse{
+		ID:            d.ID,
+		TransactionID: d.TransactionID,
+		Amount:        d.Amount,
+		Fee:           d.Fee,
+		Currency:      d.Currency,
+		Reason:        d.Reason,
+		Status:        d.Status,
+		Evidence:      d.Evidence,
+		EvidenceFiles: make([]FileResponse, 0, len(files)),
+		EvidenceDueBy: d.EvidenceDueBy.Format(time.RFC3339),
+		CreatedAt:     d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.EvidenceSubmittedAt != nil {
+		resp.EvidenceSubmittedAt = d.EvidenceSubmittedAt.Format(time.RFC3339)
+	}
+	if d.ClosedAt != nil {
+		resp.ClosedAt = d.ClosedAt.Format(time.RFC3339)
+	}
+	for _, f := range files {
+		resp.EvidenceFiles = append(resp.EvidenceFiles, newFileResponse(f))
+	}
+	return resp
+}
+
+func disputeResponse(d models.Dispute) DisputeResponse {
+	var files []models.File
+	config.DB.Where("purpose = ? AND owner_id = ?", "dispute_evidence", d.ID).Order("created_at").Find(&files)
+	return newDisputeResponse(d, files)
+}
+
+func GetDispute(c *gin.Context) {
+	d, ok := loadDispute(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, disputeResponse(d))
+}
+
+func ListDisputes(c *gin.Context) {
+	var params DisputeListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Dispute{})
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.TransactionID != "" {
+		query = query.Where("transaction_id = ?", params.TransactionID)
+	}
+
+	list, hasMore, apiErr := paginate[models.Dispute](query, models.Dispute{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]DisputeResponse, 0, len(list))
+	for _, d := range list {
+		data = append(data, disputeResponse(d))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/disputes",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// SubmitDisputeEvidence accepts multipart/form-data (or a urlencoded form)
+// with evidence[<field>] text values, any number of "files" uploads and an
+// optional submit=true to send the evidence for review.
+func SubmitDisputeEvidence(c *gin.Context) {
+	d, ok := loadDispute(c)
+	if !ok {
+		return
+	}
+	if d.Status != disputes.StatusNeedsResponse {
+		apierror.Respond(c, apierror.New(apierror.CodeDisputeClosed, "Dispute "+d.ID+" is "+d.Status+" and no longer accepts evidence."))
+		return
+	}
+
+	evidence := c.PostFormMap("evidence")
+	for k, v := range evidence {
+		if !validEvidenceField(k) {
+			apierror.Respond(c, apierror.Invalid("evidence["+k+"]", "Unknown evidence field. Valid fields are "+strings.Join(disputes.EvidenceFields, ", ")+"."))
+			return
+		}
+		if len(v) > 20000 {
+			apierror.Respond(c, apierror.Invalid("evidence["+k+"]", "Evidence text can be at most 20000 characters."))
+			return
+		}
+	}
+
+	// The uploads are deleted again unless the evidence is accepted.
+	var uploads []*models.File
+	accepted := false
+	defer func() {
+		if accepted {
+			return
+		}
+		for _, f := range uploads {
+			storage.Delete(config.DB, f)
+		}
+	}()
+	if form, err := c.MultipartForm(); err == nil {
+		headers := form.File["files"]
+		if len(headers) > maxEvidenceFileCount {
+			apierror.Respond(c, apierror.Invalid("files", fmt.Sprintf("At most %d files can be uploaded at once.", maxEvidenceFileCount)))
+			return
+		}
+		for _, h := range headers {
+			if h.Size > maxEvidenceFileSize {
+				apierror.Respond(c, apierror.Invalid("files", fmt.Sprintf("%s is larger than 5 MB.", h.Filename)))
+				return
+			}
+			f, err := h.Open()
+			if err != nil {
+				apierror.Respond(c, apierror.Invalid("files", "Failed to read "+h.Filename+"."))
+				return
+			}
+			sniff := make([]byte, 512)
+			n, _ := f.Read(sniff)
+			contentType := http.DetectContentType(sniff[:n])
+			if !evidenceContentTypes[contentType] {
+				f.Close()
+				apierror.Respond(c, apierror.Invalid("files", h.Filename+" must be a PDF, PNG or JPEG file."))
+				return
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				f.Close()
+				apierror.Respond(c, apierror.Internal("Failed to read uploaded file."))
+				return
+			}
+			stored, err := storage.Save(config.DB, "dispute_evidence", d.ID, h.Filename, contentType, f)
+			f.Close()
+			if err != nil {
+				apierror.Respond(c, apierror.Internal("Failed to store uploaded file."))
+				return
+			}
+			uploads = append(uploads, stored)
+		}
+	}
+
+	submit := c.PostForm("submit") == "true"
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return disputes.SubmitEvidence(tx, &d, evidence, submit)
+	})
+	if errors.Is(err, disputes.ErrClosed) {
+		apierror.Respond(c, apierror.New(apierror.CodeDisputeClosed, "Dispute "+d.ID+" no longer accepts evidence."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to submit evidence."))
+		return
+	}
+	accepted = true
+
+	config.DB.First(&d, "id = ?", d.ID)
+	c.JSON(http.StatusOK, disputeResponse(d))
+}
+
+// CloseDispute accepts the dispute on the merchant's behalf; it is lost.
+func CloseDispute(c *gin.Context) {
+	d, ok := loadDispute(c)
+	if !ok {
+		return
+	}
+	closeDispute(c, d, disputes.StatusLost)
+}
+
+// CreateTestDispute lets the simulator open a dispute on any succeeded charge.
+func CreateTestDispute(c *gin.Context) {
+	var req TestDisputeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "general"
+	}
+	if !disputes.ValidReason(req.Reason) {
+		apierror.Respond(c, apierror.Invalid("reason", "Invalid reason. Valid reasons are "+strings.Join(disputes.Reasons, ", ")+"."))
+		return
+	}
+
+	id := c.Param("id")
+	var txn models.Transaction
+	if err := config.DB.First(&txn, "id = ?", id).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("charge", "id", id))
+		return
+	}
+
+	var d *models.Dispute
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		d, err = disputes.Open(tx, &txn, req.Reason, req.Amount)
+		return err
+	})
+	switch {
+	case errors.Is(err, disputes.ErrAlreadyDisputed):
+		apierror.Respond(c, apierror.New(apierror.CodeChargeDisputed, "Transaction "+txn.ID+" is already disputed.").WithParam("id"))
+		return
+	case errors.Is(err, disputes.ErrNotDisputable):
+		apierror.Respond(c, apierror.New(apierror.CodeChargeNotRefundable, "Cannot dispute transaction with status: "+txn.Status+".").WithParam("id"))
+		return
+	case errors.Is(err, disputes.ErrAmountTooLarge):
+		apierror.Respond(c, apierror.Invalid("amount", "Dispute amount cannot exceed the charge amount."))
+		return
+	case err != nil:
+		apierror.Respond(c, apierror.Internal("Failed to open dispute."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, disputeResponse(*d))
+}
+
+// CloseTestDispute lets the simulator rule on a dispute.
+func CloseTestDispute(c *gin.Context) {
+	var req TestDisputeCloseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	d, ok := loadDispute(c)
+	if !ok {
+		return
+	}
+	closeDispute(c, d, req.Status)
+}
+
+func closeDispute(c *gin.Context, d models.Dispute, outcome string) {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return disputes.Close(tx, &d, outcome)
+	})
+	if errors.Is(err, disputes.ErrClosed) {
+		apierror.Respond(c, apierror.New(apierror.CodeDisputeClosed, "Dispute "+d.ID+" is already closed."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to close dispute."))
+		return
+	}
+	c.JSON(http.StatusOK, disputeResponse(d))
+}
+
+func loadDispute(c *gin.Context) (models.Dispute, bool) {
+	id := c.Param("id")
+
+	var d models.Dispute
+	err := config.DB.First(&d, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("dispute", "id", id))
+		return d, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch dispute."))
+		return d, false
+	}
+	return d, true
+}
+
+func validEvidenceField(field string) bool {
+	for _, f := range disputes.EvidenceFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type FileResponse struct {
+	ID          string `json:"id"`
+	Purpose     string `json:"purpose"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	URL         string `json:"url"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func newFileResponse(f models.File) FileResponse {
+	return FileResponse{
+		ID:          f.ID,
+		Purpose:     f.Purpose,
+		Filename:    f.Filename,
+		ContentType: f.ContentType,
+		Size:        f.Size,
+		SHA256:      f.SHA256,
+		URL:         "/api/v1/files/" + f.ID + "/contents",
+		CreatedAt:   f.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func GetFile(c *gin.Context) {
+	f, ok := loadFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newFileResponse(f))
+}
+
+func DownloadFile(c *gin.Context) {
+	f, ok := loadFile(c)
+	if !ok {
+		return
+	}
+	if f.ContentType != "" {
+		c.Header("Content-Type", f.ContentType)
+	}
+	c.FileAttachment(f.Path, f.Filename)
+}
+
+func loadFile(c *gin.Context) (models.File, bool) {
+	id := c.Param("id")
+
+	var f models.File
+	err := config.DB.First(&f, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("file", "id", id))
+		return f, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch file."))
+		return f, false
+	}
+	return f, true
+}
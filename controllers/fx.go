@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+)
+
+const maxFXRateFileSize = 1 << 20
+
+type FXRateRequest struct {
+	Base  string `json:"base" binding:"required,len=3,alpha"`
+	Quote string `json:"quote" binding:"required,len=3,alpha"`
+	// Rate is the price of one unit of Base in Quote, as a decimal string
+	// with at most eight decimals.
+	Rate string `json:"rate" binding:"required"`
+}
+
+type FXRateListParams struct {
+	ListParams
+	Base  string `form:"base"`
+	Quote string `form:"quote"`
+}
+
+type FXQuoteParams struct {
+	From     string `form:"from" binding:"required,len=3,alpha"`
+	To       string `form:"to" binding:"required,len=3,alpha"`
+	Merchant string `form:"merchant" binding:"omitempty,max=64"`
+	Amount   int64  `form:"amount" binding:"omitempty,gt=0"`
+}
+
+type FXRateResponse struct {
+	ID        string `json:"id"`
+	Base      string `json:"base"`
+	Quote     string `json:"quote"`
+	Rate      string `json:"rate"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"created_at"`
+}
+
+type FXQuoteResponse struct {
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Merchant        string `json:"merchant"`
+	MidRate         string `json:"mid_rate"`
+	Rate            string `json:"rate"`
+	MarkupBps       int64  `json:"markup_bps"`
+	Amount          int64  `json:"amount,omitempty"`
+	ConvertedAmount int64  `json:"converted_amount,omitempty"`
+}
+
+type SettlementResponse struct {
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	ExchangeRate string `json:"exchange_rate"`
+	FXMarkup     int64  `json:"fx_markup"`
+}
+
+func newFXRateResponse(r models.FXRate) FXRateResponse {
+	return FXRateResponse{
+		ID:        r.ID,
+		Base:      r.Base,
+		Quote:     r.Quote,
+		Rate:      fx.FormatRate(r.Rate),
+		Source:    r.Source,
+		CreatedAt: r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newSettlementResponse(txn models.Transaction) *SettlementResponse {
+	if !fx.Converted(txn) {
+		return nil
+	}
+	return &SettlementResponse{
+		Amount:       txn.SettlementAmount,
+		Currency:     txn.SettlementCurrency,
+		ExchangeRate: fx.FormatRate(txn.ExchangeRate),
+		FXMarkup:     txn.FXMarkup,
+	}
+}
+
+// CreateFXRate loads a mid-market rate for a currency pair. It supersedes the
+// pair's previous rate for charges made from then on.
+func CreateFXRate(c *gin.Context) {
+	var req FXRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if strings.EqualFold(req.Base, req.Quote) {
+		apierror.Respond(c, apierror.Invalid("quote", "The quote currency must differ from the base currency."))
+		return
+	}
+	rate, err := fx.ParseRate(req.Rate)
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("rate", "Rate must be a positive decimal with at most eight decimals."))
+		return
+	}
+
+	r, err := fx.Add(config.DB, req.Base, req.Quote, rate, fx.SourceAPI)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create exchange rate."))
+		return
+	}
+	c.JSON(http.StatusCreated, newFXRateResponse(*r))
+}
+
+func ListFXRates(c *gin.Context) {
+	var params FXRateListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.FXRate{})
+	if params.Base != "" {
+		query = query.Where("base = ?", strings.ToLower(params.Base))
+	}
+	if params.Quote != "" {
+		query = query.Where("quote = ?", strings.ToLower(params.Quote))
+	}
+
+	list, hasMore, apiErr := paginate[models.FXRate](query, models.FXRate{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]FXRateResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newFXRateResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/fx_rates",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// ImportFXRates loads a CSV file of base,quote,rate lines. Either every rate
+// in the file is loaded or none is.
+func ImportFXRates(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		apierror.Respond(c, apierror.Missing("file"))
+		return
+	}
+	if header.Size > maxFXRateFileSize {
+		apierror.Respond(c, apierror.Invalid("file", "Rate files can be at most 1 MB."))
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("file", "Failed to read "+header.Filename+"."))
+		return
+	}
+	raw, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		apierror.Respond(c, apierror.Invalid("file", "Failed to read "+header.Filename+"."))
+		return
+	}
+
+	imported, err := fx.Import(config.DB, header.Filename, raw)
+	var syntaxErr *fx.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		apierror.Respond(c, apierror.Invalid("file", "Could not parse rates: "+syntaxErr.Error()+"."))
+		return
+	case err != nil:
+		apierror.Respond(c, apierror.Internal("Failed to import exchange rates."))
+		return
+	}
+
+	data := make([]FXRateResponse, 0, len(imported))
+	for _, r := range imported {
+		data = append(data, newFXRateResponse(r))
+	}
+	c.JSON(http.StatusCreated, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/fx_rates",
+		HasMore: false,
+		Data:    data,
+	})
+}
+
+// GetFXQuote shows the rate a merchant's charges in one currency would be
+// converted to another at right now, after the merchant's markup.
+func GetFXQuote(c *gin.Context) {
+	var params FXQuoteParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	merchantID, ok := reserveMerchant(c, params.Merchant)
+	if !ok {
+		return
+	}
+	_, plan, err := pricing.Lookup(config.DB, merchantID)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch merchant."))
+		return
+	}
+
+	quote, err := fx.NewQuote(config.DB, params.From, params.To, plan.FXMarkupBps)
+	if errors.Is(err, fx.ErrNoRate) {
+		apierror.Respond(c, apierror.New(apierror.CodeFXRateUnavailable, "There is no exchange rate from "+strings.ToLower(params.From)+" to "+strings.ToLower(params.To)+".").WithParam("to"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to quote exchange rate."))
+		return
+	}
+
+	resp := FXQuoteResponse{
+		From:      quote.From,
+		To:        quote.To,
+		Merchant:  merchantID,
+		MidRate:   fx.FormatRate(quote.Mid),
+		Rate:      fx.FormatRate(quote.Rate),
+		MarkupBps: quote.MarkupBps,
+	}
+	if params.Amount > 0 {
+		resp.Amount = params.Amount
+		resp.ConvertedAmount = fx.Convert(params.Amount, quote.Rate, quote.From, quote.To)
+	}
+	c.JSON(http.StatusOK, resp)
+}
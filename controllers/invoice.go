@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/invoices"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/taxes"
+	"gorm.io/gorm"
+)
+
+type InvoiceLineRequest struct {
+	Description string `json:"description" binding:"required,max=255"`
+	Quantity    int64  `json:"quantity" binding:"omitempty,min=1"`
+	UnitAmount  int64  `json:"unit_amount" binding:"required,gt=0"`
+	TaxRateBps  int64  `json:"tax_rate_bps" binding:"omitempty,min=0,max=10000"`
+	TaxCode     string `json:"tax_code" binding:"omitempty,max=32"`
+}
+
+type InvoiceRequest struct {
+	Customer         string               `json:"customer" binding:"required"`
+	Merchant         string               `json:"merchant" binding:"omitempty,max=64"`
+	Currency         string               `json:"currency" binding:"required,len=3,alpha"`
+	CollectionMethod string               `json:"collection_method" binding:"omitempty,oneof=charge_automatically send_invoice"`
+	DaysUntilDue     *int                 `json:"days_until_due" binding:"omitempty,min=0,max=365"`
+	Description      string               `json:"description" binding:"omitempty,max=1024"`
+	Lines            []InvoiceLineRequest `json:"lines" binding:"max=100,dive"`
+	Coupon           string               `json:"coupon" binding:"omitempty,max=64"`
+	PromotionCode    string               `json:"promotion_code" binding:"omitempty,max=64"`
+	TaxBehavior      string               `json:"tax_behavior" binding:"omitempty,oneof=exclusive inclusive"`
+	Metadata         map[string]string    `json:"metadata"`
+}
+
+type InvoicePayRequest struct {
+	Card *CardRequest `json:"card"`
+}
+
+type InvoiceListParams struct {
+	ListParams
+	Customer string `form:"customer"`
+	Merchant string `form:"merchant"`
+	Status   string `form:"status" binding:"omitempty,oneof=draft open paying paid void"`
+}
+
+type InvoiceLineResponse struct {
+	ID             string `json:"id"`
+	Description    string `json:"description"`
+	Quantity       int64  `json:"quantity"`
+	UnitAmount     int64  `json:"unit_amount"`
+	Amount         int64  `json:"amount"`
+	TaxCode        string `json:"tax_code,omitempty"`
+	TaxRateBps     int64  `json:"tax_rate_bps"`
+	DiscountAmount int64  `json:"discount_amount"`
+	Tax            int64  `json:"tax"`
+}
+
+type InvoiceResponse struct {
+	ID               string                `json:"id"`
+	Object           string                `json:"object"`
+	Number           string                `json:"number,omitempty"`
+	Customer         string                `json:"customer"`
+	Merchant         string                `json:"merchant"`
+	Currency         string                `json:"currency"`
+	Status           string                `json:"status"`
+	CollectionMethod string                `json:"collection_method"`
+	Description      string                `json:"description,omitempty"`
+	Lines            []InvoiceLineResponse `json:"lines"`
+	Subtotal         int64                 `json:"subtotal"`
+	Discount         *DiscountResponse     `json:"discount,omitempty"`
+	TaxBehavior      string                `json:"tax_behavior,omitempty"`
+	Tax              int64                 `json:"tax"`
+	Total            int64                 `json:"total"`
+	AmountPaid       int64                 `json:"amount_paid"`
+	AmountDue        int64                 `json:"amount_due"`
+	Attempts         int                   `json:"attempts"`
+	Transaction      string                `json:"transaction,omitempty"`
+	DueDate          *string               `json:"due_date"`
+	FinalizedAt      *string               `json:"finalized_at"`
+	PaidAt           *string               `json:"paid_at"`
+	PDF              string                `json:"invoice_pdf"`
+	Metadata         models.Metadata       `json:"metadata"`
+	CreatedAt        string                `json:"created_at"`
+}
+
+func newInvoiceResponse(inv models.Invoice, lines []models.InvoiceLine) InvoiceResponse {
+	resp := InvoiceResponse{
+		ID:               inv.ID,
+		Object:           "invoice",
+		Number:           inv.Number,
+		Customer:         inv.CustomerID,
+		Merchant:         inv.MerchantID,
+		Currency:         inv.Currency,
+		Status:           inv.Status,
+		CollectionMethod: inv.CollectionMethod,
+		Description:      inv.Description,
+		Lines:            make([]InvoiceLineResponse, 0, len(lines)),
+		Subtotal:         inv.Subtotal,
+		Discount:         newDiscountResponse(inv.CouponID, inv.PromotionCodeID, inv.Discount),
+		TaxBehavior:      inv.TaxBehavior,
+		Tax:              inv.Tax,
+		Total:            inv.Total,
+		AmountPaid:       inv.AmountPaid,
+		AmountDue:        inv.Total - inv.AmountPaid,
+		Attempts:         inv.Attempts,
+		Transaction:      inv.TransactionID,
+		DueDate:          formatTimePtr(inv.DueDate),
+		FinalizedAt:      formatTimePtr(inv.FinalizedAt),
+		PaidAt:           formatTimePtr(inv.PaidAt),
+		PDF:              "/api/v1/invoices/" + inv.ID + "/pdf",
+		Metadata:         inv.Metadata,
+		CreatedAt:        inv.CreatedAt.Format(time.RFC3339),
+	}
+	if inv.Status == invoices.StatusVoid {
+		resp.AmountDue = 0
+	}
+	for _, l := range lines {
+		resp.Lines = append(resp.Lines, InvoiceLineResponse{
+			ID:             l.ID,
+			Description:    l.Description,
+			Quantity:       l.Quantity,
+			UnitAmount:     l.UnitAmount,
+			Amount:         l.Amount,
+			TaxCode:        l.TaxCode,
+			TaxRateBps:     l.TaxRateBps,
+			DiscountAmount: l.DiscountAmount,
+			Tax:            l.Tax,
+		})
+	}
+	return resp
+}
+
+func newInvoiceLine(req InvoiceLineRequest) *models.InvoiceLine {
+	return &models.InvoiceLine{
+		Description: req.Description,
+		Quantity:    req.Quantity,
+		UnitAmount:  req.UnitAmount,
+		TaxRateBps:  req.TaxRateBps,
+		TaxCode:     req.TaxCode,
+	}
+}
+
+// checkInvoiceLine rejects a line whose tax does not fit its invoice: lines
+// of an invoice taxed from the rate table take a tax code rather than a rate.
+func checkInvoiceLine(c *gin.Context, inv models.Invoice, req InvoiceLineRequest, field string) bool {
+	if req.TaxCode != "" && !taxes.ValidCode(req.TaxCode) {
+		apierror.Respond(c, invalidTaxCode(nestedParam(field, "tax_code")))
+		return false
+	}
+	if inv.TaxBehavior != "" && req.TaxRateBps != 0 {
+		apierror.Respond(c, apierror.Invalid(nestedParam(field, "tax_rate_bps"), "tax_rate_bps cannot be set on invoices with a tax_behavior; the rate comes from the tax rate table."))
+		return false
+	}
+	return true
+}
+
+// CreateInvoice starts a draft invoice, optionally with its line items.
+func CreateInvoice(c *gin.Context) {
+	var req InvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	var count int64
+	config.DB.Model(&models.Customer{}).Where("id = ?", req.Customer).Count(&count)
+	if count == 0 {
+		apierror.Respond(c, apierror.NotFound("customer", "customer", req.Customer))
+		return
+	}
+	merchantID, ok := reserveMerchant(c, req.Merchant)
+	if !ok {
+		return
+	}
+	discount, ok := lookupDiscount(c, req.Coupon, req.PromotionCode, req.Customer, req.Currency)
+	if !ok {
+		return
+	}
+
+	inv := models.Invoice{
+		ID:               "in_" + uuid.NewString(),
+		MerchantID:       merchantID,
+		CustomerID:       req.Customer,
+		Currency:         strings.ToLower(req.Currency),
+		Status:           invoices.StatusDraft,
+		CollectionMethod: req.CollectionMethod,
+		Description:      req.Description,
+		TaxBehavior:      req.TaxBehavior,
+		Metadata:         metadata.Clean(req.Metadata),
+	}
+	for i, l := range req.Lines {
+		if !checkInvoiceLine(c, inv, l, "lines["+strconv.Itoa(i)+"]") {
+			return
+		}
+	}
+	if discount != nil {
+		inv.CouponID = discount.Coupon.ID
+		inv.PromotionCodeID = discount.PromotionCodeID()
+	}
+	if inv.CollectionMethod == "" {
+		inv.CollectionMethod = invoices.ChargeAutomatically
+	}
+	if req.DaysUntilDue != nil && inv.CollectionMethod != invoices.SendInvoice {
+		apierror.Respond(c, apierror.Invalid("days_until_due", "days_until_due can only be set for send_invoice invoices."))
+		return
+	}
+	if inv.CollectionMethod == invoices.SendInvoice {
+		inv.DaysUntilDue = invoices.DefaultDaysUntilDue
+		if req.DaysUntilDue != nil {
+			inv.DaysUntilDue = *req.DaysUntilDue
+		}
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&inv).Error; err != nil {
+			return err
+		}
+		for _, l := range req.Lines {
+			if err := invoices.AddLine(tx, &inv, newInvoiceLine(l)); err != nil {
+				return err
+			}
+		}
+		return events.Enqueue(tx, inv.ID, "invoice.created", invoices.Payload(inv))
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create invoice."))
+		return
+	}
+
+	respondInvoice(c, http.StatusCreated, inv)
+}
+
+func GetInvoice(c *gin.Context) {
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+	respondInvoice(c, http.StatusOK, inv)
+}
+
+func ListInvoices(c *gin.Context) {
+	var params InvoiceListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Invoice{})
+	if params.Customer != "" {
+		query = query.Where("customer_id = ?", params.Customer)
+	}
+	if params.Merchant != "" {
+		query = query.Where("merchant_id = ?", params.Merchant)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+
+	list, hasMore, apiErr := paginate[models.Invoice](query, models.Invoice{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]InvoiceResponse, 0, len(list))
+	for _, inv := range list {
+		lines, err := invoices.Lines(config.DB, inv.ID)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch invoice lines."))
+			return
+		}
+		data = append(data, newInvoiceResponse(inv, lines))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/invoices",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// AddInvoiceLine adds a line item to a draft invoice.
+func AddInvoiceLine(c *gin.Context) {
+	var req InvoiceLineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	inv, ok := loadInvoice(c)
+	if !ok || !checkInvoiceLine(c, inv, req, "") {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return invoices.AddLine(tx, &inv, newInvoiceLine(req))
+	})
+	if errors.Is(err, invoices.ErrNotDraft) {
+		apierror.Respond(c, apierror.New(apierror.CodeInvoiceNotEditable, "Invoice "+inv.ID+" has been finalized and can no longer be edited."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to add invoice line."))
+		return
+	}
+	respondInvoice(c, http.StatusOK, inv)
+}
+
+// FinalizeInvoice numbers a draft invoice and opens it for payment. Invoices
+// charged automatically are paid from the customer's card on file right
+// away; when that fails the invoice stays open and can be paid later.
+func FinalizeInvoice(c *gin.Context) {
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	_, err := invoices.Finalize(config.DB, &inv, time.Now())
+	if errors.Is(err, invoices.ErrNotDraft) {
+		apierror.Respond(c, apierror.New(apierror.CodeInvoiceNotEditable, "Invoice "+inv.ID+" has already been finalized."))
+		return
+	}
+	if errors.Is(err, invoices.ErrNoLines) {
+		apierror.Respond(c, apierror.Invalid("lines", "Invoice "+inv.ID+" has no line items."))
+		return
+	}
+	var notRedeemable *discounts.NotRedeemableError
+	if errors.As(err, &notRedeemable) {
+		respondDiscountError(c, err, inv.CouponID, "")
+		return
+	}
+	if err != nil && !errors.Is(err, invoices.ErrPaymentFailed) && !errors.Is(err, invoices.ErrNoCard) {
+		apierror.Respond(c, apierror.Internal("Failed to finalize invoice."))
+		return
+	}
+	respondInvoice(c, http.StatusOK, inv)
+}
+
+// PayInvoice collects an open invoice from the given card, or from the
+// customer's card on file.
+func PayInvoice(c *gin.Context) {
+	var req InvoicePayRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.FromBinding(err))
+			return
+		}
+	}
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	var card *processor.Card
+	if req.Card != nil {
+		card = &processor.Card{
+			Number:   req.Card.Number,
+			ExpMonth: req.Card.ExpMonth,
+			ExpYear:  req.Card.ExpYear,
+			CVC:      req.Card.CVC,
+		}
+	}
+
+	txn, err := invoices.Pay(config.DB, &inv, card, time.Now())
+	if errors.Is(err, invoices.ErrNotOpen) {
+		apierror.Respond(c, apierror.New(apierror.CodeInvoiceNotOpen, "Invoice "+inv.ID+" is "+inv.Status+" and cannot be paid."))
+		return
+	}
+	if errors.Is(err, invoices.ErrNoCard) {
+		apierror.Respond(c, apierror.Invalid("card", "Customer "+inv.CustomerID+" has no card on file."))
+		return
+	}
+	if errors.Is(err, invoices.ErrPaymentFailed) {
+		apierror.Respond(c, apierror.New(apierror.CodePaymentFailed, txn.FailureMessage+" ("+txn.ID+")").WithParam("card"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to pay invoice."))
+		return
+	}
+	respondInvoice(c, http.StatusOK, inv)
+}
+
+func VoidInvoice(c *gin.Context) {
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return invoices.Void(tx, &inv, time.Now())
+	})
+	if errors.Is(err, invoices.ErrNotOpen) {
+		apierror.Respond(c, apierror.New(apierror.CodeInvoiceNotOpen, "Invoice "+inv.ID+" is "+inv.Status+" and cannot be voided."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to void invoice."))
+		return
+	}
+	respondInvoice(c, http.StatusOK, inv)
+}
+
+// DownloadInvoicePDF renders the invoice as it stands.
+func DownloadInvoicePDF(c *gin.Context) {
+	inv, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+	lines, err := invoices.Lines(config.DB, inv.ID)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch invoice lines."))
+		return
+	}
+	merchant := models.Merchant{ID: inv.MerchantID}
+	config.DB.First(&merchant, "id = ?", inv.MerchantID)
+	cust := models.Customer{ID: inv.CustomerID}
+	config.DB.First(&cust, "id = ?", inv.CustomerID)
+
+	var buf bytes.Buffer
+	if err := invoices.Render(&buf, inv, lines, merchant, cust); err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to render invoice."))
+		return
+	}
+	filename := inv.ID + ".pdf"
+	if inv.Number != "" {
+		filename = inv.Number + ".pdf"
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+func respondInvoice(c *gin.Context, status int, inv models.Invoice) {
+	lines, err := invoices.Lines(config.DB, inv.ID)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch invoice lines."))
+		return
+	}
+	c.JSON(status, newInvoiceResponse(inv, lines))
+}
+
+func loadInvoice(c *gin.Context) (models.Invoice, bool) {
+	id := c.Param("id")
+
+	var inv models.Invoice
+	err := config.DB.First(&inv, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("invoice", "id", id))
+		return inv, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch invoice."))
+		return inv, false
+	}
+	return inv, true
+}
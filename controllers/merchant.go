@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/settlement"
+	"gorm.io/gorm"
+)
+
+type MerchantRequest struct {
+	ID                  string `json:"id" binding:"omitempty,max=64"`
+	Name                string `json:"name" binding:"omitempty,max=255"`
+	Country             string `json:"country" binding:"omitempty,len=2,alpha"`
+	PricingPlan         string `json:"pricing_plan" binding:"omitempty,max=64"`
+	SettlementDelayDays *int   `json:"settlement_delay_days" binding:"omitempty,min=0,max=30"`
+	SettlementCurrency  string `json:"settlement_currency" binding:"omitempty,len=3,alpha"`
+	// Platform is the merchant this is a connected account of, such as
+	// acct_default. Only the platform keeps its balance in the plain merchant
+	// accounts, so every other merchant is a connected account with a
+	// balance of its own.
+	Platform string `json:"platform" binding:"required,max=64"`
+}
+
+type MerchantUpdateRequest struct {
+	Name                *string `json:"name" binding:"omitempty,max=255"`
+	Country             *string `json:"country" binding:"omitempty,len=2,alpha"`
+	PricingPlan         *string `json:"pricing_plan" binding:"omitempty,max=64"`
+	SettlementDelayDays *int    `json:"settlement_delay_days" binding:"omitempty,min=0,max=30"`
+	// SettlementCurrency set to "" settles every charge in the currency it
+	// was made in.
+	SettlementCurrency *string `json:"settlement_currency" binding:"omitempty,max=3"`
+}
+
+type MerchantResponse struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name,omitempty"`
+	Country             string `json:"country,omitempty"`
+	PricingPlan         string `json:"pricing_plan"`
+	SettlementDelayDays int    `json:"settlement_delay_days"`
+	SettlementCurrency  string `json:"settlement_currency,omitempty"`
+	Platform            string `json:"platform,omitempty"`
+	CreatedAt           string `json:"created_at"`
+}
+
+type MerchantListParams struct {
+	ListParams
+	Platform string `form:"platform"`
+}
+
+// MerchantBalanceResponse is what the ledger holds for a connected account
+// or the platform.
+type MerchantBalanceResponse struct {
+	Merchant  string          `json:"merchant"`
+	Available []BalanceAmount `json:"available"`
+	Pending   []BalanceAmount `json:"pending"`
+	Reserved  []BalanceAmount `json:"reserved"`
+}
+
+func newMerchantResponse(m models.Merchant) MerchantResponse {
+	return MerchantResponse{
+		ID:                  m.ID,
+		Name:                m.Name,
+		Country:             m.Country,
+		PricingPlan:         m.PricingPlanID,
+		SettlementDelayDays: m.SettlementDelayDays,
+		SettlementCurrency:  m.SettlementCurrency,
+		Platform:            m.PlatformID,
+		CreatedAt:           m.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func CreateMerchant(c *gin.Context) {
+	var req MerchantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	m := models.Merchant{
+		ID:                  req.ID,
+		Name:                req.Name,
+		Country:             strings.ToUpper(req.Country),
+		PricingPlanID:       req.PricingPlan,
+		SettlementDelayDays: settlement.DefaultDelayDays,
+		SettlementCurrency:  strings.ToLower(req.SettlementCurrency),
+		PlatformID:          req.Platform,
+	}
+	if req.SettlementDelayDays != nil {
+		m.SettlementDelayDays = *req.SettlementDelayDays
+	}
+	if m.ID == "" {
+		m.ID = "acct_" + uuid.NewString()
+	}
+	if m.PricingPlanID == "" {
+		m.PricingPlanID = pricing.DefaultPlanID
+	}
+	if !pricingPlanExists(c, m.PricingPlanID) {
+		return
+	}
+	var platform models.Merchant
+	if err := config.DB.First(&platform, "id = ?", m.PlatformID).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("merchant", "platform", m.PlatformID))
+		return
+	}
+	if platform.PlatformID != "" {
+		apierror.Respond(c, apierror.Invalid("platform", "Merchant "+platform.ID+" is itself a connected account."))
+		return
+	}
+
+	var existing int64
+	config.DB.Model(&models.Merchant{}).Where("id = ?", m.ID).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Merchant "+m.ID+" already exists.").WithParam("id"))
+		return
+	}
+	if err := config.DB.Create(&m).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create merchant."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newMerchantResponse(m))
+}
+
+func GetMerchant(c *gin.Context) {
+	m, ok := loadMerchant(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newMerchantResponse(m))
+}
+
+// UpdateMerchant changes a merchant's details or moves it to another pricing
+// plan. New prices, settlement delays and settlement currencies apply to
+// charges made from then on.
+func UpdateMerchant(c *gin.Context) {
+	var req MerchantUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	m, ok := loadMerchant(c)
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		m.Name = *req.Name
+	}
+	if req.Country != nil {
+		m.Country = strings.ToUpper(*req.Country)
+	}
+	if req.PricingPlan != nil {
+		if !pricingPlanExists(c, *req.PricingPlan) {
+			return
+		}
+		m.PricingPlanID = *req.PricingPlan
+	}
+	if req.SettlementDelayDays != nil {
+		m.SettlementDelayDays = *req.SettlementDelayDays
+	}
+	if req.SettlementCurrency != nil {
+		currency := strings.ToLower(*req.SettlementCurrency)
+		if currency != "" && len(currency) != 3 {
+			apierror.Respond(c, apierror.Invalid("settlement_currency", "Settlement currency must be a three-letter currency code."))
+			return
+		}
+		m.SettlementCurrency = currency
+	}
+	if err := config.DB.Save(&m).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update merchant."))
+		return
+	}
+	c.JSON(http.StatusOK, newMerchantResponse(m))
+}
+
+func ListMerchants(c *gin.Context) {
+	var params MerchantListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Merchant{})
+	if params.Platform != "" {
+		query = query.Where("platform_id = ?", params.Platform)
+	}
+
+	list, hasMore, apiErr := paginate[models.Merchant](query, models.Merchant{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]MerchantResponse, 0, len(list))
+	for _, m := range list {
+		data = append(data, newMerchantResponse(m))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/merchants",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// GetMerchantBalance shows a connected account's own balance. Other
+// merchants are shown the platform's.
+func GetMerchantBalance(c *gin.Context) {
+	m, ok := loadMerchant(c)
+	if !ok {
+		return
+	}
+
+	resp := MerchantBalanceResponse{Merchant: m.ID}
+	for _, b := range []struct {
+		account string
+		dst     *[]BalanceAmount
+	}{
+		{ledger.AccountMerchantAvailable, &resp.Available},
+		{ledger.AccountMerchantPending, &resp.Pending},
+		{ledger.AccountMerchantReserved, &resp.Reserved},
+	} {
+		account, err := balance.Account(config.DB, m.ID, b.account)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+			return
+		}
+		balances, err := ledger.Balances(config.DB, account)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch balance."))
+			return
+		}
+		*b.dst = balanceAmounts(balances)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// connectedAccount checks that id, given as param, is a connected account.
+func connectedAccount(c *gin.Context, id, param string) bool {
+	var m models.Merchant
+	if err := config.DB.First(&m, "id = ?", id).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("merchant", param, id))
+		return false
+	}
+	if m.PlatformID == "" {
+		apierror.Respond(c, apierror.Invalid(param, "Merchant "+id+" is not a connected account."))
+		return false
+	}
+	return true
+}
+
+func pricingPlanExists(c *gin.Context, id string) bool {
+	var count int64
+	config.DB.Model(&models.PricingPlan{}).Where("id = ?", id).Count(&count)
+	if count == 0 {
+		apierror.Respond(c, apierror.NotFound("pricing_plan", "pricing_plan", id))
+		return false
+	}
+	return true
+}
+
+func loadMerchant(c *gin.Context) (models.Merchant, bool) {
+	id := c.Param("id")
+
+	var m models.Merchant
+	err := config.DB.First(&m, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("merchant", "id", id))
+		return m, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch merchant."))
+		return m, false
+	}
+	return m, true
+}
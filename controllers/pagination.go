@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/apierror"
+)
+
+const (
+	defaultListLimit = 10
+	maxListLimit     = 100
+)
+
+type ListParams struct {
+	Limit         int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	StartingAfter string `form:"starting_after"`
+	EndingBefore  string `form:"ending_before"`
+}
+
+type ListResponse struct {
+	Object  string      `json:"object"`
+	URL     string      `json:"url"`
+	HasMore bool        `json:"has_more"`
+	Data    interface{} `json:"data"`
+}
+
+// paginate runs query newest first, keyed on (created_at, id) so that rows
+// created in the same instant still page deterministically. The cursor object
+// must live in table.
+func paginate[T any](query *gorm.DB, table string, params ListParams) ([]T, bool, *apierror.Error) {
+	if params.StartingAfter != "" && params.EndingBefore != "" {
+		return nil, false, apierror.Invalid("ending_before", "Only one of starting_after and ending_before may be supplied.")
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	reverse := false
+	switch {
+	case params.StartingAfter != "":
+		cursor, apiErr := loadCursor(query, table, params.StartingAfter, "starting_after")
+		if apiErr != nil {
+			return nil, false, apiErr
+		}
+		query = query.Where("((created_at < ?) OR (created_at = ? AND id < ?))", cursor, cursor, params.StartingAfter).
+			Order("created_at DESC").Order("id DESC")
+	case params.EndingBefore != "":
+		cursor, apiErr := loadCursor(query, table, params.EndingBefore, "ending_before")
+		if apiErr != nil {
+			return nil, false, apiErr
+		}
+		query = query.Where("((created_at > ?) OR (created_at = ? AND id > ?))", cursor, cursor, params.EndingBefore).
+			Order("created_at ASC").Order("id ASC")
+		reverse = true
+	default:
+		query = query.Order("created_at DESC").Order("id DESC")
+	}
+
+	var rows []T
+	if err := query.Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, false, apierror.Internal("Failed to list objects.")
+	}
+
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	if reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows, hasMore, nil
+}
+
+func loadCursor(query *gorm.DB, table, id, param string) (time.Time, *apierror.Error) {
+	var cursor struct {
+		CreatedAt time.Time
+	}
+	err := query.Session(&gorm.Session{NewDB: true}).Table(table).Select("created_at").Where("id = ?", id).Take(&cursor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, apierror.NotFound("object", param, id)
+	}
+	if err != nil {
+		return time.Time{}, apierror.Internal("Failed to load pagination cursor.")
+	}
+	return cursor.CreatedAt, nil
+}
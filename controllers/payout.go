@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payouts"
+	"gorm.io/gorm"
+)
+
+type PayoutRequest struct {
+	Amount      int64             `json:"amount" binding:"required,gt=0"`
+	Currency    string            `json:"currency" binding:"required"`
+	BankAccount string            `json:"bank_account"`
+	Description string            `json:"description" binding:"omitempty,max=255"`
+	Metadata    map[string]string `json:"metadata"`
+	// Merchant pays out a connected account's balance instead of the
+	// platform's.
+	Merchant string `json:"merchant" binding:"omitempty,max=64"`
+}
+
+type PayoutResponse struct {
+	ID             string          `json:"id"`
+	Amount         int64           `json:"amount"`
+	Currency       string          `json:"currency"`
+	BankAccount    string          `json:"bank_account"`
+	Status         string          `json:"status"`
+	Automatic      bool            `json:"automatic"`
+	Description    string          `json:"description,omitempty"`
+	ArrivalDate    string          `json:"arrival_date"`
+	BankFile       string          `json:"bank_file,omitempty"`
+	Wallet         string          `json:"wallet,omitempty"`
+	Merchant       string          `json:"merchant,omitempty"`
+	FailureCode    string          `json:"failure_code,omitempty"`
+	FailureMessage string          `json:"failure_message,omitempty"`
+	Metadata       models.Metadata `json:"metadata"`
+	CreatedAt      string          `json:"created_at"`
+}
+
+type PayoutListParams struct {
+	ListParams
+	Status      string `form:"status" binding:"omitempty,oneof=pending in_transit paid failed canceled"`
+	BankAccount string `form:"bank_account"`
+	Merchant    string `form:"merchant"`
+}
+
+type PayoutScheduleRequest struct {
+	Interval     string `json:"interval" binding:"required,oneof=manual daily weekly"`
+	WeeklyAnchor string `json:"weekly_anchor" binding:"omitempty,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+}
+
+type PayoutScheduleResponse struct {
+	Interval     string `json:"interval"`
+	WeeklyAnchor string `json:"weekly_anchor,omitempty"`
+	LastRunAt    string `json:"last_run_at,omitempty"`
+}
+
+type TestPayoutFailRequest struct {
+	FailureCode string `json:"failure_code"`
+}
+
+func newPayoutResponse(p models.Payout) PayoutResponse {
+	return PayoutResponse{
+		ID:             p.ID,
+		Amount:         p.Amount,
+		Currency:       p.Currency,
+		BankAccount:    p.BankAccountID,
+		Status:         p.Status,
+		Automatic:      p.Automatic,
+		Description:    p.Description,
+		ArrivalDate:    p.ArrivalDate.Format(time.RFC3339),
+		BankFile:       p.FileID,
+		Wallet:         p.WalletID,
+		Merchant:       p.MerchantID,
+		FailureCode:    p.FailureCode,
+		FailureMessage: p.FailureMessage,
+		Metadata:       p.Metadata,
+		CreatedAt:      p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newPayoutScheduleResponse(s models.PayoutSchedule) PayoutScheduleResponse {
+	resp := PayoutScheduleResponse{
+		Interval:     s.Interval,
+		WeeklyAnchor: s.WeeklyAnchor,
+	}
+	if s.LastRunAt != nil {
+		resp.LastRunAt = s.LastRunAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// CreatePayout sends part of the available balance to a bank account. Without
+// bank_account the default account for the currency is used.
+func CreatePayout(c *gin.Context) {
+	var req PayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+	currency := strings.ToLower(req.Currency)
+	if req.Merchant != "" && !connectedAccount(c, req.Merchant, "merchant") {
+		return
+	}
+
+	var ba *models.BankAccount
+	if req.BankAccount != "" {
+		var found models.BankAccount
+		if err := config.DB.First(&found, "id = ?", req.BankAccount).Error; err != nil {
+			apierror.Respond(c, apierror.NotFound("bank_account", "bank_account", req.BankAccount))
+			return
+		}
+		if found.CustomerID != "" {
+			apierror.Respond(c, apierror.Invalid("bank_account", "Bank account "+found.ID+" belongs to customer "+found.CustomerID+"."))
+			return
+		}
+		if found.MerchantID != req.Merchant {
+			owner := "the platform"
+			if found.MerchantID != "" {
+				owner = "merchant " + found.MerchantID
+			}
+			apierror.Respond(c, apierror.Invalid("bank_account", "Bank account "+found.ID+" belongs to "+owner+"."))
+			return
+		}
+		if found.Currency != currency {
+			apierror.Respond(c, apierror.Invalid("bank_account", "Bank account "+found.ID+" does not accept "+currency+" payouts."))
+			return
+		}
+		ba = &found
+	} else {
+		var err error
+		ba, err = payouts.DefaultBankAccount(config.DB, req.Merchant, currency)
+		if errors.Is(err, payouts.ErrNoBankAccount) {
+			apierror.Respond(c, apierror.Invalid("bank_account", "No bank account is registered for "+currency+"."))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to fetch bank account."))
+			return
+		}
+	}
+
+	var p *models.Payout
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		p, err = payouts.Create(tx, ba, req.Amount, req.Description, metadata.Clean(req.Metadata), false)
+		return err
+	})
+	if errors.Is(err, payouts.ErrInsufficientFunds) {
+		apierror.Respond(c, apierror.New(apierror.CodeBalanceInsufficient, "Your available "+currency+" balance is too low to pay out this amount.").WithParam("amount"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create payout."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newPayoutResponse(*p))
+}
+
+func GetPayout(c *gin.Context) {
+	p, ok := loadPayout(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newPayoutResponse(p))
+}
+
+func ListPayouts(c *gin.Context) {
+	var params PayoutListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Payout{})
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.BankAccount != "" {
+		query = query.Where("bank_account_id = ?", params.BankAccount)
+	}
+	if params.Merchant != "" {
+		query = query.Where("merchant_id = ?", params.Merchant)
+	}
+
+	list, hasMore, apiErr := paginate[models.Payout](query, models.Payout{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]PayoutResponse, 0, len(list))
+	for _, p := range list {
+		data = append(data, newPayoutResponse(p))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/payouts",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func CancelPayout(c *gin.Context) {
+	p, ok := loadPayout(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return payouts.Cancel(tx, &p)
+	})
+	if errors.Is(err, payouts.ErrNotCancelable) || errors.Is(err, payouts.ErrInvalidTransition) {
+		apierror.Respond(c, apierror.New(apierror.CodePayoutNotCancelable, "Payout "+p.ID+" is "+p.Status+" and can no longer be canceled."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to cancel payout."))
+		return
+	}
+
+	config.DB.First(&p, "id = ?", p.ID)
+	c.JSON(http.StatusOK, newPayoutResponse(p))
+}
+
+func GetPayoutSchedule(c *gin.Context) {
+	s, err := payouts.Schedule(config.DB)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch payout schedule."))
+		return
+	}
+	c.JSON(http.StatusOK, newPayoutScheduleResponse(*s))
+}
+
+func UpdatePayoutSchedule(c *gin.Context) {
+	var req PayoutScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.Interval == payouts.IntervalWeekly && req.WeeklyAnchor == "" {
+		apierror.Respond(c, apierror.Missing("weekly_anchor"))
+		return
+	}
+	if req.Interval != payouts.IntervalWeekly {
+		req.WeeklyAnchor = ""
+	}
+
+	s, err := payouts.Schedule(config.DB)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch payout schedule."))
+		return
+	}
+	s.Interval = req.Interval
+	s.WeeklyAnchor = req.WeeklyAnchor
+	if err := config.DB.Save(s).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update payout schedule."))
+		return
+	}
+	c.JSON(http.StatusOK, newPayoutScheduleResponse(*s))
+}
+
+// PayTestPayout lets the simulated bank settle a payout without waiting for
+// its arrival date.
+func PayTestPayout(c *gin.Context) {
+	p, ok := loadPayout(c)
+	if !ok {
+		return
+	}
+	settleTestPayout(c, &p, func(tx *gorm.DB) error {
+		return payouts.MarkPaid(tx, &p)
+	})
+}
+
+func FailTestPayout(c *gin.Context) {
+	var req TestPayoutFailRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.FailureCode == "" {
+		req.FailureCode = "could_not_process"
+	}
+	p, ok := loadPayout(c)
+	if !ok {
+		return
+	}
+	settleTestPayout(c, &p, func(tx *gorm.DB) error {
+		return payouts.MarkFailed(tx, &p, req.FailureCode, "The bank could not process this payout.")
+	})
+}
+
+func settleTestPayout(c *gin.Context, p *models.Payout, settle func(tx *gorm.DB) error) {
+	status := p.Status
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if p.Status == payouts.StatusPending {
+			if err := payouts.Submit(tx, p, "", time.Now()); err != nil {
+				return err
+			}
+		}
+		return settle(tx)
+	})
+	if errors.Is(err, payouts.ErrInvalidTransition) {
+		apierror.Respond(c, apierror.Invalid("id", "Payout "+p.ID+" is already "+status+"."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update payout."))
+		return
+	}
+
+	config.DB.First(p, "id = ?", p.ID)
+	c.JSON(http.StatusOK, newPayoutResponse(*p))
+}
+
+func loadPayout(c *gin.Context) (models.Payout, bool) {
+	id := c.Param("id")
+
+	var p models.Payout
+	err := config.DB.First(&p, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("payout", "id", id))
+		return p, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch payout."))
+		return p, false
+	}
+	return p, true
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/billing"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type PriceRequest struct {
+	Product       string `json:"product" binding:"required"`
+	Currency      string `json:"currency" binding:"required,len=3,alpha"`
+	UnitAmount    int64  `json:"unit_amount" binding:"required,gt=0"`
+	BillingScheme string `json:"billing_scheme" binding:"omitempty,oneof=flat per_unit"`
+	Interval      string `json:"interval" binding:"required,oneof=day week month year"`
+	IntervalCount int    `json:"interval_count" binding:"omitempty,min=1,max=36"`
+	TrialDays     int    `json:"trial_days" binding:"omitempty,min=0,max=730"`
+}
+
+type PriceListParams struct {
+	ListParams
+	Product string `form:"product"`
+	Active  string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type PriceRecurring struct {
+	Interval      string `json:"interval"`
+	IntervalCount int    `json:"interval_count"`
+	TrialDays     int    `json:"trial_days"`
+}
+
+type PriceResponse struct {
+	ID            string         `json:"id"`
+	Object        string         `json:"object"`
+	Product       string         `json:"product"`
+	Currency      string         `json:"currency"`
+	UnitAmount    int64          `json:"unit_amount"`
+	BillingScheme string         `json:"billing_scheme"`
+	Recurring     PriceRecurring `json:"recurring"`
+	Active        bool           `json:"active"`
+	CreatedAt     string         `json:"created_at"`
+}
+
+func newPriceResponse(p models.Price) PriceResponse {
+	return PriceResponse{
+		ID:            p.ID,
+		Object:        "price",
+		Product:       p.ProductID,
+		Currency:      p.Currency,
+		UnitAmount:    p.UnitAmount,
+		BillingScheme: p.BillingScheme,
+		Recurring: PriceRecurring{
+			Interval:      p.Interval,
+			IntervalCount: p.IntervalCount,
+			TrialDays:     p.TrialDays,
+		},
+		Active:    p.Active,
+		CreatedAt: p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreatePrice adds a recurring price to a product: unit_amount every
+// interval_count intervals, flat or per unit of the subscription quantity.
+func CreatePrice(c *gin.Context) {
+	var req PriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if _, ok := loadProduct(c, req.Product, "product"); !ok {
+		return
+	}
+
+	p := models.Price{
+		ID:            "price_" + uuid.NewString(),
+		ProductID:     req.Product,
+		Currency:      strings.ToLower(req.Currency),
+		UnitAmount:    req.UnitAmount,
+		BillingScheme: req.BillingScheme,
+		Interval:      req.Interval,
+		IntervalCount: req.IntervalCount,
+		TrialDays:     req.TrialDays,
+		Active:        true,
+	}
+	if p.BillingScheme == "" {
+		p.BillingScheme = billing.SchemeFlat
+	}
+	if p.IntervalCount == 0 {
+		p.IntervalCount = 1
+	}
+	if err := config.DB.Create(&p).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create price."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newPriceResponse(p))
+}
+
+func GetPrice(c *gin.Context) {
+	p, ok := loadPrice(c, c.Param("id"), "id")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newPriceResponse(p))
+}
+
+func ListPrices(c *gin.Context) {
+	var params PriceListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Price{})
+	if params.Product != "" {
+		query = query.Where("product_id = ?", params.Product)
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.Price](query, models.Price{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]PriceResponse, 0, len(list))
+	for _, p := range list {
+		data = append(data, newPriceResponse(p))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/prices",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func loadPrice(c *gin.Context, id, param string) (models.Price, bool) {
+	var p models.Price
+	err := config.DB.First(&p, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("price", param, id))
+		return p, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch price."))
+		return p, false
+	}
+	return p, true
+}
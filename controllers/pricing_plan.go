@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"gorm.io/gorm"
+)
+
+type FeeRateRequest struct {
+	PercentBps int64 `json:"percent_bps" binding:"min=0,max=10000"`
+	Fixed      int64 `json:"fixed" binding:"min=0"`
+}
+
+type PricingPlanRequest struct {
+	ID               string                    `json:"id" binding:"omitempty,max=64"`
+	Name             string                    `json:"name" binding:"required,max=255"`
+	Rates            map[string]FeeRateRequest `json:"rates" binding:"required,min=1,dive"`
+	BrandSurcharges  map[string]int64          `json:"brand_surcharges"`
+	InternationalBps int64                     `json:"international_bps" binding:"min=0,max=10000"`
+	RefundPolicy     string                    `json:"refund_policy" binding:"omitempty,oneof=keep_fee return_fee"`
+	RefundFixedFee   int64                     `json:"refund_fixed_fee" binding:"min=0"`
+	FXMarkupBps      int64                     `json:"fx_markup_bps" binding:"min=0,max=10000"`
+}
+
+type PricingPlanResponse struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Rates            models.FeeRates   `json:"rates"`
+	BrandSurcharges  models.Surcharges `json:"brand_surcharges"`
+	InternationalBps int64             `json:"international_bps"`
+	RefundPolicy     string            `json:"refund_policy"`
+	RefundFixedFee   int64             `json:"refund_fixed_fee"`
+	FXMarkupBps      int64             `json:"fx_markup_bps"`
+	CreatedAt        string            `json:"created_at"`
+}
+
+func newPricingPlanResponse(p models.PricingPlan) PricingPlanResponse {
+	resp := PricingPlanResponse{
+		ID:               p.ID,
+		Name:             p.Name,
+		Rates:            p.Rates,
+		BrandSurcharges:  p.BrandSurcharges,
+		InternationalBps: p.InternationalBps,
+		RefundPolicy:     p.RefundPolicy,
+		RefundFixedFee:   p.RefundFixedFee,
+		FXMarkupBps:      p.FXMarkupBps,
+		CreatedAt:        p.CreatedAt.Format(time.RFC3339),
+	}
+	if resp.BrandSurcharges == nil {
+		resp.BrandSurcharges = models.Surcharges{}
+	}
+	return resp
+}
+
+// CreatePricingPlan adds a plan. rates is keyed by currency, with "*" for
+// every currency not listed.
+func CreatePricingPlan(c *gin.Context) {
+	var req PricingPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	plan := models.PricingPlan{
+		ID:               req.ID,
+		Name:             req.Name,
+		Rates:            models.FeeRates{},
+		BrandSurcharges:  models.Surcharges{},
+		InternationalBps: req.InternationalBps,
+		RefundPolicy:     req.RefundPolicy,
+		RefundFixedFee:   req.RefundFixedFee,
+		FXMarkupBps:      req.FXMarkupBps,
+	}
+	if plan.ID == "" {
+		plan.ID = "plan_" + uuid.NewString()
+	}
+	if plan.RefundPolicy == "" {
+		plan.RefundPolicy = pricing.RefundKeepFee
+	}
+	for currency, rate := range req.Rates {
+		currency = strings.ToLower(currency)
+		if currency != pricing.AnyCurrency && len(currency) != 3 {
+			apierror.Respond(c, apierror.Invalid("rates", "Rates must be keyed by a three-letter currency or \"*\"."))
+			return
+		}
+		plan.Rates[currency] = models.FeeRate{PercentBps: rate.PercentBps, Fixed: rate.Fixed}
+	}
+	for brand, bps := range req.BrandSurcharges {
+		if bps < 0 || bps > 10000 {
+			apierror.Respond(c, apierror.Invalid("brand_surcharges["+brand+"]", "Surcharges must be between 0 and 10000 basis points."))
+			return
+		}
+		plan.BrandSurcharges[strings.ToLower(brand)] = bps
+	}
+
+	var existing int64
+	config.DB.Model(&models.PricingPlan{}).Where("id = ?", plan.ID).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Pricing plan "+plan.ID+" already exists.").WithParam("id"))
+		return
+	}
+	if err := config.DB.Create(&plan).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create pricing plan."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newPricingPlanResponse(plan))
+}
+
+func GetPricingPlan(c *gin.Context) {
+	id := c.Param("id")
+
+	var plan models.PricingPlan
+	err := config.DB.First(&plan, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("pricing_plan", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch pricing plan."))
+		return
+	}
+	c.JSON(http.StatusOK, newPricingPlanResponse(plan))
+}
+
+func ListPricingPlans(c *gin.Context) {
+	var params ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	plans, hasMore, apiErr := paginate[models.PricingPlan](config.DB.Model(&models.PricingPlan{}), models.PricingPlan{}.TableName(), params)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]PricingPlanResponse, 0, len(plans))
+	for _, p := range plans {
+		data = append(data, newPricingPlanResponse(p))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/pricing_plans",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
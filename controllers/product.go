@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type ProductRequest struct {
+	ID          string `json:"id" binding:"omitempty,max=64"`
+	Name        string `json:"name" binding:"required,max=255"`
+	Description string `json:"description" binding:"omitempty,max=1024"`
+}
+
+type ProductUpdateRequest struct {
+	Name        *string `json:"name" binding:"omitempty,max=255"`
+	Description *string `json:"description" binding:"omitempty,max=1024"`
+	Active      *bool   `json:"active"`
+}
+
+type ProductResponse struct {
+	ID          string `json:"id"`
+	Object      string `json:"object"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Active      bool   `json:"active"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func newProductResponse(p models.Product) ProductResponse {
+	return ProductResponse{
+		ID:          p.ID,
+		Object:      "product",
+		Name:        p.Name,
+		Description: p.Description,
+		Active:      p.Active,
+		CreatedAt:   p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func CreateProduct(c *gin.Context) {
+	var req ProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	p := models.Product{
+		ID:          req.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Active:      true,
+	}
+	if p.ID == "" {
+		p.ID = "prod_" + uuid.NewString()
+	}
+
+	var existing int64
+	config.DB.Model(&models.Product{}).Where("id = ?", p.ID).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Product "+p.ID+" already exists.").WithParam("id"))
+		return
+	}
+	if err := config.DB.Create(&p).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create product."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newProductResponse(p))
+}
+
+func GetProduct(c *gin.Context) {
+	p, ok := loadProduct(c, c.Param("id"), "id")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newProductResponse(p))
+}
+
+// UpdateProduct changes a product. An inactive product takes no new
+// subscriptions; existing ones keep renewing.
+func UpdateProduct(c *gin.Context) {
+	var req ProductUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	p, ok := loadProduct(c, c.Param("id"), "id")
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		p.Name = *req.Name
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	if err := config.DB.Save(&p).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update product."))
+		return
+	}
+	c.JSON(http.StatusOK, newProductResponse(p))
+}
+
+func ListProducts(c *gin.Context) {
+	var params ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Product{})
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.Product](query, models.Product{}.TableName(), params)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]ProductResponse, 0, len(list))
+	for _, p := range list {
+		data = append(data, newProductResponse(p))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/products",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func loadProduct(c *gin.Context, id, param string) (models.Product, bool) {
+	var p models.Product
+	err := config.DB.First(&p, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("product", param, id))
+		return p, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch product."))
+		return p, false
+	}
+	return p, true
+}
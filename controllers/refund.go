@@ -1,62 +1,162 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
 	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
 	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
 	"github.com/vaidikcode/minipay/utils"
+	"gorm.io/gorm"
 )
 
 type RefundRequest struct {
-	TransactionID string `json:"transaction_id" binding:"required"`
+	TransactionID string            `json:"transaction_id" binding:"required"`
+	Metadata      map[string]string `json:"metadata"`
 }
 
 type RefundResponse struct {
-	ID         string `json:"id"`
-	Amount     int64  `json:"amount"`
-	Status     string `json:"status"`
-	RefundedAt string `json:"refunded_at"`
+	ID                 string          `json:"id"`
+	TransactionID      string          `json:"transaction_id"`
+	Amount             int64           `json:"amount"`
+	Currency           string          `json:"currency"`
+	Fee                int64           `json:"fee"`
+	BalanceTransaction string          `json:"balance_transaction,omitempty"`
+	Status             string          `json:"status"`
+	Metadata           models.Metadata `json:"metadata"`
+	RefundedAt         string          `json:"refunded_at"`
+}
+
+type RefundListParams struct {
+	ListParams
+	TransactionID string `form:"transaction_id"`
+}
+
+func newRefundResponse(refund models.Refund) RefundResponse {
+	return RefundResponse{
+		ID:                 refund.ID,
+		TransactionID:      refund.TransactionID,
+		Amount:             refund.Amount,
+		Currency:           refund.Currency,
+		Fee:                refund.Fee,
+		BalanceTransaction: refund.BalanceTransactionID,
+		Status:             refund.Status,
+		Metadata:           refund.Metadata,
+		RefundedAt:         refund.CreatedAt.Format(time.RFC3339),
+	}
 }
 
 func Refund(c *gin.Context) {
 	var req RefundRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
 		return
 	}
 
 	var txn models.Transaction
 	if err := config.DB.First(&txn, "id = ?", req.TransactionID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "transaction not found"})
+		apierror.Respond(c, apierror.NotFound("transaction", "transaction_id", req.TransactionID))
 		return
 	}
 
-	if txn.Status != "succeeded" {
-		c.JSON(http.StatusConflict, gin.H{"error": "cannot refund transaction with status: " + txn.Status})
+	if txn.Refunded {
+		apierror.Respond(c, apierror.New(apierror.CodeChargeAlreadyRefunded, "Transaction "+txn.ID+" has already been refunded.").WithParam("transaction_id"))
 		return
 	}
 
-	if txn.Refunded {
-		c.JSON(http.StatusConflict, gin.H{"error": "transaction already refunded"})
+	if txn.Disputed {
+		apierror.Respond(c, apierror.New(apierror.CodeChargeDisputed, "Transaction "+txn.ID+" is disputed and cannot be refunded.").WithParam("transaction_id"))
 		return
 	}
 
-	txn.Refunded = true
-	txn.Status = "refunded"
-	if err := config.DB.Save(&txn).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refund transaction"})
+	if txn.WalletID != "" {
+		apierror.Respond(c, apierror.New(apierror.CodeChargeNotRefundable, "Transaction "+txn.ID+" topped up wallet "+txn.WalletID+"; withdraw from the wallet instead.").WithParam("transaction_id"))
+		return
+	}
+
+	if txn.Status != "succeeded" {
+		apierror.Respond(c, apierror.New(apierror.CodeChargeNotRefundable, "Cannot refund transaction with status: "+txn.Status+".").WithParam("transaction_id"))
+		return
+	}
+
+	var refund *models.Refund
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refund, err = payments.Refund(tx, &txn, metadata.Clean(req.Metadata))
+		return err
+	})
+	if errors.Is(err, payments.ErrAlreadyRefunded) {
+		apierror.Respond(c, apierror.New(apierror.CodeChargeAlreadyRefunded, "Transaction "+txn.ID+" has already been refunded.").WithParam("transaction_id"))
+		return
+	}
+	if apiErr := velocityExceeded(err); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to refund transaction."))
 		return
 	}
 
 	utils.Metrics.IncRefunds()
 
-	c.JSON(http.StatusOK, RefundResponse{
-		ID:         txn.ID,
-		Amount:     txn.Amount,
-		Status:     txn.Status,
-		RefundedAt: time.Now().Format(time.RFC3339),
+	c.JSON(http.StatusOK, newRefundResponse(*refund))
+}
+
+func GetRefund(c *gin.Context) {
+	id := c.Param("id")
+
+	var refund models.Refund
+	err := config.DB.First(&refund, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("refund", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch refund."))
+		return
+	}
+
+	c.JSON(http.StatusOK, newRefundResponse(refund))
+}
+
+func ListRefunds(c *gin.Context) {
+	var params RefundListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Refund{})
+	if params.TransactionID != "" {
+		query = query.Where("transaction_id = ?", params.TransactionID)
+	}
+	query = metadata.Filter(query, metadata.ObjectRefund, c.QueryMap("metadata"))
+
+	refunds, hasMore, apiErr := paginate[models.Refund](query, models.Refund{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]RefundResponse, 0, len(refunds))
+	for _, r := range refunds {
+		data = append(data, newRefundResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/refunds",
+		HasMore: hasMore,
+		Data:    data,
 	})
 }
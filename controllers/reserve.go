@@ -0,0 +1,364 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/reserves"
+	"gorm.io/gorm"
+)
+
+type ReserveRuleRequest struct {
+	Merchant   string `json:"merchant" binding:"omitempty,max=64"`
+	Type       string `json:"type" binding:"required,oneof=rolling fixed"`
+	PercentBps int64  `json:"percent_bps" binding:"omitempty,min=1,max=10000"`
+	HoldDays   int    `json:"hold_days" binding:"omitempty,min=1,max=3650"`
+	Amount     int64  `json:"amount" binding:"omitempty,gt=0"`
+	Currency   string `json:"currency" binding:"omitempty,len=3,alpha"`
+}
+
+type ReserveRuleResponse struct {
+	ID         string `json:"id"`
+	Object     string `json:"object"`
+	Merchant   string `json:"merchant"`
+	Type       string `json:"type"`
+	PercentBps int64  `json:"percent_bps,omitempty"`
+	HoldDays   int    `json:"hold_days,omitempty"`
+	Amount     int64  `json:"amount,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+	Active     bool   `json:"active"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func newReserveRuleResponse(r models.ReserveRule) ReserveRuleResponse {
+	return ReserveRuleResponse{
+		ID:         r.ID,
+		Object:     "reserve_rule",
+		Merchant:   r.MerchantID,
+		Type:       r.Type,
+		PercentBps: r.PercentBps,
+		HoldDays:   r.HoldDays,
+		Amount:     r.Amount,
+		Currency:   r.Currency,
+		Active:     r.Active,
+		CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+type ReserveRequest struct {
+	Merchant  string     `json:"merchant" binding:"omitempty,max=64"`
+	Amount    int64      `json:"amount" binding:"required,gt=0"`
+	Currency  string     `json:"currency" binding:"required,len=3,alpha"`
+	Reason    string     `json:"reason" binding:"required,max=255"`
+	ReleaseOn *time.Time `json:"release_on"`
+}
+
+type ReserveListParams struct {
+	ListParams
+	Merchant string `form:"merchant"`
+	Status   string `form:"status" binding:"omitempty,oneof=held released"`
+	Rule     string `form:"rule"`
+}
+
+type ReserveResponse struct {
+	ID         string  `json:"id"`
+	Object     string  `json:"object"`
+	Merchant   string  `json:"merchant"`
+	Rule       string  `json:"rule,omitempty"`
+	Type       string  `json:"type"`
+	Source     string  `json:"source,omitempty"`
+	Amount     int64   `json:"amount"`
+	Currency   string  `json:"currency"`
+	Status     string  `json:"status"`
+	Reason     string  `json:"reason,omitempty"`
+	ReleaseOn  *string `json:"release_on"`
+	ReleasedAt *string `json:"released_at"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+func newReserveResponse(r models.Reserve) ReserveResponse {
+	resp := ReserveResponse{
+		ID:        r.ID,
+		Object:    "reserve",
+		Merchant:  r.MerchantID,
+		Rule:      r.RuleID,
+		Type:      r.Type,
+		Source:    r.SourceID,
+		Amount:    r.Amount,
+		Currency:  r.Currency,
+		Status:    r.Status,
+		Reason:    r.Reason,
+		CreatedAt: r.CreatedAt.Format(time.RFC3339),
+	}
+	if r.ReleaseOn != nil {
+		s := r.ReleaseOn.Format(time.RFC3339)
+		resp.ReleaseOn = &s
+	}
+	if r.ReleasedAt != nil {
+		s := r.ReleasedAt.Format(time.RFC3339)
+		resp.ReleasedAt = &s
+	}
+	return resp
+}
+
+// CreateReserveRule adds a rule holding back part of a merchant's funds as
+// its charges settle: percent_bps for hold_days (rolling), or a fixed amount
+// of currency.
+func CreateReserveRule(c *gin.Context) {
+	var req ReserveRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	merchantID, ok := reserveMerchant(c, req.Merchant)
+	if !ok {
+		return
+	}
+
+	rule := models.ReserveRule{
+		ID:         "rr_" + uuid.NewString(),
+		MerchantID: merchantID,
+		Type:       req.Type,
+		Active:     true,
+	}
+	switch req.Type {
+	case reserves.RuleRolling:
+		if req.PercentBps == 0 {
+			apierror.Respond(c, apierror.Missing("percent_bps"))
+			return
+		}
+		if req.HoldDays == 0 {
+			apierror.Respond(c, apierror.Missing("hold_days"))
+			return
+		}
+		rule.PercentBps = req.PercentBps
+		rule.HoldDays = req.HoldDays
+	case reserves.RuleFixed:
+		if req.Amount == 0 {
+			apierror.Respond(c, apierror.Missing("amount"))
+			return
+		}
+		if req.Currency == "" {
+			apierror.Respond(c, apierror.Missing("currency"))
+			return
+		}
+		rule.Amount = req.Amount
+		rule.Currency = strings.ToLower(req.Currency)
+	}
+
+	if err := config.DB.Create(&rule).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create reserve rule."))
+		return
+	}
+	c.JSON(http.StatusCreated, newReserveRuleResponse(rule))
+}
+
+func GetReserveRule(c *gin.Context) {
+	rule, ok := loadReserveRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newReserveRuleResponse(rule))
+}
+
+func ListReserveRules(c *gin.Context) {
+	var params ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.ReserveRule{})
+	if merchant := c.Query("merchant"); merchant != "" {
+		query = query.Where("merchant_id = ?", merchant)
+	}
+
+	list, hasMore, apiErr := paginate[models.ReserveRule](query, models.ReserveRule{}.TableName(), params)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]ReserveRuleResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newReserveRuleResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/reserve_rules",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// DisableReserveRule stops a rule. A fixed rule's reserves are released at
+// once; rolling reserves are released on their schedule.
+func DisableReserveRule(c *gin.Context) {
+	rule, ok := loadReserveRule(c)
+	if !ok {
+		return
+	}
+
+	if rule.Active {
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			return reserves.DisableRule(tx, &rule, time.Now())
+		})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal("Failed to disable reserve rule."))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, newReserveRuleResponse(rule))
+}
+
+// CreateReserve places a manual hold on available funds. Without release_on
+// the hold lasts until it is released.
+func CreateReserve(c *gin.Context) {
+	var req ReserveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	merchantID, ok := reserveMerchant(c, req.Merchant)
+	if !ok {
+		return
+	}
+	currency := strings.ToLower(req.Currency)
+
+	var r *models.Reserve
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		r, err = reserves.Hold(tx, merchantID, currency, req.Amount, req.Reason, req.ReleaseOn)
+		return err
+	})
+	if errors.Is(err, reserves.ErrInsufficientFunds) {
+		apierror.Respond(c, apierror.New(apierror.CodeBalanceInsufficient, "Your available "+currency+" balance is too low to hold this amount.").WithParam("amount"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create reserve."))
+		return
+	}
+	c.JSON(http.StatusCreated, newReserveResponse(*r))
+}
+
+func GetReserve(c *gin.Context) {
+	r, ok := loadReserve(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newReserveResponse(r))
+}
+
+func ListReserves(c *gin.Context) {
+	var params ReserveListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Reserve{})
+	if params.Merchant != "" {
+		query = query.Where("merchant_id = ?", params.Merchant)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.Rule != "" {
+		query = query.Where("rule_id = ?", params.Rule)
+	}
+
+	list, hasMore, apiErr := paginate[models.Reserve](query, models.Reserve{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]ReserveResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newReserveResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/reserves",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// ReleaseReserve returns a held reserve to the available balance ahead of
+// its schedule.
+func ReleaseReserve(c *gin.Context) {
+	r, ok := loadReserve(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return reserves.Release(tx, &r, time.Now())
+	})
+	if errors.Is(err, reserves.ErrReleased) {
+		apierror.Respond(c, apierror.New(apierror.CodeReserveReleased, "Reserve "+r.ID+" has already been released."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to release reserve."))
+		return
+	}
+	c.JSON(http.StatusOK, newReserveResponse(r))
+}
+
+func reserveMerchant(c *gin.Context, merchantID string) (string, bool) {
+	if merchantID == "" {
+		return pricing.DefaultMerchantID, true
+	}
+	var count int64
+	config.DB.Model(&models.Merchant{}).Where("id = ?", merchantID).Count(&count)
+	if count == 0 {
+		apierror.Respond(c, apierror.NotFound("merchant", "merchant", merchantID))
+		return "", false
+	}
+	return merchantID, true
+}
+
+func loadReserveRule(c *gin.Context) (models.ReserveRule, bool) {
+	id := c.Param("id")
+
+	var rule models.ReserveRule
+	err := config.DB.First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("reserve_rule", "id", id))
+		return rule, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch reserve rule."))
+		return rule, false
+	}
+	return rule, true
+}
+
+func loadReserve(c *gin.Context) (models.Reserve, bool) {
+	id := c.Param("id")
+
+	var r models.Reserve
+	err := config.DB.First(&r, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("reserve", "id", id))
+		return r, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch reserve."))
+		return r, false
+	}
+	return r, true
+}
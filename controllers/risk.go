@@ -0,0 +1,465 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/reviews"
+	"github.com/vaidikcode/minipay/risk"
+	"gorm.io/gorm"
+)
+
+type RiskRuleRequest struct {
+	Name       string `json:"name" binding:"max=255"`
+	Expression string `json:"expression" binding:"required,max=1024"`
+	Action     string `json:"action" binding:"required,oneof=block review allow"`
+}
+
+type RiskRuleUpdateRequest struct {
+	Name       *string `json:"name" binding:"omitempty,max=255"`
+	Expression *string `json:"expression" binding:"omitempty,max=1024"`
+	Action     *string `json:"action" binding:"omitempty,oneof=block review allow"`
+	Active     *bool   `json:"active"`
+}
+
+type RiskRuleListParams struct {
+	ListParams
+	Action string `form:"action"`
+	Active string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type ReviewListParams struct {
+	ListParams
+	Status string `form:"status"`
+	Charge string `form:"charge"`
+}
+
+type RiskRuleResponse struct {
+	ID         string `json:"id"`
+	Object     string `json:"object"`
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	Action     string `json:"action"`
+	Active     bool   `json:"active"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ChargeRiskResponse is what screening a charge found.
+type ChargeRiskResponse struct {
+	Score   int      `json:"score"`
+	Outcome string   `json:"outcome"`
+	Rules   []string `json:"rules"`
+}
+
+// ReviewDecisionRequest is the optional body of approving or rejecting a
+// review. Analyst defaults to "api".
+type ReviewDecisionRequest struct {
+	Analyst string `json:"analyst" binding:"max=255"`
+	Note    string `json:"note" binding:"max=1024"`
+}
+
+// ReviewChargeResponse is the charge a review holds, as the analyst sees it.
+type ReviewChargeResponse struct {
+	ID       string `json:"id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Customer string `json:"customer,omitempty"`
+	Status   string `json:"status"`
+}
+
+type ReviewEventResponse struct {
+	Action    string `json:"action"`
+	Actor     string `json:"actor"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ReviewResponse struct {
+	ID            string                 `json:"id"`
+	Object        string                 `json:"object"`
+	Charge        string                 `json:"charge"`
+	Status        string                 `json:"status"`
+	ChargeDetails *ReviewChargeResponse  `json:"charge_details,omitempty"`
+	Risk          *ChargeRiskResponse    `json:"risk,omitempty"`
+	Signals       map[string]interface{} `json:"signals"`
+	DefaultAction string                 `json:"default_action"`
+	ExpiresAt     string                 `json:"expires_at"`
+	ClosedBy      string                 `json:"closed_by,omitempty"`
+	Note          string                 `json:"note,omitempty"`
+	ClosedAt      string                 `json:"closed_at,omitempty"`
+	History       []ReviewEventResponse  `json:"history"`
+	CreatedAt     string                 `json:"created_at"`
+}
+
+func newRiskRuleResponse(r models.RiskRule) RiskRuleResponse {
+	return RiskRuleResponse{
+		ID:         r.ID,
+		Object:     "risk_rule",
+		Name:       r.Name,
+		Expression: r.Expression,
+		Action:     r.Action,
+		Active:     r.Active,
+		CreatedAt:  r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newChargeRiskResponse(txn models.Transaction) *ChargeRiskResponse {
+	if txn.RiskOutcome == "" {
+		return nil
+	}
+	rules := []string(txn.RiskRules)
+	if rules == nil {
+		rules = []string{}
+	}
+	return &ChargeRiskResponse{Score: txn.RiskScore, Outcome: txn.RiskOutcome, Rules: rules}
+}
+
+func newReviewResponse(r models.Review, txn *models.Transaction, history []models.ReviewEvent) ReviewResponse {
+	signals := map[string]interface{}(r.Signals)
+	if signals == nil {
+		signals = map[string]interface{}{}
+	}
+	resp := ReviewResponse{
+		ID:            r.ID,
+		Object:        "review",
+		Charge:        r.TransactionID,
+		Status:        r.Status,
+		Signals:       signals,
+		DefaultAction: r.DefaultAction,
+		ExpiresAt:     r.ExpiresAt.Format(time.RFC3339),
+		ClosedBy:      r.ClosedBy,
+		Note:          r.Note,
+		History:       make([]ReviewEventResponse, 0, len(history)),
+		CreatedAt:     r.CreatedAt.Format(time.RFC3339),
+	}
+	if txn != nil {
+		resp.ChargeDetails = &ReviewChargeResponse{
+			ID:       txn.ID,
+			Amount:   txn.Amount,
+			Currency: txn.Currency,
+			Customer: txn.Customer,
+			Status:   txn.Status,
+		}
+		resp.Risk = newChargeRiskResponse(*txn)
+	}
+	if r.ClosedAt != nil {
+		resp.ClosedAt = r.ClosedAt.Format(time.RFC3339)
+	}
+	for _, e := range history {
+		resp.History = append(resp.History, ReviewEventResponse{
+			Action:    e.Action,
+			Actor:     e.Actor,
+			Note:      e.Note,
+			CreatedAt: e.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return resp
+}
+
+// reviewHistory loads the audit trails of rs, oldest event first, by
+// review ID.
+func reviewHistory(rs ...models.Review) (map[string][]models.ReviewEvent, error) {
+	ids := make([]string, 0, len(rs))
+	for _, r := range rs {
+		ids = append(ids, r.ID)
+	}
+	var events []models.ReviewEvent
+	if err := config.DB.Where("review_id IN ?", ids).Order("created_at, id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	byReview := make(map[string][]models.ReviewEvent, len(rs))
+	for _, e := range events {
+		byReview[e.ReviewID] = append(byReview[e.ReviewID], e)
+	}
+	return byReview, nil
+}
+
+// compileRule responds with the expression's syntax error if it does not
+// compile.
+func compileRule(c *gin.Context, expression string) bool {
+	if _, err := risk.Compile(expression); err != nil {
+		apierror.Respond(c, apierror.Invalid("expression", "Invalid expression: "+err.Error()+"."))
+		return false
+	}
+	return true
+}
+
+func CreateRiskRule(c *gin.Context) {
+	var req RiskRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if !compileRule(c, req.Expression) {
+		return
+	}
+
+	rule := models.RiskRule{
+		ID:         "rule_" + uuid.NewString(),
+		Name:       req.Name,
+		Expression: req.Expression,
+		Action:     req.Action,
+		Active:     true,
+	}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create risk rule."))
+		return
+	}
+	c.JSON(http.StatusCreated, newRiskRuleResponse(rule))
+}
+
+func GetRiskRule(c *gin.Context) {
+	rule, ok := loadRiskRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newRiskRuleResponse(rule))
+}
+
+func UpdateRiskRule(c *gin.Context) {
+	var req RiskRuleUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	rule, ok := loadRiskRule(c)
+	if !ok {
+		return
+	}
+
+	if req.Expression != nil {
+		if !compileRule(c, *req.Expression) {
+			return
+		}
+		rule.Expression = *req.Expression
+	}
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Action != nil {
+		rule.Action = *req.Action
+	}
+	if req.Active != nil {
+		rule.Active = *req.Active
+	}
+	if err := config.DB.Save(&rule).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update risk rule."))
+		return
+	}
+	c.JSON(http.StatusOK, newRiskRuleResponse(rule))
+}
+
+func ListRiskRules(c *gin.Context) {
+	var params RiskRuleListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.RiskRule{})
+	if params.Action != "" {
+		query = query.Where("action = ?", params.Action)
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	rules, hasMore, apiErr := paginate[models.RiskRule](query, models.RiskRule{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]RiskRuleResponse, 0, len(rules))
+	for _, r := range rules {
+		data = append(data, newRiskRuleResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/risk_rules",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func GetReview(c *gin.Context) {
+	review, txn, ok := loadReview(c)
+	if !ok {
+		return
+	}
+	respondReview(c, review, txn)
+}
+
+func ListReviews(c *gin.Context) {
+	var params ReviewListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Review{})
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.Charge != "" {
+		query = query.Where("transaction_id = ?", params.Charge)
+	}
+
+	list, hasMore, apiErr := paginate[models.Review](query, models.Review{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	ids := make([]string, 0, len(list))
+	for _, r := range list {
+		ids = append(ids, r.TransactionID)
+	}
+	var txns []models.Transaction
+	if err := config.DB.Where("id IN ?", ids).Find(&txns).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to list reviews."))
+		return
+	}
+	byID := make(map[string]*models.Transaction, len(txns))
+	for i := range txns {
+		byID[txns[i].ID] = &txns[i]
+	}
+	history, err := reviewHistory(list...)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to list reviews."))
+		return
+	}
+
+	data := make([]ReviewResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newReviewResponse(r, byID[r.TransactionID], history[r.ID]))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/reviews",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// ApproveReview closes a review and captures the charge it holds.
+func ApproveReview(c *gin.Context) {
+	decideReview(c, reviews.Approve, "Failed to approve review.")
+}
+
+// RejectReview closes a review and voids the charge it holds, or refunds it
+// if it was captured and has not been refunded or disputed since.
+func RejectReview(c *gin.Context) {
+	decideReview(c, reviews.Reject, "Failed to reject review.")
+}
+
+func decideReview(c *gin.Context, decide func(*gorm.DB, *models.Review, string, string) error, failure string) {
+	var req ReviewDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.Analyst == "" {
+		req.Analyst = "api"
+	}
+	review, _, ok := loadReview(c)
+	if !ok {
+		return
+	}
+
+	err := decide(config.DB, &review, req.Analyst, req.Note)
+	if errors.Is(err, risk.ErrReviewClosed) || errors.Is(err, payments.ErrAlreadyRefunded) || errors.Is(err, payments.ErrNotHeld) {
+		apierror.Respond(c, reviewClosed(review))
+		return
+	}
+	if apiErr := velocityExceeded(err); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+	var perr *processor.Error
+	if errors.As(err, &perr) {
+		apierror.Respond(c, apierror.New(apierror.CodePaymentFailed, perr.Message+" ("+review.TransactionID+")"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal(failure))
+		return
+	}
+
+	var txn models.Transaction
+	if err := config.DB.First(&txn, "id = ?", review.TransactionID).Error; err != nil {
+		apierror.Respond(c, apierror.Internal(failure))
+		return
+	}
+	respondReview(c, review, txn)
+}
+
+func respondReview(c *gin.Context, review models.Review, txn models.Transaction) {
+	history, err := reviewHistory(review)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch review."))
+		return
+	}
+	c.JSON(http.StatusOK, newReviewResponse(review, &txn, history[review.ID]))
+}
+
+// velocityExceeded is the error of a refund refused by a velocity rule, or
+// nil if err is not one.
+func velocityExceeded(err error) *apierror.Error {
+	var verr *risk.VelocityError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	return apierror.New(apierror.CodeVelocityLimitExceeded, "The refund exceeds the limit of velocity rule "+verr.Rule.ID+".")
+}
+
+func reviewClosed(r models.Review) *apierror.Error {
+	return apierror.New(apierror.CodeReviewClosed, "Review "+r.ID+" is already closed.").WithParam("id")
+}
+
+func loadRiskRule(c *gin.Context) (models.RiskRule, bool) {
+	id := c.Param("id")
+
+	var rule models.RiskRule
+	err := config.DB.First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("risk_rule", "id", id))
+		return rule, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch risk rule."))
+		return rule, false
+	}
+	return rule, true
+}
+
+func loadReview(c *gin.Context) (models.Review, models.Transaction, bool) {
+	id := c.Param("id")
+
+	var review models.Review
+	var txn models.Transaction
+	err := config.DB.First(&review, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("review", "id", id))
+		return review, txn, false
+	}
+	if err == nil {
+		err = config.DB.First(&txn, "id = ?", review.TransactionID).Error
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch review."))
+		return review, txn, false
+	}
+	return review, txn, true
+}
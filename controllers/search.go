@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/search"
+)
+
+var chargeSearchSchema = search.Schema{
+	Fields: map[string]search.Field{
+		"id":           {Column: "id", Type: search.String},
+		"status":       {Column: "status", Type: search.String},
+		"customer":     {Column: "customer", Type: search.String},
+		"currency":     {Column: "currency", Type: search.String},
+		"country":      {Column: "country", Type: search.String},
+		"processor":    {Column: "processor", Type: search.String},
+		"card_brand":   {Column: "card_brand", Type: search.String},
+		"card_last4":   {Column: "card_last4", Type: search.String},
+		"failure_code": {Column: "failure_code", Type: search.String},
+		"decline_code": {Column: "decline_code", Type: search.String},
+		"amount":       {Column: "amount", Type: search.Number},
+		"created":      {Column: "created_at", Type: search.Timestamp},
+	},
+	MetadataObject: metadata.ObjectCharge,
+}
+
+var eventSearchSchema = search.Schema{
+	Fields: map[string]search.Field{
+		"type":        {Column: "event_type", Type: search.String},
+		"status":      {Column: "status", Type: search.String},
+		"transaction": {Column: "transaction_id", Type: search.String},
+		"attempts":    {Column: "attempts", Type: search.Number},
+		"created":     {Column: "created_at", Type: search.Timestamp},
+	},
+}
+
+type SearchParams struct {
+	ListParams
+	Query string `form:"query" binding:"required"`
+}
+
+type EventResponse struct {
+	ID            uint            `json:"id"`
+	Type          string          `json:"type"`
+	TransactionID string          `json:"transaction_id"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     string          `json:"created_at"`
+}
+
+func newEventResponse(e models.WebhookEvent) EventResponse {
+	return EventResponse{
+		ID:            e.ID,
+		Type:          e.EventType,
+		TransactionID: e.TransactionID,
+		Status:        e.Status,
+		Attempts:      e.Attempts,
+		Payload:       json.RawMessage(e.Payload),
+		CreatedAt:     e.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func SearchCharges(c *gin.Context) {
+	params, query, ok := bindSearch(c, config.DB.Model(&models.Transaction{}), chargeSearchSchema)
+	if !ok {
+		return
+	}
+
+	txns, hasMore, apiErr := paginate[models.Transaction](query, models.Transaction{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]ChargeResponse, 0, len(txns))
+	for _, txn := range txns {
+		data = append(data, newChargeResponse(txn, ""))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "search_result",
+		URL:     "/api/v1/charges/search",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func SearchEvents(c *gin.Context) {
+	params, query, ok := bindSearch(c, config.DB.Model(&models.WebhookEvent{}), eventSearchSchema)
+	if !ok {
+		return
+	}
+
+	evts, hasMore, apiErr := paginate[models.WebhookEvent](query, models.WebhookEvent{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]EventResponse, 0, len(evts))
+	for _, e := range evts {
+		data = append(data, newEventResponse(e))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "search_result",
+		URL:     "/api/v1/events/search",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func bindSearch(c *gin.Context, query *gorm.DB, schema search.Schema) (SearchParams, *gorm.DB, bool) {
+	var params SearchParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return params, nil, false
+	}
+
+	compiled, err := search.Compile(params.Query, schema)
+	if err != nil {
+		var syntaxErr *search.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			apierror.Respond(c, apierror.Invalid("query", "Invalid search query: "+syntaxErr.Error()+"."))
+		} else {
+			apierror.Respond(c, apierror.Internal("Failed to compile search query."))
+		}
+		return params, nil, false
+	}
+
+	return params, query.Where(compiled.SQL, compiled.Args...), true
+}
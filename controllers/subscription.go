@@ -0,0 +1,360 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/billing"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type SubscriptionRequest struct {
+	Customer  string `json:"customer" binding:"required"`
+	Price     string `json:"price" binding:"required"`
+	Quantity  int64  `json:"quantity" binding:"omitempty,min=1"`
+	TrialDays *int   `json:"trial_days" binding:"omitempty,min=0,max=730"`
+	Merchant  string `json:"merchant" binding:"omitempty,max=64"`
+	// Coupon or PromotionCode discounts the subscription for the coupon's
+	// duration.
+	Coupon        string `json:"coupon" binding:"omitempty,max=64"`
+	PromotionCode string `json:"promotion_code" binding:"omitempty,max=64"`
+}
+
+type SubscriptionUpdateRequest struct {
+	Price             string `json:"price"`
+	Quantity          int64  `json:"quantity" binding:"omitempty,min=1"`
+	ProrationBehavior string `json:"proration_behavior" binding:"omitempty,oneof=create_prorations none"`
+}
+
+type SubscriptionCancelRequest struct {
+	AtPeriodEnd bool `json:"at_period_end"`
+}
+
+type SubscriptionListParams struct {
+	ListParams
+	Customer string `form:"customer"`
+	Price    string `form:"price"`
+	Status   string `form:"status" binding:"omitempty,oneof=trialing active past_due canceled unpaid"`
+}
+
+type DunningSettingsRequest struct {
+	RetryDays   []int  `json:"retry_days" binding:"max=10,dive,min=1,max=60"`
+	FinalAction string `json:"final_action" binding:"required,oneof=canceled unpaid"`
+}
+
+type SubscriptionResponse struct {
+	ID                 string  `json:"id"`
+	Object             string  `json:"object"`
+	Customer           string  `json:"customer"`
+	Price              string  `json:"price"`
+	Merchant           string  `json:"merchant"`
+	Quantity           int64   `json:"quantity"`
+	Status             string  `json:"status"`
+	CurrentPeriodStart string  `json:"current_period_start"`
+	CurrentPeriodEnd   string  `json:"current_period_end"`
+	TrialEnd           *string `json:"trial_end"`
+	CancelAtPeriodEnd  bool    `json:"cancel_at_period_end"`
+	CanceledAt         *string `json:"canceled_at"`
+	ProrationAmount    int64   `json:"proration_amount"`
+	AmountDue          int64   `json:"amount_due"`
+	Attempts           int     `json:"attempts"`
+	NextAttemptAt      *string `json:"next_attempt_at"`
+	LatestTransaction  string  `json:"latest_transaction,omitempty"`
+	Coupon             string  `json:"coupon,omitempty"`
+	PromotionCode      string  `json:"promotion_code,omitempty"`
+	DiscountEnd        *string `json:"discount_end,omitempty"`
+	CreatedAt          string  `json:"created_at"`
+}
+
+type DunningSettingsResponse struct {
+	RetryDays   []int  `json:"retry_days"`
+	FinalAction string `json:"final_action"`
+}
+
+func formatTimePtr(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(time.RFC3339)
+	return &s
+}
+
+func newSubscriptionResponse(s models.Subscription) SubscriptionResponse {
+	return SubscriptionResponse{
+		ID:                 s.ID,
+		Object:             "subscription",
+		Customer:           s.CustomerID,
+		Price:              s.PriceID,
+		Merchant:           s.MerchantID,
+		Quantity:           s.Quantity,
+		Status:             s.Status,
+		CurrentPeriodStart: s.CurrentPeriodStart.Format(time.RFC3339),
+		CurrentPeriodEnd:   s.CurrentPeriodEnd.Format(time.RFC3339),
+		TrialEnd:           formatTimePtr(s.TrialEnd),
+		CancelAtPeriodEnd:  s.CancelAtPeriodEnd,
+		CanceledAt:         formatTimePtr(s.CanceledAt),
+		ProrationAmount:    s.ProrationAmount,
+		AmountDue:          s.AmountDue,
+		Attempts:           s.Attempts,
+		NextAttemptAt:      formatTimePtr(s.NextAttemptAt),
+		LatestTransaction:  s.LatestTransactionID,
+		Coupon:             s.CouponID,
+		PromotionCode:      s.PromotionCodeID,
+		DiscountEnd:        formatTimePtr(s.DiscountEnd),
+		CreatedAt:          s.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newDunningSettingsResponse(d models.DunningSettings) DunningSettingsResponse {
+	days := billing.RetryDays(d)
+	if days == nil {
+		days = []int{}
+	}
+	return DunningSettingsResponse{RetryDays: days, FinalAction: d.FinalAction}
+}
+
+// CreateSubscription subscribes a customer to a price. Without a trial the
+// first period is charged to the customer's card on file at once.
+func CreateSubscription(c *gin.Context) {
+	var req SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	var cust models.Customer
+	if err := config.DB.First(&cust, "id = ?", req.Customer).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("customer", "customer", req.Customer))
+		return
+	}
+	price, ok := loadPrice(c, req.Price, "price")
+	if !ok {
+		return
+	}
+	if !price.Active {
+		apierror.Respond(c, apierror.Invalid("price", "Price "+price.ID+" is not active."))
+		return
+	}
+	var product models.Product
+	if err := config.DB.First(&product, "id = ?", price.ProductID).Error; err != nil || !product.Active {
+		apierror.Respond(c, apierror.Invalid("price", "The product of price "+price.ID+" is not active."))
+		return
+	}
+	merchantID, ok := reserveMerchant(c, req.Merchant)
+	if !ok {
+		return
+	}
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	discount, ok := lookupDiscount(c, req.Coupon, req.PromotionCode, cust.ID, price.Currency)
+	if !ok {
+		return
+	}
+
+	s, txn, err := billing.Create(config.DB, cust, price, quantity, req.TrialDays, discount, merchantID, time.Now())
+	var notRedeemable *discounts.NotRedeemableError
+	if errors.As(err, &notRedeemable) {
+		respondDiscountError(c, err, req.Coupon, req.PromotionCode)
+		return
+	}
+	if errors.Is(err, billing.ErrNoCard) {
+		apierror.Respond(c, apierror.Invalid("customer", "Customer "+cust.ID+" has no card on file."))
+		return
+	}
+	if errors.Is(err, billing.ErrPaymentFailed) {
+		apierror.Respond(c, apierror.New(apierror.CodePaymentFailed, txn.FailureMessage+" ("+txn.ID+")").WithParam("customer"))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create subscription."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newSubscriptionResponse(*s))
+}
+
+func GetSubscription(c *gin.Context) {
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newSubscriptionResponse(s))
+}
+
+func ListSubscriptions(c *gin.Context) {
+	var params SubscriptionListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Subscription{})
+	if params.Customer != "" {
+		query = query.Where("customer_id = ?", params.Customer)
+	}
+	if params.Price != "" {
+		query = query.Where("price_id = ?", params.Price)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+
+	list, hasMore, apiErr := paginate[models.Subscription](query, models.Subscription{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]SubscriptionResponse, 0, len(list))
+	for _, s := range list {
+		data = append(data, newSubscriptionResponse(s))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/subscriptions",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// UpdateSubscription moves a subscription to another price or quantity. By
+// default the change is prorated into the next renewal.
+func UpdateSubscription(c *gin.Context) {
+	var req SubscriptionUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+
+	priceID := req.Price
+	if priceID == "" {
+		priceID = s.PriceID
+	}
+	price, ok := loadPrice(c, priceID, "price")
+	if !ok {
+		return
+	}
+	quantity := req.Quantity
+	if quantity == 0 {
+		quantity = s.Quantity
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return billing.ChangePrice(tx, &s, price, quantity, req.ProrationBehavior != "none", time.Now())
+	})
+	if errors.Is(err, billing.ErrEnded) {
+		apierror.Respond(c, apierror.New(apierror.CodeSubscriptionEnded, "Subscription "+s.ID+" has ended."))
+		return
+	}
+	if errors.Is(err, billing.ErrIncompatiblePrice) {
+		apierror.Respond(c, apierror.Invalid("price", "Price "+price.ID+" must have the same currency and interval as the current price."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update subscription."))
+		return
+	}
+	c.JSON(http.StatusOK, newSubscriptionResponse(s))
+}
+
+// CancelSubscription ends a subscription now, or at the end of the current
+// period with at_period_end.
+func CancelSubscription(c *gin.Context) {
+	var req SubscriptionCancelRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.FromBinding(err))
+			return
+		}
+	}
+	s, ok := loadSubscription(c)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return billing.Cancel(tx, &s, req.AtPeriodEnd, time.Now())
+	})
+	if errors.Is(err, billing.ErrEnded) {
+		apierror.Respond(c, apierror.New(apierror.CodeSubscriptionEnded, "Subscription "+s.ID+" has ended."))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to cancel subscription."))
+		return
+	}
+	c.JSON(http.StatusOK, newSubscriptionResponse(s))
+}
+
+func GetDunningSettings(c *gin.Context) {
+	d, err := billing.Dunning(config.DB)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch dunning settings."))
+		return
+	}
+	c.JSON(http.StatusOK, newDunningSettingsResponse(*d))
+}
+
+// UpdateDunningSettings sets the days after a failed renewal on which it is
+// retried, and whether the subscription is canceled or left unpaid when the
+// last retry fails.
+func UpdateDunningSettings(c *gin.Context) {
+	var req DunningSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if !sort.IntsAreSorted(req.RetryDays) {
+		apierror.Respond(c, apierror.Invalid("retry_days", "Retry days must be in increasing order."))
+		return
+	}
+	for i := 1; i < len(req.RetryDays); i++ {
+		if req.RetryDays[i] == req.RetryDays[i-1] {
+			apierror.Respond(c, apierror.Invalid("retry_days", "Retry days must be in increasing order."))
+			return
+		}
+	}
+
+	d, err := billing.Dunning(config.DB)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch dunning settings."))
+		return
+	}
+	d.RetryDays = billing.FormatRetryDays(req.RetryDays)
+	d.FinalAction = req.FinalAction
+	if err := config.DB.Save(d).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to update dunning settings."))
+		return
+	}
+	c.JSON(http.StatusOK, newDunningSettingsResponse(*d))
+}
+
+func loadSubscription(c *gin.Context) (models.Subscription, bool) {
+	id := c.Param("id")
+
+	var s models.Subscription
+	err := config.DB.First(&s, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("subscription", "id", id))
+		return s, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch subscription."))
+		return s, false
+	}
+	return s, true
+}
@@ -0,0 +1,523 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/taxes"
+	"gorm.io/gorm"
+)
+
+// TaxRequest asks for tax to be calculated on a payment. The address and VAT
+// ID default to the customer's.
+type TaxRequest struct {
+	Behavior   string `json:"behavior" binding:"required,oneof=exclusive inclusive"`
+	TaxCode    string `json:"tax_code" binding:"omitempty,max=32"`
+	Country    string `json:"country" binding:"omitempty,len=2,alpha"`
+	Region     string `json:"region" binding:"omitempty,max=16"`
+	PostalCode string `json:"postal_code" binding:"omitempty,max=16"`
+	TaxID      string `json:"tax_id" binding:"omitempty,max=32"`
+}
+
+type TaxRateRequest struct {
+	ID           string `json:"id" binding:"omitempty,max=64"`
+	Name         string `json:"name" binding:"required,max=64"`
+	Kind         string `json:"kind" binding:"required,oneof=vat sales_tax"`
+	Country      string `json:"country" binding:"required,len=2,alpha"`
+	Region       string `json:"region" binding:"omitempty,max=16"`
+	PostalPrefix string `json:"postal_prefix" binding:"omitempty,max=16"`
+	TaxCode      string `json:"tax_code" binding:"omitempty,max=32"`
+	RateBps      int64  `json:"rate_bps" binding:"min=0,max=10000"`
+}
+
+type TaxRateListParams struct {
+	ListParams
+	Country string `form:"country"`
+	Active  string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type TaxCalculationRequest struct {
+	TaxRequest
+	Amount   int64  `json:"amount" binding:"required,gt=0"`
+	Currency string `json:"currency" binding:"required,len=3,alpha"`
+	Customer string `json:"customer"`
+	Merchant string `json:"merchant" binding:"omitempty,max=64"`
+}
+
+type TaxLineListParams struct {
+	ListParams
+	Source  string `form:"source"`
+	Invoice string `form:"invoice"`
+}
+
+type TaxReportParams struct {
+	From     time.Time `form:"from" binding:"required" time_format:"2006-01-02" time_utc:"1"`
+	To       time.Time `form:"to" binding:"required" time_format:"2006-01-02" time_utc:"1"`
+	Merchant string    `form:"merchant"`
+	Currency string    `form:"currency"`
+}
+
+type TaxRateResponse struct {
+	ID           string `json:"id"`
+	Object       string `json:"object"`
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`
+	Country      string `json:"country"`
+	Region       string `json:"region,omitempty"`
+	PostalPrefix string `json:"postal_prefix,omitempty"`
+	TaxCode      string `json:"tax_code,omitempty"`
+	RateBps      int64  `json:"rate_bps"`
+	Active       bool   `json:"active"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// ChargeTaxResponse is the tax included in a charge's amount.
+type ChargeTaxResponse struct {
+	Amount   int64  `json:"amount"`
+	Behavior string `json:"behavior"`
+}
+
+type TaxCalculationResponse struct {
+	Object        string `json:"object"`
+	Currency      string `json:"currency"`
+	Behavior      string `json:"behavior"`
+	TaxCode       string `json:"tax_code"`
+	Country       string `json:"country"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	TaxRate       string `json:"tax_rate,omitempty"`
+	Jurisdiction  string `json:"jurisdiction,omitempty"`
+	RateBps       int64  `json:"rate_bps"`
+	TaxableAmount int64  `json:"taxable_amount"`
+	TaxAmount     int64  `json:"tax_amount"`
+	Total         int64  `json:"total"`
+	ReverseCharge bool   `json:"reverse_charge"`
+	CustomerTaxID string `json:"customer_tax_id,omitempty"`
+}
+
+type TaxLineResponse struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	SourceType    string `json:"source_type"`
+	Source        string `json:"source"`
+	Invoice       string `json:"invoice,omitempty"`
+	Merchant      string `json:"merchant"`
+	Customer      string `json:"customer,omitempty"`
+	Currency      string `json:"currency"`
+	Country       string `json:"country"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	TaxCode       string `json:"tax_code"`
+	TaxRate       string `json:"tax_rate,omitempty"`
+	Jurisdiction  string `json:"jurisdiction,omitempty"`
+	RateBps       int64  `json:"rate_bps"`
+	Behavior      string `json:"behavior"`
+	TaxableAmount int64  `json:"taxable_amount"`
+	TaxAmount     int64  `json:"tax_amount"`
+	ReverseCharge bool   `json:"reverse_charge"`
+	CustomerTaxID string `json:"customer_tax_id,omitempty"`
+	CreatedAt     string `json:"created_at"`
+}
+
+type TaxReportRow struct {
+	Country       string `json:"country"`
+	Region        string `json:"region,omitempty"`
+	Jurisdiction  string `json:"jurisdiction,omitempty"`
+	Kind          string `json:"kind,omitempty"`
+	RateBps       int64  `json:"rate_bps"`
+	ReverseCharge bool   `json:"reverse_charge"`
+	Currency      string `json:"currency"`
+	Count         int64  `json:"count"`
+	TaxableAmount int64  `json:"taxable_amount"`
+	TaxAmount     int64  `json:"tax_amount"`
+}
+
+type TaxReportResponse struct {
+	Object string         `json:"object"`
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Rows   []TaxReportRow `json:"rows"`
+}
+
+func newTaxRateResponse(r models.TaxRate) TaxRateResponse {
+	return TaxRateResponse{
+		ID:           r.ID,
+		Object:       "tax_rate",
+		Name:         r.Name,
+		Kind:         r.Kind,
+		Country:      r.Country,
+		Region:       r.Region,
+		PostalPrefix: r.PostalPrefix,
+		TaxCode:      r.TaxCode,
+		RateBps:      r.RateBps,
+		Active:       r.Active,
+		CreatedAt:    r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newChargeTaxResponse(txn models.Transaction) *ChargeTaxResponse {
+	if txn.TaxBehavior == "" {
+		return nil
+	}
+	return &ChargeTaxResponse{Amount: txn.TaxAmount, Behavior: txn.TaxBehavior}
+}
+
+func newTaxLineResponse(l models.TaxLine) TaxLineResponse {
+	return TaxLineResponse{
+		ID:            l.ID,
+		Object:        "tax_line",
+		SourceType:    l.SourceType,
+		Source:        l.SourceID,
+		Invoice:       l.InvoiceID,
+		Merchant:      l.MerchantID,
+		Customer:      l.CustomerID,
+		Currency:      l.Currency,
+		Country:       l.Country,
+		Region:        l.Region,
+		PostalCode:    l.PostalCode,
+		TaxCode:       l.TaxCode,
+		TaxRate:       l.TaxRateID,
+		Jurisdiction:  l.Jurisdiction,
+		RateBps:       l.RateBps,
+		Behavior:      l.Behavior,
+		TaxableAmount: l.Taxable,
+		TaxAmount:     l.Tax,
+		ReverseCharge: l.ReverseCharge,
+		CustomerTaxID: l.CustomerTaxID,
+		CreatedAt:     l.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// taxParams checks the tax of a request, if it has any, and turns it into
+// calculation parameters. Errors name their params within field, or at the
+// top level when field is empty.
+func taxParams(c *gin.Context, req *TaxRequest, field string) (*taxes.Params, bool) {
+	if req == nil {
+		return nil, true
+	}
+	if req.TaxCode != "" && !taxes.ValidCode(req.TaxCode) {
+		apierror.Respond(c, invalidTaxCode(nestedParam(field, "tax_code")))
+		return nil, false
+	}
+	if req.TaxID != "" {
+		if _, _, err := taxes.NormalizeTaxID(req.TaxID); err != nil {
+			apierror.Respond(c, apierror.Invalid(nestedParam(field, "tax_id"), "Invalid VAT ID: "+req.TaxID+"."))
+			return nil, false
+		}
+	}
+	return &taxes.Params{
+		Behavior:      req.Behavior,
+		TaxCode:       req.TaxCode,
+		Address:       taxes.Address{Country: req.Country, Region: req.Region, PostalCode: req.PostalCode},
+		CustomerTaxID: req.TaxID,
+	}, true
+}
+
+// CreateTaxRate adds a row to the rate table. Rates are never edited:
+// disable the old one and add the new one when a rate changes.
+func CreateTaxRate(c *gin.Context) {
+	var req TaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.TaxCode != "" && !taxes.ValidCode(req.TaxCode) {
+		apierror.Respond(c, invalidTaxCode("tax_code"))
+		return
+	}
+
+	rate := models.TaxRate{
+		ID:           req.ID,
+		Name:         req.Name,
+		Kind:         req.Kind,
+		Country:      strings.ToUpper(req.Country),
+		Region:       strings.ToUpper(req.Region),
+		PostalPrefix: strings.ToUpper(strings.ReplaceAll(req.PostalPrefix, " ", "")),
+		TaxCode:      req.TaxCode,
+		RateBps:      req.RateBps,
+		Active:       true,
+	}
+	if rate.ID == "" {
+		rate.ID = "txr_" + uuid.NewString()
+	}
+
+	var existing int64
+	config.DB.Model(&models.TaxRate{}).Where("id = ?", rate.ID).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Tax rate "+rate.ID+" already exists.").WithParam("id"))
+		return
+	}
+	if err := config.DB.Create(&rate).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create tax rate."))
+		return
+	}
+	c.JSON(http.StatusCreated, newTaxRateResponse(rate))
+}
+
+func GetTaxRate(c *gin.Context) {
+	rate, ok := loadTaxRate(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newTaxRateResponse(rate))
+}
+
+func ListTaxRates(c *gin.Context) {
+	var params TaxRateListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.TaxRate{})
+	if params.Country != "" {
+		query = query.Where("country = ?", strings.ToUpper(params.Country))
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.TaxRate](query, models.TaxRate{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]TaxRateResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newTaxRateResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/tax_rates",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func DisableTaxRate(c *gin.Context) {
+	rate, ok := loadTaxRate(c)
+	if !ok {
+		return
+	}
+	if err := config.DB.Model(&rate).Update("active", false).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to disable tax rate."))
+		return
+	}
+	c.JSON(http.StatusOK, newTaxRateResponse(rate))
+}
+
+// CreateTaxCalculation previews the tax on an amount without charging
+// anything.
+func CreateTaxCalculation(c *gin.Context) {
+	var req TaxCalculationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	params, ok := taxParams(c, &req.TaxRequest, "")
+	if !ok {
+		return
+	}
+	merchantID, ok := reserveMerchant(c, req.Merchant)
+	if !ok {
+		return
+	}
+	var merchant models.Merchant
+	config.DB.First(&merchant, "id = ?", merchantID)
+	params.MerchantCountry = merchant.Country
+	params.Amount = req.Amount
+
+	if req.Customer != "" {
+		var cust models.Customer
+		if err := config.DB.First(&cust, "id = ?", req.Customer).Error; err != nil {
+			apierror.Respond(c, apierror.NotFound("customer", "customer", req.Customer))
+			return
+		}
+		if params.Address.Country == "" {
+			params.Address = taxes.Address{Country: cust.Country, Region: cust.Region, PostalCode: cust.PostalCode}
+		}
+		if params.CustomerTaxID == "" {
+			params.CustomerTaxID = cust.TaxID
+		}
+	}
+	if params.Address.Country == "" {
+		apierror.Respond(c, apierror.Missing("country"))
+		return
+	}
+
+	res, err := taxes.Calculate(config.DB, *params)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to calculate tax."))
+		return
+	}
+	resp := TaxCalculationResponse{
+		Object:        "tax_calculation",
+		Currency:      strings.ToLower(req.Currency),
+		Behavior:      res.Behavior,
+		TaxCode:       res.TaxCode,
+		Country:       res.Address.Country,
+		Region:        res.Address.Region,
+		PostalCode:    res.Address.PostalCode,
+		RateBps:       res.RateBps,
+		TaxableAmount: res.Taxable,
+		TaxAmount:     res.Tax,
+		Total:         res.Total(),
+		ReverseCharge: res.ReverseCharge,
+		CustomerTaxID: res.CustomerTaxID,
+	}
+	if res.Rate != nil {
+		resp.TaxRate = res.Rate.ID
+		resp.Jurisdiction = res.Rate.Name
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListTaxLines lists the recorded tax of charges, invoice lines and their
+// reversals, optionally for one source object or invoice.
+func ListTaxLines(c *gin.Context) {
+	var params TaxLineListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.TaxLine{})
+	if params.Source != "" {
+		query = query.Where("source_id = ?", params.Source)
+	}
+	if params.Invoice != "" {
+		query = query.Where("invoice_id = ?", params.Invoice)
+	}
+
+	list, hasMore, apiErr := paginate[models.TaxLine](query, models.TaxLine{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]TaxLineResponse, 0, len(list))
+	for _, l := range list {
+		data = append(data, newTaxLineResponse(l))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/tax_lines",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// GetTaxReport sums the tax recorded from one day to another, both
+// included, by jurisdiction and rate.
+func GetTaxReport(c *gin.Context) {
+	params, ok := bindTaxReport(c)
+	if !ok {
+		return
+	}
+	rows, err := taxes.Report(config.DB, params)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to build tax report."))
+		return
+	}
+
+	resp := TaxReportResponse{
+		Object: "tax_report",
+		From:   params.From.Format("2006-01-02"),
+		To:     params.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		Rows:   make([]TaxReportRow, 0, len(rows)),
+	}
+	for _, r := range rows {
+		resp.Rows = append(resp.Rows, TaxReportRow{
+			Country:       r.Country,
+			Region:        r.Region,
+			Jurisdiction:  r.Jurisdiction,
+			Kind:          r.Kind,
+			RateBps:       r.RateBps,
+			ReverseCharge: r.ReverseCharge,
+			Currency:      r.Currency,
+			Count:         r.Count,
+			TaxableAmount: r.Taxable,
+			TaxAmount:     r.Tax,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ExportTaxReport downloads the tax lines of the report period as CSV.
+func ExportTaxReport(c *gin.Context) {
+	params, ok := bindTaxReport(c)
+	if !ok {
+		return
+	}
+	lines, err := taxes.ReportLines(config.DB, params)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to build tax report."))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := taxes.WriteCSV(&buf, lines); err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to write tax report."))
+		return
+	}
+	filename := "tax_report_" + params.From.Format("20060102") + "_" + params.To.AddDate(0, 0, -1).Format("20060102") + ".csv"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+func bindTaxReport(c *gin.Context) (taxes.ReportParams, bool) {
+	var req TaxReportParams
+	if err := c.ShouldBindQuery(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return taxes.ReportParams{}, false
+	}
+	if req.To.Before(req.From) {
+		apierror.Respond(c, apierror.Invalid("to", "to must not be before from."))
+		return taxes.ReportParams{}, false
+	}
+	return taxes.ReportParams{
+		From:       req.From,
+		To:         req.To.AddDate(0, 0, 1),
+		MerchantID: req.Merchant,
+		Currency:   strings.ToLower(req.Currency),
+	}, true
+}
+
+// nestedParam names param name within field, as in tax[tax_code].
+func nestedParam(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "[" + name + "]"
+}
+
+func invalidTaxCode(param string) *apierror.Error {
+	return apierror.Invalid(param, "Invalid tax code. Valid codes are "+strings.Join(taxes.Codes, ", ")+".")
+}
+
+func loadTaxRate(c *gin.Context) (models.TaxRate, bool) {
+	id := c.Param("id")
+
+	var rate models.TaxRate
+	err := config.DB.First(&rate, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("tax_rate", "id", id))
+		return rate, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch tax rate."))
+		return rate, false
+	}
+	return rate, true
+}
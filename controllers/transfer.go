@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type TransferListParams struct {
+	ListParams
+	Charge      string `form:"charge"`
+	Destination string `form:"destination"`
+}
+
+type TransferReversalResponse struct {
+	ID        string `json:"id"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Refund    string `json:"refund,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type TransferResponse struct {
+	ID             string                     `json:"id"`
+	Charge         string                     `json:"charge"`
+	Platform       string                     `json:"platform"`
+	Destination    string                     `json:"destination"`
+	Amount         int64                      `json:"amount"`
+	Currency       string                     `json:"currency"`
+	AmountReversed int64                      `json:"amount_reversed"`
+	Reversals      []TransferReversalResponse `json:"reversals"`
+	CreatedAt      string                     `json:"created_at"`
+}
+
+func newTransferResponse(t models.Transfer, reversals []models.TransferReversal) TransferResponse {
+	resp := TransferResponse{
+		ID:             t.ID,
+		Charge:         t.TransactionID,
+		Platform:       t.PlatformID,
+		Destination:    t.DestinationID,
+		Amount:         t.Amount,
+		Currency:       t.Currency,
+		AmountReversed: t.AmountReversed,
+		Reversals:      make([]TransferReversalResponse, 0, len(reversals)),
+		CreatedAt:      t.CreatedAt.Format(time.RFC3339),
+	}
+	for _, r := range reversals {
+		resp.Reversals = append(resp.Reversals, TransferReversalResponse{
+			ID:        r.ID,
+			Amount:    r.Amount,
+			Currency:  r.Currency,
+			Refund:    r.RefundID,
+			CreatedAt: r.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return resp
+}
+
+func GetTransfer(c *gin.Context) {
+	id := c.Param("id")
+
+	var t models.Transfer
+	err := config.DB.First(&t, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("transfer", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch transfer."))
+		return
+	}
+
+	var reversals []models.TransferReversal
+	if err := config.DB.Where("transfer_id = ?", t.ID).Order("created_at").Find(&reversals).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch transfer."))
+		return
+	}
+	c.JSON(http.StatusOK, newTransferResponse(t, reversals))
+}
+
+func ListTransfers(c *gin.Context) {
+	var params TransferListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Transfer{})
+	if params.Charge != "" {
+		query = query.Where("transaction_id = ?", params.Charge)
+	}
+	if params.Destination != "" {
+		query = query.Where("destination_id = ?", params.Destination)
+	}
+
+	list, hasMore, apiErr := paginate[models.Transfer](query, models.Transfer{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	ids := make([]string, 0, len(list))
+	for _, t := range list {
+		ids = append(ids, t.ID)
+	}
+	var reversals []models.TransferReversal
+	if err := config.DB.Where("transfer_id IN ?", ids).Order("created_at").Find(&reversals).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to list transfers."))
+		return
+	}
+	byTransfer := make(map[string][]models.TransferReversal)
+	for _, r := range reversals {
+		byTransfer[r.TransferID] = append(byTransfer[r.TransferID], r)
+	}
+
+	data := make([]TransferResponse, 0, len(list))
+	for _, t := range list {
+		data = append(data, newTransferResponse(t, byTransfer[t.ID]))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/transfers",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/risk"
+	"gorm.io/gorm"
+)
+
+type VelocityRuleRequest struct {
+	Name          string `json:"name" binding:"omitempty,max=255"`
+	Event         string `json:"event" binding:"required,oneof=charge refund"`
+	Scope         string `json:"scope" binding:"required,oneof=customer email card_fingerprint ip merchant"`
+	Metric        string `json:"metric" binding:"required,oneof=count amount"`
+	Currency      string `json:"currency" binding:"omitempty,len=3"`
+	Limit         int64  `json:"limit" binding:"required,min=1"`
+	WindowSeconds int64  `json:"window_seconds" binding:"required,min=60,max=2592000"`
+}
+
+type VelocityRuleListParams struct {
+	ListParams
+	Event  string `form:"event"`
+	Scope  string `form:"scope"`
+	Active string `form:"active" binding:"omitempty,oneof=true false"`
+}
+
+type VelocityRuleResponse struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Name          string `json:"name,omitempty"`
+	Event         string `json:"event"`
+	Scope         string `json:"scope"`
+	Metric        string `json:"metric"`
+	Currency      string `json:"currency,omitempty"`
+	Limit         int64  `json:"limit"`
+	WindowSeconds int64  `json:"window_seconds"`
+	Active        bool   `json:"active"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func newVelocityRuleResponse(r models.VelocityRule) VelocityRuleResponse {
+	return VelocityRuleResponse{
+		ID:            r.ID,
+		Object:        "velocity_rule",
+		Name:          r.Name,
+		Event:         r.Event,
+		Scope:         r.Scope,
+		Metric:        r.Metric,
+		Currency:      r.Currency,
+		Limit:         r.Limit,
+		WindowSeconds: r.WindowSeconds,
+		Active:        r.Active,
+		CreatedAt:     r.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func CreateVelocityRule(c *gin.Context) {
+	var req VelocityRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if req.Metric == risk.MetricAmount && req.Currency == "" {
+		apierror.Respond(c, apierror.Missing("currency"))
+		return
+	}
+	if req.Metric == risk.MetricCount && req.Currency != "" {
+		apierror.Respond(c, apierror.Invalid("currency", "Only amount rules have a currency."))
+		return
+	}
+
+	rule := models.VelocityRule{
+		ID:            "vr_" + uuid.NewString(),
+		Name:          req.Name,
+		Event:         req.Event,
+		Scope:         req.Scope,
+		Metric:        req.Metric,
+		Currency:      strings.ToLower(req.Currency),
+		Limit:         req.Limit,
+		WindowSeconds: req.WindowSeconds,
+		Active:        true,
+	}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create velocity rule."))
+		return
+	}
+	c.JSON(http.StatusCreated, newVelocityRuleResponse(rule))
+}
+
+func GetVelocityRule(c *gin.Context) {
+	rule, ok := loadVelocityRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newVelocityRuleResponse(rule))
+}
+
+func ListVelocityRules(c *gin.Context) {
+	var params VelocityRuleListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.VelocityRule{})
+	if params.Event != "" {
+		query = query.Where("event = ?", params.Event)
+	}
+	if params.Scope != "" {
+		query = query.Where("scope = ?", params.Scope)
+	}
+	if params.Active != "" {
+		query = query.Where("active = ?", params.Active == "true")
+	}
+
+	list, hasMore, apiErr := paginate[models.VelocityRule](query, models.VelocityRule{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]VelocityRuleResponse, 0, len(list))
+	for _, r := range list {
+		data = append(data, newVelocityRuleResponse(r))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/velocity_rules",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func DisableVelocityRule(c *gin.Context) {
+	rule, ok := loadVelocityRule(c)
+	if !ok {
+		return
+	}
+	if err := config.DB.Model(&rule).Update("active", false).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to disable velocity rule."))
+		return
+	}
+	c.JSON(http.StatusOK, newVelocityRuleResponse(rule))
+}
+
+func loadVelocityRule(c *gin.Context) (models.VelocityRule, bool) {
+	id := c.Param("id")
+
+	var rule models.VelocityRule
+	err := config.DB.First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("velocity_rule", "id", id))
+		return rule, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch velocity rule."))
+		return rule, false
+	}
+	return rule, true
+}
@@ -0,0 +1,483 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/payouts"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/wallets"
+	"gorm.io/gorm"
+)
+
+type WalletRequest struct {
+	Customer string            `json:"customer" binding:"required,max=64"`
+	Currency string            `json:"currency" binding:"required,len=3,alpha"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type WalletListParams struct {
+	ListParams
+	Customer string `form:"customer"`
+	Currency string `form:"currency"`
+}
+
+type WalletTransactionListParams struct {
+	ListParams
+	Type string `form:"type" binding:"omitempty,oneof=top_up payment transfer_in transfer_out withdrawal withdrawal_reversal"`
+}
+
+type WalletTopUpRequest struct {
+	Amount    int64        `json:"amount" binding:"required,gt=0"`
+	Card      *CardRequest `json:"card"`
+	ReturnURL string       `json:"return_url" binding:"omitempty,url,max=2048"`
+}
+
+type WalletPaymentRequest struct {
+	Amount      int64  `json:"amount" binding:"required,gt=0"`
+	Merchant    string `json:"merchant" binding:"omitempty,max=64"`
+	Description string `json:"description" binding:"omitempty,max=255"`
+}
+
+type WalletWithdrawalRequest struct {
+	Amount      int64             `json:"amount" binding:"required,gt=0"`
+	BankAccount string            `json:"bank_account" binding:"required,max=64"`
+	Description string            `json:"description" binding:"omitempty,max=255"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// WalletTransferRequest names the receiving wallet, or the customer whose
+// wallet in the sending wallet's currency receives the transfer.
+type WalletTransferRequest struct {
+	FromWallet  string            `json:"from_wallet" binding:"required,max=64"`
+	ToWallet    string            `json:"to_wallet" binding:"omitempty,max=64"`
+	ToCustomer  string            `json:"to_customer" binding:"omitempty,max=64"`
+	Amount      int64             `json:"amount" binding:"required,gt=0"`
+	Description string            `json:"description" binding:"omitempty,max=255"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+type WalletResponse struct {
+	ID        string          `json:"id"`
+	Customer  string          `json:"customer"`
+	Currency  string          `json:"currency"`
+	Balance   int64           `json:"balance"`
+	Metadata  models.Metadata `json:"metadata"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type WalletTransactionResponse struct {
+	ID                 string `json:"id"`
+	Wallet             string `json:"wallet"`
+	Type               string `json:"type"`
+	Amount             int64  `json:"amount"`
+	Currency           string `json:"currency"`
+	BalanceAfter       int64  `json:"balance_after"`
+	SourceType         string `json:"source_type,omitempty"`
+	Source             string `json:"source,omitempty"`
+	BalanceTransaction string `json:"balance_transaction,omitempty"`
+	Description        string `json:"description,omitempty"`
+	CreatedAt          string `json:"created_at"`
+}
+
+type WalletTransferResponse struct {
+	ID          string          `json:"id"`
+	FromWallet  string          `json:"from_wallet"`
+	ToWallet    string          `json:"to_wallet"`
+	Amount      int64           `json:"amount"`
+	Currency    string          `json:"currency"`
+	Description string          `json:"description,omitempty"`
+	Metadata    models.Metadata `json:"metadata"`
+	CreatedAt   string          `json:"created_at"`
+}
+
+func newWalletResponse(w models.Wallet) WalletResponse {
+	return WalletResponse{
+		ID:        w.ID,
+		Customer:  w.CustomerID,
+		Currency:  w.Currency,
+		Balance:   w.Balance,
+		Metadata:  w.Metadata,
+		CreatedAt: w.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newWalletTransactionResponse(wt models.WalletTransaction) WalletTransactionResponse {
+	return WalletTransactionResponse{
+		ID:           wt.ID,
+		Wallet:       wt.WalletID,
+		Type:         wt.Type,
+		Amount:       wt.Amount,
+		Currency:     wt.Currency,
+		BalanceAfter: wt.BalanceAfter,
+		SourceType:   wt.SourceType,
+		Source:       wt.SourceID,
+		Description:  wt.Description,
+		CreatedAt:    wt.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newWalletTransferResponse(t models.WalletTransfer) WalletTransferResponse {
+	return WalletTransferResponse{
+		ID:          t.ID,
+		FromWallet:  t.FromWalletID,
+		ToWallet:    t.ToWalletID,
+		Amount:      t.Amount,
+		Currency:    t.Currency,
+		Description: t.Description,
+		Metadata:    t.Metadata,
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// respondWalletError maps an error moving money in or out of a wallet.
+func respondWalletError(c *gin.Context, err error, fallback string) {
+	var limitErr *wallets.LimitError
+	switch {
+	case errors.Is(err, wallets.ErrInsufficientFunds):
+		apierror.Respond(c, apierror.New(apierror.CodeBalanceInsufficient, "The wallet balance is too low for this amount.").WithParam("amount"))
+	case errors.As(err, &limitErr):
+		apierror.Respond(c, apierror.New(apierror.CodeWalletLimitExceeded, "Wallet limit exceeded: "+limitErr.Message+".").WithParam("amount"))
+	case errors.Is(err, wallets.ErrCurrencyMismatch):
+		apierror.Respond(c, apierror.Invalid("to_wallet", "Transfers must be between wallets in the same currency."))
+	case errors.Is(err, wallets.ErrSameWallet):
+		apierror.Respond(c, apierror.Invalid("to_wallet", "A wallet cannot transfer to itself."))
+	default:
+		apierror.Respond(c, apierror.Internal(fallback))
+	}
+}
+
+// CreateWallet opens a customer's wallet in a currency. A customer has at
+// most one wallet per currency.
+func CreateWallet(c *gin.Context) {
+	var req WalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	var count int64
+	config.DB.Model(&models.Customer{}).Where("id = ?", req.Customer).Count(&count)
+	if count == 0 {
+		apierror.Respond(c, apierror.NotFound("customer", "customer", req.Customer))
+		return
+	}
+
+	w := models.Wallet{
+		ID:         "wal_" + uuid.NewString(),
+		CustomerID: req.Customer,
+		Currency:   strings.ToLower(req.Currency),
+		Metadata:   metadata.Clean(req.Metadata),
+	}
+	var existing int64
+	config.DB.Model(&models.Wallet{}).Where("customer_id = ? AND currency = ?", w.CustomerID, w.Currency).Count(&existing)
+	if existing > 0 {
+		apierror.Respond(c, apierror.New(apierror.CodeResourceExists, "Customer "+w.CustomerID+" already has a "+w.Currency+" wallet.").WithParam("currency"))
+		return
+	}
+	if err := config.DB.Create(&w).Error; err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to create wallet."))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newWalletResponse(w))
+}
+
+func GetWallet(c *gin.Context) {
+	w, ok := loadWallet(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newWalletResponse(w))
+}
+
+func ListWallets(c *gin.Context) {
+	var params WalletListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+
+	query := config.DB.Model(&models.Wallet{})
+	if params.Customer != "" {
+		query = query.Where("customer_id = ?", params.Customer)
+	}
+	if params.Currency != "" {
+		query = query.Where("currency = ?", strings.ToLower(params.Currency))
+	}
+
+	list, hasMore, apiErr := paginate[models.Wallet](query, models.Wallet{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]WalletResponse, 0, len(list))
+	for _, w := range list {
+		data = append(data, newWalletResponse(w))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/wallets",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+func ListWalletTransactions(c *gin.Context) {
+	var params WalletTransactionListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	w, ok := loadWallet(c)
+	if !ok {
+		return
+	}
+
+	query := config.DB.Model(&models.WalletTransaction{}).Where("wallet_id = ?", w.ID)
+	if params.Type != "" {
+		query = query.Where("type = ?", params.Type)
+	}
+
+	list, hasMore, apiErr := paginate[models.WalletTransaction](query, models.WalletTransaction{}.TableName(), params.ListParams)
+	if apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+
+	data := make([]WalletTransactionResponse, 0, len(list))
+	for _, wt := range list {
+		data = append(data, newWalletTransactionResponse(wt))
+	}
+
+	c.JSON(http.StatusOK, ListResponse{
+		Object:  "list",
+		URL:     "/api/v1/wallets/" + w.ID + "/transactions",
+		HasMore: hasMore,
+		Data:    data,
+	})
+}
+
+// TopUpWallet charges the customer's card, the one on file unless card is
+// given, and credits the wallet once the charge succeeds.
+func TopUpWallet(c *gin.Context) {
+	var req WalletTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	w, ok := loadWallet(c)
+	if !ok {
+		return
+	}
+	if err := wallets.CheckTopUp(config.DB, &w, req.Amount); err != nil {
+		respondWalletError(c, err, "Failed to check wallet limits.")
+		return
+	}
+
+	var card processor.Card
+	if req.Card != nil {
+		card = processor.Card{
+			Number:   req.Card.Number,
+			ExpMonth: req.Card.ExpMonth,
+			ExpYear:  req.Card.ExpYear,
+			CVC:      req.Card.CVC,
+		}
+	} else {
+		var cust models.Customer
+		config.DB.First(&cust, "id = ?", w.CustomerID)
+		card = payments.CardOnFile(cust)
+	}
+
+	txn, err := payments.Charge(config.DB, payments.Params{
+		Amount:    req.Amount,
+		Currency:  w.Currency,
+		Customer:  w.CustomerID,
+		Card:      card,
+		Metadata:  models.Metadata{},
+		Wallet:    &w,
+		IP:        c.ClientIP(),
+		ReturnURL: req.ReturnURL,
+	})
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to top up wallet."))
+		return
+	}
+	respondCharge(c, *txn, "", true)
+}
+
+// PayFromWallet pays a merchant, acct_default unless merchant is given, out
+// of the wallet.
+func PayFromWallet(c *gin.Context) {
+	var req WalletPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	w, ok := loadWallet(c)
+	if !ok {
+		return
+	}
+	merchantID := req.Merchant
+	if merchantID == "" {
+		merchantID = pricing.DefaultMerchantID
+	}
+	var count int64
+	config.DB.Model(&models.Merchant{}).Where("id = ?", merchantID).Count(&count)
+	if count == 0 {
+		apierror.Respond(c, apierror.NotFound("merchant", "merchant", merchantID))
+		return
+	}
+
+	var wt *models.WalletTransaction
+	var bt *models.BalanceTransaction
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		wt, bt, err = wallets.Pay(tx, &w, merchantID, req.Amount, req.Description)
+		return err
+	})
+	if err != nil {
+		respondWalletError(c, err, "Failed to pay from wallet.")
+		return
+	}
+
+	resp := newWalletTransactionResponse(*wt)
+	resp.BalanceTransaction = bt.ID
+	c.JSON(http.StatusCreated, resp)
+}
+
+// WithdrawFromWallet pays part of the wallet out to one of its customer's
+// bank accounts.
+func WithdrawFromWallet(c *gin.Context) {
+	var req WalletWithdrawalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+	w, ok := loadWallet(c)
+	if !ok {
+		return
+	}
+
+	var ba models.BankAccount
+	if err := config.DB.First(&ba, "id = ?", req.BankAccount).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("bank_account", "bank_account", req.BankAccount))
+		return
+	}
+	if ba.CustomerID != w.CustomerID {
+		apierror.Respond(c, apierror.Invalid("bank_account", "Bank account "+ba.ID+" does not belong to customer "+w.CustomerID+"."))
+		return
+	}
+	if ba.Currency != w.Currency {
+		apierror.Respond(c, apierror.Invalid("bank_account", "Bank account "+ba.ID+" does not accept "+w.Currency+" payouts."))
+		return
+	}
+
+	var p *models.Payout
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		p, err = payouts.CreateWithdrawal(tx, &w, &ba, req.Amount, req.Description, metadata.Clean(req.Metadata))
+		return err
+	})
+	if err != nil {
+		respondWalletError(c, err, "Failed to withdraw from wallet.")
+		return
+	}
+	c.JSON(http.StatusCreated, newPayoutResponse(*p))
+}
+
+// CreateWalletTransfer moves money between two customers' wallets at once.
+func CreateWalletTransfer(c *gin.Context) {
+	var req WalletTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Respond(c, apierror.FromBinding(err))
+		return
+	}
+	if apiErr := metadata.Validate(req.Metadata); apiErr != nil {
+		apierror.Respond(c, apiErr)
+		return
+	}
+	if (req.ToWallet == "") == (req.ToCustomer == "") {
+		apierror.Respond(c, apierror.Invalid("to_wallet", "Give exactly one of to_wallet and to_customer."))
+		return
+	}
+
+	var from, to models.Wallet
+	if err := config.DB.First(&from, "id = ?", req.FromWallet).Error; err != nil {
+		apierror.Respond(c, apierror.NotFound("wallet", "from_wallet", req.FromWallet))
+		return
+	}
+	if req.ToWallet != "" {
+		if err := config.DB.First(&to, "id = ?", req.ToWallet).Error; err != nil {
+			apierror.Respond(c, apierror.NotFound("wallet", "to_wallet", req.ToWallet))
+			return
+		}
+	} else if err := config.DB.First(&to, "customer_id = ? AND currency = ?", req.ToCustomer, from.Currency).Error; err != nil {
+		apierror.Respond(c, apierror.Invalid("to_customer", "Customer "+req.ToCustomer+" has no "+from.Currency+" wallet."))
+		return
+	}
+
+	var t *models.WalletTransfer
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = wallets.Transfer(tx, &from, &to, req.Amount, req.Description, metadata.Clean(req.Metadata))
+		return err
+	})
+	if err != nil {
+		respondWalletError(c, err, "Failed to transfer between wallets.")
+		return
+	}
+	c.JSON(http.StatusCreated, newWalletTransferResponse(*t))
+}
+
+func GetWalletTransfer(c *gin.Context) {
+	id := c.Param("id")
+
+	var t models.WalletTransfer
+	err := config.DB.First(&t, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("wallet_transfer", "id", id))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch wallet transfer."))
+		return
+	}
+	c.JSON(http.StatusOK, newWalletTransferResponse(t))
+}
+
+func loadWallet(c *gin.Context) (models.Wallet, bool) {
+	id := c.Param("id")
+
+	var w models.Wallet
+	err := config.DB.First(&w, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Respond(c, apierror.NotFound("wallet", "id", id))
+		return w, false
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("Failed to fetch wallet."))
+		return w, false
+	}
+	return w, true
+}
@@ -0,0 +1,224 @@
+// Package discounts resolves coupons and promotion codes, works out how much
+// they take off an amount and counts their redemptions.
+package discounts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	DurationOnce      = "once"
+	DurationRepeating = "repeating"
+	DurationForever   = "forever"
+)
+
+var (
+	ErrCouponNotFound        = errors.New("discounts: no such coupon")
+	ErrPromotionCodeNotFound = errors.New("discounts: no such promotion code")
+)
+
+// NotRedeemableError explains why a coupon or promotion code cannot be used.
+type NotRedeemableError struct {
+	// Param is "coupon" or "promotion_code".
+	Param   string
+	Message string
+}
+
+func (e *NotRedeemableError) Error() string {
+	return "discounts: " + e.Message
+}
+
+// Discount is a coupon being applied, through PromotionCode when the
+// customer entered one.
+type Discount struct {
+	Coupon        models.Coupon
+	PromotionCode *models.PromotionCode
+}
+
+// Applied is what a discount took off one payment.
+type Applied struct {
+	CouponID        string
+	PromotionCodeID string
+	Amount          int64
+}
+
+// Payload is the itemized discount in webhook payloads.
+func (a Applied) Payload() map[string]interface{} {
+	return map[string]interface{}{
+		"coupon":         a.CouponID,
+		"promotion_code": a.PromotionCodeID,
+		"amount":         a.Amount,
+	}
+}
+
+func (d *Discount) PromotionCodeID() string {
+	if d.PromotionCode == nil {
+		return ""
+	}
+	return d.PromotionCode.ID
+}
+
+// Apply works out the discount on amount.
+func (d *Discount) Apply(amount int64) Applied {
+	return Applied{
+		CouponID:        d.Coupon.ID,
+		PromotionCodeID: d.PromotionCodeID(),
+		Amount:          Off(d.Coupon, amount),
+	}
+}
+
+// Off is what c takes off amount: a percentage rounded half up, or a fixed
+// amount, never more than amount itself.
+func Off(c models.Coupon, amount int64) int64 {
+	if amount <= 0 {
+		return 0
+	}
+	off := c.AmountOff
+	if c.PercentOffBps > 0 {
+		off = (amount*c.PercentOffBps + 5000) / 10000
+	}
+	if off > amount {
+		off = amount
+	}
+	return off
+}
+
+// Lookup resolves a coupon ID or promotion code for a payment by customerID
+// in currency and checks that it can still be redeemed at now. It does not
+// count a redemption; see Redeem.
+func Lookup(db *gorm.DB, couponID, code, customerID, currency string, now time.Time) (*Discount, error) {
+	d := &Discount{}
+	if code != "" {
+		var promo models.PromotionCode
+		if err := db.First(&promo, "code = ?", strings.ToUpper(code)).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrPromotionCodeNotFound
+			}
+			return nil, err
+		}
+		if couponID != "" && couponID != promo.CouponID {
+			return nil, &NotRedeemableError{"promotion_code", "Promotion code " + promo.Code + " is not for coupon " + couponID + "."}
+		}
+		if err := checkPromotionCode(promo, customerID, now); err != nil {
+			return nil, err
+		}
+		d.PromotionCode = &promo
+		couponID = promo.CouponID
+	}
+
+	if err := db.First(&d.Coupon, "id = ?", couponID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+	param := "coupon"
+	if d.PromotionCode != nil {
+		param = "promotion_code"
+	}
+	if err := checkCoupon(d.Coupon, currency, now); err != nil {
+		err.Param = param
+		return nil, err
+	}
+	return d, nil
+}
+
+func checkCoupon(c models.Coupon, currency string, now time.Time) *NotRedeemableError {
+	switch {
+	case !c.Valid:
+		return &NotRedeemableError{Message: "Coupon " + c.ID + " is no longer valid."}
+	case c.RedeemBy != nil && !now.Before(*c.RedeemBy):
+		return &NotRedeemableError{Message: "Coupon " + c.ID + " has expired."}
+	case c.MaxRedemptions > 0 && c.TimesRedeemed >= c.MaxRedemptions:
+		return &NotRedeemableError{Message: "Coupon " + c.ID + " has been fully redeemed."}
+	case c.AmountOff > 0 && !strings.EqualFold(c.Currency, currency):
+		return &NotRedeemableError{Message: fmt.Sprintf("Coupon %s is for %s payments.", c.ID, c.Currency)}
+	}
+	return nil
+}
+
+func checkPromotionCode(p models.PromotionCode, customerID string, now time.Time) error {
+	switch {
+	case !p.Active:
+		return &NotRedeemableError{"promotion_code", "Promotion code " + p.Code + " is no longer active."}
+	case p.ExpiresAt != nil && !now.Before(*p.ExpiresAt):
+		return &NotRedeemableError{"promotion_code", "Promotion code " + p.Code + " has expired."}
+	case p.MaxRedemptions > 0 && p.TimesRedeemed >= p.MaxRedemptions:
+		return &NotRedeemableError{"promotion_code", "Promotion code " + p.Code + " has been fully redeemed."}
+	case p.CustomerID != "" && p.CustomerID != customerID:
+		return &NotRedeemableError{"promotion_code", "Promotion code " + p.Code + " cannot be used by this customer."}
+	}
+	return nil
+}
+
+// Load fetches the discount a coupon and, optionally, a promotion code
+// already attached to an invoice or subscription stand for.
+func Load(db *gorm.DB, couponID, promotionCodeID string) (*Discount, error) {
+	d := &Discount{}
+	if err := db.First(&d.Coupon, "id = ?", couponID).Error; err != nil {
+		return nil, err
+	}
+	if promotionCodeID != "" {
+		var promo models.PromotionCode
+		if err := db.First(&promo, "id = ?", promotionCodeID).Error; err != nil {
+			return nil, err
+		}
+		d.PromotionCode = &promo
+	}
+	return d, nil
+}
+
+// Redeem counts one redemption of d. The counters only move while they are
+// below their limits, so concurrent redemptions can never exceed them; the
+// one that loses gets a NotRedeemableError.
+func Redeem(tx *gorm.DB, d *Discount) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if d.PromotionCode != nil {
+			res := tx.Model(&models.PromotionCode{}).
+				Where("id = ? AND active = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", d.PromotionCode.ID, true).
+				Update("times_redeemed", gorm.Expr("times_redeemed + 1"))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return &NotRedeemableError{"promotion_code", "Promotion code " + d.PromotionCode.Code + " has been fully redeemed."}
+			}
+		}
+		res := tx.Model(&models.Coupon{}).
+			Where("id = ? AND valid = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", d.Coupon.ID, true).
+			Update("times_redeemed", gorm.Expr("times_redeemed + 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			param := "coupon"
+			if d.PromotionCode != nil {
+				param = "promotion_code"
+			}
+			return &NotRedeemableError{param, "Coupon " + d.Coupon.ID + " has been fully redeemed."}
+		}
+		return nil
+	})
+}
+
+// Unredeem gives back a redemption whose payment failed.
+func Unredeem(tx *gorm.DB, d *Discount) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if d.PromotionCode != nil {
+			err := tx.Model(&models.PromotionCode{}).Where("id = ? AND times_redeemed > 0", d.PromotionCode.ID).
+				Update("times_redeemed", gorm.Expr("times_redeemed - 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Coupon{}).Where("id = ? AND times_redeemed > 0", d.Coupon.ID).
+			Update("times_redeemed", gorm.Expr("times_redeemed - 1")).Error
+	})
+}
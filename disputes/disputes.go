@@ -0,0 +1,244 @@
+package disputes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+)
+
+const (
+	StatusNeedsResponse = "needs_response"
+	StatusUnderReview   = "under_review"
+	StatusWon           = "won"
+	StatusLost          = "lost"
+)
+
+var Reasons = []string{
+	"fraudulent",
+	"duplicate",
+	"product_not_received",
+	"product_unacceptable",
+	"subscription_canceled",
+	"credit_not_processed",
+	"general",
+}
+
+// EvidenceFields are the text fields accepted as dispute evidence.
+var EvidenceFields = []string{
+	"customer_name",
+	"customer_email",
+	"product_description",
+	"shipping_tracking_number",
+	"refund_policy",
+	"cancellation_policy",
+	"uncategorized_text",
+}
+
+var (
+	// ResponseWindow is how long a merchant has to submit evidence.
+	ResponseWindow = 7 * 24 * time.Hour
+	// Fee is charged to the merchant, in minor units of the currency it
+	// settles the charge in, for every dispute.
+	Fee int64 = 1500
+)
+
+var (
+	ErrAlreadyDisputed = errors.New("disputes: charge already disputed")
+	ErrNotDisputable   = errors.New("disputes: charge cannot be disputed")
+	ErrAmountTooLarge  = errors.New("disputes: amount exceeds charge amount")
+	ErrClosed          = errors.New("disputes: dispute is closed")
+)
+
+func ValidReason(reason string) bool {
+	for _, r := range Reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func Payload(d models.Dispute) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              d.ID,
+		"transaction_id":  d.TransactionID,
+		"amount":          d.Amount,
+		"fee":             d.Fee,
+		"currency":        d.Currency,
+		"reason":          d.Reason,
+		"status":          d.Status,
+		"evidence_due_by": d.EvidenceDueBy.Format(time.RFC3339),
+	}
+}
+
+// Open creates a dispute for txn and withdraws the disputed amount and the
+// dispute fee from the merchant balance. amount 0 disputes the full charge.
+func Open(tx *gorm.DB, txn *models.Transaction, reason string, amount int64) (*models.Dispute, error) {
+	if txn.Status != "succeeded" {
+		return nil, ErrNotDisputable
+	}
+	if txn.Disputed {
+		return nil, ErrAlreadyDisputed
+	}
+	if amount == 0 {
+		amount = txn.Amount
+	}
+	if amount > txn.Amount {
+		return nil, ErrAmountTooLarge
+	}
+
+	res := tx.Model(txn).Where("disputed = ?", false).Update("disputed", true)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrAlreadyDisputed
+	}
+
+	d := &models.Dispute{
+		ID:            "dp_" + uuid.NewString(),
+		TransactionID: txn.ID,
+		Amount:        amount,
+		Fee:           Fee,
+		Currency:      txn.Currency,
+		Reason:        reason,
+		Status:        StatusNeedsResponse,
+		Evidence:      models.Metadata{},
+		EvidenceDueBy: time.Now().Add(ResponseWindow),
+	}
+	if err := tx.Create(d).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := balance.RecordDispute(tx, d, txn, false); err != nil {
+		return nil, err
+	}
+	if _, err := balance.RecordFee(tx, txn.MerchantID, "dispute", d.ID, fx.SettlementCurrency(*txn), d.Fee,
+		ledger.AccountDisputeFees, "Dispute fee for "+txn.ID); err != nil {
+		return nil, err
+	}
+
+	if err := events.Enqueue(tx, txn.ID, "charge.dispute.created", Payload(*d)); err != nil {
+		return nil, err
+	}
+	if err := events.Enqueue(tx, txn.ID, "charge.dispute.funds_withdrawn", Payload(*d)); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SubmitEvidence merges evidence into d. When submit is true the dispute goes
+// under review and the processor that handled the charge may decide it
+// straight away.
+func SubmitEvidence(tx *gorm.DB, d *models.Dispute, evidence map[string]string, submit bool) error {
+	if d.Status != StatusNeedsResponse {
+		return ErrClosed
+	}
+
+	merged := models.Metadata{}
+	for k, v := range d.Evidence {
+		merged[k] = v
+	}
+	for k, v := range evidence {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	d.Evidence = merged
+
+	if submit {
+		now := time.Now()
+		d.EvidenceSubmittedAt = &now
+		d.Status = StatusUnderReview
+	}
+	if err := tx.Save(d).Error; err != nil {
+		return err
+	}
+	if err := events.Enqueue(tx, d.TransactionID, "charge.dispute.updated", Payload(*d)); err != nil {
+		return err
+	}
+
+	if !submit {
+		return nil
+	}
+
+	var txn models.Transaction
+	if err := tx.First(&txn, "id = ?", d.TransactionID).Error; err != nil {
+		return err
+	}
+	if reviewer, ok := processor.Lookup(txn.Processor).(processor.DisputeReviewer); ok {
+		if outcome := reviewer.ReviewDispute(d.Evidence); outcome != "" {
+			return Close(tx, d, outcome)
+		}
+	}
+	return nil
+}
+
+// Close settles d as won or lost. Winning returns the disputed amount to the
+// merchant; the fee is kept either way.
+func Close(tx *gorm.DB, d *models.Dispute, outcome string) error {
+	if d.Status == StatusWon || d.Status == StatusLost {
+		return ErrClosed
+	}
+
+	now := time.Now()
+	res := tx.Model(d).Where("status = ?", d.Status).Updates(map[string]interface{}{
+		"status":    outcome,
+		"closed_at": now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrClosed
+	}
+
+	if outcome == StatusWon {
+		var txn models.Transaction
+		if err := tx.First(&txn, "id = ?", d.TransactionID).Error; err != nil {
+			return err
+		}
+		if _, err := balance.RecordDispute(tx, d, &txn, true); err != nil {
+			return err
+		}
+		if err := events.Enqueue(tx, d.TransactionID, "charge.dispute.funds_reinstated", Payload(*d)); err != nil {
+			return err
+		}
+	}
+	return events.Enqueue(tx, d.TransactionID, "charge.dispute.closed", Payload(*d))
+}
+
+// ExpireOverdue loses every dispute still waiting for evidence after its
+// deadline. It returns the number of disputes closed.
+func ExpireOverdue(db *gorm.DB, now time.Time) (int, error) {
+	var overdue []models.Dispute
+	if err := db.Where("status = ? AND evidence_due_by <= ?", StatusNeedsResponse, now).Find(&overdue).Error; err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for i := range overdue {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return Close(tx, &overdue[i], StatusLost)
+		})
+		if errors.Is(err, ErrClosed) {
+			continue
+		}
+		if err != nil {
+			return closed, err
+		}
+		closed++
+	}
+	return closed, nil
+}
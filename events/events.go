@@ -0,0 +1,44 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/utils"
+)
+
+func TargetURL() string {
+	target := os.Getenv("WEBHOOK_TARGET")
+	if target == "" {
+		target = "http://localhost:8081/webhook"
+	}
+	return target
+}
+
+func Enqueue(db *gorm.DB, transactionID, eventType string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	event := models.WebhookEvent{
+		TransactionID: transactionID,
+		EventType:     eventType,
+		Payload:       string(payloadBytes),
+		TargetURL:     TargetURL(),
+		Status:        "pending",
+		Attempts:      0,
+		NextRunAt:     time.Now(),
+	}
+
+	if err := db.Create(&event).Error; err != nil {
+		return err
+	}
+
+	utils.Metrics.IncPendingWebhooks()
+	return nil
+}
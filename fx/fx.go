@@ -0,0 +1,168 @@
+// Package fx converts charges from the currency they are presented in to the
+// currency their merchant settles in, at rates from the local rate table.
+// Amounts are in minor units on both sides, scaled by each currency's
+// ISO 4217 exponent.
+package fx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+// Scale is the fixed point of rates: a rate of Scale converts one to one.
+const Scale = 100_000_000
+
+var ErrNoRate = errors.New("fx: no exchange rate")
+
+// ParseRate reads a positive decimal rate with at most eight decimals, such
+// as 1.0842.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 8 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("fx: invalid rate %q", s)
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > 1_000_000 {
+		return 0, fmt.Errorf("fx: invalid rate %q", s)
+	}
+	var f int64
+	if frac != "" {
+		if f, err = strconv.ParseInt(frac+strings.Repeat("0", 8-len(frac)), 10, 64); err != nil || f < 0 {
+			return 0, fmt.Errorf("fx: invalid rate %q", s)
+		}
+	}
+	rate := w*Scale + f
+	if rate == 0 {
+		return 0, fmt.Errorf("fx: invalid rate %q", s)
+	}
+	return rate, nil
+}
+
+// FormatRate writes rate as a decimal without trailing zeros.
+func FormatRate(rate int64) string {
+	s := fmt.Sprintf("%d.%08d", rate/Scale, rate%Scale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// exponents are the ISO 4217 minor units of the currencies that do not
+// have two decimals.
+var exponents = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "isk": 0, "jpy": 0, "kmf": 0,
+	"krw": 0, "pyg": 0, "rwf": 0, "ugx": 0, "uyi": 0, "vnd": 0, "vuv": 0,
+	"xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "iqd": 3, "jod": 3, "kwd": 3, "lyd": 3, "omr": 3, "tnd": 3,
+	"clf": 4, "uyw": 4,
+}
+
+// Exponent is the number of decimals of currency: 0 for jpy, 3 for kwd and
+// 2 for most.
+func Exponent(currency string) int {
+	if e, ok := exponents[strings.ToLower(currency)]; ok {
+		return e
+	}
+	return 2
+}
+
+// Convert converts amount, in minor units of from, at rate into minor units
+// of to, rounding half away from zero.
+func Convert(amount, rate int64, from, to string) int64 {
+	n := new(big.Int).Mul(big.NewInt(amount), big.NewInt(rate))
+	n.Mul(n, pow10(Exponent(to)))
+	d := new(big.Int).Mul(big.NewInt(Scale), pow10(Exponent(from)))
+	neg := n.Sign() < 0
+	n.Abs(n)
+	n.Add(n, new(big.Int).Quo(d, big.NewInt(2)))
+	n.Quo(n, d)
+	if neg {
+		n.Neg(n)
+	}
+	return n.Int64()
+}
+
+func pow10(e int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(e)), nil)
+}
+
+// Rate is the latest mid-market rate from one currency to another. Without
+// a rate for the pair the inverse of the latest rate the other way is used.
+func Rate(db *gorm.DB, from, to string) (int64, error) {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if from == to {
+		return Scale, nil
+	}
+	var r models.FXRate
+	err := db.Where("base = ? AND quote = ?", from, to).Order("created_at DESC").Limit(1).Find(&r).Error
+	if err != nil {
+		return 0, err
+	}
+	if r.ID != "" {
+		return r.Rate, nil
+	}
+	err = db.Where("base = ? AND quote = ?", to, from).Order("created_at DESC").Limit(1).Find(&r).Error
+	if err != nil {
+		return 0, err
+	}
+	if r.ID == "" {
+		return 0, ErrNoRate
+	}
+	return (Scale*Scale + r.Rate/2) / r.Rate, nil
+}
+
+// Quote is the rate a charge is converted at: the mid-market rate less the
+// markup, which MiniPay keeps.
+type Quote struct {
+	From      string
+	To        string
+	Mid       int64
+	Rate      int64
+	MarkupBps int64
+}
+
+func NewQuote(db *gorm.DB, from, to string, markupBps int64) (Quote, error) {
+	mid, err := Rate(db, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{
+		From:      strings.ToLower(from),
+		To:        strings.ToLower(to),
+		Mid:       mid,
+		Rate:      mid * (10000 - markupBps) / 10000,
+		MarkupBps: markupBps,
+	}, nil
+}
+
+// Converted reports whether txn settles in another currency than it was
+// presented in.
+func Converted(txn models.Transaction) bool {
+	return txn.SettlementCurrency != "" && txn.SettlementCurrency != txn.Currency
+}
+
+// SettlementCurrency is the currency txn's merchant is paid in.
+func SettlementCurrency(txn models.Transaction) string {
+	if Converted(txn) {
+		return txn.SettlementCurrency
+	}
+	return txn.Currency
+}
+
+// Settled is what amount of txn's presentment currency was worth to its
+// merchant when txn was converted. The whole charge is worth exactly its
+// settlement amount.
+func Settled(txn models.Transaction, amount int64) int64 {
+	if !Converted(txn) {
+		return amount
+	}
+	if amount == txn.Amount {
+		return txn.SettlementAmount
+	}
+	return Convert(amount, txn.ExchangeRate, txn.Currency, txn.SettlementCurrency)
+}
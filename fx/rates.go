@@ -0,0 +1,110 @@
+package fx
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+// SourceAPI marks rates loaded one at a time through the API.
+const SourceAPI = "api"
+
+// SyntaxError is a malformed line of a rates file.
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Add stores a rate for one unit of base in quote, superseding the previous
+// rate for the pair.
+func Add(tx *gorm.DB, base, quote string, rate int64, source string) (*models.FXRate, error) {
+	r := &models.FXRate{
+		ID:     "fxr_" + uuid.NewString(),
+		Base:   strings.ToLower(base),
+		Quote:  strings.ToLower(quote),
+		Rate:   rate,
+		Source: source,
+	}
+	return r, tx.Create(r).Error
+}
+
+// Import loads a CSV file of base,quote,rate lines, such as eur,usd,1.0842.
+// A header line is skipped. Either every rate is loaded or none is.
+func Import(db *gorm.DB, source string, data []byte) ([]models.FXRate, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	type row struct {
+		base, quote string
+		rate        int64
+	}
+	var rows []row
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &SyntaxError{Line: line, Message: err.Error()}
+		}
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		if len(rec) != 3 {
+			return nil, &SyntaxError{Line: line, Message: "expected base,quote,rate"}
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), "base") {
+			continue
+		}
+		base, quote := strings.ToLower(strings.TrimSpace(rec[0])), strings.ToLower(strings.TrimSpace(rec[1]))
+		if !validCurrency(base) || !validCurrency(quote) || base == quote {
+			return nil, &SyntaxError{Line: line, Message: "invalid currency pair " + rec[0] + "/" + rec[1]}
+		}
+		rate, err := ParseRate(rec[2])
+		if err != nil {
+			return nil, &SyntaxError{Line: line, Message: "invalid rate " + rec[2]}
+		}
+		rows = append(rows, row{base, quote, rate})
+	}
+	if len(rows) == 0 {
+		return nil, &SyntaxError{Line: 1, Message: "no rates"}
+	}
+
+	imported := make([]models.FXRate, 0, len(rows))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			rate, err := Add(tx, row.base, row.quote, row.rate, source)
+			if err != nil {
+				return err
+			}
+			imported = append(imported, *rate)
+		}
+		return nil
+	})
+	return imported, err
+}
+
+func validCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,520 @@
+// Package invoices drafts, finalizes and collects invoices. Finalizing
+// numbers an invoice from its merchant's sequence; it is then charged to the
+// customer's card on file straight away or sent to be paid by its due date.
+package invoices
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/taxes"
+)
+
+// An open invoice is paying while Pay charges it, so that no other payment
+// or void can take it meanwhile.
+const (
+	StatusDraft  = "draft"
+	StatusOpen   = "open"
+	StatusPaying = "paying"
+	StatusPaid   = "paid"
+	StatusVoid   = "void"
+)
+
+const (
+	ChargeAutomatically = "charge_automatically"
+	SendInvoice         = "send_invoice"
+)
+
+// PaymentTimeout is how long a payment may keep an invoice paying. An
+// invoice paying for longer was left so by an interrupted payment and can be
+// paid again.
+const PaymentTimeout = 10 * time.Minute
+
+// DefaultDaysUntilDue is how long a sent invoice has to be paid when the
+// invoice does not say.
+const DefaultDaysUntilDue = 30
+
+var (
+	ErrNotDraft      = errors.New("invoices: invoice is no longer a draft")
+	ErrNotOpen       = errors.New("invoices: invoice is not open")
+	ErrNoLines       = errors.New("invoices: invoice has no line items")
+	ErrNoCard        = errors.New("invoices: customer has no card on file")
+	ErrPaymentFailed = errors.New("invoices: payment failed")
+)
+
+func Payload(inv models.Invoice) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":                inv.ID,
+		"number":            inv.Number,
+		"merchant":          inv.MerchantID,
+		"customer":          inv.CustomerID,
+		"currency":          inv.Currency,
+		"status":            inv.Status,
+		"collection_method": inv.CollectionMethod,
+		"subtotal":          inv.Subtotal,
+		"tax":               inv.Tax,
+		"tax_behavior":      inv.TaxBehavior,
+		"total":             inv.Total,
+		"amount_paid":       inv.AmountPaid,
+		"attempts":          inv.Attempts,
+		"metadata":          inv.Metadata,
+	}
+	if inv.DueDate != nil {
+		payload["due_date"] = inv.DueDate.Format(time.RFC3339)
+	}
+	if inv.TransactionID != "" {
+		payload["transaction"] = inv.TransactionID
+	}
+	if inv.CouponID != "" {
+		payload["discount"] = discount(inv).Payload()
+	}
+	return payload
+}
+
+// AddLine prices line and adds it to the draft inv, updating its totals.
+func AddLine(tx *gorm.DB, inv *models.Invoice, line *models.InvoiceLine) error {
+	if inv.Status != StatusDraft {
+		return ErrNotDraft
+	}
+	if line.ID == "" {
+		line.ID = "il_" + uuid.NewString()
+	}
+	if line.Quantity == 0 {
+		line.Quantity = 1
+	}
+	line.InvoiceID = inv.ID
+	line.Amount = line.Quantity * line.UnitAmount
+	line.Tax = tax(line.Amount, line.TaxRateBps)
+	if err := tx.Create(line).Error; err != nil {
+		return err
+	}
+	_, err := updateTotals(tx, inv)
+	return err
+}
+
+// tax is rateBps of amount, rounded half up.
+func tax(amount, rateBps int64) int64 {
+	return (amount*rateBps + 5000) / 10000
+}
+
+func Lines(db *gorm.DB, invoiceID string) ([]models.InvoiceLine, error) {
+	var lines []models.InvoiceLine
+	err := db.Where("invoice_id = ?", invoiceID).Order("created_at, id").Find(&lines).Error
+	return lines, err
+}
+
+// discount is what inv's coupon took off it.
+func discount(inv models.Invoice) discounts.Applied {
+	return discounts.Applied{CouponID: inv.CouponID, PromotionCodeID: inv.PromotionCodeID, Amount: inv.Discount}
+}
+
+// lineTax is the tax worked out for one line of an invoice.
+type lineTax struct {
+	line   models.InvoiceLine
+	result taxes.Result
+}
+
+// updateTotals sums inv's lines. The coupon's discount is spread over the
+// lines in proportion to their amounts, the last line taking the rounding
+// remainder, and each line is taxed on what is left of it: at its own rate,
+// or from the rate table for the customer's address when inv has a tax
+// behavior.
+func updateTotals(tx *gorm.DB, inv *models.Invoice) ([]lineTax, error) {
+	lines, err := Lines(tx, inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	var subtotal int64
+	for _, l := range lines {
+		subtotal += l.Amount
+	}
+
+	var off int64
+	if inv.CouponID != "" {
+		var coupon models.Coupon
+		if err := tx.First(&coupon, "id = ?", inv.CouponID).Error; err != nil {
+			return nil, err
+		}
+		off = discounts.Off(coupon, subtotal)
+	}
+
+	var cust models.Customer
+	if err := tx.First(&cust, "id = ?", inv.CustomerID).Error; err != nil {
+		return nil, err
+	}
+	var merchant models.Merchant
+	if inv.TaxBehavior != "" {
+		if err := tx.First(&merchant, "id = ?", inv.MerchantID).Error; err != nil {
+			return nil, err
+		}
+	}
+	address := taxes.Address{Country: cust.Country, Region: cust.Region, PostalCode: cust.PostalCode}
+
+	var taxTotal, allocated int64
+	taxed := make([]lineTax, 0, len(lines))
+	for i, l := range lines {
+		share := int64(0)
+		if off > 0 {
+			share = off * l.Amount / subtotal
+			if i == len(lines)-1 {
+				share = off - allocated
+			}
+			allocated += share
+		}
+
+		res := taxes.Result{
+			Behavior: taxes.Exclusive,
+			TaxCode:  l.TaxCode,
+			Address:  address,
+			RateBps:  l.TaxRateBps,
+			Taxable:  l.Amount - share,
+			Tax:      tax(l.Amount-share, l.TaxRateBps),
+		}
+		if inv.TaxBehavior != "" {
+			res, err = taxes.Calculate(tx, taxes.Params{
+				Amount:          l.Amount - share,
+				Behavior:        inv.TaxBehavior,
+				TaxCode:         l.TaxCode,
+				Address:         address,
+				CustomerTaxID:   cust.TaxID,
+				MerchantCountry: merchant.Country,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		taxTotal += res.Tax
+		if share != l.DiscountAmount || res.Tax != l.Tax || res.RateBps != l.TaxRateBps {
+			err := tx.Model(&models.InvoiceLine{}).Where("id = ?", l.ID).
+				Updates(map[string]interface{}{"discount_amount": share, "tax": res.Tax, "tax_rate_bps": res.RateBps}).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		taxed = append(taxed, lineTax{line: l, result: res})
+	}
+
+	total := subtotal - off + taxTotal
+	if inv.TaxBehavior == taxes.Inclusive {
+		total = subtotal - off
+	}
+	return taxed, update(tx, inv, map[string]interface{}{
+		"subtotal": subtotal,
+		"discount": off,
+		"tax":      taxTotal,
+		"total":    total,
+	})
+}
+
+// recordTax stores the tax of inv's lines for reporting. Lines without a
+// rate of their own are left out unless inv is taxed from the rate table.
+func recordTax(tx *gorm.DB, inv *models.Invoice, taxed []lineTax) error {
+	for _, t := range taxed {
+		if inv.TaxBehavior == "" && t.line.TaxRateBps == 0 {
+			continue
+		}
+		src := taxes.Source{
+			Type:       taxes.SourceInvoiceLine,
+			ID:         t.line.ID,
+			InvoiceID:  inv.ID,
+			MerchantID: inv.MerchantID,
+			CustomerID: inv.CustomerID,
+			Currency:   inv.Currency,
+		}
+		if err := taxes.Record(tx, src, t.result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalize numbers the draft inv and opens it for payment, redeeming its
+// coupon and recording its tax. Invoices charged automatically are collected from the customer's
+// card on file at once; a failed payment leaves the invoice open and is
+// returned with its transaction. An invoice with nothing to pay is marked
+// paid.
+func Finalize(db *gorm.DB, inv *models.Invoice, now time.Time) (*models.Transaction, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if inv.Status != StatusDraft {
+			return ErrNotDraft
+		}
+		var count int64
+		if err := tx.Model(&models.InvoiceLine{}).Where("invoice_id = ?", inv.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNoLines
+		}
+		// The customer's address may have changed since the lines were
+		// added, so the tax is worked out again before it is fixed.
+		taxed, err := updateTotals(tx, inv)
+		if err != nil {
+			return err
+		}
+		if err := recordTax(tx, inv, taxed); err != nil {
+			return err
+		}
+		if inv.CouponID != "" {
+			d, err := discounts.Load(tx, inv.CouponID, inv.PromotionCodeID)
+			if err != nil {
+				return err
+			}
+			if err := discounts.Redeem(tx, d); err != nil {
+				return err
+			}
+		}
+
+		number, err := nextNumber(tx, inv.MerchantID)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			"number":       number,
+			"status":       StatusOpen,
+			"finalized_at": now,
+		}
+		if inv.CollectionMethod == SendInvoice {
+			updates["due_date"] = now.AddDate(0, 0, inv.DaysUntilDue)
+		}
+		res := tx.Model(&models.Invoice{}).Where("id = ? AND status = ?", inv.ID, StatusDraft).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotDraft
+		}
+		if err := tx.First(inv, "id = ?", inv.ID).Error; err != nil {
+			return err
+		}
+		if err := events.Enqueue(tx, inv.ID, "invoice.finalized", Payload(*inv)); err != nil {
+			return err
+		}
+		if inv.Total == 0 {
+			return markPaid(tx, inv, nil, StatusOpen, now)
+		}
+		if inv.CollectionMethod == SendInvoice {
+			return events.Enqueue(tx, inv.ID, "invoice.sent", Payload(*inv))
+		}
+		return nil
+	})
+	if err != nil || inv.Status != StatusOpen || inv.CollectionMethod != ChargeAutomatically {
+		return nil, err
+	}
+	return Pay(db, inv, nil, now)
+}
+
+// Pay charges the open inv to card, or to the customer's card on file when
+// card is nil. A declined payment returns ErrPaymentFailed along with the
+// transaction and leaves the invoice open. Invoices are charged off
+// session, so a card that needs 3-D Secure is declined. The invoice is
+// claimed before it is charged, so concurrent payments or a void cannot
+// charge it twice or leave an orphaned charge.
+func Pay(db *gorm.DB, inv *models.Invoice, card *processor.Card, now time.Time) (*models.Transaction, error) {
+	if inv.Status != StatusOpen && inv.Status != StatusPaying {
+		return nil, ErrNotOpen
+	}
+	if card == nil {
+		var cust models.Customer
+		if err := db.First(&cust, "id = ?", inv.CustomerID).Error; err != nil {
+			return nil, err
+		}
+		if cust.CardToken == "" {
+			return nil, ErrNoCard
+		}
+		onFile := payments.CardOnFile(cust)
+		card = &onFile
+	}
+	if err := claim(db, inv); err != nil {
+		return nil, err
+	}
+
+	params := payments.Params{
+		Amount:     inv.Total - inv.AmountPaid,
+		Currency:   inv.Currency,
+		Customer:   inv.CustomerID,
+		MerchantID: inv.MerchantID,
+		Card:       *card,
+		Metadata:   models.Metadata{"invoice": inv.ID},
+		OffSession: true,
+	}
+	if inv.Discount > 0 {
+		applied := discount(*inv)
+		params.Amount += applied.Amount
+		params.Discount = &applied
+	}
+	txn, err := payments.Charge(db, params)
+	if err != nil {
+		if releaseErr := update(db, inv, map[string]interface{}{"status": StatusOpen}); releaseErr != nil {
+			return nil, releaseErr
+		}
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if txn.Status != "succeeded" {
+			if err := update(tx, inv, map[string]interface{}{"status": StatusOpen, "attempts": inv.Attempts + 1}); err != nil {
+				return err
+			}
+			payload := Payload(*inv)
+			payload["failed_transaction"] = txn.ID
+			return events.Enqueue(tx, inv.ID, "invoice.payment_failed", payload)
+		}
+		return markPaid(tx, inv, txn, StatusPaying, now)
+	})
+	if err != nil {
+		return txn, err
+	}
+	if txn.Status != "succeeded" {
+		return txn, ErrPaymentFailed
+	}
+	return txn, nil
+}
+
+// claim moves inv from open to paying. It returns ErrNotOpen, with inv
+// reloaded, when another payment or a void took inv first.
+func claim(db *gorm.DB, inv *models.Invoice) error {
+	res := db.Model(&models.Invoice{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", inv.ID, StatusOpen, StatusPaying, time.Now().Add(-PaymentTimeout)).
+		Update("status", StatusPaying)
+	if res.Error != nil {
+		return res.Error
+	}
+	if err := db.First(inv, "id = ?", inv.ID).Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotOpen
+	}
+	return nil
+}
+
+// markPaid marks inv paid if its status is still from.
+func markPaid(tx *gorm.DB, inv *models.Invoice, txn *models.Transaction, from string, now time.Time) error {
+	updates := map[string]interface{}{
+		"status":      StatusPaid,
+		"amount_paid": inv.Total,
+		"paid_at":     now,
+	}
+	if txn != nil {
+		updates["transaction_id"] = txn.ID
+		updates["attempts"] = inv.Attempts + 1
+	}
+	res := tx.Model(&models.Invoice{}).Where("id = ? AND status = ?", inv.ID, from).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotOpen
+	}
+	if err := tx.First(inv, "id = ?", inv.ID).Error; err != nil {
+		return err
+	}
+	return events.Enqueue(tx, inv.ID, "invoice.paid", Payload(*inv))
+}
+
+// Void cancels a draft or open invoice; it can no longer be paid. The tax
+// recorded when an open invoice was finalized is reversed.
+func Void(tx *gorm.DB, inv *models.Invoice, now time.Time) error {
+	res := tx.Model(&models.Invoice{}).Where("id = ? AND status IN ?", inv.ID, []string{StatusDraft, StatusOpen}).
+		Updates(map[string]interface{}{"status": StatusVoid, "voided_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotOpen
+	}
+	lines, err := taxes.InvoiceLines(tx, inv.ID)
+	if err != nil {
+		return err
+	}
+	if err := taxes.Reverse(tx, lines, taxes.SourceInvoiceVoid, inv.ID); err != nil {
+		return err
+	}
+	if err := tx.First(inv, "id = ?", inv.ID).Error; err != nil {
+		return err
+	}
+	return events.Enqueue(tx, inv.ID, "invoice.voided", Payload(*inv))
+}
+
+// MarkOverdue flags sent invoices still open after their due date and emits
+// invoice.overdue once for each. It returns how many it flagged.
+func MarkOverdue(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.Invoice
+	err := db.Where("status = ? AND collection_method = ? AND due_date <= ? AND overdue_at IS NULL",
+		StatusOpen, SendInvoice, now).Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	flagged := 0
+	for i := range due {
+		inv := &due[i]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&models.Invoice{}).Where("id = ? AND overdue_at IS NULL", inv.ID).Update("overdue_at", now)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			flagged++
+			return events.Enqueue(tx, inv.ID, "invoice.overdue", Payload(*inv))
+		})
+		if err != nil {
+			return flagged, err
+		}
+	}
+	return flagged, nil
+}
+
+// nextNumber takes the next number from the merchant's invoice sequence.
+// The increment happens inside the caller's transaction, so a rolled back
+// finalization gives its number back.
+func nextNumber(tx *gorm.DB, merchantID string) (string, error) {
+	seq := models.InvoiceSequence{MerchantID: merchantID}
+	if err := tx.Where(seq).Attrs(models.InvoiceSequence{Prefix: prefix(merchantID), Next: 1}).FirstOrCreate(&seq).Error; err != nil {
+		return "", err
+	}
+	res := tx.Model(&models.InvoiceSequence{}).Where("merchant_id = ?", merchantID).
+		Update("next", gorm.Expr("next + 1"))
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if err := tx.First(&seq, "merchant_id = ?", merchantID).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%04d", seq.Prefix, seq.Next-1), nil
+}
+
+// prefix derives an invoice number prefix from a merchant ID: acct_default
+// numbers its invoices DEFAULT-0001, DEFAULT-0002 and so on.
+func prefix(merchantID string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(strings.TrimPrefix(merchantID, "acct_")) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		}
+		if b.Len() == 8 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "INV"
+	}
+	return b.String()
+}
+
+// update writes updates to inv and reloads it.
+func update(tx *gorm.DB, inv *models.Invoice, updates map[string]interface{}) error {
+	if err := tx.Model(&models.Invoice{}).Where("id = ?", inv.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	return tx.First(inv, "id = ?", inv.ID).Error
+}
@@ -0,0 +1,153 @@
+package invoices
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pdf"
+	"github.com/vaidikcode/minipay/taxes"
+)
+
+const (
+	margin    = 50.0
+	rowHeight = 18.0
+	// Right edges of the line item columns.
+	colQty    = 360.0
+	colUnit   = 460.0
+	colAmount = pdf.PageWidth - margin
+)
+
+// Render writes inv as a PDF. Drafts are marked as such and have no number
+// yet. Long invoices continue their line items on further pages.
+func Render(w io.Writer, inv models.Invoice, lines []models.InvoiceLine, merchant models.Merchant, cust models.Customer) error {
+	title := "Invoice " + inv.Number
+	if inv.Status == StatusDraft {
+		title = "Draft invoice"
+	}
+	doc := pdf.New(title)
+	page := doc.AddPage()
+
+	y := pdf.PageHeight - margin - 10
+	page.Text(margin, y, pdf.Bold, 22, title)
+	from := merchant.Name
+	if from == "" {
+		from = merchant.ID
+	}
+	page.TextRight(colAmount, y, pdf.Bold, 12, from)
+
+	y -= 36
+	details := [][2]string{{"Invoice ID", inv.ID}}
+	if inv.FinalizedAt != nil {
+		details = append(details, [2]string{"Date of issue", inv.FinalizedAt.Format("January 2, 2006")})
+	}
+	switch {
+	case inv.DueDate != nil:
+		details = append(details, [2]string{"Date due", inv.DueDate.Format("January 2, 2006")})
+	case inv.CollectionMethod == ChargeAutomatically:
+		details = append(details, [2]string{"Payment", "Charged to the card on file"})
+	}
+	details = append(details, [2]string{"Status", strings.ToUpper(inv.Status[:1]) + inv.Status[1:]})
+	for _, d := range details {
+		page.Text(margin, y, pdf.Bold, 10, d[0])
+		page.Text(margin+90, y, pdf.Regular, 10, d[1])
+		y -= 14
+	}
+
+	y -= 14
+	page.Text(margin, y, pdf.Bold, 10, "Bill to")
+	y -= 14
+	for _, s := range []string{cust.Name, cust.Email, cust.ID} {
+		if s != "" {
+			page.Text(margin, y, pdf.Regular, 10, s)
+			y -= 14
+		}
+	}
+	if inv.Description != "" {
+		y -= 10
+		page.Text(margin, y, pdf.Regular, 10, inv.Description)
+		y -= 14
+	}
+
+	y -= 20
+	header := func() {
+		page.Text(margin, y, pdf.Bold, 9, "Description")
+		page.TextRight(colQty, y, pdf.Bold, 9, "Qty")
+		page.TextRight(colUnit, y, pdf.Bold, 9, "Unit price")
+		page.TextRight(colAmount, y, pdf.Bold, 9, "Amount")
+		page.Line(margin, y-6, colAmount, y-6, 0.5)
+		y -= rowHeight + 4
+	}
+	header()
+	for _, l := range lines {
+		if y < margin+rowHeight {
+			page = doc.AddPage()
+			y = pdf.PageHeight - margin
+			header()
+		}
+		description := l.Description
+		if l.TaxRateBps > 0 {
+			description += fmt.Sprintf(" (tax %s%%)", percent(l.TaxRateBps))
+		}
+		page.Text(margin, y, pdf.Regular, 10, description)
+		page.TextRight(colQty, y, pdf.Regular, 10, fmt.Sprint(l.Quantity))
+		page.TextRight(colUnit, y, pdf.Regular, 10, money(l.UnitAmount, inv.Currency))
+		page.TextRight(colAmount, y, pdf.Regular, 10, money(l.Amount, inv.Currency))
+		y -= rowHeight
+	}
+
+	totals := [][2]string{{"Subtotal", money(inv.Subtotal, inv.Currency)}}
+	if inv.Discount > 0 {
+		totals = append(totals, [2]string{"Discount (" + inv.CouponID + ")", "-" + money(inv.Discount, inv.Currency)})
+	}
+	taxLabel := "Tax"
+	if inv.TaxBehavior == taxes.Inclusive {
+		taxLabel = "Tax (included)"
+	}
+	totals = append(totals,
+		[2]string{taxLabel, money(inv.Tax, inv.Currency)},
+		[2]string{"Total", money(inv.Total, inv.Currency)},
+		[2]string{"Amount paid", money(inv.AmountPaid, inv.Currency)},
+		[2]string{"Amount due", money(inv.Total-inv.AmountPaid, inv.Currency)},
+	)
+	if y < margin+float64(len(totals))*rowHeight+10 {
+		page = doc.AddPage()
+		y = pdf.PageHeight - margin
+	}
+	page.Line(colQty, y+rowHeight-6, colAmount, y+rowHeight-6, 0.5)
+	y -= 4
+	for i, t := range totals {
+		font := pdf.Regular
+		if i == len(totals)-1 {
+			font = pdf.Bold
+		}
+		page.TextRight(colUnit, y, font, 10, t[0])
+		page.TextRight(colAmount, y, font, 10, t[1])
+		y -= rowHeight
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// money formats amount in minor units as 1,234.56 USD.
+func money(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	units := fmt.Sprint(amount / 100)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	return fmt.Sprintf("%s%s.%02d %s", sign, units, amount%100, strings.ToUpper(currency))
+}
+
+// percent formats basis points as a percentage, 1950 as 19.5.
+func percent(bps int64) string {
+	s := fmt.Sprintf("%d.%02d", bps/100, bps%100)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
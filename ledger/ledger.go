@@ -0,0 +1,160 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+// Accounts hold signed balances. Merchant accounts are positive when MiniPay
+// owes the merchant; processor_clearing is negative while the processor owes
+// MiniPay. discounts takes the part of a list price that a discount waived
+// and gets it back from the merchant straight away, so it nets to zero while
+// itemizing every discount. fx_conversion holds the currency MiniPay bought
+// or sold converting charges for merchants, in each currency; fx_revenue is
+// the markup on conversions and fx_gains_losses what converting back at a
+// later rate gained (positive) or lost. Each customer wallet has an account
+// of its own, named by WalletAccount, holding what MiniPay owes the customer.
+// Connected accounts have their own merchant accounts, named by
+// ConnectedAccount; transfers carries their share of a charge over from the
+// platform and nets to zero.
+const (
+	AccountMerchantAvailable = "merchant_available"
+	AccountMerchantPending   = "merchant_pending"
+	AccountMerchantReserved  = "merchant_reserved"
+	AccountProcessorClearing = "processor_clearing"
+	AccountDisputeFees       = "dispute_fees"
+	AccountPayoutsInTransit  = "payouts_in_transit"
+	AccountPayoutsPaid       = "payouts_paid"
+	AccountFeeRevenue        = "fee_revenue"
+	AccountAdjustments       = "adjustments"
+	AccountDiscounts         = "discounts"
+	AccountFXConversion      = "fx_conversion"
+	AccountFXRevenue         = "fx_revenue"
+	AccountFXGainsLosses     = "fx_gains_losses"
+	AccountTransfers         = "transfers"
+)
+
+// WalletAccount is the account of the wallet with id walletID.
+func WalletAccount(walletID string) string {
+	return "wallet:" + walletID
+}
+
+// ConnectedAccount is the connected account merchantID's own account for
+// account, one of the merchant accounts.
+func ConnectedAccount(account, merchantID string) string {
+	return account + ":" + merchantID
+}
+
+type Line struct {
+	Account  string
+	Currency string
+	Amount   int64
+}
+
+type Journal struct {
+	SourceType  string
+	SourceID    string
+	Description string
+	Lines       []Line
+}
+
+// Post writes every line of j under one journal ID. The lines of each
+// currency must sum to zero.
+func Post(tx *gorm.DB, j Journal) (string, error) {
+	sums := make(map[string]int64)
+	for _, l := range j.Lines {
+		if l.Account == "" || l.Currency == "" {
+			return "", fmt.Errorf("ledger: line missing account or currency in %s %s", j.SourceType, j.SourceID)
+		}
+		sums[l.Currency] += l.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return "", fmt.Errorf("ledger: %s %s is unbalanced by %d %s", j.SourceType, j.SourceID, sum, currency)
+		}
+	}
+
+	journalID := "jrnl_" + uuid.NewString()
+	entries := make([]models.LedgerEntry, 0, len(j.Lines))
+	for _, l := range j.Lines {
+		if l.Amount == 0 {
+			continue
+		}
+		entries = append(entries, models.LedgerEntry{
+			JournalID:   journalID,
+			Account:     l.Account,
+			Currency:    l.Currency,
+			Amount:      l.Amount,
+			SourceType:  j.SourceType,
+			SourceID:    j.SourceID,
+			Description: j.Description,
+		})
+	}
+	if len(entries) == 0 {
+		return journalID, nil
+	}
+	return journalID, tx.Create(&entries).Error
+}
+
+// Transfer is a two-line journal moving amount from one account to another.
+func Transfer(tx *gorm.DB, sourceType, sourceID, description, from, to, currency string, amount int64) (string, error) {
+	return Post(tx, Journal{
+		SourceType:  sourceType,
+		SourceID:    sourceID,
+		Description: description,
+		Lines: []Line{
+			{Account: from, Currency: currency, Amount: -amount},
+			{Account: to, Currency: currency, Amount: amount},
+		},
+	})
+}
+
+// Lock takes the lock of account in currency until tx ends. Whoever checks
+// a balance before drawing on it locks it first, so the balance cannot
+// change between the check and the debit.
+func Lock(tx *gorm.DB, account, currency string) error {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.LedgerLock{Account: account, Currency: currency}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.LedgerLock{}).
+		Where("account = ? AND currency = ?", account, currency).
+		Update("version", gorm.Expr("version + 1")).Error
+}
+
+func Balance(db *gorm.DB, account, currency string) (int64, error) {
+	var total int64
+	err := db.Model(&models.LedgerEntry{}).
+		Where("account = ? AND currency = ?", account, currency).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// Balances returns the balance of account in every currency it has entries in.
+func Balances(db *gorm.DB, account string) (map[string]int64, error) {
+	var rows []struct {
+		Currency string
+		Total    int64
+	}
+	err := db.Model(&models.LedgerEntry{}).
+		Where("account = ?", account).
+		Select("currency, SUM(amount) AS total").
+		Group("currency").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		balances[r.Currency] = r.Total
+	}
+	return balances, nil
+}
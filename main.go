@@ -1,28 +1,60 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"text/tabwriter"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/reconciliation"
 	"github.com/vaidikcode/minipay/routes"
 	"github.com/vaidikcode/minipay/utils"
 	"github.com/vaidikcode/minipay/workers"
 )
 
 func main() {
+	// The key is loaded first: opening the database tokenizes cards on file.
+	keyed := processor.LoadFingerprintKey()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	utils.InitLogger()
+	if !keyed {
+		log.Println("CARD_FINGERPRINT_KEY is not set; card fingerprints and tokens use the development key")
+	}
 	config.InitDB("minipay.db")
 
+	if path := os.Getenv("ROUTING_CONFIG"); path != "" {
+		router, err := processor.LoadRouter(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		processor.Default = router
+	}
+
 	go workers.StartWebhookWorker(1 * time.Second)
+	go workers.StartDisputeWorker(1 * time.Minute)
+	go workers.StartSettlementWorker(1 * time.Minute)
+	go workers.StartPayoutWorker(1 * time.Minute)
+	go workers.StartBillingWorker(1 * time.Minute)
+	go workers.StartRiskWorker(1 * time.Minute)
+	go workers.StartAuthenticationWorker(1 * time.Minute)
 
 	r := gin.Default()
 
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-Request-Id, Idempotency-Key, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -42,3 +74,113 @@ func main() {
 
 	r.Run(":" + port)
 }
+
+// runCommand handles `minipay <command>` invocations that run once and exit
+// instead of starting the server.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import-statement":
+		return importStatement(args[1:])
+	case "import-fx-rates":
+		return importFXRates(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\nusage: minipay [import-statement [-db path] [-format camt053|mt940|csv] file... | import-fx-rates [-db path] file...]\n", args[0])
+	return 2
+}
+
+func importStatement(args []string) int {
+	fs := flag.NewFlagSet("import-statement", flag.ContinueOnError)
+	dbPath := fs.String("db", "minipay.db", "SQLite database to import into")
+	format := fs.String("format", "", "statement format: camt053, mt940 or csv (detected when empty)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: minipay import-statement [-db path] [-format camt053|mt940|csv] file...")
+		return 2
+	}
+
+	config.InitDB(*dbPath)
+
+	status := 0
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		imported, err := reconciliation.Import(config.DB, *format, filepath.Base(path), data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		for _, stmt := range imported {
+			report, err := reconciliation.BuildReport(config.DB, stmt.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				status = 1
+				continue
+			}
+			printReport(report)
+		}
+	}
+	return status
+}
+
+func importFXRates(args []string) int {
+	fs := flag.NewFlagSet("import-fx-rates", flag.ContinueOnError)
+	dbPath := fs.String("db", "minipay.db", "SQLite database to import into")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: minipay import-fx-rates [-db path] file...")
+		return 2
+	}
+
+	config.InitDB(*dbPath)
+
+	status := 0
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		imported, err := fx.Import(config.DB, filepath.Base(path), data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		for _, r := range imported {
+			fmt.Printf("%s  %s/%s  %s\n", r.ID, r.Base, r.Quote, fx.FormatRate(r.Rate))
+		}
+	}
+	return status
+}
+
+func printReport(r *reconciliation.Report) {
+	s := r.Statement
+	fmt.Printf("%s  %s %s  %s to %s\n", s.ID, s.Format, s.Account, s.PeriodStart.Format("2006-01-02"), s.PeriodEnd.Format("2006-01-02"))
+	fmt.Printf("matched %d  unmatched %d  suspicious %d  missing %d\n\n",
+		len(r.Matched), len(r.Unmatched), len(r.Suspicious), len(r.Missing))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDATE\tAMOUNT\tREFERENCE\tMATCH\tNOTE")
+	for _, group := range [][]models.BankStatementLine{r.Matched, r.Suspicious, r.Unmatched} {
+		for _, l := range group {
+			fmt.Fprintf(w, "%s\t%s\t%d %s\t%s\t%s\t%s\n",
+				l.Status, l.BookingDate.Format("2006-01-02"), l.Amount, l.Currency, l.Reference, l.MatchID, l.Note)
+		}
+	}
+	for _, e := range r.Missing {
+		fmt.Fprintf(w, "missing\t%s\t%d %s\t\t%s\t%s expected but not on the statement\n",
+			e.Date.Format("2006-01-02"), e.Amount, e.Currency, e.ID, e.Type)
+	}
+	w.Flush()
+	fmt.Println()
+}
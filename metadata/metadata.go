@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/apierror"
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	MaxKeys        = 50
+	MaxKeyLength   = 40
+	MaxValueLength = 500
+)
+
+const (
+	ObjectCharge   = "charge"
+	ObjectRefund   = "refund"
+	ObjectCustomer = "customer"
+)
+
+// Validate checks md against the key count and length limits. Empty values
+// are allowed so that updates can unset keys.
+func Validate(md map[string]string) *apierror.Error {
+	if len(md) > MaxKeys {
+		return apierror.Invalid("metadata", fmt.Sprintf("Metadata can have at most %d keys.", MaxKeys))
+	}
+
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == "" {
+			return apierror.Invalid("metadata", "Metadata keys must not be empty.")
+		}
+		if len(k) > MaxKeyLength {
+			return apierror.Invalid("metadata["+k+"]", fmt.Sprintf("Metadata keys can be at most %d characters.", MaxKeyLength))
+		}
+		if len(md[k]) > MaxValueLength {
+			return apierror.Invalid("metadata["+k+"]", fmt.Sprintf("Metadata values can be at most %d characters.", MaxValueLength))
+		}
+	}
+	return nil
+}
+
+// Merge applies updates on top of current. An empty value removes the key.
+func Merge(current models.Metadata, updates map[string]string) models.Metadata {
+	merged := models.Metadata{}
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range updates {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Clean drops keys with empty values, for objects created from scratch.
+func Clean(md map[string]string) models.Metadata {
+	return Merge(nil, md)
+}
+
+// Sync replaces the lookup rows for one object with md.
+func Sync(tx *gorm.DB, objectType, objectID string, md models.Metadata) error {
+	if err := tx.Where("object_type = ? AND object_id = ?", objectType, objectID).Delete(&models.MetadataEntry{}).Error; err != nil {
+		return err
+	}
+	if len(md) == 0 {
+		return nil
+	}
+
+	entries := make([]models.MetadataEntry, 0, len(md))
+	for k, v := range md {
+		entries = append(entries, models.MetadataEntry{ObjectType: objectType, ObjectID: objectID, Key: k, Value: v})
+	}
+	return tx.Create(&entries).Error
+}
+
+// Filter restricts query to objects whose metadata contains every pair in
+// filters. The subquery works unchanged on SQLite and Postgres.
+func Filter(query *gorm.DB, objectType string, filters map[string]string) *gorm.DB {
+	keys := make([]string, 0, len(filters))
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		query = query.Where(
+			"id IN (SELECT object_id FROM metadata_entries WHERE object_type = ? AND meta_key = ? AND meta_value = ?)",
+			objectType, k, filters[k],
+		)
+	}
+	return query
+}
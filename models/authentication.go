@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Authentication is the 3-D Secure challenge of a charge that waits in
+// requires_action until the cardholder completes it. Signals and Tax keep
+// what the charge was screened and taxed with, as JSON, for when it
+// resumes; ReturnURL is where the cardholder is sent back to. Challenge is
+// the processor's state of the challenge, without card details, handed back
+// to it when the challenge is completed.
+type Authentication struct {
+	ID            string  `gorm:"primaryKey"`
+	TransactionID string  `gorm:"size:64;uniqueIndex;not null"`
+	ReturnURL     string  `gorm:"size:2048"`
+	Signals       Signals `gorm:"type:text"`
+	Tax           string  `gorm:"type:text"`
+	Challenge     string  `gorm:"type:text"`
+	CompletedAt   *time.Time
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+func (a Authentication) TableName() string {
+	return "authentications"
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// BalanceTransaction is one movement of a merchant's balance. Amount is the
+// gross, Fee what MiniPay keeps and Net = Amount - Fee what the balance moves.
+// Balance transactions are never updated once written.
+type BalanceTransaction struct {
+	ID          string     `gorm:"primaryKey"`
+	MerchantID  string     `gorm:"size:64;index;not null"`
+	Type        string     `gorm:"size:32;index;not null"`
+	SourceID    string     `gorm:"size:64;index"`
+	Amount      int64      `gorm:"not null"`
+	Fee         int64      `gorm:"not null;default:0"`
+	Net         int64      `gorm:"not null"`
+	Currency    string     `gorm:"size:8;index;not null"`
+	FeeDetails  FeeDetails `gorm:"type:text"`
+	Description string     `gorm:"size:255"`
+	Status      string     `gorm:"size:16;index;default:'available'"`
+	AvailableOn time.Time  `gorm:"index"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime;index"`
+}
+
+func (b BalanceTransaction) TableName() string {
+	return "balance_transactions"
+}
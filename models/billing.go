@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+type Product struct {
+	ID          string    `gorm:"primaryKey"`
+	Name        string    `gorm:"size:255;not null"`
+	Description string    `gorm:"size:1024"`
+	Active      bool      `gorm:"default:true"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
+
+func (p Product) TableName() string {
+	return "products"
+}
+
+// Price is what a product costs per billing period of IntervalCount
+// Intervals. A flat price is charged as is; a per_unit price is multiplied
+// by the subscription's quantity.
+type Price struct {
+	ID            string    `gorm:"primaryKey"`
+	ProductID     string    `gorm:"size:64;index;not null"`
+	Currency      string    `gorm:"size:8;not null"`
+	UnitAmount    int64     `gorm:"not null"`
+	BillingScheme string    `gorm:"size:16;not null"`
+	Interval      string    `gorm:"size:8;not null"`
+	IntervalCount int       `gorm:"not null"`
+	TrialDays     int       `gorm:"default:0"`
+	Active        bool      `gorm:"default:true"`
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+func (p Price) TableName() string {
+	return "prices"
+}
+
+// Subscription bills a customer for a price every period. Periods end on the
+// same day of the month as BillingAnchor. ProrationAmount is
+// carried over from plan changes into the next renewal; AmountDue is what a
+// past_due subscription still has to collect. A coupon discounts the periods
+// starting before DiscountEnd, or every period when it is nil.
+type Subscription struct {
+	ID                  string    `gorm:"primaryKey"`
+	CustomerID          string    `gorm:"size:64;index;not null"`
+	PriceID             string    `gorm:"size:64;index;not null"`
+	MerchantID          string    `gorm:"size:64;index;not null"`
+	Quantity            int64     `gorm:"not null;default:1"`
+	Status              string    `gorm:"size:16;index;not null"`
+	BillingAnchor       time.Time `gorm:"not null"`
+	CurrentPeriodStart  time.Time `gorm:"not null"`
+	CurrentPeriodEnd    time.Time `gorm:"index;not null"`
+	TrialEnd            *time.Time
+	CancelAtPeriodEnd   bool `gorm:"default:false"`
+	CanceledAt          *time.Time
+	ProrationAmount     int64      `gorm:"default:0"`
+	AmountDue           int64      `gorm:"default:0"`
+	Attempts            int        `gorm:"default:0"`
+	NextAttemptAt       *time.Time `gorm:"index"`
+	LatestTransactionID string     `gorm:"size:64"`
+	CouponID            string     `gorm:"size:64;index"`
+	PromotionCodeID     string     `gorm:"size:64"`
+	DiscountEnd         *time.Time
+	Metadata            Metadata  `gorm:"type:text"`
+	CreatedAt           time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+func (s Subscription) TableName() string {
+	return "subscriptions"
+}
+
+// DunningSettings is the single row configuring how failed renewals are
+// retried: RetryDays are the days after the first failure to try again, and
+// FinalAction is what happens to the subscription when they all fail.
+type DunningSettings struct {
+	ID          uint      `gorm:"primaryKey"`
+	RetryDays   string    `gorm:"size:64;not null"`
+	FinalAction string    `gorm:"size:16;not null"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+}
+
+func (d DunningSettings) TableName() string {
+	return "dunning_settings"
+}
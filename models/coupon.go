@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Coupon takes PercentOffBps off, or AmountOff of Currency. On subscriptions
+// Duration decides how long it keeps applying: to the first payment only
+// (once), for DurationInMonths (repeating) or forever. TimesRedeemed counts
+// the charges, invoices and subscriptions it has been applied to, up to
+// MaxRedemptions when that is set.
+type Coupon struct {
+	ID               string     `gorm:"primaryKey"`
+	Name             string     `gorm:"size:255"`
+	PercentOffBps    int64      `gorm:"default:0"`
+	AmountOff        int64      `gorm:"default:0"`
+	Currency         string     `gorm:"size:8"`
+	Duration         string     `gorm:"size:16;not null"`
+	DurationInMonths int        `gorm:"default:0"`
+	MaxRedemptions   int64      `gorm:"default:0"`
+	TimesRedeemed    int64      `gorm:"default:0"`
+	RedeemBy         *time.Time `gorm:"index"`
+	Valid            bool       `gorm:"default:true"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime;index"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime"`
+}
+
+func (c Coupon) TableName() string {
+	return "coupons"
+}
+
+// PromotionCode is a code customers enter to redeem a coupon, with its own
+// redemption limit and expiry. CustomerID restricts it to one customer.
+type PromotionCode struct {
+	ID             string     `gorm:"primaryKey"`
+	Code           string     `gorm:"size:64;uniqueIndex;not null"`
+	CouponID       string     `gorm:"size:64;index;not null"`
+	CustomerID     string     `gorm:"size:64"`
+	MaxRedemptions int64      `gorm:"default:0"`
+	TimesRedeemed  int64      `gorm:"default:0"`
+	ExpiresAt      *time.Time `gorm:"index"`
+	Active         bool       `gorm:"default:true"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime;index"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime"`
+}
+
+func (p PromotionCode) TableName() string {
+	return "promotion_codes"
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Customer may keep a card on file for recurring payments: its processor
+// token, brand, last four digits and expiry, never its number. Country, Region, PostalCode and TaxID
+// (a VAT ID) decide how the customer's payments are taxed. KYCTier is how far
+// the customer's identity has been verified, which sets their wallet limits.
+type Customer struct {
+	ID           string    `gorm:"primaryKey"`
+	Email        string    `gorm:"size:255;index"`
+	Name         string    `gorm:"size:255"`
+	Country      string    `gorm:"size:2"`
+	Region       string    `gorm:"size:16"`
+	PostalCode   string    `gorm:"size:16"`
+	TaxID        string    `gorm:"size:32"`
+	KYCTier      string    `gorm:"size:16;not null;default:'none'"`
+	CardToken    string    `gorm:"size:80"`
+	CardExpMonth int       `gorm:"default:0"`
+	CardExpYear  int       `gorm:"default:0"`
+	CardBrand    string    `gorm:"size:16"`
+	CardLast4    string    `gorm:"size:4"`
+	Metadata     Metadata  `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+func (c Customer) TableName() string {
+	return "customers"
+}
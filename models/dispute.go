@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type Dispute struct {
+	ID                  string    `gorm:"primaryKey"`
+	TransactionID       string    `gorm:"size:64;index;not null"`
+	Amount              int64     `gorm:"not null"`
+	Fee                 int64     `gorm:"not null;default:0"`
+	Currency            string    `gorm:"size:8;not null"`
+	Reason              string    `gorm:"size:64;not null"`
+	Status              string    `gorm:"size:32;index;not null"`
+	Evidence            Metadata  `gorm:"type:text"`
+	EvidenceDueBy       time.Time `gorm:"index"`
+	EvidenceSubmittedAt *time.Time
+	ClosedAt            *time.Time
+	CreatedAt           time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+func (d Dispute) TableName() string {
+	return "disputes"
+}
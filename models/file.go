@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type File struct {
+	ID          string    `gorm:"primaryKey"`
+	Purpose     string    `gorm:"size:32;index;not null"`
+	OwnerID     string    `gorm:"size:64;index"`
+	Filename    string    `gorm:"size:255;not null"`
+	ContentType string    `gorm:"size:128"`
+	Size        int64     `gorm:"not null"`
+	SHA256      string    `gorm:"size:64"`
+	Path        string    `gorm:"size:512;not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (f File) TableName() string {
+	return "files"
+}
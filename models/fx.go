@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// FXRate is the mid-market price of one unit of Base in Quote, in units of
+// 1e-8. Rates are never changed: loading a new rate for a pair supersedes
+// the old one, which is kept for the record. Source is "api" or the name of
+// the file the rate was loaded from.
+type FXRate struct {
+	ID        string    `gorm:"primaryKey"`
+	Base      string    `gorm:"size:8;index:idx_fx_rates_pair;not null"`
+	Quote     string    `gorm:"size:8;index:idx_fx_rates_pair;not null"`
+	Rate      int64     `gorm:"not null"`
+	Source    string    `gorm:"size:255"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (r FXRate) TableName() string {
+	return "fx_rates"
+}
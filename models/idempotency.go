@@ -2,9 +2,12 @@ package models
 
 import "time"
 
+// IdempotencyKey binds a key to the charge it made. RequestHash identifies
+// the request the key was first sent with.
 type IdempotencyKey struct {
-	ID            string    `gorm:"primaryKey"`
-	TransactionID string    `gorm:"index;not null"`
+	ID            string `gorm:"primaryKey"`
+	TransactionID string `gorm:"index;not null"`
+	RequestHash   string
 	CreatedAt     time.Time `gorm:"autoCreateTime"`
 }
 
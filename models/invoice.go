@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// Invoice bills a customer for its line items. Drafts can still be edited;
+// finalizing assigns Number from the merchant's invoice sequence and fixes
+// the totals. CollectionMethod decides whether the invoice is charged to the
+// customer's card on file right away or sent and paid by DueDate. A coupon
+// takes Discount off the subtotal before tax. With a TaxBehavior of
+// "exclusive" or "inclusive" the lines are taxed from the tax rate table
+// instead of their own rates; inclusive line amounts already contain the
+// tax, which is then not added to the total.
+type Invoice struct {
+	ID               string   `gorm:"primaryKey"`
+	Number           string   `gorm:"size:32;index"`
+	MerchantID       string   `gorm:"size:64;index;not null"`
+	CustomerID       string   `gorm:"size:64;index;not null"`
+	Currency         string   `gorm:"size:8;not null"`
+	Status           string   `gorm:"size:16;index;not null"`
+	CollectionMethod string   `gorm:"size:32;not null"`
+	DaysUntilDue     int      `gorm:"default:0"`
+	Description      string   `gorm:"size:1024"`
+	Subtotal         int64    `gorm:"default:0"`
+	CouponID         string   `gorm:"size:64;index"`
+	PromotionCodeID  string   `gorm:"size:64"`
+	Discount         int64    `gorm:"default:0"`
+	TaxBehavior      string   `gorm:"size:16"`
+	Tax              int64    `gorm:"default:0"`
+	Total            int64    `gorm:"default:0"`
+	AmountPaid       int64    `gorm:"default:0"`
+	Attempts         int      `gorm:"default:0"`
+	TransactionID    string   `gorm:"size:64;index"`
+	Metadata         Metadata `gorm:"type:text"`
+	DueDate          *time.Time
+	FinalizedAt      *time.Time
+	PaidAt           *time.Time
+	VoidedAt         *time.Time
+	OverdueAt        *time.Time
+	CreatedAt        time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime"`
+}
+
+func (i Invoice) TableName() string {
+	return "invoices"
+}
+
+// InvoiceLine is one item of an invoice: Quantity times UnitAmount, with
+// TaxRateBps of tax on top. DiscountAmount is the line's share of the
+// invoice discount; tax is charged on what is left. TaxCode picks the rate
+// when the invoice is taxed from the rate table, which also sets TaxRateBps.
+type InvoiceLine struct {
+	ID             string    `gorm:"primaryKey"`
+	InvoiceID      string    `gorm:"size:64;index;not null"`
+	Description    string    `gorm:"size:255;not null"`
+	Quantity       int64     `gorm:"not null;default:1"`
+	UnitAmount     int64     `gorm:"not null"`
+	Amount         int64     `gorm:"not null"`
+	TaxCode        string    `gorm:"size:32"`
+	TaxRateBps     int64     `gorm:"default:0"`
+	DiscountAmount int64     `gorm:"default:0"`
+	Tax            int64     `gorm:"default:0"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (l InvoiceLine) TableName() string {
+	return "invoice_lines"
+}
+
+// InvoiceSequence hands out a merchant's invoice numbers, Prefix-0001
+// upwards, without gaps.
+type InvoiceSequence struct {
+	MerchantID string    `gorm:"primaryKey"`
+	Prefix     string    `gorm:"size:16;not null"`
+	Next       int64     `gorm:"not null;default:1"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
+
+func (s InvoiceSequence) TableName() string {
+	return "invoice_sequences"
+}
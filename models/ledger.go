@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type LedgerEntry struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement"`
+	JournalID   string    `gorm:"size:64;index;not null"`
+	Account     string    `gorm:"size:64;index:idx_ledger_account,priority:1;not null"`
+	Currency    string    `gorm:"size:8;index:idx_ledger_account,priority:2;not null"`
+	Amount      int64     `gorm:"not null"`
+	SourceType  string    `gorm:"size:32;index:idx_ledger_source,priority:1"`
+	SourceID    string    `gorm:"size:64;index:idx_ledger_source,priority:2"`
+	Description string    `gorm:"size:255"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (l LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// LedgerLock is the row locked while a balance is checked and drawn on, so
+// that two debits of the same account and currency take turns.
+type LedgerLock struct {
+	Account   string    `gorm:"size:64;primaryKey"`
+	Currency  string    `gorm:"size:8;primaryKey"`
+	Version   int64     `gorm:"not null;default:0"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (l LedgerLock) TableName() string {
+	return "ledger_locks"
+}
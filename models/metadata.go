@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metadata is stored as a JSON object in a text column so it reads the same
+// on every database. Lookups go through MetadataEntry instead.
+type Metadata map[string]string
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*m = Metadata{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("models: cannot scan %T into Metadata", src)
+	}
+	if len(data) == 0 {
+		*m = Metadata{}
+		return nil
+	}
+	return json.Unmarshal(data, m)
+}
+
+type MetadataEntry struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	ObjectType string `gorm:"size:32;not null;index:idx_metadata_lookup,priority:1;index:idx_metadata_object,priority:1"`
+	ObjectID   string `gorm:"size:64;not null;index:idx_metadata_object,priority:2"`
+	Key        string `gorm:"column:meta_key;size:40;not null;index:idx_metadata_lookup,priority:2"`
+	Value      string `gorm:"column:meta_value;size:500;not null;index:idx_metadata_lookup,priority:3"`
+}
+
+func (m MetadataEntry) TableName() string {
+	return "metadata_entries"
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// BankAccount is where payouts are sent. Accounts with a CustomerID belong
+// to that customer and only receive their wallet withdrawals; accounts with
+// a MerchantID belong to that connected account.
+type BankAccount struct {
+	ID                 string    `gorm:"primaryKey"`
+	Currency           string    `gorm:"size:8;index;not null"`
+	Country            string    `gorm:"size:2;not null"`
+	AccountHolderName  string    `gorm:"size:255;not null"`
+	AccountHolderType  string    `gorm:"size:16;default:'company'"`
+	IBAN               string    `gorm:"size:34"`
+	BIC                string    `gorm:"size:11"`
+	RoutingNumber      string    `gorm:"size:9"`
+	AccountNumber      string    `gorm:"size:34"`
+	Last4              string    `gorm:"size:4"`
+	DefaultForCurrency bool      `gorm:"default:false"`
+	CustomerID         string    `gorm:"size:64;index"`
+	MerchantID         string    `gorm:"size:64;index"`
+	CreatedAt          time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+}
+
+func (b BankAccount) TableName() string {
+	return "bank_accounts"
+}
+
+// Payout sends funds to a bank account: from the platform's available
+// balance, the connected account with MerchantID's, or the wallet with
+// WalletID.
+type Payout struct {
+	ID             string    `gorm:"primaryKey"`
+	Amount         int64     `gorm:"not null"`
+	Currency       string    `gorm:"size:8;index;not null"`
+	BankAccountID  string    `gorm:"size:64;index;not null"`
+	Status         string    `gorm:"size:32;index;not null"`
+	Automatic      bool      `gorm:"default:false"`
+	Description    string    `gorm:"size:255"`
+	ArrivalDate    time.Time `gorm:"index"`
+	FileID         string    `gorm:"size:64;index"`
+	FailureCode    string    `gorm:"size:64"`
+	FailureMessage string    `gorm:"size:255"`
+	WalletID       string    `gorm:"size:64;index"`
+	MerchantID     string    `gorm:"size:64;index"`
+	Metadata       Metadata  `gorm:"type:text"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
+
+func (p Payout) TableName() string {
+	return "payouts"
+}
+
+type PayoutSchedule struct {
+	ID           uint   `gorm:"primaryKey"`
+	Interval     string `gorm:"size:16;not null;default:'manual'"`
+	WeeklyAnchor string `gorm:"size:16"`
+	LastRunAt    *time.Time
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+func (p PayoutSchedule) TableName() string {
+	return "payout_schedules"
+}
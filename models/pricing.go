@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FeeRate is a percentage in basis points plus a fixed amount in minor units.
+type FeeRate struct {
+	PercentBps int64 `json:"percent_bps"`
+	Fixed      int64 `json:"fixed"`
+}
+
+// FeeRates maps a lowercase currency, or "*" for any other, to its rate.
+type FeeRates map[string]FeeRate
+
+// Surcharges maps a card brand to an extra percentage in basis points.
+type Surcharges map[string]int64
+
+// FeeDetail is one component of a fee; negative amounts are fees returned.
+type FeeDetail struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+type FeeDetails []FeeDetail
+
+func (r FeeRates) Value() (driver.Value, error) {
+	return jsonValue(r, "{}")
+}
+
+func (r *FeeRates) Scan(src interface{}) error {
+	return jsonScan(src, r, "FeeRates")
+}
+
+func (s Surcharges) Value() (driver.Value, error) {
+	return jsonValue(s, "{}")
+}
+
+func (s *Surcharges) Scan(src interface{}) error {
+	return jsonScan(src, s, "Surcharges")
+}
+
+func (d FeeDetails) Value() (driver.Value, error) {
+	return jsonValue(d, "[]")
+}
+
+func (d *FeeDetails) Scan(src interface{}) error {
+	return jsonScan(src, d, "FeeDetails")
+}
+
+func jsonValue(v interface{}, empty string) (driver.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(b) == "null" {
+		return empty, nil
+	}
+	return string(b), nil
+}
+
+func jsonScan(src, dst interface{}, name string) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("models: cannot scan %T into %s", src, name)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dst)
+}
+
+type PricingPlan struct {
+	ID               string     `gorm:"primaryKey"`
+	Name             string     `gorm:"size:255;not null"`
+	Rates            FeeRates   `gorm:"type:text"`
+	BrandSurcharges  Surcharges `gorm:"type:text"`
+	InternationalBps int64      `gorm:"default:0"`
+	RefundPolicy     string     `gorm:"size:32;not null;default:'keep_fee'"`
+	RefundFixedFee   int64      `gorm:"default:0"`
+	FXMarkupBps      int64      `gorm:"default:0"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime;index"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime"`
+}
+
+func (p PricingPlan) TableName() string {
+	return "pricing_plans"
+}
+
+// Merchant is an account charges are made for. SettlementDelayDays is how
+// many business days charge funds stay pending before they can be paid out.
+// Charges in another currency than SettlementCurrency, when it is set, are
+// converted to it. A merchant with a PlatformID is a connected account of
+// that platform: it is paid its share of the platform's charges and has
+// balances and payouts of its own.
+type Merchant struct {
+	ID                  string    `gorm:"primaryKey"`
+	Name                string    `gorm:"size:255"`
+	Country             string    `gorm:"size:2"`
+	PricingPlanID       string    `gorm:"size:64;index;not null"`
+	SettlementDelayDays int       `gorm:"not null;default:0"`
+	SettlementCurrency  string    `gorm:"size:8"`
+	PlatformID          string    `gorm:"size:64;index"`
+	CreatedAt           time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+func (m Merchant) TableName() string {
+	return "merchants"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type Refund struct {
+	ID                   string    `gorm:"primaryKey"`
+	TransactionID        string    `gorm:"size:64;index;not null"`
+	Amount               int64     `gorm:"not null"`
+	Currency             string    `gorm:"size:8;not null"`
+	Status               string    `gorm:"size:32;index;default:'succeeded'"`
+	Fee                  int64     `gorm:"default:0"`
+	BalanceTransactionID string    `gorm:"size:64"`
+	Metadata             Metadata  `gorm:"type:text"`
+	CreatedAt            time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt            time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r Refund) TableName() string {
+	return "refunds"
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// ReserveRule holds back part of a merchant's settled funds. A rolling rule
+// holds PercentBps of every settled charge for HoldDays; a fixed rule keeps
+// Amount of Currency held for as long as it is active.
+type ReserveRule struct {
+	ID         string    `gorm:"primaryKey"`
+	MerchantID string    `gorm:"size:64;index;not null"`
+	Type       string    `gorm:"size:16;not null"`
+	PercentBps int64     `gorm:"default:0"`
+	HoldDays   int       `gorm:"default:0"`
+	Amount     int64     `gorm:"default:0"`
+	Currency   string    `gorm:"size:8"`
+	Active     bool      `gorm:"index;default:true"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r ReserveRule) TableName() string {
+	return "reserve_rules"
+}
+
+// Reserve is an amount moved from the available to the reserved balance,
+// either by a rule or by hand.
+type Reserve struct {
+	ID         string     `gorm:"primaryKey"`
+	MerchantID string     `gorm:"size:64;index;not null"`
+	RuleID     string     `gorm:"size:64;index"`
+	Type       string     `gorm:"size:16;not null"`
+	SourceID   string     `gorm:"size:64;index"`
+	Amount     int64      `gorm:"not null"`
+	Currency   string     `gorm:"size:8;not null"`
+	Status     string     `gorm:"size:16;index;default:'held'"`
+	Reason     string     `gorm:"size:255"`
+	ReleaseOn  *time.Time `gorm:"index"`
+	ReleasedAt *time.Time
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r Reserve) TableName() string {
+	return "reserves"
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// StringList is a list of strings stored as JSON.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	return jsonValue(l, "[]")
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	return jsonScan(src, l, "StringList")
+}
+
+// Signals are attributes of a charge by name, stored as JSON.
+type Signals map[string]interface{}
+
+func (s Signals) Value() (driver.Value, error) {
+	return jsonValue(s, "{}")
+}
+
+func (s *Signals) Scan(src interface{}) error {
+	return jsonScan(src, s, "Signals")
+}
+
+// RiskRule blocks, holds for review or allows the charges its Expression
+// matches. Inactive rules are kept but not evaluated.
+type RiskRule struct {
+	ID         string    `gorm:"primaryKey"`
+	Name       string    `gorm:"size:255"`
+	Expression string    `gorm:"size:1024;not null"`
+	Action     string    `gorm:"size:16;index;not null"`
+	Active     bool      `gorm:"not null;default:true"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r RiskRule) TableName() string {
+	return "risk_rules"
+}
+
+// Review holds a charge a review rule matched until an analyst approves it,
+// capturing the charge, or rejects it, voiding or refunding the charge. An
+// open review is closed with its DefaultAction once ExpiresAt passes.
+// Signals are the attributes the charge was screened with.
+type Review struct {
+	ID            string    `gorm:"primaryKey"`
+	TransactionID string    `gorm:"size:64;uniqueIndex;not null"`
+	Status        string    `gorm:"size:16;index;not null"`
+	Signals       Signals   `gorm:"type:text"`
+	DefaultAction string    `gorm:"size:16;not null"`
+	ExpiresAt     time.Time `gorm:"index"`
+	ClosedBy      string    `gorm:"size:255"`
+	Note          string    `gorm:"size:1024"`
+	ClosedAt      *time.Time
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r Review) TableName() string {
+	return "reviews"
+}
+
+// ReviewEvent is an entry of a review's audit trail: who opened, approved,
+// rejected or expired it, when and why.
+type ReviewEvent struct {
+	ID        string    `gorm:"primaryKey"`
+	ReviewID  string    `gorm:"size:64;index;not null"`
+	Action    string    `gorm:"size:16;not null"`
+	Actor     string    `gorm:"size:255;not null"`
+	Note      string    `gorm:"size:1024"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (e ReviewEvent) TableName() string {
+	return "review_events"
+}
+
+// BlocklistEntry refuses every charge whose customer, customer email, card
+// fingerprint or IP address is Value. An IP entry may be a CIDR range.
+type BlocklistEntry struct {
+	ID        string    `gorm:"primaryKey"`
+	Type      string    `gorm:"size:32;uniqueIndex:idx_blocklist_type_value;not null"`
+	Value     string    `gorm:"size:255;uniqueIndex:idx_blocklist_type_value;not null"`
+	Reason    string    `gorm:"size:255"`
+	Active    bool      `gorm:"not null;default:true"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (e BlocklistEntry) TableName() string {
+	return "blocklist_entries"
+}
+
+// VelocityRule caps how many of Event, or how much of them in Currency when
+// Metric is "amount", a single value of Scope may have in the sliding window
+// of WindowSeconds that ends now.
+type VelocityRule struct {
+	ID            string    `gorm:"primaryKey"`
+	Name          string    `gorm:"size:255"`
+	Event         string    `gorm:"size:16;index;not null"`
+	Scope         string    `gorm:"size:16;not null"`
+	Metric        string    `gorm:"size:16;not null"`
+	Currency      string    `gorm:"size:8"`
+	Limit         int64     `gorm:"column:max_value;not null"`
+	WindowSeconds int64     `gorm:"not null"`
+	Active        bool      `gorm:"not null;default:true"`
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r VelocityRule) TableName() string {
+	return "velocity_rules"
+}
+
+// VelocityEvent is a charge or refund counted against the value of a scope
+// it was made with, such as its customer or card fingerprint.
+type VelocityEvent struct {
+	ID        string    `gorm:"primaryKey"`
+	Event     string    `gorm:"size:16;index:idx_velocity_events_lookup,priority:3;not null"`
+	Scope     string    `gorm:"size:16;index:idx_velocity_events_lookup,priority:1;not null"`
+	Value     string    `gorm:"size:255;index:idx_velocity_events_lookup,priority:2;not null"`
+	SourceID  string    `gorm:"size:64;index"`
+	Amount    int64     `gorm:"not null"`
+	Currency  string    `gorm:"size:8;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_velocity_events_lookup,priority:4"`
+}
+
+func (e VelocityEvent) TableName() string {
+	return "velocity_events"
+}
+
+// VelocityCounter is the row locked while the events of one scope value are
+// counted and recorded, so that instances sharing the database take turns.
+// Hits counts the checks made against it.
+type VelocityCounter struct {
+	ID        string    `gorm:"primaryKey"`
+	Hits      int64     `gorm:"not null;default:0"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (c VelocityCounter) TableName() string {
+	return "velocity_counters"
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type BankStatement struct {
+	ID             string `gorm:"primaryKey"`
+	Format         string `gorm:"size:16;not null"`
+	Reference      string `gorm:"size:64"`
+	Account        string `gorm:"size:64;index"`
+	Currency       string `gorm:"size:8;index"`
+	OpeningBalance int64
+	ClosingBalance int64
+	HasBalances    bool      `gorm:"default:false"`
+	PeriodStart    time.Time `gorm:"index"`
+	PeriodEnd      time.Time `gorm:"index"`
+	FileID         string    `gorm:"size:64;index"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (s BankStatement) TableName() string {
+	return "bank_statements"
+}
+
+type BankStatementLine struct {
+	ID          string    `gorm:"primaryKey"`
+	StatementID string    `gorm:"size:64;index;not null"`
+	BookingDate time.Time `gorm:"index"`
+	ValueDate   time.Time
+	Amount      int64     `gorm:"not null"`
+	Currency    string    `gorm:"size:8;index"`
+	Reference   string    `gorm:"size:255"`
+	Description string    `gorm:"size:1024"`
+	Status      string    `gorm:"size:16;index;not null"`
+	MatchType   string    `gorm:"size:16"`
+	MatchID     string    `gorm:"size:64;index"`
+	Note        string    `gorm:"size:255"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+func (l BankStatementLine) TableName() string {
+	return "bank_statement_lines"
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// TaxRate is one row of the tax rate table: RateBps of tax on sales to
+// Country, narrowed to a Region and to postal codes starting with
+// PostalPrefix when those are set, and to one product TaxCode when that is
+// set. Kind is "vat" or "sales_tax"; only VAT can be reverse charged.
+type TaxRate struct {
+	ID           string    `gorm:"primaryKey"`
+	Name         string    `gorm:"size:64;not null"`
+	Kind         string    `gorm:"size:16;not null"`
+	Country      string    `gorm:"size:2;index;not null"`
+	Region       string    `gorm:"size:16"`
+	PostalPrefix string    `gorm:"size:16"`
+	TaxCode      string    `gorm:"size:32"`
+	RateBps      int64     `gorm:"not null"`
+	Active       bool      `gorm:"default:true"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r TaxRate) TableName() string {
+	return "tax_rates"
+}
+
+// TaxLine records the tax worked out for a charge or an invoice line, and
+// its reversal by a refund or a voided invoice. Taxable is the amount net of
+// tax; a reversal has negative Taxable and Tax. TaxRateID is empty when no
+// rate applied to the sale.
+type TaxLine struct {
+	ID            string    `gorm:"primaryKey"`
+	SourceType    string    `gorm:"size:32;not null"`
+	SourceID      string    `gorm:"size:64;index;not null"`
+	InvoiceID     string    `gorm:"size:64;index"`
+	MerchantID    string    `gorm:"size:64;index;not null"`
+	CustomerID    string    `gorm:"size:64"`
+	Currency      string    `gorm:"size:8;not null"`
+	Country       string    `gorm:"size:2"`
+	Region        string    `gorm:"size:16"`
+	PostalCode    string    `gorm:"size:16"`
+	TaxCode       string    `gorm:"size:32"`
+	TaxRateID     string    `gorm:"size:64"`
+	Jurisdiction  string    `gorm:"size:64"`
+	Kind          string    `gorm:"size:16"`
+	RateBps       int64     `gorm:"default:0"`
+	Behavior      string    `gorm:"size:16;not null"`
+	Taxable       int64     `gorm:"not null"`
+	Tax           int64     `gorm:"not null"`
+	ReverseCharge bool      `gorm:"default:false"`
+	CustomerTaxID string    `gorm:"size:32"`
+	CreatedAt     time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (l TaxLine) TableName() string {
+	return "tax_lines"
+}
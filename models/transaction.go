@@ -2,15 +2,60 @@ package models
 
 import "time"
 
+// Transaction is a charge. Amount is presented to the customer in Currency.
+// When the merchant settles in another currency, SettlementAmount is what
+// the merchant is paid in SettlementCurrency, converted at ExchangeRate (in
+// units of 1e-8), and FXMarkup is the part of the converted amount MiniPay
+// kept; Fee is then in SettlementCurrency too. A charge with a WalletID tops
+// up that wallet instead of paying the merchant. A charge split among
+// connected accounts has a Transfer to each, made from its Splits once it is
+// captured; ApplicationFeeAmount is what is left for the platform.
+// RiskScore, RiskOutcome and RiskRules are what screening the charge found:
+// its score from 0 to 100, what was done with it and the rules that
+// matched. CardFingerprint and IPAddress identify the card and the client
+// the charge came from. ThreeDSecure is the outcome of the charge's 3-D
+// Secure challenge, if the card needed one.
 type Transaction struct {
-	ID        string    `gorm:"primaryKey"`
-	Amount    int64     `gorm:"not null"`
-	Currency  string    `gorm:"size:8;not null;default:'usd'"`
-	Customer  string    `gorm:"size:64;index"`
-	Status    string    `gorm:"size:32;index;default:'pending'"`
-	Refunded  bool      `gorm:"default:false"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID                   string         `gorm:"primaryKey"`
+	Amount               int64          `gorm:"not null"`
+	Currency             string         `gorm:"size:8;not null;default:'usd'"`
+	Customer             string         `gorm:"size:64;index"`
+	MerchantID           string         `gorm:"size:64;index;default:'acct_default'"`
+	Status               string         `gorm:"size:32;index;default:'pending'"`
+	Refunded             bool           `gorm:"default:false"`
+	Disputed             bool           `gorm:"default:false"`
+	Country              string         `gorm:"size:2"`
+	CardBrand            string         `gorm:"size:16"`
+	CardLast4            string         `gorm:"size:4"`
+	CardFingerprint      string         `gorm:"size:32;index"`
+	IPAddress            string         `gorm:"size:45"`
+	Processor            string         `gorm:"size:64;index"`
+	ProcessorRef         string         `gorm:"size:128"`
+	FailureType          string         `gorm:"size:32"`
+	FailureCode          string         `gorm:"size:64"`
+	DeclineCode          string         `gorm:"size:64"`
+	FailureMessage       string         `gorm:"size:255"`
+	Fee                  int64          `gorm:"default:0"`
+	BalanceTransactionID string         `gorm:"size:64"`
+	DiscountAmount       int64          `gorm:"default:0"`
+	CouponID             string         `gorm:"size:64;index"`
+	PromotionCodeID      string         `gorm:"size:64"`
+	TaxAmount            int64          `gorm:"default:0"`
+	TaxBehavior          string         `gorm:"size:16"`
+	SettlementAmount     int64          `gorm:"default:0"`
+	SettlementCurrency   string         `gorm:"size:8"`
+	ExchangeRate         int64          `gorm:"default:0"`
+	FXMarkup             int64          `gorm:"default:0"`
+	WalletID             string         `gorm:"size:64;index"`
+	ApplicationFeeAmount int64          `gorm:"default:0"`
+	Splits               TransferSplits `gorm:"type:text"`
+	RiskScore            int            `gorm:"default:0"`
+	RiskOutcome          string         `gorm:"size:16;index"`
+	RiskRules            StringList     `gorm:"type:text"`
+	ThreeDSecure         string         `gorm:"size:16"`
+	Metadata             Metadata       `gorm:"type:text"`
+	CreatedAt            time.Time      `gorm:"autoCreateTime;index"`
+	UpdatedAt            time.Time      `gorm:"autoUpdateTime"`
 }
 
 func (t Transaction) TableName() string {
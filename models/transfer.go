@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// TransferSplit sends Amount of a charge, in the charge's currency, to the
+// connected account Destination.
+type TransferSplit struct {
+	Destination string `json:"destination"`
+	Amount      int64  `json:"amount"`
+}
+
+// TransferSplits is a list of splits stored as JSON.
+type TransferSplits []TransferSplit
+
+func (s TransferSplits) Value() (driver.Value, error) {
+	return jsonValue(s, "[]")
+}
+
+func (s *TransferSplits) Scan(src interface{}) error {
+	return jsonScan(src, s, "TransferSplits")
+}
+
+// Transfer sends a connected account its share of a platform's charge.
+// Amount and Currency are what the account is paid, in the charge's
+// settlement currency; AmountReversed is what refunds have taken back.
+type Transfer struct {
+	ID             string    `gorm:"primaryKey"`
+	TransactionID  string    `gorm:"size:64;index;not null"`
+	PlatformID     string    `gorm:"size:64;index;not null"`
+	DestinationID  string    `gorm:"size:64;index;not null"`
+	Amount         int64     `gorm:"not null"`
+	Currency       string    `gorm:"size:8;not null"`
+	AmountReversed int64     `gorm:"not null;default:0"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
+
+func (t Transfer) TableName() string {
+	return "transfers"
+}
+
+// TransferReversal takes part of a transfer back from the connected account
+// when the charge is refunded.
+type TransferReversal struct {
+	ID         string    `gorm:"primaryKey"`
+	TransferID string    `gorm:"size:64;index;not null"`
+	RefundID   string    `gorm:"size:64;index"`
+	Amount     int64     `gorm:"not null"`
+	Currency   string    `gorm:"size:8;not null"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (r TransferReversal) TableName() string {
+	return "transfer_reversals"
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Wallet holds a customer's stored value in one currency. Balance mirrors
+// the wallet's ledger account and only ever changes in the same database
+// transaction as a journal posted to it.
+type Wallet struct {
+	ID         string    `gorm:"primaryKey"`
+	CustomerID string    `gorm:"size:64;uniqueIndex:idx_wallets_customer_currency;not null"`
+	Currency   string    `gorm:"size:8;uniqueIndex:idx_wallets_customer_currency;not null"`
+	Balance    int64     `gorm:"not null;default:0"`
+	Metadata   Metadata  `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
+
+func (w Wallet) TableName() string {
+	return "wallets"
+}
+
+// WalletTransaction is one movement in or out of a wallet. Amount is
+// negative for money leaving it. SourceType and SourceID name what moved
+// it: a top-up charge, a transfer, a payment to a merchant or a payout.
+type WalletTransaction struct {
+	ID           string    `gorm:"primaryKey"`
+	WalletID     string    `gorm:"size:64;index;not null"`
+	Type         string    `gorm:"size:32;index;not null"`
+	Amount       int64     `gorm:"not null"`
+	Currency     string    `gorm:"size:8;not null"`
+	BalanceAfter int64     `gorm:"not null"`
+	SourceType   string    `gorm:"size:32"`
+	SourceID     string    `gorm:"size:64;index"`
+	Description  string    `gorm:"size:255"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (t WalletTransaction) TableName() string {
+	return "wallet_transactions"
+}
+
+// WalletTransfer moves money from one customer's wallet to another's in the
+// same currency.
+type WalletTransfer struct {
+	ID           string    `gorm:"primaryKey"`
+	FromWalletID string    `gorm:"size:64;index;not null"`
+	ToWalletID   string    `gorm:"size:64;index;not null"`
+	Amount       int64     `gorm:"not null"`
+	Currency     string    `gorm:"size:8;not null"`
+	Description  string    `gorm:"size:255"`
+	Metadata     Metadata  `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (t WalletTransfer) TableName() string {
+	return "wallet_transfers"
+}
@@ -0,0 +1,192 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/risk"
+	"github.com/vaidikcode/minipay/taxes"
+)
+
+// StatusRequiresAction is the status of a charge waiting for its cardholder
+// to pass a 3-D Secure challenge. ThreeDSecure outcomes say how the
+// challenge went.
+const (
+	StatusRequiresAction = "requires_action"
+
+	ThreeDSecurePending       = "pending"
+	ThreeDSecureAuthenticated = "authenticated"
+	ThreeDSecureFailed        = "failed"
+	ThreeDSecureExpired       = "expired"
+)
+
+// ChallengeTimeout is how long a charge waits for its 3-D Secure challenge
+// before ExpireChallenges cancels it.
+const ChallengeTimeout = time.Hour
+
+var ErrNotAwaitingAction = errors.New("payments: charge is not awaiting authentication")
+
+// ChallengeURL is the page where the cardholder completes the 3-D Secure
+// challenge of txn.
+func ChallengeURL(txn models.Transaction) string {
+	return "/3ds/" + txn.ID
+}
+
+// requireAction leaves txn waiting for the challenge the processor asked
+// for in result, keeping what c needs to resume it.
+func requireAction(db *gorm.DB, txn *models.Transaction, result *processor.Result, c completion, returnURL string) error {
+	tax := ""
+	if c.tax != nil {
+		b, err := json.Marshal(c.tax)
+		if err != nil {
+			return err
+		}
+		tax = string(b)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{
+			"status":         StatusRequiresAction,
+			"three_d_secure": ThreeDSecurePending,
+			"processor":      result.Processor,
+			"processor_ref":  result.Reference,
+		}
+		if err := tx.Model(txn).Where("status = ?", "pending").Updates(updates).Error; err != nil {
+			return err
+		}
+		a := models.Authentication{
+			ID:            "3ds_" + uuid.NewString(),
+			TransactionID: txn.ID,
+			ReturnURL:     returnURL,
+			Signals:       models.Signals(c.signals),
+			Tax:           tax,
+			Challenge:     result.Challenge,
+		}
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+		return events.Enqueue(tx, txn.ID, "payment.requires_action", Payload(*txn))
+	})
+}
+
+// Authenticate completes the 3-D Secure challenge of txn, which the
+// cardholder passed if authenticated, and has the processor decide the
+// charge, which then carries on as if it had just been made. It returns the
+// challenge, and ErrNotAwaitingAction if txn is not waiting for one.
+func Authenticate(db *gorm.DB, txn *models.Transaction, authenticated bool) (*models.Authentication, error) {
+	var a models.Authentication
+	if err := db.First(&a, "transaction_id = ?", txn.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotAwaitingAction
+		}
+		return nil, err
+	}
+	merchant, plan, err := pricing.Lookup(db, txn.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	c := completion{merchant: merchant, plan: plan, signals: risk.Attributes(a.Signals)}
+	if a.Tax != "" {
+		var tax taxes.Result
+		if err := json.Unmarshal([]byte(a.Tax), &tax); err != nil {
+			return nil, err
+		}
+		c.tax = &tax
+	}
+	if txn.WalletID != "" {
+		var w models.Wallet
+		if err := db.First(&w, "id = ?", txn.WalletID).Error; err != nil {
+			return nil, err
+		}
+		c.wallet = &w
+	}
+
+	outcome := ThreeDSecureFailed
+	if authenticated {
+		outcome = ThreeDSecureAuthenticated
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(txn).Where("status = ? AND three_d_secure = ?", StatusRequiresAction, ThreeDSecurePending).
+			Update("three_d_secure", outcome)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotAwaitingAction
+		}
+		now := time.Now()
+		a.CompletedAt = &now
+		return tx.Model(&a).Update("completed_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result *processor.Result
+	if p, ok := processor.Lookup(txn.Processor).(processor.Authenticator); ok {
+		result, err = p.Authenticate(txn.ProcessorRef, a.Challenge, authenticated)
+	} else {
+		err = &processor.Error{Type: processor.ErrorTypeAPI, Code: processor.CodeProcessingError, Message: "The payment processor cannot authenticate charges."}
+	}
+	return &a, complete(db, txn, txn.Processor, result, err, c)
+}
+
+// ExpireChallenges cancels the charges that have waited for their 3-D
+// Secure challenge for longer than ChallengeTimeout at now, releasing their
+// discount redemptions and telling the processor to drop them. A charge whose
+// challenge was completed but never decided, because Authenticate did not get
+// to finish, is canceled once it has been left that long too. It returns how
+// many it canceled.
+func ExpireChallenges(db *gorm.DB, now time.Time) (int, error) {
+	cutoff := now.Add(-ChallengeTimeout)
+	var stale []models.Transaction
+	err := db.Where("status = ? AND ((three_d_secure = ? AND created_at <= ?) OR (three_d_secure <> ? AND updated_at <= ?))",
+		StatusRequiresAction, ThreeDSecurePending, cutoff, ThreeDSecurePending, cutoff).
+		Find(&stale).Error
+	if err != nil {
+		return 0, err
+	}
+
+	canceled := 0
+	for i := range stale {
+		txn := &stale[i]
+		updates := map[string]interface{}{"status": StatusCanceled}
+		if txn.ThreeDSecure == ThreeDSecurePending {
+			updates["three_d_secure"] = ThreeDSecureExpired
+		}
+		expired := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(txn).Where("status = ? AND three_d_secure = ?", StatusRequiresAction, txn.ThreeDSecure).
+				Updates(updates)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			expired = true
+			if err := unredeem(tx, txn); err != nil {
+				return err
+			}
+			if c, ok := processor.Lookup(txn.Processor).(processor.Capturer); ok {
+				if err := c.Void(txn.ProcessorRef); err != nil {
+					return err
+				}
+			}
+			return events.Enqueue(tx, txn.ID, "payment.canceled", Payload(*txn))
+		})
+		if err != nil {
+			log.Printf("failed to expire challenge of %s: %v", txn.ID, err)
+			continue
+		}
+		if expired {
+			canceled++
+		}
+	}
+	return canceled, nil
+}
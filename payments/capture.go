@@ -0,0 +1,90 @@
+package payments
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/taxes"
+)
+
+// Statuses of a charge held for review: the processor authorized it, and it
+// is captured or voided once the review is decided.
+const (
+	StatusRequiresCapture = "requires_capture"
+	StatusCanceled        = "canceled"
+)
+
+var ErrNotHeld = errors.New("payments: charge is not awaiting capture")
+
+// Capture captures the held charge txn inside tx and books it as if it had
+// just succeeded. It returns ErrNotHeld if txn is not awaiting capture.
+func Capture(tx *gorm.DB, txn *models.Transaction) error {
+	merchant, plan, err := pricing.Lookup(tx, txn.MerchantID)
+	if err != nil {
+		return err
+	}
+	res := tx.Model(txn).Where("status = ?", StatusRequiresCapture).Update("status", "succeeded")
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotHeld
+	}
+	if err := book(tx, txn, merchant, plan); err != nil {
+		return err
+	}
+	if c, ok := processor.Lookup(txn.Processor).(processor.Capturer); ok {
+		if err := c.Capture(txn.ProcessorRef, txn.Amount); err != nil {
+			return err
+		}
+	}
+	return events.Enqueue(tx, txn.ID, "payment.succeeded", Payload(*txn))
+}
+
+// Void releases the held charge txn inside tx, reversing its tax and giving
+// back its coupon or promotion code redemption. It returns ErrNotHeld if txn
+// is not awaiting capture.
+func Void(tx *gorm.DB, txn *models.Transaction) error {
+	res := tx.Model(txn).Where("status = ?", StatusRequiresCapture).Update("status", StatusCanceled)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotHeld
+	}
+	lines, err := taxes.Lines(tx, taxes.SourceCharge, txn.ID)
+	if err != nil {
+		return err
+	}
+	if err := taxes.Reverse(tx, lines, taxes.SourceChargeVoid, txn.ID); err != nil {
+		return err
+	}
+	if err := unredeem(tx, txn); err != nil {
+		return err
+	}
+	if c, ok := processor.Lookup(txn.Processor).(processor.Capturer); ok {
+		if err := c.Void(txn.ProcessorRef); err != nil {
+			return err
+		}
+	}
+	return events.Enqueue(tx, txn.ID, "charge.voided", Payload(*txn))
+}
+
+// unredeem gives back the redemption of the coupon or promotion code that
+// txn, which will not be paid, was discounted with.
+func unredeem(tx *gorm.DB, txn *models.Transaction) error {
+	if txn.CouponID == "" {
+		return nil
+	}
+	d, err := discounts.Load(tx, txn.CouponID, txn.PromotionCodeID)
+	if err != nil {
+		return err
+	}
+	return discounts.Unredeem(tx, d)
+}
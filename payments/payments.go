@@ -0,0 +1,396 @@
+// Package payments runs a charge from start to finish: it records the
+// transaction, sends it to the processor, books the fee and balance
+// transaction and emits the charge webhook. The charges API and recurring
+// billing both charge through it.
+package payments
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/disputes"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/risk"
+	"github.com/vaidikcode/minipay/settlement"
+	"github.com/vaidikcode/minipay/taxes"
+	"github.com/vaidikcode/minipay/transfers"
+	"github.com/vaidikcode/minipay/utils"
+	"github.com/vaidikcode/minipay/wallets"
+)
+
+type Params struct {
+	Amount     int64
+	Currency   string
+	Customer   string
+	Country    string
+	MerchantID string
+	Card       processor.Card
+	Metadata   models.Metadata
+	// Discount comes off Amount; the transaction is for what is left.
+	// Redeeming it is up to the caller.
+	Discount *discounts.Applied
+	// Tax, when set, is calculated on what is left after the discount.
+	// Missing address fields and the VAT ID come from the customer.
+	Tax *taxes.Params
+	// IdempotencyKey, when set, is bound to the transaction before the
+	// processor is called.
+	IdempotencyKey string
+	// RequestHash identifies the request IdempotencyKey came with, so that
+	// the key cannot be reused for another.
+	RequestHash string
+	// Wallet, when set, is topped up with the charge, which then carries no
+	// fee and is neither converted nor paid to the merchant.
+	Wallet *models.Wallet
+	// Splits send parts of the charge to connected accounts of the
+	// merchant, as worked out by transfers.Resolve with ApplicationFee.
+	Splits         []transfers.Split
+	ApplicationFee int64
+	// IP is the address of the client the charge came from, if any.
+	IP string
+	// ReturnURL is where a cardholder challenged with 3-D Secure is sent
+	// back to. OffSession charges are declined instead of challenged.
+	ReturnURL  string
+	OffSession bool
+}
+
+// completion is what a charge needs, besides its transaction, once the
+// processor has decided it.
+type completion struct {
+	merchant *models.Merchant
+	plan     *models.PricingPlan
+	wallet   *models.Wallet
+	tax      *taxes.Result
+	signals  risk.Attributes
+}
+
+// Payload is the webhook payload of a charge.
+func Payload(txn models.Transaction) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":       txn.ID,
+		"amount":   txn.Amount,
+		"currency": txn.Currency,
+		"customer": txn.Customer,
+		"merchant": txn.MerchantID,
+		"status":   txn.Status,
+		"fee":      txn.Fee,
+		"metadata": txn.Metadata,
+	}
+	if txn.Processor != "" {
+		payload["processor"] = txn.Processor
+	}
+	if txn.WalletID != "" {
+		payload["wallet"] = txn.WalletID
+	}
+	if txn.DiscountAmount > 0 {
+		payload["discount"] = Discount(txn).Payload()
+	}
+	if fx.Converted(txn) {
+		payload["settlement"] = map[string]interface{}{
+			"amount":        txn.SettlementAmount,
+			"currency":      txn.SettlementCurrency,
+			"exchange_rate": fx.FormatRate(txn.ExchangeRate),
+		}
+	}
+	if txn.ApplicationFeeAmount > 0 {
+		payload["application_fee_amount"] = txn.ApplicationFeeAmount
+	}
+	if txn.RiskOutcome != "" {
+		payload["risk"] = map[string]interface{}{"score": txn.RiskScore, "outcome": txn.RiskOutcome, "rules": txn.RiskRules}
+	}
+	if txn.TaxBehavior != "" {
+		payload["tax"] = map[string]interface{}{"amount": txn.TaxAmount, "behavior": txn.TaxBehavior}
+	}
+	if txn.ThreeDSecure != "" {
+		payload["three_d_secure"] = txn.ThreeDSecure
+	}
+	if txn.Status == StatusRequiresAction {
+		payload["next_action"] = map[string]interface{}{"type": "redirect_to_url", "url": ChallengeURL(txn)}
+	}
+	if txn.Status == "failed" {
+		payload["failure_code"] = txn.FailureCode
+		payload["decline_code"] = txn.DeclineCode
+		payload["failure_message"] = txn.FailureMessage
+	}
+	return payload
+}
+
+// Discount is the discount itemized on txn.
+func Discount(txn models.Transaction) discounts.Applied {
+	return discounts.Applied{CouponID: txn.CouponID, PromotionCodeID: txn.PromotionCodeID, Amount: txn.DiscountAmount}
+}
+
+// CardOnFile is the card saved on c for recurring payments.
+func CardOnFile(c models.Customer) processor.Card {
+	return processor.Card{Token: c.CardToken, Brand: c.CardBrand, Last4: c.CardLast4, ExpMonth: c.CardExpMonth, ExpYear: c.CardExpYear}
+}
+
+// Charge charges p and returns the transaction, which has failed rather than
+// returning an error when the processor declines. The merchant defaults to
+// pricing.DefaultMerchantID and the country to the customer's.
+func Charge(db *gorm.DB, p Params) (*models.Transaction, error) {
+	merchantID := p.MerchantID
+	if merchantID == "" {
+		merchantID = pricing.DefaultMerchantID
+	}
+	merchant, plan, err := pricing.Lookup(db, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var customer models.Customer
+	if p.Country == "" || p.Tax != nil {
+		db.First(&customer, "id = ?", p.Customer)
+	}
+	country := p.Country
+	if country == "" {
+		country = customer.Country
+	}
+
+	brand, last4, fingerprint := p.Card.Describe()
+	txn := models.Transaction{
+		ID:              "txn_" + uuid.NewString(),
+		Amount:          p.Amount,
+		Currency:        strings.ToLower(p.Currency),
+		Customer:        p.Customer,
+		MerchantID:      merchant.ID,
+		Country:         strings.ToUpper(country),
+		Status:          "pending",
+		CardBrand:       brand,
+		CardLast4:       last4,
+		CardFingerprint: fingerprint,
+		IPAddress:       p.IP,
+		Metadata:        p.Metadata,
+	}
+	if p.Wallet != nil {
+		txn.WalletID = p.Wallet.ID
+	}
+	if p.Discount != nil {
+		txn.Amount -= p.Discount.Amount
+		txn.DiscountAmount = p.Discount.Amount
+		txn.CouponID = p.Discount.CouponID
+		txn.PromotionCodeID = p.Discount.PromotionCodeID
+	}
+	var tax *taxes.Result
+	if p.Tax != nil {
+		params := *p.Tax
+		params.Amount = txn.Amount
+		params.MerchantCountry = merchant.Country
+		if params.Address.Country == "" {
+			params.Address = taxes.Address{Country: country, Region: customer.Region, PostalCode: customer.PostalCode}
+		}
+		if params.CustomerTaxID == "" {
+			params.CustomerTaxID = customer.TaxID
+		}
+		res, err := taxes.Calculate(db, params)
+		if err != nil {
+			return nil, err
+		}
+		txn.Amount = res.Total()
+		txn.TaxAmount = res.Tax
+		txn.TaxBehavior = res.Behavior
+		tax = &res
+	}
+	if len(p.Splits) > 0 {
+		var splits []transfers.Split
+		splits, txn.ApplicationFeeAmount, err = transfers.Resolve(txn.Amount, p.ApplicationFee, p.Splits)
+		if err != nil {
+			return nil, err
+		}
+		txn.Splits = models.TransferSplits(splits)
+	}
+	if p.Wallet == nil && merchant.SettlementCurrency != "" && merchant.SettlementCurrency != txn.Currency {
+		quote, err := fx.NewQuote(db, txn.Currency, merchant.SettlementCurrency, plan.FXMarkupBps)
+		if err != nil {
+			return nil, err
+		}
+		txn.SettlementCurrency = quote.To
+		txn.SettlementAmount = fx.Convert(txn.Amount, quote.Rate, quote.From, quote.To)
+		txn.ExchangeRate = quote.Rate
+		txn.FXMarkup = fx.Convert(txn.Amount, quote.Mid, quote.From, quote.To) - txn.SettlementAmount
+	}
+	assessment, err := risk.Assess(db, txn)
+	if err != nil {
+		return nil, err
+	}
+	// A top-up could be spent before its review closed, so it is blocked
+	// instead.
+	if assessment.Outcome == risk.ActionReview && p.Wallet != nil {
+		assessment.Outcome = risk.ActionBlock
+		assessment.DeclineCode = risk.DeclineRule
+	}
+	assessment.Apply(&txn)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if txn.RiskOutcome != risk.ActionBlock {
+			err := risk.CheckVelocity(tx, risk.EventCharge, assessment.Subjects, txn.Amount, txn.Currency, txn.ID)
+			var verr *risk.VelocityError
+			if errors.As(err, &verr) {
+				assessment.Block(risk.DeclineVelocity, verr.Rule.ID)
+				assessment.Apply(&txn)
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&txn).Error; err != nil {
+			return err
+		}
+		return metadata.Sync(tx, metadata.ObjectCharge, txn.ID, txn.Metadata)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.IdempotencyKey != "" {
+		db.Create(&models.IdempotencyKey{ID: p.IdempotencyKey, TransactionID: txn.ID, RequestHash: p.RequestHash})
+	}
+
+	var result *processor.Result
+	processorName := ""
+	if txn.RiskOutcome == risk.ActionBlock {
+		err = risk.Declined(assessment.DeclineCode)
+	} else {
+		result, err = processor.Default.Charge(processor.ChargeParams{
+			TransactionID:  txn.ID,
+			IdempotencyKey: txn.ID,
+			Amount:         txn.Amount,
+			Currency:       txn.Currency,
+			Customer:       txn.Customer,
+			Country:        txn.Country,
+			Card:           p.Card,
+			CaptureLater:   txn.RiskOutcome == risk.ActionReview,
+			OffSession:     p.OffSession,
+		})
+		processorName = processor.Default.Name()
+	}
+
+	c := completion{merchant: merchant, plan: plan, wallet: p.Wallet, tax: tax, signals: assessment.Attributes}
+	if err == nil && result != nil && result.RequiresAction {
+		if err := requireAction(db, &txn, result, c, p.ReturnURL); err != nil {
+			return nil, err
+		}
+		return &txn, nil
+	}
+	if err := complete(db, &txn, processorName, result, err, c); err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// complete records the processor's decision on txn, which it returned as
+// result or err, and books the charge if it succeeded.
+func complete(db *gorm.DB, txn *models.Transaction, processorName string, result *processor.Result, err error, c completion) error {
+	processorRef := ""
+	if result != nil {
+		if result.Processor != "" {
+			processorName = result.Processor
+		}
+		processorRef = result.Reference
+	}
+
+	eventType := "payment.succeeded"
+	updates := map[string]interface{}{"status": "succeeded"}
+	if err == nil && result != nil && result.Authorized {
+		eventType = "payment.authorized"
+		updates["status"] = StatusRequiresCapture
+	}
+	if err != nil {
+		var perr *processor.Error
+		if !errors.As(err, &perr) {
+			perr = &processor.Error{
+				Type:    processor.ErrorTypeAPI,
+				Code:    processor.CodeProcessingError,
+				Message: "An error occurred while processing your card.",
+			}
+		}
+		eventType = "charge.failed"
+		updates = map[string]interface{}{
+			"status":          "failed",
+			"failure_type":    perr.Type,
+			"failure_code":    perr.Code,
+			"decline_code":    perr.DeclineCode,
+			"failure_message": perr.Message,
+		}
+	}
+	updates["processor"] = processorName
+	updates["processor_ref"] = processorRef
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(txn).Where("status IN ?", []string{"pending", StatusRequiresAction}).Updates(updates).Error; err != nil {
+			return err
+		}
+		if txn.Status == "succeeded" && c.wallet != nil {
+			if _, err := wallets.TopUp(tx, c.wallet, txn); err != nil {
+				return err
+			}
+		} else if txn.Status == "succeeded" || txn.Status == StatusRequiresCapture {
+			// A held charge is booked when it is captured, but its tax is
+			// owed from now on unless it is voided.
+			if txn.Status == "succeeded" {
+				if err := book(tx, txn, c.merchant, c.plan); err != nil {
+					return err
+				}
+			}
+			if c.tax != nil {
+				src := taxes.Source{Type: taxes.SourceCharge, ID: txn.ID, MerchantID: txn.MerchantID, CustomerID: txn.Customer, Currency: txn.Currency}
+				if err := taxes.Record(tx, src, *c.tax); err != nil {
+					return err
+				}
+			}
+			if txn.RiskOutcome == risk.ActionReview {
+				if _, err := risk.OpenReview(tx, txn, c.signals, risk.LoadReviewPolicy()); err != nil {
+					return err
+				}
+			}
+		}
+		return events.Enqueue(tx, txn.ID, eventType, Payload(*txn))
+	})
+	if err != nil {
+		return err
+	}
+
+	if txn.Status == "failed" {
+		utils.Metrics.IncFailedCharges()
+	} else {
+		utils.Metrics.IncCharges()
+	}
+
+	// Top-ups are not disputed against the merchant, who never received them.
+	if result != nil && result.Dispute != nil && c.wallet == nil && txn.Status == "succeeded" {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := disputes.Open(tx, txn, result.Dispute.Reason, 0)
+			return err
+		})
+		if err != nil {
+			log.Printf("failed to open simulated dispute for %s: %v", txn.ID, err)
+		}
+	}
+	return nil
+}
+
+// book records the fee, balance transaction and transfers of the succeeded
+// charge txn.
+func book(tx *gorm.DB, txn *models.Transaction, merchant *models.Merchant, plan *models.PricingPlan) error {
+	fee := pricing.ChargeFee(*plan, fx.Settled(*txn, txn.Amount), fx.SettlementCurrency(*txn), txn.CardBrand, pricing.International(*merchant, *txn))
+	availableOn := settlement.LoadCalendar().AvailableOn(time.Now(), merchant.SettlementDelayDays)
+	bt, err := balance.RecordCharge(tx, txn, fee, availableOn)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(txn).Updates(map[string]interface{}{"fee": fee.Amount, "balance_transaction_id": bt.ID}).Error; err != nil {
+		return err
+	}
+	_, err = transfers.Create(tx, txn, txn.Splits, availableOn)
+	return err
+}
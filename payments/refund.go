@@ -0,0 +1,95 @@
+package payments
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/metadata"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/risk"
+	"github.com/vaidikcode/minipay/taxes"
+	"github.com/vaidikcode/minipay/transfers"
+)
+
+var ErrAlreadyRefunded = errors.New("payments: charge already refunded")
+
+// Refund refunds the succeeded charge txn in full inside tx: it books the
+// refund and its fee, reverses the charge's transfers and tax and closes its
+// review, if one is open. It returns ErrAlreadyRefunded if txn was refunded
+// already, and a *risk.VelocityError if the refund exceeds a velocity limit.
+func Refund(tx *gorm.DB, txn *models.Transaction, md models.Metadata) (*models.Refund, error) {
+	_, plan, err := pricing.Lookup(tx, txn.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := tx.Model(txn).Where("status = ? AND refunded = ?", "succeeded", false).
+		Updates(map[string]interface{}{"refunded": true, "status": "refunded"})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrAlreadyRefunded
+	}
+
+	refund := &models.Refund{
+		ID:            "re_" + uuid.NewString(),
+		TransactionID: txn.ID,
+		Amount:        txn.Amount,
+		Currency:      txn.Currency,
+		Status:        "succeeded",
+		Metadata:      md,
+	}
+	subjects, err := risk.SubjectsFor(tx, *txn)
+	if err != nil {
+		return nil, err
+	}
+	if err := risk.CheckVelocity(tx, risk.EventRefund, subjects, refund.Amount, refund.Currency, refund.ID); err != nil {
+		return nil, err
+	}
+	fee := pricing.RefundFee(*plan, refund.Amount, txn.Amount, txn.Fee)
+	bt, err := balance.RecordRefund(tx, refund, txn, fee)
+	if err != nil {
+		return nil, err
+	}
+	refund.Fee = fee.Amount
+	refund.BalanceTransactionID = bt.ID
+	if err := tx.Create(refund).Error; err != nil {
+		return nil, err
+	}
+	if err := metadata.Sync(tx, metadata.ObjectRefund, refund.ID, refund.Metadata); err != nil {
+		return nil, err
+	}
+	if err := transfers.Reverse(tx, txn, refund); err != nil {
+		return nil, err
+	}
+	lines, err := taxes.Lines(tx, taxes.SourceCharge, txn.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := taxes.Reverse(tx, lines, taxes.SourceRefund, refund.ID); err != nil {
+		return nil, err
+	}
+	if err := risk.CloseOpenReview(tx, txn, risk.Decision{Status: risk.ReviewRefunded, Actor: risk.ActorSystem, Note: "The charge was refunded."}); err != nil {
+		return nil, err
+	}
+	err = events.Enqueue(tx, txn.ID, "charge.refunded", map[string]interface{}{
+		"id":             refund.ID,
+		"transaction_id": txn.ID,
+		"amount":         refund.Amount,
+		"fee":            refund.Fee,
+		"currency":       refund.Currency,
+		"status":         refund.Status,
+		"metadata":       refund.Metadata,
+		"charge":         Payload(*txn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
@@ -0,0 +1,162 @@
+package payoutfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recordSize     = 94
+	blockingFactor = 10
+
+	serviceClassCredits  = "220"
+	transactionCodeCheck = "22"
+)
+
+var ErrInvalidPayment = errors.New("payoutfile: invalid payment")
+
+type NACHAConfig struct {
+	// ImmediateDestination is the routing number of the bank the file is sent
+	// to; ImmediateOrigin identifies the sender, usually the company ID.
+	ImmediateDestination     string
+	ImmediateDestinationName string
+	ImmediateOrigin          string
+	ImmediateOriginName      string
+	CompanyName              string
+	CompanyID                string
+	ODFIRouting              string
+	SECCode                  string
+	EntryDescription         string
+}
+
+// WriteNACHA writes a single-batch credit file. Every payment must carry a
+// routing and account number. The file ID modifier tells apart files created
+// on the same day and must be A-Z or 0-9.
+func WriteNACHA(w io.Writer, cfg NACHAConfig, payments []Payment, created, effective time.Time, modifier byte) (Totals, error) {
+	var totals Totals
+	if len(payments) == 0 {
+		return totals, fmt.Errorf("%w: a batch needs at least one payment", ErrInvalidPayment)
+	}
+	sec := cfg.SECCode
+	if sec == "" {
+		sec = "CCD"
+	}
+	description := cfg.EntryDescription
+	if description == "" {
+		description = "PAYOUT"
+	}
+	odfi := leftPad(cfg.ODFIRouting, 8, '0')[:8]
+
+	var records []string
+	records = append(records, record(
+		"1", "01",
+		" "+leftPad(cfg.ImmediateDestination, 9, '0'),
+		rightPad(cfg.ImmediateOrigin, 10),
+		created.Format("060102"), created.Format("1504"),
+		string(modifier), "094", "10", "1",
+		alpha(cfg.ImmediateDestinationName, 23),
+		alpha(cfg.ImmediateOriginName, 23),
+		alpha("", 8),
+	))
+	records = append(records, record(
+		"5", serviceClassCredits,
+		alpha(cfg.CompanyName, 16),
+		alpha("", 20),
+		rightPad(cfg.CompanyID, 10),
+		sec,
+		alpha(description, 10),
+		effective.Format("060102"),
+		effective.Format("060102"),
+		"   ", "1", odfi, num(1, 7),
+	))
+
+	var hash int64
+	for i, p := range payments {
+		if len(p.RoutingNumber) != 9 || p.AccountNumber == "" || p.Amount <= 0 {
+			return totals, fmt.Errorf("%w: payment %s needs a routing number, account number and positive amount", ErrInvalidPayment, p.ID)
+		}
+		rdfi, err := strconv.ParseInt(p.RoutingNumber[:8], 10, 64)
+		if err != nil {
+			return totals, fmt.Errorf("%w: payment %s has a non-numeric routing number", ErrInvalidPayment, p.ID)
+		}
+		hash += rdfi
+		totals.Total += p.Amount
+		records = append(records, record(
+			"6", transactionCodeCheck,
+			p.RoutingNumber[:8], p.RoutingNumber[8:],
+			alpha(p.AccountNumber, 17),
+			num(p.Amount, 10),
+			alpha(p.ID, 15),
+			alpha(p.Name, 22),
+			"  ", "0",
+			odfi+num(int64(i+1), 7),
+		))
+	}
+	totals.Count = len(payments)
+	totals.Hash = num(hash%10_000_000_000, 10)
+
+	records = append(records, record(
+		"8", serviceClassCredits,
+		num(int64(totals.Count), 6),
+		totals.Hash,
+		num(0, 12), num(totals.Total, 12),
+		rightPad(cfg.CompanyID, 10),
+		alpha("", 19), alpha("", 6),
+		odfi, num(1, 7),
+	))
+
+	blocks := (len(records) + 1 + blockingFactor - 1) / blockingFactor
+	records = append(records, record(
+		"9", num(1, 6), num(int64(blocks), 6),
+		num(int64(totals.Count), 8),
+		totals.Hash,
+		num(0, 12), num(totals.Total, 12),
+		alpha("", 39),
+	))
+	for len(records)%blockingFactor != 0 {
+		records = append(records, strings.Repeat("9", recordSize))
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, r := range records {
+		if len(r) != recordSize {
+			return totals, fmt.Errorf("payoutfile: record %q is %d characters, want %d", r[:1], len(r), recordSize)
+		}
+		bw.WriteString(r)
+		bw.WriteString("\n")
+	}
+	return totals, bw.Flush()
+}
+
+func record(fields ...string) string {
+	return strings.Join(fields, "")
+}
+
+// alpha left-justifies s in a blank-filled field of width n.
+func alpha(s string, n int) string {
+	return rightPad(ascii(s), n)
+}
+
+func rightPad(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+func leftPad(s string, n int, pad byte) string {
+	if len(s) >= n {
+		return s
+	}
+	return strings.Repeat(string(pad), n-len(s)) + s
+}
+
+// num right-justifies v in a zero-filled field of width n.
+func num(v int64, n int) string {
+	return leftPad(fmt.Sprint(v), n, '0')
+}
@@ -0,0 +1,73 @@
+// Package payoutfile writes the bank files that carry payouts to the bank:
+// NACHA ACH batches for USD and ISO 20022 pain.001 credit transfers for EUR.
+package payoutfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Payment is a single credit to a payout's bank account.
+type Payment struct {
+	ID            string
+	Amount        int64
+	Name          string
+	RoutingNumber string
+	AccountNumber string
+	IBAN          string
+	BIC           string
+	Reference     string
+}
+
+// Totals are the control totals written into a file's trailer.
+type Totals struct {
+	Count int
+	Total int64
+	// Hash is the NACHA entry hash; empty for SEPA files.
+	Hash string
+}
+
+func decimal(amount int64) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+// ascii upper-cases s and blanks anything outside printable ASCII, which is
+// what NACHA alphanumeric fields accept.
+func ascii(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		if c >= 0x20 && c <= 0x7e {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
+
+// latin restricts s to the SEPA character set.
+func latin(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case strings.ContainsRune("/-?:().,'+ ", c):
+			b.WriteRune(c)
+		case c == '_':
+			b.WriteRune('-')
+		case c == '&':
+			b.WriteRune('+')
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package payoutfile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+const pain001Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.09"
+
+type SEPAConfig struct {
+	InitiatingParty string
+	DebtorName      string
+	DebtorIBAN      string
+	DebtorBIC       string
+}
+
+type pain001Document struct {
+	XMLName xml.Name          `xml:"Document"`
+	Xmlns   string            `xml:"xmlns,attr"`
+	Init    pain001Initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001Initiation struct {
+	GrpHdr pain001GroupHeader `xml:"GrpHdr"`
+	PmtInf pain001PaymentInfo `xml:"PmtInf"`
+}
+
+type pain001GroupHeader struct {
+	MsgID    string    `xml:"MsgId"`
+	CreDtTm  string    `xml:"CreDtTm"`
+	NbOfTxs  int       `xml:"NbOfTxs"`
+	CtrlSum  string    `xml:"CtrlSum"`
+	InitgPty partyName `xml:"InitgPty"`
+}
+
+type pain001PaymentInfo struct {
+	PmtInfID    string          `xml:"PmtInfId"`
+	PmtMtd      string          `xml:"PmtMtd"`
+	NbOfTxs     int             `xml:"NbOfTxs"`
+	CtrlSum     string          `xml:"CtrlSum"`
+	SvcLvl      string          `xml:"PmtTpInf>SvcLvl>Cd"`
+	ReqdExctnDt string          `xml:"ReqdExctnDt>Dt"`
+	Dbtr        partyName       `xml:"Dbtr"`
+	DbtrIBAN    string          `xml:"DbtrAcct>Id>IBAN"`
+	DbtrBIC     string          `xml:"DbtrAgt>FinInstnId>BICFI"`
+	ChrgBr      string          `xml:"ChrgBr"`
+	Txs         []pain001Credit `xml:"CdtTrfTxInf"`
+}
+
+type pain001Credit struct {
+	EndToEndID string        `xml:"PmtId>EndToEndId"`
+	Amount     instructedAmt `xml:"Amt>InstdAmt"`
+	CdtrAgt    *agent        `xml:"CdtrAgt"`
+	Cdtr       partyName     `xml:"Cdtr"`
+	CdtrIBAN   string        `xml:"CdtrAcct>Id>IBAN"`
+	Ustrd      string        `xml:"RmtInf>Ustrd,omitempty"`
+}
+
+type agent struct {
+	BICFI string `xml:"FinInstnId>BICFI"`
+}
+
+type partyName struct {
+	Nm string `xml:"Nm"`
+}
+
+type instructedAmt struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WritePain001 writes a SEPA credit transfer initiation with one payment
+// information block. Every payment must carry an IBAN; the BIC is optional.
+func WritePain001(w io.Writer, cfg SEPAConfig, payments []Payment, messageID string, created, execution time.Time) (Totals, error) {
+	var totals Totals
+	if len(payments) == 0 {
+		return totals, fmt.Errorf("%w: a credit transfer needs at least one payment", ErrInvalidPayment)
+	}
+
+	info := pain001PaymentInfo{
+		PmtInfID:    messageID + "-1",
+		PmtMtd:      "TRF",
+		SvcLvl:      "SEPA",
+		ReqdExctnDt: execution.Format("2006-01-02"),
+		Dbtr:        partyName{truncate(latin(cfg.DebtorName), 70)},
+		DbtrIBAN:    cfg.DebtorIBAN,
+		DbtrBIC:     cfg.DebtorBIC,
+		ChrgBr:      "SLEV",
+	}
+	for _, p := range payments {
+		if p.IBAN == "" || p.Amount <= 0 {
+			return totals, fmt.Errorf("%w: payment %s needs an IBAN and positive amount", ErrInvalidPayment, p.ID)
+		}
+		totals.Total += p.Amount
+		var creditorAgent *agent
+		if p.BIC != "" {
+			creditorAgent = &agent{BICFI: p.BIC}
+		}
+		info.Txs = append(info.Txs, pain001Credit{
+			EndToEndID: truncate(p.ID, 35),
+			Amount:     instructedAmt{Ccy: "EUR", Value: decimal(p.Amount)},
+			CdtrAgt:    creditorAgent,
+			Cdtr:       partyName{truncate(latin(p.Name), 70)},
+			CdtrIBAN:   p.IBAN,
+			Ustrd:      truncate(latin(p.Reference), 140),
+		})
+	}
+	totals.Count = len(payments)
+	info.NbOfTxs = totals.Count
+	info.CtrlSum = decimal(totals.Total)
+
+	doc := pain001Document{
+		Xmlns: pain001Namespace,
+		Init: pain001Initiation{
+			GrpHdr: pain001GroupHeader{
+				MsgID:    truncate(messageID, 35),
+				CreDtTm:  created.UTC().Format("2006-01-02T15:04:05"),
+				NbOfTxs:  totals.Count,
+				CtrlSum:  decimal(totals.Total),
+				InitgPty: partyName{truncate(latin(cfg.InitiatingParty), 70)},
+			},
+			PmtInf: info,
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return totals, err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return totals, err
+	}
+	_, err := io.WriteString(w, "\n")
+	return totals, err
+}
@@ -0,0 +1,138 @@
+package payouts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payoutfile"
+	"github.com/vaidikcode/minipay/storage"
+)
+
+const FilePurpose = "payout_file"
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func nachaConfig() payoutfile.NACHAConfig {
+	return payoutfile.NACHAConfig{
+		ImmediateDestination:     env("ACH_DESTINATION_ROUTING", "110000000"),
+		ImmediateDestinationName: env("ACH_DESTINATION_NAME", "MINIPAY TEST BANK"),
+		ImmediateOrigin:          env("ACH_COMPANY_ID", "1234567890"),
+		ImmediateOriginName:      env("ACH_COMPANY_NAME", "MINIPAY"),
+		CompanyName:              env("ACH_COMPANY_NAME", "MINIPAY"),
+		CompanyID:                env("ACH_COMPANY_ID", "1234567890"),
+		ODFIRouting:              env("ACH_DESTINATION_ROUTING", "110000000"),
+	}
+}
+
+func sepaConfig() payoutfile.SEPAConfig {
+	return payoutfile.SEPAConfig{
+		InitiatingParty: env("SEPA_DEBTOR_NAME", "MiniPay"),
+		DebtorName:      env("SEPA_DEBTOR_NAME", "MiniPay"),
+		DebtorIBAN:      env("SEPA_DEBTOR_IBAN", "DE89370400440532013000"),
+		DebtorBIC:       env("SEPA_DEBTOR_BIC", "COBADEFFXXX"),
+	}
+}
+
+// rail picks the bank file format a payout travels in, or "" when MiniPay
+// does not produce files for the account's currency.
+func rail(ba models.BankAccount) string {
+	switch {
+	case ba.Currency == "usd" && ba.RoutingNumber != "":
+		return "ach"
+	case ba.Currency == "eur" && ba.IBAN != "":
+		return "sepa"
+	}
+	return ""
+}
+
+// secCode picks the NACHA entry class of an ACH payout from its recipient:
+// PPD for consumers, such as wallet holders withdrawing to their own
+// accounts, and CCD for businesses.
+func secCode(ba models.BankAccount) string {
+	if ba.CustomerID != "" || ba.AccountHolderType == "individual" {
+		return "PPD"
+	}
+	return "CCD"
+}
+
+func payment(p models.Payout, ba models.BankAccount) payoutfile.Payment {
+	reference := p.Description
+	if reference == "" {
+		reference = p.ID
+	}
+	return payoutfile.Payment{
+		ID:            p.ID,
+		Amount:        p.Amount,
+		Name:          ba.AccountHolderName,
+		RoutingNumber: ba.RoutingNumber,
+		AccountNumber: ba.AccountNumber,
+		IBAN:          ba.IBAN,
+		BIC:           ba.BIC,
+		Reference:     reference,
+	}
+}
+
+// messageID names a SEPA file by the second it was created and a random
+// part, since several files can be created within a second. It is 28
+// characters, within the 35 that MsgId allows.
+func messageID(now time.Time) string {
+	return "MP" + now.UTC().Format("20060102150405") + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
+
+// WriteFile renders the bank file for payouts on one rail and stores it as a
+// downloadable payout_file. An ACH file is a single batch, so its payouts
+// must share a secCode.
+func WriteFile(db *gorm.DB, railName string, list []models.Payout, accounts map[string]models.BankAccount, now time.Time) (*models.File, error) {
+	payments := make([]payoutfile.Payment, 0, len(list))
+	for _, p := range list {
+		payments = append(payments, payment(p, accounts[p.BankAccountID]))
+	}
+
+	var (
+		buf         bytes.Buffer
+		err         error
+		filename    string
+		contentType string
+	)
+	switch railName {
+	case "ach":
+		var today int64
+		db.Model(&models.File{}).Where("purpose = ? AND created_at >= ? AND filename LIKE ?",
+			FilePurpose, now.Truncate(24*time.Hour), "ach_%").Count(&today)
+		modifier := byte('A' + today%26)
+		cfg := nachaConfig()
+		for _, p := range list {
+			sec := secCode(accounts[p.BankAccountID])
+			if cfg.SECCode != "" && sec != cfg.SECCode {
+				return nil, fmt.Errorf("payouts: %s payouts cannot share an ACH batch with %s payouts", sec, cfg.SECCode)
+			}
+			cfg.SECCode = sec
+		}
+		_, err = payoutfile.WriteNACHA(&buf, cfg, payments, now, now.Add(TransitTime), modifier)
+		filename = fmt.Sprintf("ach_%s_%c.txt", now.Format("20060102"), modifier)
+		contentType = "text/plain"
+	case "sepa":
+		msgID := messageID(now)
+		_, err = payoutfile.WritePain001(&buf, sepaConfig(), payments, msgID, now, now.Add(TransitTime))
+		filename = "sepa_" + msgID + ".xml"
+		contentType = "application/xml"
+	default:
+		return nil, fmt.Errorf("payouts: no bank file format for rail %q", railName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return storage.Save(db, FilePurpose, "", filename, contentType, &buf)
+}
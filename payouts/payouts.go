@@ -0,0 +1,466 @@
+package payouts
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+	"github.com/vaidikcode/minipay/wallets"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusInTransit = "in_transit"
+	StatusPaid      = "paid"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+const (
+	IntervalManual = "manual"
+	IntervalDaily  = "daily"
+	IntervalWeekly = "weekly"
+)
+
+// TransitTime is how long a payout spends in_transit before the bank pays it.
+var TransitTime = 24 * time.Hour
+
+// Test account numbers that the simulated bank rejects.
+var simulatedFailures = map[string][2]string{
+	"000111111116": {"no_account", "The bank account could not be located."},
+	"000111111113": {"account_closed", "The bank account has been closed."},
+}
+
+var (
+	ErrInsufficientFunds = errors.New("payouts: insufficient available balance")
+	ErrNoBankAccount     = errors.New("payouts: no bank account for currency")
+	ErrNotCancelable     = errors.New("payouts: payout can no longer be canceled")
+)
+
+func Payload(p models.Payout) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":           p.ID,
+		"amount":       p.Amount,
+		"currency":     p.Currency,
+		"bank_account": p.BankAccountID,
+		"status":       p.Status,
+		"automatic":    p.Automatic,
+		"arrival_date": p.ArrivalDate.Format(time.RFC3339),
+		"metadata":     p.Metadata,
+	}
+	if p.FileID != "" {
+		payload["bank_file"] = p.FileID
+	}
+	if p.WalletID != "" {
+		payload["wallet"] = p.WalletID
+	}
+	if p.MerchantID != "" {
+		payload["merchant"] = p.MerchantID
+	}
+	if p.FailureCode != "" {
+		payload["failure_code"] = p.FailureCode
+		payload["failure_message"] = p.FailureMessage
+	}
+	return payload
+}
+
+// Available is the available balance of the connected account merchantID,
+// or the platform's when merchantID is empty.
+func Available(db *gorm.DB, merchantID, currency string) (int64, error) {
+	account, err := balance.Account(db, merchantID, ledger.AccountMerchantAvailable)
+	if err != nil {
+		return 0, err
+	}
+	return ledger.Balance(db, account, currency)
+}
+
+// DefaultBankAccount returns the default account for currency of the
+// connected account merchantID, or the platform's when merchantID is empty,
+// falling back to the most recently added one.
+func DefaultBankAccount(db *gorm.DB, merchantID, currency string) (*models.BankAccount, error) {
+	var ba models.BankAccount
+	err := db.Where("currency = ? AND COALESCE(customer_id, '') = '' AND COALESCE(merchant_id, '') = ?", currency, merchantID).
+		Order("default_for_currency DESC").Order("created_at DESC").First(&ba).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoBankAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ba, nil
+}
+
+// Create draws amount from the available balance of the owner of ba, the
+// platform or a connected account, into a pending payout. The balance stays
+// locked until tx ends, so concurrent payouts cannot overdraw it.
+func Create(tx *gorm.DB, ba *models.BankAccount, amount int64, description string, metadata models.Metadata, automatic bool) (*models.Payout, error) {
+	// Only connected accounts have bank accounts of their own, so the balance
+	// is named without a read and the lock is the first statement of tx.
+	account := ledger.AccountMerchantAvailable
+	if ba.MerchantID != "" {
+		account = ledger.ConnectedAccount(account, ba.MerchantID)
+	}
+	if err := ledger.Lock(tx, account, ba.Currency); err != nil {
+		return nil, err
+	}
+	available, err := ledger.Balance(tx, account, ba.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount > available {
+		return nil, ErrInsufficientFunds
+	}
+
+	p := &models.Payout{
+		ID:            "po_" + uuid.NewString(),
+		Amount:        amount,
+		Currency:      ba.Currency,
+		BankAccountID: ba.ID,
+		Status:        StatusPending,
+		Automatic:     automatic,
+		Description:   description,
+		ArrivalDate:   time.Now().Add(TransitTime),
+		MerchantID:    ba.MerchantID,
+		Metadata:      metadata,
+	}
+	if err := tx.Create(p).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := balance.RecordPayout(tx, p, merchant(p), balance.TypePayout); err != nil {
+		return nil, err
+	}
+	if err := events.Enqueue(tx, p.ID, "payout.created", Payload(*p)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CreateWithdrawal pays amount out of wallet w to ba, a bank account of the
+// wallet's customer.
+func CreateWithdrawal(tx *gorm.DB, w *models.Wallet, ba *models.BankAccount, amount int64, description string, metadata models.Metadata) (*models.Payout, error) {
+	p := &models.Payout{
+		ID:            "po_" + uuid.NewString(),
+		Amount:        amount,
+		Currency:      w.Currency,
+		BankAccountID: ba.ID,
+		Status:        StatusPending,
+		Description:   description,
+		ArrivalDate:   time.Now().Add(TransitTime),
+		WalletID:      w.ID,
+		Metadata:      metadata,
+	}
+	if err := tx.Create(p).Error; err != nil {
+		return nil, err
+	}
+	if _, err := wallets.Withdraw(tx, w, p); err != nil {
+		return nil, err
+	}
+	if err := events.Enqueue(tx, p.ID, "payout.created", Payload(*p)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Cancel returns a pending payout's funds to the available balance, or to
+// the wallet they were withdrawn from.
+func Cancel(tx *gorm.DB, p *models.Payout) error {
+	if err := transition(tx, p, StatusPending, StatusCanceled, nil); err != nil {
+		return err
+	}
+	if err := returnFunds(tx, p, balance.TypePayoutCancel); err != nil {
+		return err
+	}
+	return events.Enqueue(tx, p.ID, "payout.canceled", Payload(*p))
+}
+
+// Submit hands a pending payout to the bank, in the bank file fileID when
+// one was produced for it.
+func Submit(tx *gorm.DB, p *models.Payout, fileID string, now time.Time) error {
+	err := transition(tx, p, StatusPending, StatusInTransit, map[string]interface{}{
+		"arrival_date": now.Add(TransitTime),
+		"file_id":      fileID,
+	})
+	if err != nil {
+		return err
+	}
+	p.ArrivalDate = now.Add(TransitTime)
+	p.FileID = fileID
+	return events.Enqueue(tx, p.ID, "payout.updated", Payload(*p))
+}
+
+func MarkPaid(tx *gorm.DB, p *models.Payout) error {
+	if err := transition(tx, p, StatusInTransit, StatusPaid, nil); err != nil {
+		return err
+	}
+	if _, err := ledger.Transfer(tx, "payout", p.ID, "payout paid",
+		ledger.AccountPayoutsInTransit, ledger.AccountPayoutsPaid, p.Currency, p.Amount); err != nil {
+		return err
+	}
+	return events.Enqueue(tx, p.ID, "payout.paid", Payload(*p))
+}
+
+// MarkFailed returns the funds of a payout the bank rejected.
+func MarkFailed(tx *gorm.DB, p *models.Payout, code, message string) error {
+	err := transition(tx, p, StatusInTransit, StatusFailed, map[string]interface{}{
+		"failure_code":    code,
+		"failure_message": message,
+	})
+	if err != nil {
+		return err
+	}
+	if err := returnFunds(tx, p, balance.TypePayoutFailure); err != nil {
+		return err
+	}
+	return events.Enqueue(tx, p.ID, "payout.failed", Payload(*p))
+}
+
+// returnFunds books the funds of a payout that will not be paid back where
+// they came from. typ is the balance transaction type for a merchant payout.
+func returnFunds(tx *gorm.DB, p *models.Payout, typ string) error {
+	if p.WalletID != "" {
+		_, err := wallets.ReverseWithdrawal(tx, p)
+		return err
+	}
+	_, err := balance.RecordPayout(tx, p, merchant(p), typ)
+	return err
+}
+
+// merchant is whose balance p was paid out of.
+func merchant(p *models.Payout) string {
+	if p.MerchantID != "" {
+		return p.MerchantID
+	}
+	return pricing.DefaultMerchantID
+}
+
+var ErrInvalidTransition = errors.New("payouts: invalid status transition")
+
+func transition(tx *gorm.DB, p *models.Payout, from, to string, extra map[string]interface{}) error {
+	if p.Status != from {
+		if from == StatusPending && to == StatusCanceled {
+			return ErrNotCancelable
+		}
+		return ErrInvalidTransition
+	}
+	updates := map[string]interface{}{"status": to}
+	for k, v := range extra {
+		updates[k] = v
+	}
+	res := tx.Model(p).Where("status = ?", from).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidTransition
+	}
+	p.Status = to
+	return nil
+}
+
+// Process submits pending payouts and settles those whose arrival date has
+// passed, failing the ones sent to the simulator's rejected accounts.
+func Process(db *gorm.DB, now time.Time) error {
+	if err := submitPending(db, now); err != nil {
+		return err
+	}
+
+	var arrived []models.Payout
+	if err := db.Where("status = ? AND arrival_date <= ?", StatusInTransit, now).Find(&arrived).Error; err != nil {
+		return err
+	}
+	for i := range arrived {
+		p := &arrived[i]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var ba models.BankAccount
+			if err := tx.First(&ba, "id = ?", p.BankAccountID).Error; err != nil {
+				return err
+			}
+			account := ba.AccountNumber
+			if ba.IBAN != "" {
+				account = ba.IBAN
+			}
+			if failure, ok := simulatedFailures[account]; ok {
+				return MarkFailed(tx, p, failure[0], failure[1])
+			}
+			return MarkPaid(tx, p)
+		})
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileGroup is the payouts that travel in one bank file: those on the same
+// rail and, for ACH, with the same SEC code.
+type fileGroup struct {
+	rail string
+	sec  string
+}
+
+// submitPending sends pending payouts to the bank, one bank file per
+// fileGroup.
+func submitPending(db *gorm.DB, now time.Time) error {
+	var pending []models.Payout
+	if err := db.Where("status = ?", StatusPending).Order("created_at").Find(&pending).Error; err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.BankAccountID)
+	}
+	var list []models.BankAccount
+	if err := db.Where("id IN ?", ids).Find(&list).Error; err != nil {
+		return err
+	}
+	accounts := make(map[string]models.BankAccount, len(list))
+	for _, ba := range list {
+		accounts[ba.ID] = ba
+	}
+
+	byGroup := map[fileGroup][]models.Payout{}
+	var groups []fileGroup
+	for _, p := range pending {
+		ba := accounts[p.BankAccountID]
+		g := fileGroup{rail: rail(ba)}
+		if g.rail == "ach" {
+			g.sec = secCode(ba)
+		}
+		if _, ok := byGroup[g]; !ok {
+			groups = append(groups, g)
+		}
+		byGroup[g] = append(byGroup[g], p)
+	}
+
+	for _, g := range groups {
+		batch := byGroup[g]
+		fileID := ""
+		if g.rail != "" {
+			file, err := WriteFile(db, g.rail, batch, accounts, now)
+			if err != nil {
+				return err
+			}
+			fileID = file.ID
+		}
+		for i := range batch {
+			err := db.Transaction(func(tx *gorm.DB) error { return Submit(tx, &batch[i], fileID, now) })
+			if err != nil && !errors.Is(err, ErrInvalidTransition) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func Schedule(db *gorm.DB) (*models.PayoutSchedule, error) {
+	var s models.PayoutSchedule
+	err := db.FirstOrCreate(&s, models.PayoutSchedule{ID: 1}).Error
+	if err != nil {
+		return nil, err
+	}
+	if s.Interval == "" {
+		s.Interval = IntervalManual
+	}
+	return &s, nil
+}
+
+// Due reports whether an automatic payout run should happen at now.
+func Due(s *models.PayoutSchedule, now time.Time) bool {
+	switch s.Interval {
+	case IntervalDaily:
+	case IntervalWeekly:
+		if !strings.EqualFold(now.Weekday().String(), s.WeeklyAnchor) {
+			return false
+		}
+	default:
+		return false
+	}
+	if s.LastRunAt == nil {
+		return true
+	}
+	y1, m1, d1 := s.LastRunAt.Date()
+	y2, m2, d2 := now.Date()
+	return y1 != y2 || m1 != m2 || d1 != d2
+}
+
+// RunSchedule pays out the full available balance of every currency that has
+// a bank account, the platform's and each connected account's, at most once
+// per scheduled day. It returns the payouts made.
+func RunSchedule(db *gorm.DB, now time.Time) ([]models.Payout, error) {
+	s, err := Schedule(db)
+	if err != nil {
+		return nil, err
+	}
+	if !Due(s, now) {
+		return nil, nil
+	}
+
+	var connected []string
+	if err := db.Model(&models.Merchant{}).Where("COALESCE(platform_id, '') <> ''").Order("created_at").Pluck("id", &connected).Error; err != nil {
+		return nil, err
+	}
+
+	var created []models.Payout
+	for _, merchantID := range append([]string{""}, connected...) {
+		made, err := payOutAll(db, merchantID)
+		created = append(created, made...)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	s.LastRunAt = &now
+	return created, db.Save(s).Error
+}
+
+// payOutAll pays out the whole available balance of the connected account
+// merchantID, or the platform's when merchantID is empty.
+func payOutAll(db *gorm.DB, merchantID string) ([]models.Payout, error) {
+	account, err := balance.Account(db, merchantID, ledger.AccountMerchantAvailable)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := ledger.Balances(db, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var created []models.Payout
+	for currency, available := range balances {
+		if available <= 0 {
+			continue
+		}
+		ba, err := DefaultBankAccount(db, merchantID, currency)
+		if errors.Is(err, ErrNoBankAccount) {
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			p, err := Create(tx, ba, available, "automatic payout", models.Metadata{}, true)
+			if err != nil {
+				return err
+			}
+			created = append(created, *p)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrInsufficientFunds) {
+			return created, err
+		}
+	}
+	return created, nil
+}
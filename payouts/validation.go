@@ -0,0 +1,80 @@
+package payouts
+
+import (
+	"math/big"
+	"strings"
+)
+
+// ibanLengths covers the SEPA countries MiniPay pays out to.
+var ibanLengths = map[string]int{
+	"AT": 20, "BE": 16, "BG": 22, "CH": 21, "CY": 28, "CZ": 24, "DE": 22,
+	"DK": 18, "EE": 20, "ES": 24, "FI": 18, "FR": 27, "GB": 22, "GR": 27,
+	"HR": 21, "HU": 28, "IE": 22, "IS": 26, "IT": 27, "LI": 21, "LT": 20,
+	"LU": 20, "LV": 21, "MC": 27, "MT": 31, "NL": 18, "NO": 15, "PL": 28,
+	"PT": 25, "RO": 24, "SE": 24, "SI": 19, "SK": 24, "SM": 27,
+}
+
+// NormalizeIBAN strips spaces and upper-cases an IBAN as typed by a person.
+func NormalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+}
+
+// ValidIBAN checks the country length and the ISO 13616 mod-97 checksum.
+func ValidIBAN(iban string) bool {
+	if len(iban) < 15 {
+		return false
+	}
+	want, ok := ibanLengths[iban[:2]]
+	if !ok || len(iban) != want {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			digits.WriteString(big.NewInt(int64(c - 'A' + 10)).String())
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// ValidABARouting checks a US routing transit number's 3-7-1 checksum.
+func ValidABARouting(routing string) bool {
+	if len(routing) != 9 {
+		return false
+	}
+	d := make([]int, 9)
+	for i, c := range routing {
+		if c < '0' || c > '9' {
+			return false
+		}
+		d[i] = int(c - '0')
+	}
+	sum := 3*(d[0]+d[3]+d[6]) + 7*(d[1]+d[4]+d[7]) + (d[2] + d[5] + d[8])
+	return sum != 0 && sum%10 == 0
+}
+
+func ValidBIC(bic string) bool {
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+	for i, c := range bic {
+		switch {
+		case i < 6 && (c < 'A' || c > 'Z'):
+			return false
+		case i >= 6 && !((c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')):
+			return false
+		}
+	}
+	return true
+}
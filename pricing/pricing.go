@@ -0,0 +1,147 @@
+// Package pricing holds the pricing plans merchants are billed on and works
+// out the fee MiniPay keeps on each charge and refund.
+package pricing
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/settlement"
+)
+
+const (
+	DefaultPlanID     = "plan_standard"
+	DefaultMerchantID = "acct_default"
+)
+
+// Refund policies decide what happens to the charge fee when it is refunded.
+const (
+	RefundKeepFee   = "keep_fee"
+	RefundReturnFee = "return_fee"
+)
+
+var RefundPolicies = []string{RefundKeepFee, RefundReturnFee}
+
+const AnyCurrency = "*"
+
+var ErrMerchantNotFound = errors.New("pricing: merchant not found")
+
+// StandardPlan is what merchants pay unless they are moved to another plan.
+func StandardPlan() models.PricingPlan {
+	return models.PricingPlan{
+		ID:   DefaultPlanID,
+		Name: "Standard",
+		Rates: models.FeeRates{
+			"usd":       {PercentBps: 290, Fixed: 30},
+			"eur":       {PercentBps: 150, Fixed: 25},
+			AnyCurrency: {PercentBps: 290, Fixed: 30},
+		},
+		BrandSurcharges:  models.Surcharges{"amex": 60},
+		InternationalBps: 150,
+		RefundPolicy:     RefundKeepFee,
+		FXMarkupBps:      100,
+	}
+}
+
+// EnsureDefaults creates the standard plan and the default merchant if they
+// do not exist yet.
+func EnsureDefaults(db *gorm.DB) error {
+	plan := StandardPlan()
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&plan).Error; err != nil {
+		return err
+	}
+	merchant := models.Merchant{
+		ID:                  DefaultMerchantID,
+		Name:                "Default merchant",
+		PricingPlanID:       DefaultPlanID,
+		SettlementDelayDays: settlement.DefaultDelayDays,
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&merchant).Error
+}
+
+// Lookup returns a merchant and the plan it is billed on.
+func Lookup(db *gorm.DB, merchantID string) (*models.Merchant, *models.PricingPlan, error) {
+	var merchant models.Merchant
+	err := db.First(&merchant, "id = ?", merchantID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrMerchantNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var plan models.PricingPlan
+	if err := db.First(&plan, "id = ?", merchant.PricingPlanID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &merchant, &plan, nil
+}
+
+// Fee is the total fee and how it was made up.
+type Fee struct {
+	Amount  int64
+	Details models.FeeDetails
+}
+
+func (f *Fee) add(typ, description string, amount int64) {
+	if amount == 0 {
+		return
+	}
+	f.Amount += amount
+	f.Details = append(f.Details, models.FeeDetail{Type: typ, Description: description, Amount: amount})
+}
+
+// percent applies basis points to amount, rounding half up.
+func percent(amount, bps int64) int64 {
+	return (amount*bps + 5000) / 10000
+}
+
+// International reports whether a charge crosses borders, which is only
+// known when both countries are.
+func International(merchant models.Merchant, txn models.Transaction) bool {
+	return merchant.Country != "" && txn.Country != "" && merchant.Country != txn.Country
+}
+
+// ChargeFee prices a succeeded charge. The fee never exceeds the amount.
+func ChargeFee(plan models.PricingPlan, amount int64, currency, brand string, international bool) Fee {
+	var fee Fee
+	rate, ok := plan.Rates[currency]
+	if !ok {
+		rate = plan.Rates[AnyCurrency]
+	}
+	fee.add("processing", "Processing fee", percent(amount, rate.PercentBps)+rate.Fixed)
+	if bps := plan.BrandSurcharges[brand]; bps > 0 {
+		fee.add("brand_surcharge", brand+" surcharge", percent(amount, bps))
+	}
+	if international && plan.InternationalBps > 0 {
+		fee.add("international", "International card surcharge", percent(amount, plan.InternationalBps))
+	}
+	if fee.Amount > amount {
+		fee.Details = append(fee.Details, models.FeeDetail{Type: "cap", Description: "Fee capped at the charge amount", Amount: amount - fee.Amount})
+		fee.Amount = amount
+	}
+	return fee
+}
+
+// RefundFee prices refunding amount of a charge that cost chargeFee. Under
+// return_fee the matching share of the charge fee is given back, as a
+// negative fee; a fixed refund fee is added on top.
+func RefundFee(plan models.PricingPlan, amount, chargeAmount, chargeFee int64) Fee {
+	var fee Fee
+	if plan.RefundPolicy == RefundReturnFee && chargeAmount > 0 {
+		fee.add("fee_return", "Charge fee returned", -(chargeFee*amount)/chargeAmount)
+	}
+	fee.add("refund", "Refund fee", plan.RefundFixedFee)
+	return fee
+}
+
+func ValidRefundPolicy(policy string) bool {
+	for _, p := range RefundPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
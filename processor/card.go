@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// developmentFingerprintKey keys fingerprints when CARD_FINGERPRINT_KEY is
+// not set. Anyone can recompute those, so it is only fit for testing.
+const developmentFingerprintKey = "minipay-development-fingerprint-key"
+
+var fingerprintKey = []byte(developmentFingerprintKey)
+
+func Brand(number string) string {
+	switch {
+	case number == "":
+		return ""
+	case strings.HasPrefix(number, "4"):
+		return "visa"
+	case strings.HasPrefix(number, "34"), strings.HasPrefix(number, "37"):
+		return "amex"
+	case strings.HasPrefix(number, "6011"), strings.HasPrefix(number, "65"):
+		return "discover"
+	case len(number) >= 2 && number[0] == '5' && number[1] >= '1' && number[1] <= '5':
+		return "mastercard"
+	case len(number) >= 4 && number[:4] >= "2221" && number[:4] <= "2720":
+		return "mastercard"
+	default:
+		return "unknown"
+	}
+}
+
+func Last4(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return number[len(number)-4:]
+}
+
+// LoadFingerprintKey reads the secret key of card fingerprints from
+// CARD_FINGERPRINT_KEY. It reports false, and keeps the development key,
+// when the variable is not set.
+func LoadFingerprintKey() bool {
+	key := os.Getenv("CARD_FINGERPRINT_KEY")
+	if key == "" {
+		fingerprintKey = []byte(developmentFingerprintKey)
+		return false
+	}
+	fingerprintKey = []byte(key)
+	return true
+}
+
+// Fingerprint identifies a card number without revealing it, so that
+// charges made with the same card can be told apart from others. It is an
+// HMAC under the fingerprint key: card numbers have too few unknown digits
+// for a plain hash to hide them.
+func Fingerprint(number string) string {
+	if number == "" {
+		return ""
+	}
+	return hex.EncodeToString(cardMAC(number)[:8])
+}
+
+// Token stands for a card number kept on file, so that the number itself is
+// never stored: cards on file are charged with Card.Token instead. It is the
+// whole HMAC that Fingerprint is the start of.
+func Token(number string) string {
+	if number == "" {
+		return ""
+	}
+	return tokenPrefix + hex.EncodeToString(cardMAC(number))
+}
+
+const tokenPrefix = "tok_"
+
+func cardMAC(number string) []byte {
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(number))
+	return mac.Sum(nil)
+}
+
+// Describe returns the brand, last four digits and fingerprint of c, from its
+// number or, for a card on file, from its token and what was kept with it.
+func (c Card) Describe() (brand, last4, fingerprint string) {
+	if c.Number != "" || c.Token == "" {
+		return Brand(c.Number), Last4(c.Number), Fingerprint(c.Number)
+	}
+	if hexMAC := strings.TrimPrefix(c.Token, tokenPrefix); len(hexMAC) >= 16 {
+		fingerprint = hexMAC[:16]
+	}
+	return c.Brand, c.Last4, fingerprint
+}
+
+func ValidLuhn(number string) bool {
+	if len(number) < 12 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
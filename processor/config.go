@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type ProcessorConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type RoutingConfig struct {
+	Processors []ProcessorConfig `json:"processors"`
+	Rules      []Rule            `json:"rules"`
+	Default    Rule              `json:"default"`
+	TimeoutMS  int               `json:"timeout_ms"`
+}
+
+func LoadRouter(path string) (*Router, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg RoutingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse routing config: %w", err)
+	}
+
+	processors := make([]Processor, 0, len(cfg.Processors))
+	for _, pc := range cfg.Processors {
+		switch pc.Type {
+		case "", "simulator":
+			processors = append(processors, NewSimulator(pc.Name))
+		default:
+			return nil, fmt.Errorf("processor %q: unsupported type %q", pc.Name, pc.Type)
+		}
+	}
+
+	if cfg.Default.Name == "" {
+		cfg.Default.Name = "default"
+	}
+	return NewRouter(processors, cfg.Rules, cfg.Default, time.Duration(cfg.TimeoutMS)*time.Millisecond)
+}
@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ErrorTypeCard = "card_error"
+	ErrorTypeAPI  = "api_error"
+)
+
+const (
+	CodeCardDeclined      = "card_declined"
+	CodeInsufficientFunds = "insufficient_funds"
+	CodeExpiredCard       = "expired_card"
+	CodeIncorrectCVC      = "incorrect_cvc"
+	CodeInvalidNumber     = "invalid_number"
+	CodeProcessingError   = "processing_error"
+	// CodeAuthenticationFailed declines a charge whose cardholder failed or
+	// could not be asked for 3-D Secure authentication.
+	CodeAuthenticationFailed = "authentication_failed"
+
+	CodeProcessorUnavailable = "processor_unavailable"
+	CodeTimeout              = "timeout"
+)
+
+// Card is charged by its Number or, when it is kept on file, by its Token,
+// with the Brand and Last4 kept alongside the token.
+type Card struct {
+	Number   string
+	ExpMonth int
+	ExpYear  int
+	CVC      string
+	Token    string
+	Brand    string
+	Last4    string
+}
+
+type ChargeParams struct {
+	TransactionID string
+	// IdempotencyKey makes sending the same charge twice to a processor
+	// charge it once. The router sends each processor its own AttemptKey.
+	IdempotencyKey string
+	Amount         int64
+	Currency       string
+	Customer       string
+	Country        string
+	Card           Card
+	// CaptureLater asks the processor only to authorize the charge. A
+	// processor that cannot captures it anyway.
+	CaptureLater bool
+	// OffSession charges are made without the cardholder present, so they
+	// are declined rather than challenged when the card needs 3-D Secure.
+	OffSession bool
+}
+
+// AttemptKey is the idempotency key of the attempt to charge key on
+// processor.
+func AttemptKey(key, processor string) string {
+	return key + ":" + processor
+}
+
+type Result struct {
+	Processor string
+	Reference string
+	Dispute   *DisputeNotice
+	// Authorized reports that the charge was authorized but not captured.
+	Authorized bool
+	// RequiresAction reports that the cardholder must pass a 3-D Secure
+	// challenge before the processor decides the charge, by calling
+	// Authenticator.Authenticate with Reference and Challenge.
+	RequiresAction bool
+	// Challenge is the processor's state of the challenge, which the caller
+	// keeps until the challenge is completed. It holds no card details.
+	Challenge string
+}
+
+// DisputeNotice tells the caller that the cardholder has disputed a charge
+// the processor just approved.
+type DisputeNotice struct {
+	Reason string
+}
+
+const (
+	DisputeWon  = "won"
+	DisputeLost = "lost"
+)
+
+// DisputeReviewer is implemented by processors that decide disputes from the
+// submitted evidence. An empty outcome leaves the dispute under review.
+type DisputeReviewer interface {
+	ReviewDispute(evidence map[string]string) string
+}
+
+// Capturer is implemented by processors that can authorize a charge and
+// capture or void it later, by the Reference of its Result.
+type Capturer interface {
+	Capture(reference string, amount int64) error
+	Void(reference string) error
+}
+
+// Authenticator is implemented by processors that challenge cardholders
+// with 3-D Secure. Authenticate decides the charge with reference once its
+// challenge, the Challenge of its Result, is passed or failed.
+type Authenticator interface {
+	Authenticate(reference, challenge string, authenticated bool) (*Result, error)
+}
+
+type Error struct {
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code,omitempty"`
+	Message     string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether err is transient and the charge may be sent to
+// another processor.
+func Retryable(err error) bool {
+	var perr *Error
+	if !errors.As(err, &perr) {
+		return false
+	}
+	return perr.Code == CodeProcessorUnavailable || perr.Code == CodeTimeout
+}
+
+type Processor interface {
+	Name() string
+	Charge(params ChargeParams) (*Result, error)
+}
+
+var Default Processor = NewSimulator("simulator")
+
+// Lookup returns the processor registered under name, falling back to Default.
+func Lookup(name string) Processor {
+	if r, ok := Default.(*Router); ok {
+		if p := r.Processor(name); p != nil {
+			return p
+		}
+	}
+	return Default
+}
@@ -0,0 +1,230 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type Target struct {
+	Processor string `json:"processor"`
+	Weight    int    `json:"weight"`
+}
+
+type Match struct {
+	Currencies []string `json:"currencies"`
+	CardBrands []string `json:"card_brands"`
+	Countries  []string `json:"countries"`
+	MinAmount  int64    `json:"min_amount"`
+	MaxAmount  int64    `json:"max_amount"`
+}
+
+type Rule struct {
+	Name     string   `json:"name"`
+	Match    Match    `json:"match"`
+	Targets  []Target `json:"targets"`
+	Fallback []string `json:"fallback"`
+}
+
+// Router picks a processor for each charge from the first matching rule and
+// fails over to the remaining candidates on retryable errors.
+type Router struct {
+	processors map[string]Processor
+	rules      []Rule
+	defaults   Rule
+	timeout    time.Duration
+}
+
+func NewRouter(processors []Processor, rules []Rule, defaults Rule, timeout time.Duration) (*Router, error) {
+	r := &Router{
+		processors: make(map[string]Processor, len(processors)),
+		rules:      rules,
+		defaults:   defaults,
+		timeout:    timeout,
+	}
+	for _, p := range processors {
+		r.processors[p.Name()] = p
+	}
+
+	for _, rule := range append([]Rule{defaults}, rules...) {
+		for _, t := range rule.Targets {
+			if _, ok := r.processors[t.Processor]; !ok {
+				return nil, fmt.Errorf("rule %q: unknown processor %q", rule.Name, t.Processor)
+			}
+			if t.Weight < 0 {
+				return nil, fmt.Errorf("rule %q: negative weight for %q", rule.Name, t.Processor)
+			}
+		}
+		for _, name := range rule.Fallback {
+			if _, ok := r.processors[name]; !ok {
+				return nil, fmt.Errorf("rule %q: unknown fallback processor %q", rule.Name, name)
+			}
+		}
+	}
+	if len(defaults.Targets) == 0 {
+		return nil, fmt.Errorf("default route has no targets")
+	}
+	return r, nil
+}
+
+func (r *Router) Name() string {
+	return "router"
+}
+
+func (r *Router) Processor(name string) Processor {
+	return r.processors[name]
+}
+
+func (r *Router) Charge(params ChargeParams) (*Result, error) {
+	var lastErr error
+	var last string
+	for _, name := range r.Candidates(params) {
+		last = name
+		attempt := params
+		if params.IdempotencyKey != "" {
+			attempt.IdempotencyKey = AttemptKey(params.IdempotencyKey, name)
+		}
+		result, err := r.call(r.processors[name], attempt)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+		if !Retryable(err) {
+			break
+		}
+	}
+	return &Result{Processor: last}, lastErr
+}
+
+type outcome struct {
+	result *Result
+	err    error
+}
+
+// call charges params on p, giving up after the router's timeout. A charge
+// given up on is voided if it succeeds after all, since the router has
+// moved on to another processor.
+func (r *Router) call(p Processor, params ChargeParams) (*Result, error) {
+	if r.timeout <= 0 {
+		return p.Charge(params)
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := p.Charge(params)
+		done <- outcome{result, err}
+	}()
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		go abandon(p, done)
+		return nil, &Error{Type: ErrorTypeAPI, Code: CodeTimeout, Message: "The payment processor did not respond in time."}
+	}
+}
+
+// abandon waits for a call that timed out and voids its charge if it went
+// through late, so that the customer is not charged twice.
+func abandon(p Processor, done <-chan outcome) {
+	o := <-done
+	if o.err != nil || o.result == nil {
+		return
+	}
+	c, ok := p.(Capturer)
+	if !ok {
+		log.Printf("router: %s charged %s after timing out and cannot void it", p.Name(), o.result.Reference)
+		return
+	}
+	if err := c.Void(o.result.Reference); err != nil {
+		log.Printf("router: failed to void late charge %s on %s: %v", o.result.Reference, p.Name(), err)
+	}
+}
+
+// Candidates returns the processors to try for params, in order. The first
+// entry is drawn by weight from the matching rule's targets; the remaining
+// targets and the rule's fallbacks follow.
+func (r *Router) Candidates(params ChargeParams) []string {
+	rule := r.defaults
+	for _, candidate := range r.rules {
+		if candidate.Match.matches(params) {
+			rule = candidate
+			break
+		}
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+
+	if primary := pickWeighted(rule.Targets); primary != "" {
+		add(primary)
+	}
+	for _, t := range rule.Targets {
+		add(t.Processor)
+	}
+	for _, name := range rule.Fallback {
+		add(name)
+	}
+	return order
+}
+
+func pickWeighted(targets []Target) string {
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		if len(targets) == 0 {
+			return ""
+		}
+		return targets[0].Processor
+	}
+
+	n := rand.Intn(total)
+	for _, t := range targets {
+		if n < t.Weight {
+			return t.Processor
+		}
+		n -= t.Weight
+	}
+	return targets[len(targets)-1].Processor
+}
+
+func (m Match) matches(params ChargeParams) bool {
+	if len(m.Currencies) > 0 && !containsFold(m.Currencies, params.Currency) {
+		return false
+	}
+	if brand, _, _ := params.Card.Describe(); len(m.CardBrands) > 0 && !containsFold(m.CardBrands, brand) {
+		return false
+	}
+	if len(m.Countries) > 0 && !containsFold(m.Countries, params.Country) {
+		return false
+	}
+	if m.MinAmount > 0 && params.Amount < m.MinAmount {
+		return false
+	}
+	if m.MaxAmount > 0 && params.Amount > m.MaxAmount {
+		return false
+	}
+	return true
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
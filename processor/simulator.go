@@ -0,0 +1,343 @@
+package processor
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Test card numbers understood by the simulator. Any other valid card number
+// is approved.
+var simulatedDeclines = map[string]Error{
+	"4000000000000002": {Type: ErrorTypeCard, Code: CodeCardDeclined, DeclineCode: "generic_decline", Message: "Your card was declined."},
+	"4000000000009995": {Type: ErrorTypeCard, Code: CodeInsufficientFunds, DeclineCode: "insufficient_funds", Message: "Your card has insufficient funds."},
+	"4000000000009987": {Type: ErrorTypeCard, Code: CodeCardDeclined, DeclineCode: "lost_card", Message: "Your card was declined."},
+	"4000000000009979": {Type: ErrorTypeCard, Code: CodeCardDeclined, DeclineCode: "stolen_card", Message: "Your card was declined."},
+	"4000000000000069": {Type: ErrorTypeCard, Code: CodeExpiredCard, DeclineCode: "expired_card", Message: "Your card has expired."},
+	"4000000000000127": {Type: ErrorTypeCard, Code: CodeIncorrectCVC, DeclineCode: "incorrect_cvc", Message: "Your card's security code is incorrect."},
+	"4000000000000119": {Type: ErrorTypeAPI, Code: CodeProcessingError, DeclineCode: "processing_error", Message: "An error occurred while processing your card."},
+	"4000008260003178": {Type: ErrorTypeCard, Code: CodeInsufficientFunds, DeclineCode: "insufficient_funds", Message: "Your card has insufficient funds."},
+}
+
+// Approved test cards that are disputed straight after the charge succeeds.
+var simulatedDisputes = map[string]string{
+	"4000000000000259": "fraudulent",
+	"4000000000001976": "product_not_received",
+}
+
+// Test cards that need 3-D Secure: always, or only for charges from the
+// EEA, where strong customer authentication is required. Challenged cards
+// that are also simulated declines are declined once authenticated.
+var (
+	simulatedChallenges = map[string]bool{
+		"4000002760003184": true,
+		"4000008260003178": true,
+	}
+	simulatedSCAChallenges = map[string]bool{
+		"4000002500003155": true,
+	}
+)
+
+// simulatedCard is the test card that token, a card on file, stands for, or
+// "" for any other card, which is approved.
+func simulatedCard(token string) string {
+	for number := range simulatedDeclines {
+		if Token(number) == token {
+			return number
+		}
+	}
+	for number := range simulatedDisputes {
+		if Token(number) == token {
+			return number
+		}
+	}
+	for number := range simulatedChallenges {
+		if Token(number) == token {
+			return number
+		}
+	}
+	for number := range simulatedSCAChallenges {
+		if Token(number) == token {
+			return number
+		}
+	}
+	return ""
+}
+
+// eea are the countries of the European Economic Area.
+var eea = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true, "EE": true, "ES": true, "FI": true,
+	"FR": true, "GR": true, "HR": true, "HU": true, "IE": true, "IS": true, "IT": true, "LI": true, "LT": true, "LU": true,
+	"LV": true, "MT": true, "NL": true, "NO": true, "PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+type Simulator struct {
+	name        string
+	now         func() time.Time
+	unavailable atomic.Bool
+	latency     atomic.Int64
+
+	mu sync.Mutex
+	// statuses are what became of the charges that went through, by
+	// reference, and results their results by idempotency key. memos lists
+	// both in the order they were recorded, to forget them after
+	// IdempotencyWindow.
+	statuses map[string]string
+	results  map[string]Result
+	memos    []memo
+}
+
+// IdempotencyWindow is how long the simulator remembers a charge: its status
+// and the result it replays for the charge's idempotency key.
+const IdempotencyWindow = 24 * time.Hour
+
+type memo struct {
+	reference      string
+	idempotencyKey string
+	at             time.Time
+}
+
+func NewSimulator(name string) *Simulator {
+	return &Simulator{name: name, now: time.Now, statuses: map[string]string{}, results: map[string]Result{}}
+}
+
+func (s *Simulator) Name() string {
+	return s.name
+}
+
+// SetUnavailable makes every charge fail with processor_unavailable, as if the
+// processor were down.
+func (s *Simulator) SetUnavailable(unavailable bool) {
+	s.unavailable.Store(unavailable)
+}
+
+func (s *Simulator) SetLatency(d time.Duration) {
+	s.latency.Store(int64(d))
+}
+
+// SetClock makes the simulator tell the time with now, which decides card
+// expiry and when charges are forgotten.
+func (s *Simulator) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+func (s *Simulator) clock() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now()
+}
+
+func (s *Simulator) Charge(params ChargeParams) (*Result, error) {
+	if d := time.Duration(s.latency.Load()); d > 0 {
+		time.Sleep(d)
+	}
+	if s.unavailable.Load() {
+		return nil, &Error{Type: ErrorTypeAPI, Code: CodeProcessorUnavailable, Message: "The payment processor is temporarily unavailable."}
+	}
+	if result, ok := s.replay(params.IdempotencyKey); ok {
+		return result, nil
+	}
+
+	card := params.Card
+	if card.Number == "" && card.Token != "" {
+		card.Number = simulatedCard(card.Token)
+		params.Card = card
+	}
+
+	if card.Number != "" {
+		if !ValidLuhn(card.Number) {
+			return nil, &Error{Type: ErrorTypeCard, Code: CodeInvalidNumber, Message: "Your card number is invalid."}
+		}
+		if challenged(params) {
+			if params.OffSession {
+				return nil, &Error{Type: ErrorTypeCard, Code: CodeAuthenticationFailed, DeclineCode: "authentication_required", Message: "The card requires authentication, which is not possible off session."}
+			}
+			challenge, err := json.Marshal(s.assess(params))
+			if err != nil {
+				return nil, err
+			}
+			reference := s.name + "_" + uuid.NewString()
+			result := &Result{Processor: s.name, Reference: reference, RequiresAction: true, Challenge: string(challenge)}
+			s.record(params.IdempotencyKey, result, "requires_action")
+			return result, nil
+		}
+	}
+	return s.decide(params, s.name+"_"+uuid.NewString())
+}
+
+// Authenticate decides a challenged charge: a failed challenge declines it,
+// and a passed one is decided as challenge, its verdict, says.
+func (s *Simulator) Authenticate(reference, challenge string, authenticated bool) (*Result, error) {
+	if s.unavailable.Load() {
+		return nil, &Error{Type: ErrorTypeAPI, Code: CodeProcessorUnavailable, Message: "The payment processor is temporarily unavailable."}
+	}
+	var v verdict
+	if err := json.Unmarshal([]byte(challenge), &v); err != nil {
+		return nil, &Error{Type: ErrorTypeAPI, Code: CodeProcessingError, Message: "The payment processor has no challenge for this charge."}
+	}
+	if !authenticated {
+		return nil, &Error{Type: ErrorTypeCard, Code: CodeAuthenticationFailed, DeclineCode: "authentication_failed", Message: "The cardholder failed 3-D Secure authentication."}
+	}
+	return s.conclude(v, reference)
+}
+
+// verdict is how the simulator decides a charge. A challenged charge's is
+// worked out before the challenge, so that no card details are kept while
+// it waits.
+type verdict struct {
+	Decline        *Error `json:"decline,omitempty"`
+	Authorized     bool   `json:"authorized,omitempty"`
+	Dispute        string `json:"dispute,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (s *Simulator) decide(params ChargeParams, reference string) (*Result, error) {
+	return s.conclude(s.assess(params), reference)
+}
+
+func (s *Simulator) assess(params ChargeParams) verdict {
+	v := verdict{Authorized: params.CaptureLater, IdempotencyKey: params.IdempotencyKey}
+	card := params.Card
+	if card.Number != "" || card.Token != "" {
+		if decline, ok := simulatedDeclines[card.Number]; ok {
+			v.Decline = &decline
+		} else if expired(card, s.clock()) {
+			v.Decline = &Error{Type: ErrorTypeCard, Code: CodeExpiredCard, DeclineCode: "expired_card", Message: "Your card has expired."}
+		}
+	}
+	v.Dispute = simulatedDisputes[card.Number]
+	return v
+}
+
+func (s *Simulator) conclude(v verdict, reference string) (*Result, error) {
+	if v.Decline != nil {
+		return nil, v.Decline
+	}
+	result := &Result{Processor: s.name, Reference: reference, Authorized: v.Authorized}
+	if v.Dispute != "" {
+		result.Dispute = &DisputeNotice{Reason: v.Dispute}
+	}
+	status := "succeeded"
+	if result.Authorized {
+		status = "authorized"
+	}
+	s.record(v.IdempotencyKey, result, status)
+	return result, nil
+}
+
+// Status is what became of the charge sent with idempotencyKey: succeeded,
+// authorized, captured, voided or requires_action, or empty if none went
+// through.
+func (s *Simulator) Status(idempotencyKey string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[idempotencyKey]
+	if !ok {
+		return ""
+	}
+	return s.statuses[result.Reference]
+}
+
+// replay returns the result of the charge already sent with idempotencyKey.
+func (s *Simulator) replay(idempotencyKey string) (*Result, bool) {
+	if idempotencyKey == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.results[idempotencyKey]
+	return &result, ok
+}
+
+func (s *Simulator) record(idempotencyKey string, result *Result, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.forget(now.Add(-IdempotencyWindow))
+	s.statuses[result.Reference] = status
+	if idempotencyKey != "" {
+		s.results[idempotencyKey] = *result
+	}
+	s.memos = append(s.memos, memo{reference: result.Reference, idempotencyKey: idempotencyKey, at: now})
+}
+
+// forget drops the charges recorded before cutoff. s.mu must be held.
+func (s *Simulator) forget(cutoff time.Time) {
+	n := 0
+	for _, m := range s.memos {
+		if !m.at.Before(cutoff) {
+			break
+		}
+		delete(s.statuses, m.reference)
+		if r, ok := s.results[m.idempotencyKey]; ok && r.Reference == m.reference {
+			delete(s.results, m.idempotencyKey)
+		}
+		n++
+	}
+	if n > 0 {
+		s.memos = append(s.memos[:0:0], s.memos[n:]...)
+	}
+}
+
+func (s *Simulator) setStatus(reference, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.statuses[reference]; ok {
+		s.statuses[reference] = status
+	}
+}
+
+func challenged(params ChargeParams) bool {
+	return simulatedChallenges[params.Card.Number] ||
+		(simulatedSCAChallenges[params.Card.Number] && eea[strings.ToUpper(params.Country)])
+}
+
+// Capture captures an authorized charge; the simulator only fails while it
+// is unavailable.
+func (s *Simulator) Capture(reference string, amount int64) error {
+	if s.unavailable.Load() {
+		return &Error{Type: ErrorTypeAPI, Code: CodeProcessorUnavailable, Message: "The payment processor is temporarily unavailable."}
+	}
+	s.setStatus(reference, "captured")
+	return nil
+}
+
+// Void releases an authorized charge, or reverses one that went through.
+func (s *Simulator) Void(reference string) error {
+	if s.unavailable.Load() {
+		return &Error{Type: ErrorTypeAPI, Code: CodeProcessorUnavailable, Message: "The payment processor is temporarily unavailable."}
+	}
+	s.setStatus(reference, "voided")
+	return nil
+}
+
+// ReviewDispute wins disputes whose evidence mentions "winning_evidence" and
+// loses those that mention "losing_evidence". Anything else stays under
+// review until it is closed by hand.
+func (s *Simulator) ReviewDispute(evidence map[string]string) string {
+	outcome := ""
+	for _, v := range evidence {
+		if strings.Contains(v, "winning_evidence") {
+			return DisputeWon
+		}
+		if strings.Contains(v, "losing_evidence") {
+			outcome = DisputeLost
+		}
+	}
+	return outcome
+}
+
+func expired(card Card, now time.Time) bool {
+	if card.ExpYear == 0 || card.ExpMonth == 0 {
+		return false
+	}
+	// Cards are valid through the last day of their expiry month.
+	end := time.Date(card.ExpYear, time.Month(card.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+	return !now.Before(end)
+}
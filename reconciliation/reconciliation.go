@@ -0,0 +1,412 @@
+// Package reconciliation imports bank statements and matches their lines
+// against the payouts and settlement deposits MiniPay expects on the account.
+package reconciliation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payouts"
+	"github.com/vaidikcode/minipay/statements"
+	"github.com/vaidikcode/minipay/storage"
+)
+
+const (
+	StatusMatched    = "matched"
+	StatusUnmatched  = "unmatched"
+	StatusSuspicious = "suspicious"
+)
+
+const (
+	TypePayout     = "payout"
+	TypeSettlement = "settlement"
+)
+
+const FilePurpose = "bank_statement"
+
+// MatchWindow is how far a booking date may be from the expected date.
+var MatchWindow = 3 * 24 * time.Hour
+
+// SettlementDelay is when the processor deposits a day's charges.
+var SettlementDelay = 24 * time.Hour
+
+var (
+	ErrAlreadyImported = errors.New("reconciliation: statement already imported")
+	ErrUnbalanced      = errors.New("reconciliation: statement lines do not add up to the closing balance")
+)
+
+// Expected is a movement MiniPay expects to see on its bank account. Amount is
+// negative for money leaving the account.
+type Expected struct {
+	Type      string
+	ID        string
+	Amount    int64
+	Currency  string
+	Date      time.Time
+	Status    string
+	Reference string
+}
+
+type Report struct {
+	Statement  models.BankStatement
+	Matched    []models.BankStatementLine
+	Unmatched  []models.BankStatementLine
+	Suspicious []models.BankStatementLine
+	// Missing are expected movements in the statement period without a line.
+	Missing []Expected
+}
+
+// Import parses data, stores the raw file and its statements, and reconciles
+// each statement. format may be empty to detect it.
+func Import(db *gorm.DB, format, filename string, data []byte) ([]models.BankStatement, error) {
+	parsed, err := statements.Parse(format, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range parsed {
+		if !s.Balanced() {
+			return nil, fmt.Errorf("%w (statement %s)", ErrUnbalanced, s.Reference)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	var duplicates int64
+	db.Model(&models.File{}).Where("purpose = ? AND sha256 = ?", FilePurpose, hex.EncodeToString(sum[:])).Count(&duplicates)
+	if duplicates > 0 {
+		return nil, ErrAlreadyImported
+	}
+
+	var imported []models.BankStatement
+	err = db.Transaction(func(tx *gorm.DB) error {
+		file, err := storage.Save(tx, FilePurpose, "", filename, "text/plain", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		for _, s := range parsed {
+			start, end := s.Period()
+			stmt := models.BankStatement{
+				ID:             "bst_" + uuid.NewString(),
+				Format:         s.Format,
+				Reference:      s.Reference,
+				Account:        s.Account,
+				Currency:       s.Currency,
+				OpeningBalance: s.OpeningBalance,
+				ClosingBalance: s.ClosingBalance,
+				HasBalances:    s.HasBalances,
+				PeriodStart:    start,
+				PeriodEnd:      end,
+				FileID:         file.ID,
+			}
+			if err := tx.Create(&stmt).Error; err != nil {
+				return err
+			}
+			for _, l := range s.Lines {
+				line := models.BankStatementLine{
+					ID:          "bsl_" + uuid.NewString(),
+					StatementID: stmt.ID,
+					BookingDate: l.BookingDate,
+					ValueDate:   l.ValueDate,
+					Amount:      l.Amount,
+					Currency:    l.Currency,
+					Reference:   truncate(l.Reference, 255),
+					Description: truncate(l.Description, 1024),
+					Status:      StatusUnmatched,
+				}
+				if err := tx.Create(&line).Error; err != nil {
+					return err
+				}
+			}
+			imported = append(imported, stmt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range imported {
+		if err := Reconcile(db, stmt.ID); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+// Reconcile matches the statement's unmatched and suspicious lines again;
+// matched lines keep their match.
+func Reconcile(db *gorm.DB, statementID string) error {
+	var stmt models.BankStatement
+	if err := db.First(&stmt, "id = ?", statementID).Error; err != nil {
+		return err
+	}
+	var lines []models.BankStatementLine
+	if err := db.Where("statement_id = ? AND status <> ?", stmt.ID, StatusMatched).
+		Order("booking_date").Order("id").Find(&lines).Error; err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	expected, err := expectedBetween(db, stmt.PeriodStart.Add(-MatchWindow), stmt.PeriodEnd.Add(MatchWindow))
+	if err != nil {
+		return err
+	}
+	taken, err := matchedIDs(db)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range lines {
+			l := &lines[i]
+			status, match, note := classify(*l, expected, taken)
+			l.Status, l.Note, l.MatchType, l.MatchID = status, note, "", ""
+			if match != nil {
+				l.MatchType, l.MatchID = match.Type, match.ID
+				if status == StatusMatched {
+					taken[match.ID] = l.ID
+				}
+			}
+			if err := tx.Save(l).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// classify decides a line's status. A payout reference in the line decides
+// the match; otherwise a single expected movement of the same amount within
+// the date window does.
+func classify(l models.BankStatementLine, expected []Expected, taken map[string]string) (string, *Expected, string) {
+	text := normalize(l.Reference + " " + l.Description)
+	for i := range expected {
+		e := &expected[i]
+		if e.Reference == "" || !strings.Contains(text, e.Reference) {
+			continue
+		}
+		switch {
+		case taken[e.ID] != "":
+			return StatusSuspicious, e, "Duplicate of line " + taken[e.ID] + " for " + e.Type + " " + e.ID + "."
+		case e.Status == payouts.StatusFailed || e.Status == payouts.StatusCanceled:
+			return StatusSuspicious, e, "References " + e.Status + " " + e.Type + " " + e.ID + "."
+		case e.Currency != l.Currency || e.Amount != l.Amount:
+			return StatusSuspicious, e, fmt.Sprintf("Amount differs from %s %s: expected %d %s.", e.Type, e.ID, e.Amount, e.Currency)
+		case !withinWindow(e.Date, l.BookingDate):
+			return StatusSuspicious, e, "Booked outside the date window of " + e.Type + " " + e.ID + "."
+		}
+		return StatusMatched, e, "Matched by reference."
+	}
+
+	var candidates []*Expected
+	for i := range expected {
+		e := &expected[i]
+		if e.Amount != l.Amount || e.Currency != l.Currency || taken[e.ID] != "" || !withinWindow(e.Date, l.BookingDate) {
+			continue
+		}
+		if e.Status == payouts.StatusFailed || e.Status == payouts.StatusCanceled {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	switch len(candidates) {
+	case 0:
+		return StatusUnmatched, nil, ""
+	case 1:
+		return StatusMatched, candidates[0], "Matched by amount and date."
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return distance(candidates[i].Date, l.BookingDate) < distance(candidates[j].Date, l.BookingDate)
+	})
+	if distance(candidates[0].Date, l.BookingDate) < distance(candidates[1].Date, l.BookingDate) {
+		return StatusMatched, candidates[0], "Matched by amount and closest date."
+	}
+	return StatusSuspicious, nil, fmt.Sprintf("%d movements of this amount are expected around this date.", len(candidates))
+}
+
+// BuildReport groups the statement's lines by status and lists the expected
+// movements of its period that no line accounts for.
+func BuildReport(db *gorm.DB, statementID string) (*Report, error) {
+	r := &Report{}
+	if err := db.First(&r.Statement, "id = ?", statementID).Error; err != nil {
+		return nil, err
+	}
+	var lines []models.BankStatementLine
+	if err := db.Where("statement_id = ?", statementID).Order("booking_date").Order("id").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		switch l.Status {
+		case StatusMatched:
+			r.Matched = append(r.Matched, l)
+		case StatusSuspicious:
+			r.Suspicious = append(r.Suspicious, l)
+		default:
+			r.Unmatched = append(r.Unmatched, l)
+		}
+	}
+
+	expected, err := expectedBetween(db, r.Statement.PeriodStart, r.Statement.PeriodEnd.Add(24*time.Hour-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	taken, err := matchedIDs(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range expected {
+		if taken[e.ID] != "" || e.Status == payouts.StatusFailed || e.Status == payouts.StatusCanceled {
+			continue
+		}
+		if r.Statement.Currency != "" && e.Currency != r.Statement.Currency {
+			continue
+		}
+		r.Missing = append(r.Missing, e)
+	}
+	return r, nil
+}
+
+// expectedBetween lists payouts arriving and settlement deposits due in
+// [from, to].
+func expectedBetween(db *gorm.DB, from, to time.Time) ([]Expected, error) {
+	var list []Expected
+
+	var sent []models.Payout
+	if err := db.Where("arrival_date BETWEEN ? AND ? AND status <> ?", from, to, payouts.StatusPending).
+		Order("arrival_date").Find(&sent).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range sent {
+		list = append(list, Expected{
+			Type:      TypePayout,
+			ID:        p.ID,
+			Amount:    -p.Amount,
+			Currency:  p.Currency,
+			Date:      p.ArrivalDate,
+			Status:    p.Status,
+			Reference: payoutReference(p.ID),
+		})
+	}
+
+	settlements, err := settlementsBetween(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(list, settlements...), nil
+}
+
+// settlementsBetween nets each day's succeeded charges and refunds per
+// currency into the deposit expected SettlementDelay later.
+func settlementsBetween(db *gorm.DB, from, to time.Time) ([]Expected, error) {
+	dayFrom := from.Add(-SettlementDelay).UTC().Truncate(24 * time.Hour)
+	dayTo := to.Add(-SettlementDelay)
+
+	type key struct {
+		day      string
+		currency string
+	}
+	totals := map[key]int64{}
+
+	var charges []models.Transaction
+	if err := db.Where("status = ? AND created_at BETWEEN ? AND ?", "succeeded", dayFrom, dayTo).Find(&charges).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range charges {
+		totals[key{t.CreatedAt.UTC().Format("20060102"), t.Currency}] += t.Amount
+	}
+	var refunds []models.Refund
+	if err := db.Where("created_at BETWEEN ? AND ?", dayFrom, dayTo).Find(&refunds).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range refunds {
+		totals[key{r.CreatedAt.UTC().Format("20060102"), r.Currency}] -= r.Amount
+	}
+
+	var list []Expected
+	for k, amount := range totals {
+		if amount <= 0 {
+			continue
+		}
+		day, _ := time.Parse("20060102", k.day)
+		date := day.Add(SettlementDelay)
+		if date.Before(from.UTC().Truncate(24*time.Hour)) || date.After(to) {
+			continue
+		}
+		list = append(list, Expected{
+			Type:     TypeSettlement,
+			ID:       "stl_" + k.currency + "_" + k.day,
+			Amount:   amount,
+			Currency: k.currency,
+			Date:     date,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// matchedIDs maps every expected movement already matched to its line.
+func matchedIDs(db *gorm.DB) (map[string]string, error) {
+	var lines []models.BankStatementLine
+	if err := db.Select("id", "match_id").Where("status = ?", StatusMatched).Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	taken := make(map[string]string, len(lines))
+	for _, l := range lines {
+		taken[l.MatchID] = l.ID
+	}
+	return taken, nil
+}
+
+// payoutReference is the part of a payout ID that survives every bank file
+// format: the NACHA individual ID keeps only its first 15 characters.
+func payoutReference(id string) string {
+	ref := normalize(id)
+	if len(ref) > 13 {
+		ref = ref[:13]
+	}
+	return ref
+}
+
+// normalize upper-cases s and drops everything but letters and digits, so
+// po_1a2b-... and PO-1A2B... compare equal.
+func normalize(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func withinWindow(expected, booked time.Time) bool {
+	return distance(expected, booked) <= MatchWindow
+}
+
+func distance(a, b time.Time) time.Duration {
+	a = a.UTC().Truncate(24 * time.Hour)
+	b = b.UTC().Truncate(24 * time.Hour)
+	if a.After(b) {
+		return a.Sub(b)
+	}
+	return b.Sub(a)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
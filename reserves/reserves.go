@@ -0,0 +1,211 @@
+// Package reserves holds back part of a merchant's funds in a reserved
+// balance, by rule as charges settle or by hand, and releases them again.
+package reserves
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	RuleRolling = "rolling"
+	RuleFixed   = "fixed"
+	TypeManual  = "manual"
+)
+
+const (
+	StatusHeld     = "held"
+	StatusReleased = "released"
+)
+
+var (
+	ErrReleased          = errors.New("reserves: reserve already released")
+	ErrInsufficientFunds = errors.New("reserves: available balance too low")
+)
+
+// Apply holds back funds from a charge, or a connected account's transfer,
+// whose funds just became available, according to the active rules of its
+// merchant. It is a balance.ReleaseHook.
+func Apply(tx *gorm.DB, bt models.BalanceTransaction) error {
+	if (bt.Type != balance.TypeCharge && bt.Type != balance.TypeTransfer) || bt.Net <= 0 {
+		return nil
+	}
+	var rules []models.ReserveRule
+	if err := tx.Where("merchant_id = ? AND active = ?", bt.MerchantID, true).Order("created_at").Find(&rules).Error; err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// A discounted charge is booked at list price; reserve what the merchant
+	// actually keeps.
+	var discount int64
+	err := tx.Model(&models.BalanceTransaction{}).Where("source_id = ? AND type = ?", bt.SourceID, balance.TypeDiscount).
+		Select("COALESCE(SUM(net), 0)").Scan(&discount).Error
+	if err != nil {
+		return err
+	}
+	net := bt.Net + discount
+
+	remaining := net
+	for _, rule := range rules {
+		r := &models.Reserve{
+			MerchantID: bt.MerchantID,
+			RuleID:     rule.ID,
+			Type:       rule.Type,
+			SourceID:   bt.SourceID,
+			Currency:   bt.Currency,
+		}
+		switch rule.Type {
+		case RuleRolling:
+			r.Amount = (net*rule.PercentBps + 5000) / 10000
+			releaseOn := bt.AvailableOn.AddDate(0, 0, rule.HoldDays)
+			r.ReleaseOn = &releaseOn
+			r.Reason = "Rolling reserve"
+		case RuleFixed:
+			if rule.Currency != bt.Currency {
+				continue
+			}
+			var held int64
+			err := tx.Model(&models.Reserve{}).Where("rule_id = ? AND status = ?", rule.ID, StatusHeld).
+				Select("COALESCE(SUM(amount), 0)").Scan(&held).Error
+			if err != nil {
+				return err
+			}
+			r.Amount = rule.Amount - held
+			r.Reason = "Fixed reserve"
+		}
+		if r.Amount > remaining {
+			r.Amount = remaining
+		}
+		if r.Amount <= 0 {
+			continue
+		}
+		if err := hold(tx, r); err != nil {
+			return err
+		}
+		remaining -= r.Amount
+	}
+	return nil
+}
+
+// Hold moves amount from the available balance into a manual reserve. A nil
+// releaseOn keeps it until it is released by hand.
+func Hold(tx *gorm.DB, merchantID, currency string, amount int64, reason string, releaseOn *time.Time) (*models.Reserve, error) {
+	account, err := balance.Account(tx, merchantID, ledger.AccountMerchantAvailable)
+	if err != nil {
+		return nil, err
+	}
+	available, err := ledger.Balance(tx, account, currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount > available {
+		return nil, ErrInsufficientFunds
+	}
+	r := &models.Reserve{
+		MerchantID: merchantID,
+		Type:       TypeManual,
+		Amount:     amount,
+		Currency:   currency,
+		Reason:     reason,
+		ReleaseOn:  releaseOn,
+	}
+	return r, hold(tx, r)
+}
+
+func hold(tx *gorm.DB, r *models.Reserve) error {
+	r.ID = "rsv_" + uuid.NewString()
+	r.Status = StatusHeld
+	if err := tx.Create(r).Error; err != nil {
+		return err
+	}
+	_, err := balance.RecordReserve(tx, r, false)
+	return err
+}
+
+// Release returns a held reserve to the available balance.
+func Release(tx *gorm.DB, r *models.Reserve, now time.Time) error {
+	res := tx.Model(r).Where("status = ?", StatusHeld).Updates(map[string]interface{}{
+		"status":      StatusReleased,
+		"released_at": now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrReleased
+	}
+	r.Status = StatusReleased
+	r.ReleasedAt = &now
+	_, err := balance.RecordReserve(tx, r, true)
+	return err
+}
+
+// ReleaseDue releases every reserve whose release date has come. It returns
+// the number of reserves released.
+func ReleaseDue(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.Reserve
+	err := db.Where("status = ? AND release_on IS NOT NULL AND release_on <= ?", StatusHeld, now).
+		Order("release_on").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return Release(tx, &due[i], now)
+		})
+		if errors.Is(err, ErrReleased) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// DisableRule stops a rule from holding further funds. The reserves of a
+// fixed rule are released with it; rolling reserves keep their schedule.
+func DisableRule(tx *gorm.DB, rule *models.ReserveRule, now time.Time) error {
+	if err := tx.Model(rule).Update("active", false).Error; err != nil {
+		return err
+	}
+	rule.Active = false
+	if rule.Type != RuleFixed {
+		return nil
+	}
+	var held []models.Reserve
+	if err := tx.Where("rule_id = ? AND status = ?", rule.ID, StatusHeld).Find(&held).Error; err != nil {
+		return err
+	}
+	for i := range held {
+		if err := Release(tx, &held[i], now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Settle makes pending funds due by now available, applying reserve rules as
+// it goes, and releases the reserves that are due. It returns the number of
+// balance transactions settled.
+func Settle(db *gorm.DB, now time.Time) (int, error) {
+	n, err := balance.Release(db, now, Apply)
+	if err != nil {
+		return n, err
+	}
+	_, err = ReleaseDue(db, now)
+	return n, err
+}
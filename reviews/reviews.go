@@ -0,0 +1,99 @@
+// Package reviews is the workflow of the charges risk rules hold for
+// review: an analyst approves a review, capturing its charge, or rejects
+// it, voiding the charge or refunding it if it was captured already. Reviews
+// nobody decides are closed with their default action once they expire.
+package reviews
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/risk"
+	"github.com/vaidikcode/minipay/utils"
+)
+
+// Approve closes the open review r as approved by actor with note, and
+// captures its charge if it is held. It returns risk.ErrReviewClosed if r
+// was closed already.
+func Approve(db *gorm.DB, r *models.Review, actor, note string) error {
+	return decide(db, r, risk.Decision{Status: risk.ReviewApproved, Actor: actor, Note: note})
+}
+
+// Reject closes the open review r as rejected by actor with note, and voids
+// its charge, or refunds it if it was captured and can still be refunded.
+// It returns risk.ErrReviewClosed if r was closed already.
+func Reject(db *gorm.DB, r *models.Review, actor, note string) error {
+	return decide(db, r, risk.Decision{Status: risk.ReviewRejected, Actor: actor, Note: note})
+}
+
+func decide(db *gorm.DB, r *models.Review, d risk.Decision) error {
+	refunded := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var txn models.Transaction
+		if err := tx.First(&txn, "id = ?", r.TransactionID).Error; err != nil {
+			return err
+		}
+		if err := risk.CloseReview(tx, r, d); err != nil {
+			return err
+		}
+		switch {
+		case txn.Status == payments.StatusRequiresCapture && d.Status == risk.ReviewApproved:
+			return payments.Capture(tx, &txn)
+		case txn.Status == payments.StatusRequiresCapture:
+			return payments.Void(tx, &txn)
+		case d.Status == risk.ReviewRejected && txn.Status == "succeeded" && !txn.Refunded && !txn.Disputed:
+			_, err := payments.Refund(tx, &txn, models.Metadata{})
+			refunded = err == nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if refunded {
+		utils.Metrics.IncRefunds()
+	}
+	return nil
+}
+
+// ExpireOverdue closes the open reviews that expired by now with their
+// default action, and returns how many it closed. A review that cannot be
+// closed is logged and left for the next run, so it does not hold up the
+// others.
+func ExpireOverdue(db *gorm.DB, now time.Time) (int, error) {
+	var overdue []models.Review
+	if err := db.Where("status = ? AND expires_at <= ?", risk.ReviewOpen, now).Order("expires_at").Find(&overdue).Error; err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for i := range overdue {
+		r := &overdue[i]
+		d := risk.Decision{
+			Status:  risk.ReviewRejected,
+			Actor:   risk.ActorSystem,
+			Note:    fmt.Sprintf("The review expired after %d hours.", int(r.ExpiresAt.Sub(r.CreatedAt).Round(time.Hour).Hours())),
+			Expired: true,
+		}
+		if r.DefaultAction == risk.DecisionApprove {
+			d.Status = risk.ReviewApproved
+		}
+		err := decide(db, r, d)
+		if errors.Is(err, risk.ErrReviewClosed) {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to expire review %s: %v", r.ID, err)
+			continue
+		}
+		closed++
+	}
+	return closed, nil
+}
@@ -0,0 +1,116 @@
+package risk
+
+import (
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+// Scopes are what a charge is blocklisted or counted by. Blocklist entries
+// have every scope but ScopeMerchant as their type.
+const (
+	ScopeCustomer        = "customer"
+	ScopeEmail           = "email"
+	ScopeCardFingerprint = "card_fingerprint"
+	ScopeIP              = "ip"
+	ScopeMerchant        = "merchant"
+)
+
+var BlocklistTypes = []string{ScopeCustomer, ScopeEmail, ScopeCardFingerprint, ScopeIP}
+
+// Subjects are the values of each scope a charge was made with, leaving out
+// those it has none of.
+type Subjects map[string]string
+
+// SubjectsOf returns the subjects of txn; email is its customer's.
+func SubjectsOf(txn models.Transaction, email string) Subjects {
+	s := Subjects{}
+	for scope, value := range map[string]string{
+		ScopeCustomer:        txn.Customer,
+		ScopeEmail:           strings.ToLower(email),
+		ScopeCardFingerprint: txn.CardFingerprint,
+		ScopeIP:              txn.IPAddress,
+		ScopeMerchant:        txn.MerchantID,
+	} {
+		if value != "" {
+			s[scope] = value
+		}
+	}
+	return s
+}
+
+// SubjectsFor returns the subjects of the saved charge txn.
+func SubjectsFor(db *gorm.DB, txn models.Transaction) (Subjects, error) {
+	var emails []string
+	if txn.Customer != "" {
+		if err := db.Model(&models.Customer{}).Where("id = ?", txn.Customer).Limit(1).Pluck("email", &emails).Error; err != nil {
+			return nil, err
+		}
+	}
+	email := ""
+	if len(emails) == 1 {
+		email = emails[0]
+	}
+	return SubjectsOf(txn, email), nil
+}
+
+// NormalizeBlocklistValue checks value for an entry of typ and returns it as
+// it is stored and matched: emails in lower case, and IP addresses and
+// ranges in their canonical form. It reports false if value is not valid.
+func NormalizeBlocklistValue(typ, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	switch typ {
+	case ScopeEmail:
+		return strings.ToLower(value), strings.Contains(value, "@")
+	case ScopeIP:
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String(), true
+		}
+		if _, ipnet, err := net.ParseCIDR(value); err == nil {
+			return ipnet.String(), true
+		}
+		return "", false
+	}
+	return value, value != ""
+}
+
+// Blocklisted returns the first active blocklist entry that matches s, or
+// nil if none does.
+func Blocklisted(db *gorm.DB, s Subjects) (*models.BlocklistEntry, error) {
+	var conds []string
+	var args []interface{}
+	for _, typ := range []string{ScopeCustomer, ScopeEmail, ScopeCardFingerprint} {
+		if v, ok := s[typ]; ok {
+			conds = append(conds, "(type = ? AND value = ?)")
+			args = append(args, typ, v)
+		}
+	}
+	var entries []models.BlocklistEntry
+	if len(conds) > 0 {
+		err := db.Where("active = ?", true).Where(strings.Join(conds, " OR "), args...).
+			Order("created_at, id").Limit(1).Find(&entries).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 1 {
+			return &entries[0], nil
+		}
+	}
+
+	ip := net.ParseIP(s[ScopeIP])
+	if ip == nil {
+		return nil, nil
+	}
+	if err := db.Where("active = ? AND type = ?", true, ScopeIP).Order("created_at, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if _, ipnet, err := net.ParseCIDR(e.Value); err == nil && ipnet.Contains(ip) || ip.Equal(net.ParseIP(e.Value)) {
+			return &entries[i], nil
+		}
+	}
+	return nil, nil
+}
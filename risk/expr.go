@@ -0,0 +1,459 @@
+package risk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of an attribute or expression.
+type Kind int
+
+const (
+	Number Kind = iota
+	String
+	Bool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	default:
+		return "boolean"
+	}
+}
+
+// SyntaxError is an expression that does not compile. Pos is the 1-based
+// offset of the offending token.
+type SyntaxError struct {
+	Pos     int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Message)
+}
+
+// Expr is a compiled rule expression.
+type Expr struct {
+	root node
+}
+
+// Compile parses src and checks it against the attributes a charge has. The
+// expression must be a condition: comparisons of attributes with literals or
+// each other, `in` lists, and, or, not and parentheses.
+func Compile(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &SyntaxError{Pos: t.pos, Message: "unexpected " + t.String()}
+	}
+	if root.kind() != Bool {
+		return nil, &SyntaxError{Pos: 1, Message: "expression is a " + root.kind().String() + ", not a condition"}
+	}
+	return &Expr{root: root}, nil
+}
+
+// Match reports whether the expression holds for attrs.
+func (e *Expr) Match(attrs Attributes) bool {
+	return e.root.eval(attrs).(bool)
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',':
+			kind := map[byte]tokKind{'(': tokLParen, ')': tokRParen, '[': tokLBracket, ']': tokRBracket, ',': tokComma}[c]
+			toks = append(toks, token{kind, string(c), start + 1})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			i++
+			if i < len(src) && src[i] == '=' {
+				i++
+			}
+			op := src[start:i]
+			if op == "=" || op == "!" {
+				return nil, &SyntaxError{Pos: start + 1, Message: "unknown operator " + strconv.Quote(op)}
+			}
+			toks = append(toks, token{tokOp, op, start + 1})
+		case c == '\'' || c == '"':
+			i++
+			for i < len(src) && src[i] != c {
+				i++
+			}
+			if i == len(src) {
+				return nil, &SyntaxError{Pos: start + 1, Message: "unterminated string"}
+			}
+			toks = append(toks, token{tokString, src[start+1 : i], start + 1})
+			i++
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			i++
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == '_') {
+				i++
+			}
+			toks = append(toks, token{tokNumber, src[start:i], start + 1})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			toks = append(toks, token{tokIdent, src[start:i], start + 1})
+		default:
+			return nil, &SyntaxError{Pos: start + 1, Message: "unexpected character " + strconv.Quote(string(c))}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src) + 1}), nil
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, word) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.keyword("or") {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if err := wantBool(pos, "or", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{and: false, left: left, right: right}
+	}
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.keyword("and") {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if err := wantBool(pos, "and", left, right); err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+}
+
+func (p *parser) not() (node, error) {
+	pos := p.peek().pos
+	if p.keyword("not") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if err := wantBool(pos, "not", operand); err != nil {
+			return nil, err
+		}
+		return &negation{operand}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokOp:
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != right.kind() {
+			return nil, &SyntaxError{Pos: t.pos, Message: "cannot compare a " + left.kind().String() + " with a " + right.kind().String()}
+		}
+		if left.kind() != Number && t.text != "==" && t.text != "!=" {
+			return nil, &SyntaxError{Pos: t.pos, Message: "operator " + t.text + " needs numbers"}
+		}
+		return &compare{op: t.text, left: left, right: right}, nil
+	case t.kind == tokIdent && (strings.EqualFold(t.text, "in") || strings.EqualFold(t.text, "not") && p.i+1 < len(p.toks) && strings.EqualFold(p.toks[p.i+1].text, "in")):
+		negate := p.keyword("not")
+		p.keyword("in")
+		list, err := p.list(left.kind())
+		if err != nil {
+			return nil, err
+		}
+		var n node = &membership{left: left, list: list}
+		if negate {
+			n = &negation{n}
+		}
+		return n, nil
+	}
+	return left, nil
+}
+
+func (p *parser) list(kind Kind) ([]interface{}, error) {
+	if t := p.next(); t.kind != tokLBracket {
+		return nil, &SyntaxError{Pos: t.pos, Message: "expected [ after in, got " + t.String()}
+	}
+	var list []interface{}
+	for {
+		t := p.peek()
+		if t.kind == tokRBracket && len(list) == 0 {
+			p.next()
+			return list, nil
+		}
+		item, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		lit, ok := item.(*literal)
+		if !ok {
+			return nil, &SyntaxError{Pos: t.pos, Message: "lists can only hold literals"}
+		}
+		if lit.k != kind {
+			return nil, &SyntaxError{Pos: t.pos, Message: "list of " + kind.String() + "s holds a " + lit.k.String()}
+		}
+		list = append(list, lit.v)
+		switch sep := p.next(); sep.kind {
+		case tokComma:
+		case tokRBracket:
+			return list, nil
+		default:
+			return nil, &SyntaxError{Pos: sep.pos, Message: "expected , or ], got " + sep.String()}
+		}
+	}
+}
+
+func (p *parser) operand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &SyntaxError{Pos: closing.pos, Message: "expected ), got " + closing.String()}
+		}
+		return inner, nil
+	case tokNumber:
+		v, err := strconv.ParseFloat(strings.ReplaceAll(t.text, "_", ""), 64)
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Message: "invalid number " + strconv.Quote(t.text)}
+		}
+		return &literal{k: Number, v: v}, nil
+	case tokString:
+		return &literal{k: String, v: t.text}, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literal{k: Bool, v: true}, nil
+		case "false":
+			return &literal{k: Bool, v: false}, nil
+		case "and", "or", "not", "in":
+			return nil, &SyntaxError{Pos: t.pos, Message: "unexpected " + t.String()}
+		}
+		kind, ok := Schema[t.text]
+		if !ok {
+			return nil, &SyntaxError{Pos: t.pos, Message: "unknown attribute " + strconv.Quote(t.text)}
+		}
+		return &attribute{name: t.text, k: kind}, nil
+	}
+	return nil, &SyntaxError{Pos: t.pos, Message: "unexpected " + t.String()}
+}
+
+func wantBool(pos int, op string, operands ...node) error {
+	for _, n := range operands {
+		if n.kind() != Bool {
+			return &SyntaxError{Pos: pos, Message: op + " needs conditions, not a " + n.kind().String()}
+		}
+	}
+	return nil
+}
+
+type node interface {
+	kind() Kind
+	eval(attrs Attributes) interface{}
+}
+
+type literal struct {
+	k Kind
+	v interface{}
+}
+
+func (n *literal) kind() Kind                  { return n.k }
+func (n *literal) eval(Attributes) interface{} { return n.v }
+
+type attribute struct {
+	name string
+	k    Kind
+}
+
+func (n *attribute) kind() Kind { return n.k }
+
+// eval reads the attribute, or its zero value when attrs lacks it.
+func (n *attribute) eval(attrs Attributes) interface{} {
+	if v, ok := attrs[n.name]; ok {
+		return v
+	}
+	switch n.k {
+	case Number:
+		return float64(0)
+	case String:
+		return ""
+	}
+	return false
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (n *compare) kind() Kind { return Bool }
+
+func (n *compare) eval(attrs Attributes) interface{} {
+	l, r := n.left.eval(attrs), n.right.eval(attrs)
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	a, b := l.(float64), r.(float64)
+	switch n.op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+type membership struct {
+	left node
+	list []interface{}
+}
+
+func (n *membership) kind() Kind { return Bool }
+
+func (n *membership) eval(attrs Attributes) interface{} {
+	v := n.left.eval(attrs)
+	for _, item := range n.list {
+		if equal(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares strings regardless of case, so that currencies and
+// countries match however they are written.
+func equal(a, b interface{}) bool {
+	if s, ok := a.(string); ok {
+		return strings.EqualFold(s, b.(string))
+	}
+	return a == b
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (n *logical) kind() Kind { return Bool }
+
+func (n *logical) eval(attrs Attributes) interface{} {
+	if n.and {
+		return n.left.eval(attrs).(bool) && n.right.eval(attrs).(bool)
+	}
+	return n.left.eval(attrs).(bool) || n.right.eval(attrs).(bool)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n *negation) kind() Kind { return Bool }
+
+func (n *negation) eval(attrs Attributes) interface{} {
+	return !n.operand.eval(attrs).(bool)
+}
@@ -0,0 +1,160 @@
+package risk
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	ReviewOpen     = "open"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	// ReviewRefunded closes a review whose charge was refunded while it
+	// was open.
+	ReviewRefunded = "refunded"
+)
+
+// Decisions a review can be closed with when it expires.
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// Actions of a review's audit trail, besides the statuses it is closed with.
+const (
+	ReviewActionOpened  = "opened"
+	ReviewActionExpired = "expired"
+)
+
+// ActorSystem is the actor of what MiniPay does to a review by itself.
+const ActorSystem = "system"
+
+var ErrReviewClosed = errors.New("risk: review is closed")
+
+// ReviewPolicy is how long reviews stay open, and what is decided for those
+// nobody decides in time.
+type ReviewPolicy struct {
+	Expiry        time.Duration
+	DefaultAction string
+}
+
+var DefaultReviewPolicy = ReviewPolicy{Expiry: 72 * time.Hour, DefaultAction: DecisionReject}
+
+// LoadReviewPolicy reads the review policy from REVIEW_EXPIRY_HOURS and
+// REVIEW_DEFAULT_ACTION ("approve" or "reject"), falling back to
+// DefaultReviewPolicy for either.
+func LoadReviewPolicy() ReviewPolicy {
+	p := DefaultReviewPolicy
+	if h, err := strconv.Atoi(os.Getenv("REVIEW_EXPIRY_HOURS")); err == nil && h > 0 {
+		p.Expiry = time.Duration(h) * time.Hour
+	}
+	switch a := os.Getenv("REVIEW_DEFAULT_ACTION"); a {
+	case DecisionApprove, DecisionReject:
+		p.DefaultAction = a
+	}
+	return p
+}
+
+// Decision closes a review with Status. Expired decisions are the review's
+// DefaultAction, taken by ActorSystem.
+type Decision struct {
+	Status  string
+	Actor   string
+	Note    string
+	Expired bool
+}
+
+func ReviewPayload(r models.Review) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":             r.ID,
+		"charge":         r.TransactionID,
+		"status":         r.Status,
+		"default_action": r.DefaultAction,
+		"expires_at":     r.ExpiresAt.Format(time.RFC3339),
+	}
+	if r.ClosedAt != nil {
+		payload["closed_at"] = r.ClosedAt.Format(time.RFC3339)
+		payload["closed_by"] = r.ClosedBy
+		payload["note"] = r.Note
+	}
+	return payload
+}
+
+// OpenReview holds the charge txn for review under policy. signals are the
+// attributes it was screened with.
+func OpenReview(tx *gorm.DB, txn *models.Transaction, signals Attributes, policy ReviewPolicy) (*models.Review, error) {
+	r := &models.Review{
+		ID:            "prv_" + uuid.NewString(),
+		TransactionID: txn.ID,
+		Status:        ReviewOpen,
+		Signals:       models.Signals(signals),
+		DefaultAction: policy.DefaultAction,
+		ExpiresAt:     time.Now().Add(policy.Expiry),
+	}
+	if err := tx.Create(r).Error; err != nil {
+		return nil, err
+	}
+	if err := logReview(tx, r, ReviewActionOpened, ActorSystem, ""); err != nil {
+		return nil, err
+	}
+	if err := events.Enqueue(tx, r.ID, "review.opened", ReviewPayload(*r)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CloseReview closes the open review r with d, recording it in the audit
+// trail. It returns ErrReviewClosed if r was closed already.
+func CloseReview(tx *gorm.DB, r *models.Review, d Decision) error {
+	now := time.Now()
+	res := tx.Model(&models.Review{}).Where("id = ? AND status = ?", r.ID, ReviewOpen).
+		Updates(map[string]interface{}{"status": d.Status, "closed_by": d.Actor, "note": d.Note, "closed_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrReviewClosed
+	}
+	r.Status = d.Status
+	r.ClosedBy = d.Actor
+	r.Note = d.Note
+	r.ClosedAt = &now
+
+	action := d.Status
+	if d.Expired {
+		action = ReviewActionExpired
+	}
+	if err := logReview(tx, r, action, d.Actor, d.Note); err != nil {
+		return err
+	}
+	return events.Enqueue(tx, r.ID, "review.closed", ReviewPayload(*r))
+}
+
+// CloseOpenReview closes the review of the charge txn with d, if it has one
+// that is open.
+func CloseOpenReview(tx *gorm.DB, txn *models.Transaction, d Decision) error {
+	var reviews []models.Review
+	err := tx.Where("transaction_id = ? AND status = ?", txn.ID, ReviewOpen).Limit(1).Find(&reviews).Error
+	if err != nil || len(reviews) == 0 {
+		return err
+	}
+	return CloseReview(tx, &reviews[0], d)
+}
+
+func logReview(tx *gorm.DB, r *models.Review, action, actor, note string) error {
+	return tx.Create(&models.ReviewEvent{
+		ID:       "rve_" + uuid.NewString(),
+		ReviewID: r.ID,
+		Action:   action,
+		Actor:    actor,
+		Note:     note,
+	}).Error
+}
@@ -0,0 +1,271 @@
+// Package risk screens charges before they reach the processor. A charge
+// made by a blocklisted customer, email, card or IP address is refused
+// outright, and so is one that would exceed a velocity limit. Every other
+// charge is given a score from 0 to 100 by a few built-in signals, then the
+// active rules are evaluated against its attributes: a rule that matches
+// blocks the charge, holds it for review or allows it whatever other rules
+// say.
+package risk
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+)
+
+// Rule actions, from the weakest to the strongest. A charge with no matching
+// rule has the outcome OutcomeNormal.
+const (
+	ActionReview = "review"
+	ActionBlock  = "block"
+	ActionAllow  = "allow"
+
+	OutcomeNormal = "normal"
+)
+
+var Actions = []string{ActionBlock, ActionReview, ActionAllow}
+
+// Decline codes of blocked charges.
+const (
+	DeclineRule      = "fraudulent"
+	DeclineBlocklist = "merchant_blacklist"
+	DeclineVelocity  = "card_velocity_exceeded"
+)
+
+var declineMessages = map[string]string{
+	DeclineRule:      "The payment was blocked by a risk rule.",
+	DeclineBlocklist: "The payment was blocked by a blocklist entry.",
+	DeclineVelocity:  "The payment exceeds a velocity limit.",
+}
+
+// ValidAction reports whether action is one of Actions.
+func ValidAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Attributes are what rules can test of a charge, by name: numbers are
+// float64, strings string and conditions bool.
+type Attributes map[string]interface{}
+
+// Schema lists the attributes of a charge and their kinds. Amounts are in
+// minor units of the charge currency.
+var Schema = map[string]Kind{
+	"amount":                Number,
+	"currency":              String,
+	"country":               String,
+	"merchant":              String,
+	"risk_score":            Number,
+	"card.brand":            String,
+	"card.last4":            String,
+	"card.fingerprint":      String,
+	"ip":                    String,
+	"customer.id":           String,
+	"customer.email":        String,
+	"customer.country":      String,
+	"customer.known":        Bool,
+	"customer.age_days":     Number,
+	"customer.charges":      Number,
+	"customer.disputes":     Number,
+	"customer.declines_24h": Number,
+}
+
+// Assessment is what screening a charge found.
+type Assessment struct {
+	Score   int
+	Outcome string
+	// Rules are the IDs of the rules that matched, in the order they were
+	// created, or of the blocklist entry or velocity rule that refused the
+	// charge.
+	Rules []string
+	// DeclineCode says why a blocked charge was refused.
+	DeclineCode string
+	// Subjects are what the charge is counted by in CheckVelocity.
+	Subjects Subjects
+	// Attributes are what the rules were evaluated against, and what a
+	// review shows as the charge's signals.
+	Attributes Attributes
+}
+
+// Block refuses the charge with declineCode because of the blocklist entry
+// or velocity rule id.
+func (a *Assessment) Block(declineCode, id string) {
+	a.Outcome = ActionBlock
+	a.DeclineCode = declineCode
+	a.Rules = []string{id}
+}
+
+// Declined is the failure of a charge blocked with declineCode.
+func Declined(declineCode string) *processor.Error {
+	return &processor.Error{
+		Type:        processor.ErrorTypeCard,
+		Code:        processor.CodeCardDeclined,
+		DeclineCode: declineCode,
+		Message:     declineMessages[declineCode],
+	}
+}
+
+// Apply records a on txn.
+func (a Assessment) Apply(txn *models.Transaction) {
+	txn.RiskScore = a.Score
+	txn.RiskOutcome = a.Outcome
+	txn.RiskRules = models.StringList(a.Rules)
+}
+
+// Assess scores txn, which need not be saved yet, checks it against the
+// blocklist and evaluates the active rules against it. Velocity limits are
+// checked by CheckVelocity when the charge is recorded.
+func Assess(db *gorm.DB, txn models.Transaction) (*Assessment, error) {
+	attrs, err := attributes(db, txn)
+	if err != nil {
+		return nil, err
+	}
+	a := &Assessment{Score: Score(attrs), Outcome: OutcomeNormal, Rules: []string{}, Attributes: attrs}
+	attrs["risk_score"] = float64(a.Score)
+
+	a.Subjects = SubjectsOf(txn, attrs["customer.email"].(string))
+	entry, err := Blocklisted(db, a.Subjects)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		a.Block(DeclineBlocklist, entry.ID)
+		return a, nil
+	}
+
+	var rules []models.RiskRule
+	if err := db.Where("active = ?", true).Order("created_at, id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		expr, err := Compile(rule.Expression)
+		if err != nil {
+			log.Printf("skipping risk rule %s: %v", rule.ID, err)
+			continue
+		}
+		if !expr.Match(attrs) {
+			continue
+		}
+		a.Rules = append(a.Rules, rule.ID)
+		if rank(rule.Action) > rank(a.Outcome) {
+			a.Outcome = rule.Action
+		}
+	}
+	if a.Outcome == ActionBlock {
+		a.DeclineCode = DeclineRule
+	}
+	return a, nil
+}
+
+// rank orders outcomes so that an allow rule overrides block rules, which
+// override review rules.
+func rank(outcome string) int {
+	switch outcome {
+	case ActionReview:
+		return 1
+	case ActionBlock:
+		return 2
+	case ActionAllow:
+		return 3
+	}
+	return 0
+}
+
+// Score is the built-in risk score of a charge with attrs, from 0 to 100.
+func Score(attrs Attributes) int {
+	score := 0
+	if !attrs["customer.known"].(bool) {
+		score += 15
+	} else if attrs["customer.age_days"].(float64) < 1 {
+		score += 20
+	}
+	switch amount := attrs["amount"].(float64); {
+	case amount >= 500000:
+		score += 35
+	case amount >= 100000:
+		score += 20
+	}
+	if c := attrs["customer.country"].(string); c != "" && !strings.EqualFold(c, attrs["country"].(string)) {
+		score += 15
+	}
+	if attrs["customer.disputes"].(float64) > 0 {
+		score += 30
+	}
+	if attrs["customer.declines_24h"].(float64) >= 3 {
+		score += 20
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score
+}
+
+func attributes(db *gorm.DB, txn models.Transaction) (Attributes, error) {
+	attrs := Attributes{
+		"amount":                float64(txn.Amount),
+		"currency":              txn.Currency,
+		"country":               txn.Country,
+		"merchant":              txn.MerchantID,
+		"card.brand":            txn.CardBrand,
+		"card.last4":            txn.CardLast4,
+		"card.fingerprint":      txn.CardFingerprint,
+		"ip":                    txn.IPAddress,
+		"customer.id":           txn.Customer,
+		"customer.email":        "",
+		"customer.country":      "",
+		"customer.known":        false,
+		"customer.age_days":     float64(0),
+		"customer.charges":      float64(0),
+		"customer.disputes":     float64(0),
+		"customer.declines_24h": float64(0),
+	}
+	if txn.Customer == "" {
+		return attrs, nil
+	}
+
+	var customers []models.Customer
+	if err := db.Where("id = ?", txn.Customer).Limit(1).Find(&customers).Error; err != nil {
+		return nil, err
+	}
+	if len(customers) == 1 {
+		c := customers[0]
+		attrs["customer.known"] = true
+		attrs["customer.email"] = c.Email
+		attrs["customer.country"] = c.Country
+		attrs["customer.age_days"] = float64(int(time.Since(c.CreatedAt).Hours() / 24))
+	}
+
+	var charges, declines, disputes int64
+	err := db.Model(&models.Transaction{}).
+		Where("customer = ? AND status IN ?", txn.Customer, []string{"succeeded", "refunded"}).
+		Count(&charges).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&models.Transaction{}).
+		Where("customer = ? AND status = ? AND created_at >= ?", txn.Customer, "failed", time.Now().Add(-24*time.Hour)).
+		Count(&declines).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&models.Dispute{}).
+		Joins("JOIN transactions ON transactions.id = disputes.transaction_id").
+		Where("transactions.customer = ?", txn.Customer).
+		Count(&disputes).Error
+	if err != nil {
+		return nil, err
+	}
+	attrs["customer.charges"] = float64(charges)
+	attrs["customer.declines_24h"] = float64(declines)
+	attrs["customer.disputes"] = float64(disputes)
+	return attrs, nil
+}
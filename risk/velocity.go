@@ -0,0 +1,139 @@
+package risk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	EventCharge = "charge"
+	EventRefund = "refund"
+
+	MetricCount  = "count"
+	MetricAmount = "amount"
+)
+
+var VelocityScopes = []string{ScopeCustomer, ScopeEmail, ScopeCardFingerprint, ScopeIP, ScopeMerchant}
+
+// MaxWindow is the longest window a velocity rule may have. Events older
+// than it are pruned.
+const MaxWindow = 30 * 24 * time.Hour
+
+// VelocityError is a charge or refund that would take a scope value past the
+// limit of Rule.
+type VelocityError struct {
+	Rule models.VelocityRule
+}
+
+func (e *VelocityError) Error() string {
+	return fmt.Sprintf("risk: velocity rule %s exceeded", e.Rule.ID)
+}
+
+// CheckVelocity counts a charge or refund, event, of amount made with the
+// subjects s against the active velocity rules, and records it under
+// sourceID unless it would exceed one, in which case it returns a
+// *VelocityError. Only the scopes some active rule of event uses are
+// recorded. It must run inside a transaction: the counters of the scope
+// values it checks stay locked until the transaction ends, so that charges
+// on other instances sharing the database see each other's events.
+func CheckVelocity(tx *gorm.DB, event string, s Subjects, amount int64, currency, sourceID string) error {
+	var rules []models.VelocityRule
+	if err := tx.Where("active = ? AND event = ?", true, event).Order("created_at, id").Find(&rules).Error; err != nil {
+		return err
+	}
+	var scopes []string
+	for _, rule := range rules {
+		if _, ok := s[rule.Scope]; ok && !contains(scopes, rule.Scope) {
+			scopes = append(scopes, rule.Scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	// Lock in the same order everywhere so that two checks cannot wait on
+	// each other.
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		if err := lockCounter(tx, scope+":"+s[scope]); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		value, ok := s[rule.Scope]
+		if !ok || rule.Metric == MetricAmount && !strings.EqualFold(rule.Currency, currency) {
+			continue
+		}
+		since := now.Add(-time.Duration(rule.WindowSeconds) * time.Second)
+		query := tx.Model(&models.VelocityEvent{}).
+			Where("scope = ? AND value = ? AND event = ? AND created_at > ?", rule.Scope, value, event, since)
+		var used int64
+		if rule.Metric == MetricAmount {
+			if err := query.Where("currency = ?", strings.ToLower(currency)).Select("COALESCE(SUM(amount), 0)").Scan(&used).Error; err != nil {
+				return err
+			}
+			used += amount
+		} else {
+			if err := query.Count(&used).Error; err != nil {
+				return err
+			}
+			used++
+		}
+		if used > rule.Limit {
+			return &VelocityError{Rule: rule}
+		}
+	}
+
+	for _, scope := range scopes {
+		e := models.VelocityEvent{
+			ID:        "ve_" + uuid.NewString(),
+			Event:     event,
+			Scope:     scope,
+			Value:     s[scope],
+			SourceID:  sourceID,
+			Amount:    amount,
+			Currency:  strings.ToLower(currency),
+			CreatedAt: now,
+		}
+		if err := tx.Create(&e).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockCounter creates the counter id if need be and bumps it, which holds
+// its row lock until the transaction ends.
+func lockCounter(tx *gorm.DB, id string) error {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.VelocityCounter{ID: id}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.VelocityCounter{}).Where("id = ?", id).
+		Update("hits", gorm.Expr("hits + 1")).Error
+}
+
+// PruneVelocity deletes the events older than MaxWindow, which no rule can
+// count any more.
+func PruneVelocity(db *gorm.DB, now time.Time) (int64, error) {
+	res := db.Where("created_at < ?", now.Add(-MaxWindow)).Delete(&models.VelocityEvent{})
+	return res.RowsAffected, res.Error
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
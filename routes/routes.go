@@ -1,22 +1,173 @@
 package routes
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
 	"github.com/vaidikcode/minipay/controllers"
+	"github.com/vaidikcode/minipay/middleware"
 	"github.com/vaidikcode/minipay/utils"
 )
 
 func Register(r *gin.Engine) {
+	if err := r.SetTrustedProxies(TrustedProxies()); err != nil {
+		log.Printf("ignoring TRUSTED_PROXIES: %v", err)
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(middleware.RequestID())
+
+	r.NoRoute(func(c *gin.Context) {
+		apierror.Respond(c, apierror.New(apierror.CodeURLInvalid, "Unrecognized request URL ("+c.Request.Method+": "+c.Request.URL.Path+")."))
+	})
+
 	api := r.Group("/api/v1")
 	{
 		api.POST("/charges", controllers.Charge)
+		api.GET("/charges", controllers.ListCharges)
+		api.GET("/charges/search", controllers.SearchCharges)
+		api.GET("/charges/:id", controllers.GetCharge)
 		api.POST("/refunds", controllers.Refund)
+		api.GET("/refunds", controllers.ListRefunds)
+		api.GET("/refunds/:id", controllers.GetRefund)
 		api.GET("/balance", controllers.Balance)
+		api.GET("/balance_transactions", controllers.ListBalanceTransactions)
+		api.GET("/balance_transactions/:id", controllers.GetBalanceTransaction)
+		api.POST("/balance_adjustments", controllers.CreateBalanceAdjustment)
+		api.POST("/reserve_rules", controllers.CreateReserveRule)
+		api.GET("/reserve_rules", controllers.ListReserveRules)
+		api.GET("/reserve_rules/:id", controllers.GetReserveRule)
+		api.POST("/reserve_rules/:id/disable", controllers.DisableReserveRule)
+		api.POST("/risk_rules", controllers.CreateRiskRule)
+		api.GET("/risk_rules", controllers.ListRiskRules)
+		api.GET("/risk_rules/:id", controllers.GetRiskRule)
+		api.POST("/risk_rules/:id", controllers.UpdateRiskRule)
+		api.GET("/reviews", controllers.ListReviews)
+		api.GET("/reviews/:id", controllers.GetReview)
+		api.POST("/reviews/:id/approve", controllers.ApproveReview)
+		api.POST("/reviews/:id/reject", controllers.RejectReview)
+		api.POST("/blocklist_entries", controllers.CreateBlocklistEntry)
+		api.GET("/blocklist_entries", controllers.ListBlocklistEntries)
+		api.GET("/blocklist_entries/:id", controllers.GetBlocklistEntry)
+		api.POST("/blocklist_entries/:id/disable", controllers.DisableBlocklistEntry)
+		api.POST("/velocity_rules", controllers.CreateVelocityRule)
+		api.GET("/velocity_rules", controllers.ListVelocityRules)
+		api.GET("/velocity_rules/:id", controllers.GetVelocityRule)
+		api.POST("/velocity_rules/:id/disable", controllers.DisableVelocityRule)
+		api.POST("/reserves", controllers.CreateReserve)
+		api.GET("/reserves", controllers.ListReserves)
+		api.GET("/reserves/:id", controllers.GetReserve)
+		api.POST("/reserves/:id/release", controllers.ReleaseReserve)
+
+		api.POST("/customers", controllers.CreateCustomer)
+		api.GET("/customers", controllers.ListCustomers)
+		api.GET("/customers/:id", controllers.GetCustomer)
+		api.POST("/customers/:id", controllers.UpdateCustomer)
+
+		api.POST("/products", controllers.CreateProduct)
+		api.GET("/products", controllers.ListProducts)
+		api.GET("/products/:id", controllers.GetProduct)
+		api.POST("/products/:id", controllers.UpdateProduct)
+		api.POST("/prices", controllers.CreatePrice)
+		api.GET("/prices", controllers.ListPrices)
+		api.GET("/prices/:id", controllers.GetPrice)
+		api.POST("/subscriptions", controllers.CreateSubscription)
+		api.GET("/subscriptions", controllers.ListSubscriptions)
+		api.GET("/subscriptions/:id", controllers.GetSubscription)
+		api.POST("/subscriptions/:id", controllers.UpdateSubscription)
+		api.POST("/subscriptions/:id/cancel", controllers.CancelSubscription)
+		api.GET("/dunning_settings", controllers.GetDunningSettings)
+		api.POST("/dunning_settings", controllers.UpdateDunningSettings)
+		api.POST("/invoices", controllers.CreateInvoice)
+		api.GET("/invoices", controllers.ListInvoices)
+		api.GET("/invoices/:id", controllers.GetInvoice)
+		api.POST("/invoices/:id/lines", controllers.AddInvoiceLine)
+		api.POST("/invoices/:id/finalize", controllers.FinalizeInvoice)
+		api.POST("/invoices/:id/pay", controllers.PayInvoice)
+		api.POST("/invoices/:id/void", controllers.VoidInvoice)
+		api.GET("/invoices/:id/pdf", controllers.DownloadInvoicePDF)
+		api.POST("/coupons", controllers.CreateCoupon)
+		api.GET("/coupons", controllers.ListCoupons)
+		api.GET("/coupons/:id", controllers.GetCoupon)
+		api.POST("/coupons/:id/disable", controllers.DisableCoupon)
+		api.POST("/promotion_codes", controllers.CreatePromotionCode)
+		api.GET("/promotion_codes", controllers.ListPromotionCodes)
+		api.GET("/promotion_codes/:id", controllers.GetPromotionCode)
+		api.POST("/promotion_codes/:id/disable", controllers.DisablePromotionCode)
+		api.POST("/tax_rates", controllers.CreateTaxRate)
+		api.GET("/tax_rates", controllers.ListTaxRates)
+		api.GET("/tax_rates/:id", controllers.GetTaxRate)
+		api.POST("/tax_rates/:id/disable", controllers.DisableTaxRate)
+		api.POST("/tax_calculations", controllers.CreateTaxCalculation)
+		api.GET("/tax_lines", controllers.ListTaxLines)
+		api.GET("/tax_report", controllers.GetTaxReport)
+		api.GET("/tax_report/csv", controllers.ExportTaxReport)
+		api.POST("/fx_rates", controllers.CreateFXRate)
+		api.GET("/fx_rates", controllers.ListFXRates)
+		api.POST("/fx_rates/import", controllers.ImportFXRates)
+		api.GET("/fx_quote", controllers.GetFXQuote)
+		api.POST("/wallets", controllers.CreateWallet)
+		api.GET("/wallets", controllers.ListWallets)
+		api.GET("/wallets/:id", controllers.GetWallet)
+		api.GET("/wallets/:id/transactions", controllers.ListWalletTransactions)
+		api.POST("/wallets/:id/top_up", controllers.TopUpWallet)
+		api.POST("/wallets/:id/pay", controllers.PayFromWallet)
+		api.POST("/wallets/:id/withdraw", controllers.WithdrawFromWallet)
+		api.GET("/transfers", controllers.ListTransfers)
+		api.GET("/transfers/:id", controllers.GetTransfer)
+		api.POST("/wallet_transfers", controllers.CreateWalletTransfer)
+		api.GET("/wallet_transfers/:id", controllers.GetWalletTransfer)
+
+		api.GET("/disputes", controllers.ListDisputes)
+		api.GET("/disputes/:id", controllers.GetDispute)
+		api.POST("/disputes/:id/evidence", controllers.SubmitDisputeEvidence)
+		api.POST("/disputes/:id/close", controllers.CloseDispute)
+
+		api.GET("/files/:id", controllers.GetFile)
+		api.GET("/files/:id/contents", controllers.DownloadFile)
+
+		api.POST("/merchants", controllers.CreateMerchant)
+		api.GET("/merchants", controllers.ListMerchants)
+		api.GET("/merchants/:id", controllers.GetMerchant)
+		api.POST("/merchants/:id", controllers.UpdateMerchant)
+		api.GET("/merchants/:id/balance", controllers.GetMerchantBalance)
+		api.POST("/pricing_plans", controllers.CreatePricingPlan)
+		api.GET("/pricing_plans", controllers.ListPricingPlans)
+		api.GET("/pricing_plans/:id", controllers.GetPricingPlan)
+
+		api.POST("/bank_accounts", controllers.CreateBankAccount)
+		api.GET("/bank_accounts", controllers.ListBankAccounts)
+		api.GET("/bank_accounts/:id", controllers.GetBankAccount)
+
+		api.POST("/payouts", controllers.CreatePayout)
+		api.GET("/payouts", controllers.ListPayouts)
+		api.GET("/payouts/:id", controllers.GetPayout)
+		api.POST("/payouts/:id/cancel", controllers.CancelPayout)
+		api.GET("/payout_schedule", controllers.GetPayoutSchedule)
+		api.POST("/payout_schedule", controllers.UpdatePayoutSchedule)
+
+		api.POST("/bank_statements", controllers.ImportBankStatement)
+		api.GET("/bank_statements", controllers.ListBankStatements)
+		api.GET("/bank_statements/:id", controllers.GetBankStatement)
+		api.GET("/bank_statements/:id/reconciliation", controllers.GetReconciliationReport)
+		api.POST("/bank_statements/:id/reconcile", controllers.ReconcileBankStatement)
+
+		api.GET("/events/search", controllers.SearchEvents)
+
+		if TestHelpersEnabled() {
+			api.POST("/test_helpers/charges/:id/dispute", controllers.CreateTestDispute)
+			api.POST("/test_helpers/disputes/:id/close", controllers.CloseTestDispute)
+			api.POST("/test_helpers/payouts/:id/paid", controllers.PayTestPayout)
+			api.POST("/test_helpers/payouts/:id/fail", controllers.FailTestPayout)
+		}
 	}
 
 	r.GET("/metrics", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"total_charges":      utils.Metrics.TotalCharges(),
+			"failed_charges":     utils.Metrics.FailedCharges(),
 			"total_refunds":      utils.Metrics.TotalRefunds(),
 			"pending_webhooks":   utils.Metrics.PendingWebhooks(),
 			"delivered_webhooks": utils.Metrics.DeliveredHooks(),
@@ -25,7 +176,32 @@ func Register(r *gin.Engine) {
 		})
 	})
 
+	// The simulated 3-D Secure page the next_action of a charge points to.
+	r.GET("/3ds/:id", controllers.ChallengePage)
+	r.POST("/3ds/:id", controllers.CompleteChallenge)
+
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 }
+
+// TrustedProxies reads TRUSTED_PROXIES, a comma separated list of the IPs
+// and CIDR ranges of the proxies in front of MiniPay. Client IPs feed the IP
+// blocklist and velocity limits, so X-Forwarded-For is only believed from
+// these; with none set, the client IP is always the remote address.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// TestHelpersEnabled reports whether MINIPAY_TEST_HELPERS is 1. Test helpers
+// open, rule on and settle objects by hand, so they are only served in test
+// mode.
+func TestHelpersEnabled() bool {
+	return os.Getenv("MINIPAY_TEST_HELPERS") == "1"
+}
@@ -0,0 +1,172 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FieldType int
+
+const (
+	String FieldType = iota
+	Number
+	Timestamp
+)
+
+type Field struct {
+	Column string
+	Type   FieldType
+}
+
+// Schema lists the fields a resource can be searched on. Only columns named
+// here ever reach SQL; every value is bound as a parameter. MetadataObject,
+// when set, enables metadata["key"] clauses against metadata_entries.
+type Schema struct {
+	Fields         map[string]Field
+	MetadataObject string
+}
+
+// Query is a compiled WHERE clause, ready for gorm's Where(SQL, Args...).
+type Query struct {
+	SQL  string
+	Args []interface{}
+}
+
+func Compile(input string, schema Schema) (*Query, error) {
+	n, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	sql, err := q.compile(n, schema)
+	if err != nil {
+		return nil, err
+	}
+	q.SQL = sql
+	return q, nil
+}
+
+func (q *Query) compile(n Node, schema Schema) (string, error) {
+	switch n := n.(type) {
+	case And:
+		return q.binary(n.Left, n.Right, "AND", schema)
+	case Or:
+		return q.binary(n.Left, n.Right, "OR", schema)
+	case Not:
+		inner, err := q.compile(n.Expr, schema)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	case Clause:
+		return q.clause(n, schema)
+	}
+	return "", fmt.Errorf("search: unknown node %T", n)
+}
+
+func (q *Query) binary(left, right Node, op string, schema Schema) (string, error) {
+	l, err := q.compile(left, schema)
+	if err != nil {
+		return "", err
+	}
+	r, err := q.compile(right, schema)
+	if err != nil {
+		return "", err
+	}
+	return "(" + l + " " + op + " " + r + ")", nil
+}
+
+func (q *Query) clause(c Clause, schema Schema) (string, error) {
+	if c.Field == "metadata" && schema.MetadataObject != "" {
+		return q.metadataClause(c, schema)
+	}
+
+	field, ok := schema.Fields[c.Field]
+	if !ok {
+		return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("unknown field %q; searchable fields are %s", c.Field, schema.fieldList())}
+	}
+	if c.HasKey {
+		return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("field %q does not take a [key]", c.Field)}
+	}
+
+	switch field.Type {
+	case String:
+		if c.IsNumber {
+			return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("%s is a text field; quote the value: %s%s%q", c.Field, c.Field, c.Operator, c.Value)}
+		}
+		switch c.Operator {
+		case OpEqual:
+			q.Args = append(q.Args, c.Value)
+			return field.Column + " = ?", nil
+		case OpContains:
+			q.Args = append(q.Args, "%"+escapeLike(c.Value)+"%")
+			return field.Column + ` LIKE ? ESCAPE '\'`, nil
+		default:
+			return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("operator %q is not supported on text field %s; use \":\" or \"~\"", c.Operator, c.Field)}
+		}
+	case Number, Timestamp:
+		if !c.IsNumber {
+			return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("%s expects an unquoted number, found %q", c.Field, c.Value)}
+		}
+		if c.Operator == OpContains {
+			return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf(`operator "~" is not supported on numeric field %s`, c.Field)}
+		}
+		n, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("%s value %s is out of range", c.Field, c.Value)}
+		}
+		var arg interface{} = n
+		if field.Type == Timestamp {
+			arg = time.Unix(n, 0)
+		}
+		q.Args = append(q.Args, arg)
+		op := string(c.Operator)
+		if c.Operator == OpEqual {
+			op = "="
+		}
+		return field.Column + " " + op + " ?", nil
+	}
+	return "", fmt.Errorf("search: unknown field type %d", field.Type)
+}
+
+func (q *Query) metadataClause(c Clause, schema Schema) (string, error) {
+	if !c.HasKey {
+		return "", &SyntaxError{Pos: c.Pos, Message: `metadata needs a key, for example metadata["order_id"]:"42"`}
+	}
+	if c.IsNumber {
+		return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("metadata values are text; quote the value: metadata[%q]%s%q", c.Key, c.Operator, c.Value)}
+	}
+
+	const subquery = "id IN (SELECT object_id FROM metadata_entries WHERE object_type = ? AND meta_key = ? AND "
+	switch c.Operator {
+	case OpEqual:
+		q.Args = append(q.Args, schema.MetadataObject, c.Key, c.Value)
+		return subquery + "meta_value = ?)", nil
+	case OpContains:
+		q.Args = append(q.Args, schema.MetadataObject, c.Key, "%"+escapeLike(c.Value)+"%")
+		return subquery + `meta_value LIKE ? ESCAPE '\')`, nil
+	default:
+		return "", &SyntaxError{Pos: c.Pos, Message: fmt.Sprintf("operator %q is not supported on metadata; use \":\" or \"~\"", c.Operator)}
+	}
+}
+
+func (s Schema) fieldList() string {
+	names := make([]string, 0, len(s.Fields)+1)
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	if s.MetadataObject != "" {
+		names = append(names, "metadata[...]")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
@@ -0,0 +1,205 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokColon
+	tokTilde
+	tokGT
+	tokGTE
+	tokLT
+	tokLTE
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of query"
+	case tokIdent:
+		return "field name"
+	case tokString:
+		return "quoted string"
+	case tokNumber:
+		return "number"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return `"-"`
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokLBracket:
+		return `"["`
+	case tokRBracket:
+		return `"]"`
+	case tokColon:
+		return `":"`
+	case tokTilde:
+		return `"~"`
+	case tokGT:
+		return `">"`
+	case tokGTE:
+		return `">="`
+	case tokLT:
+		return `"<"`
+	case tokLTE:
+		return `"<="`
+	}
+	return "token"
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) describe() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// SyntaxError reports a problem with the query text. Pos is the 1-based
+// character position the problem was found at.
+type SyntaxError struct {
+	Pos     int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Message)
+}
+
+func errorf(pos int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Pos: pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case c == ':':
+			tokens = append(tokens, token{tokColon, ":", i})
+			i++
+		case c == '~':
+			tokens = append(tokens, token{tokTilde, "~", i})
+			i++
+		case c == '-':
+			tokens = append(tokens, token{tokNot, "-", i})
+			i++
+		case c == '>' || c == '<':
+			kind, text := tokGT, ">"
+			if c == '<' {
+				kind, text = tokLT, "<"
+			}
+			if i+1 < len(input) && input[i+1] == '=' {
+				kind++
+				text += "="
+				tokens = append(tokens, token{kind, text, i})
+				i += 2
+			} else {
+				tokens = append(tokens, token{kind, text, i})
+				i++
+			}
+		case c == '"' || c == '\'':
+			tok, next, err := lexString(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = next
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(input) && input[i] >= '0' && input[i] <= '9' {
+				i++
+			}
+			if i < len(input) && isIdentChar(input[i]) {
+				return nil, errorf(start, "invalid number %q", input[start:i+1])
+			}
+			tokens = append(tokens, token{tokNumber, input[start:i], start})
+		case isIdentStart(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			word := input[start:i]
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{tokAnd, word, start})
+			case "OR":
+				tokens = append(tokens, token{tokOr, word, start})
+			default:
+				tokens = append(tokens, token{tokIdent, word, start})
+			}
+		default:
+			return nil, errorf(i, "unexpected character %q", string(c))
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(input)})
+	return tokens, nil
+}
+
+func lexString(input string, start int) (token, int, error) {
+	quote := input[start]
+	var b strings.Builder
+	i := start + 1
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == '\\' && i+1 < len(input):
+			b.WriteByte(input[i+1])
+			i += 2
+		case c == quote:
+			return token{tokString, b.String(), start}, i + 1, nil
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return token{}, 0, errorf(start, "unterminated string starting with %s", string(quote))
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '.'
+}
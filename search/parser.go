@@ -0,0 +1,225 @@
+package search
+
+import "fmt"
+
+const (
+	maxQueryLength = 1000
+	maxClauses     = 20
+)
+
+type Operator string
+
+const (
+	OpEqual    Operator = ":"
+	OpContains Operator = "~"
+	OpGT       Operator = ">"
+	OpGTE      Operator = ">="
+	OpLT       Operator = "<"
+	OpLTE      Operator = "<="
+)
+
+type Node interface {
+	node()
+}
+
+type And struct {
+	Left, Right Node
+}
+
+type Or struct {
+	Left, Right Node
+}
+
+type Not struct {
+	Expr Node
+}
+
+// Clause is a single comparison such as amount>1000 or
+// metadata["order_id"]:"42". Key is set only for bracketed fields.
+type Clause struct {
+	Field    string
+	Key      string
+	HasKey   bool
+	Operator Operator
+	Value    string
+	IsNumber bool
+	Pos      int
+}
+
+func (And) node()    {}
+func (Or) node()     {}
+func (Not) node()    {}
+func (Clause) node() {}
+
+type parser struct {
+	tokens  []token
+	pos     int
+	clauses int
+}
+
+// Parse turns a query such as
+//
+//	status:"succeeded" AND (amount>1000 OR -customer:"cust_123")
+//
+// into a syntax tree. AND binds tighter than OR; "-" negates the following
+// clause or group.
+func Parse(input string) (Node, error) {
+	if len(input) > maxQueryLength {
+		return nil, &SyntaxError{Pos: maxQueryLength, Message: fmt.Sprintf("query is longer than %d characters", maxQueryLength)}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, errorf(0, "query is empty")
+	}
+
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		if tok.kind == tokRParen {
+			return nil, errorf(tok.pos, `unmatched ")"`)
+		}
+		return nil, errorf(tok.pos, "expected AND or OR before %s", tok.describe())
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, errorf(closing.pos, `expected ")" to close "(" at position %d, found %s`, tok.pos+1, closing.describe())
+		}
+		return n, nil
+	case tokIdent:
+		return p.parseClause(tok)
+	case tokAnd, tokOr:
+		return nil, errorf(tok.pos, "expected a field name before %s", tok.describe())
+	case tokEOF:
+		return nil, errorf(tok.pos, "unexpected end of query, expected a field name")
+	default:
+		return nil, errorf(tok.pos, "expected a field name, found %s", tok.describe())
+	}
+}
+
+func (p *parser) parseClause(field token) (Node, error) {
+	p.clauses++
+	if p.clauses > maxClauses {
+		return nil, errorf(field.pos, "query has more than %d clauses", maxClauses)
+	}
+
+	clause := Clause{Field: field.text, Pos: field.pos + 1}
+
+	if p.peek().kind == tokLBracket {
+		p.next()
+		key := p.next()
+		if key.kind != tokString {
+			return nil, errorf(key.pos, "expected a quoted key inside %s[...], found %s", field.text, key.describe())
+		}
+		if closing := p.next(); closing.kind != tokRBracket {
+			return nil, errorf(closing.pos, `expected "]" after %s[%q, found %s`, field.text, key.text, closing.describe())
+		}
+		clause.Key = key.text
+		clause.HasKey = true
+	}
+
+	op := p.next()
+	switch op.kind {
+	case tokColon:
+		clause.Operator = OpEqual
+	case tokTilde:
+		clause.Operator = OpContains
+	case tokGT:
+		clause.Operator = OpGT
+	case tokGTE:
+		clause.Operator = OpGTE
+	case tokLT:
+		clause.Operator = OpLT
+	case tokLTE:
+		clause.Operator = OpLTE
+	default:
+		return nil, errorf(op.pos, `expected an operator (":", "~", ">", ">=", "<", "<=") after %q, found %s`, field.text, op.describe())
+	}
+
+	value := p.next()
+	switch value.kind {
+	case tokString:
+		clause.Value = value.text
+	case tokNumber:
+		clause.Value = value.text
+		clause.IsNumber = true
+	case tokIdent:
+		return nil, errorf(value.pos, "string values must be quoted: use %s%s%q", field.text, op.text, value.text)
+	default:
+		return nil, errorf(value.pos, "expected a value after %s%s, found %s", field.text, op.text, value.describe())
+	}
+
+	return clause, nil
+}
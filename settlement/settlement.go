@@ -0,0 +1,58 @@
+// Package settlement decides when the funds of a charge become available to
+// the merchant: a number of business days after the charge, skipping
+// weekends and bank holidays.
+package settlement
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultDelayDays is the settlement delay of new merchants: T+2.
+const DefaultDelayDays = 2
+
+const dateLayout = "2006-01-02"
+
+// Calendar knows which days banks are closed. Days are UTC dates.
+type Calendar struct {
+	Holidays map[string]bool
+}
+
+// LoadCalendar reads bank holidays from SETTLEMENT_HOLIDAYS, a comma
+// separated list of YYYY-MM-DD dates. Malformed dates are ignored.
+func LoadCalendar() Calendar {
+	cal := Calendar{Holidays: map[string]bool{}}
+	for _, d := range strings.Split(os.Getenv("SETTLEMENT_HOLIDAYS"), ",") {
+		d = strings.TrimSpace(d)
+		if _, err := time.Parse(dateLayout, d); err == nil {
+			cal.Holidays[d] = true
+		}
+	}
+	return cal
+}
+
+func (c Calendar) BusinessDay(t time.Time) bool {
+	t = t.UTC()
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.Holidays[t.Format(dateLayout)]
+}
+
+// AvailableOn is the start of the delayDays-th business day after the day of
+// t. A delay of 0 makes funds available at once.
+func (c Calendar) AvailableOn(t time.Time, delayDays int) time.Time {
+	if delayDays <= 0 {
+		return t
+	}
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for delayDays > 0 {
+		day = day.AddDate(0, 0, 1)
+		if c.BusinessDay(day) {
+			delayDays--
+		}
+	}
+	return day
+}
@@ -0,0 +1,164 @@
+package statements
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+// The element names are shared by camt.053.001.02 through .08; namespaces are
+// ignored so every version decodes.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID       string        `xml:"Id"`
+	IBAN     string        `xml:"Acct>Id>IBAN"`
+	Other    string        `xml:"Acct>Id>Othr>Id"`
+	Currency string        `xml:"Acct>Ccy"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtBalance struct {
+	Code   string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount camtAmount `xml:"Amt"`
+	Sign   string     `xml:"CdtDbtInd"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtEntry struct {
+	Ref         string      `xml:"NtryRef"`
+	Amount      camtAmount  `xml:"Amt"`
+	Sign        string      `xml:"CdtDbtInd"`
+	BookingDate camtDate    `xml:"BookgDt"`
+	ValueDate   camtDate    `xml:"ValDt"`
+	ServicerRef string      `xml:"AcctSvcrRef"`
+	Details     []camtTxDtl `xml:"NtryDtls>TxDtls"`
+	Info        string      `xml:"AddtlNtryInf"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTxDtl struct {
+	EndToEndID string   `xml:"Refs>EndToEndId"`
+	Unstruct   []string `xml:"RmtInf>Ustrd"`
+}
+
+func (d camtDate) time() (time.Time, bool) {
+	if d.Date != "" {
+		t, err := time.Parse("2006-01-02", d.Date)
+		return t, err == nil
+	}
+	if len(d.DateTime) >= 10 {
+		t, err := time.Parse("2006-01-02", d.DateTime[:10])
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+func (a camtAmount) signed(sign string) (int64, bool) {
+	v, ok := parseAmount(a.Value, '.')
+	if !ok || v < 0 {
+		return 0, false
+	}
+	if sign == "DBIT" {
+		v = -v
+	}
+	return v, true
+}
+
+func parseCAMT053(data []byte) ([]Statement, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, &SyntaxError{Format: FormatCAMT053, Message: err.Error()}
+	}
+
+	var list []Statement
+	for _, cs := range doc.Statements {
+		s := Statement{
+			Format:    FormatCAMT053,
+			Reference: cs.ID,
+			Account:   cs.IBAN,
+			Currency:  strings.ToLower(cs.Currency),
+		}
+		if s.Account == "" {
+			s.Account = cs.Other
+		}
+
+		var opening, closing bool
+		for _, b := range cs.Balances {
+			v, ok := b.Amount.signed(b.Sign)
+			if !ok {
+				return nil, &SyntaxError{Format: FormatCAMT053, Message: "invalid balance amount " + b.Amount.Value}
+			}
+			switch b.Code {
+			case "OPBD", "PRCD":
+				s.OpeningBalance, opening = v, true
+			case "CLBD":
+				s.ClosingBalance, closing = v, true
+			}
+			if s.Currency == "" {
+				s.Currency = strings.ToLower(b.Amount.Currency)
+			}
+		}
+		s.HasBalances = opening && closing
+
+		for _, e := range cs.Entries {
+			amount, ok := e.Amount.signed(e.Sign)
+			if !ok {
+				return nil, &SyntaxError{Format: FormatCAMT053, Message: "invalid entry amount " + e.Amount.Value}
+			}
+			booked, ok := e.BookingDate.time()
+			if !ok {
+				return nil, &SyntaxError{Format: FormatCAMT053, Message: "entry " + e.Ref + " has no booking date"}
+			}
+			value, ok := e.ValueDate.time()
+			if !ok {
+				value = booked
+			}
+
+			var refs, texts []string
+			for _, d := range e.Details {
+				if d.EndToEndID != "" && d.EndToEndID != "NOTPROVIDED" {
+					refs = append(refs, d.EndToEndID)
+				}
+				texts = append(texts, d.Unstruct...)
+			}
+			if len(refs) == 0 {
+				refs = append(refs, firstNonEmpty(e.ServicerRef, e.Ref))
+			}
+			if e.Info != "" {
+				texts = append(texts, e.Info)
+			}
+
+			s.Lines = append(s.Lines, Line{
+				BookingDate: booked,
+				ValueDate:   value,
+				Amount:      amount,
+				Currency:    strings.ToLower(firstNonEmpty(e.Amount.Currency, s.Currency)),
+				Reference:   strings.Join(refs, " "),
+				Description: strings.Join(texts, " "),
+			})
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package statements
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// CSV statements need a header row. date, amount and currency are required;
+// value_date, reference and description are optional. Amounts use a decimal
+// point and are negative for debits.
+var csvColumns = map[string]string{
+	"date":         "date",
+	"booking_date": "date",
+	"value_date":   "value_date",
+	"amount":       "amount",
+	"currency":     "currency",
+	"reference":    "reference",
+	"description":  "description",
+}
+
+func parseCSV(data []byte) ([]Statement, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, &SyntaxError{Format: FormatCSV, Line: 1, Message: "missing header row"}
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		if col, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			index[col] = i
+		}
+	}
+	for _, col := range []string{"date", "amount", "currency"} {
+		if _, ok := index[col]; !ok {
+			return nil, &SyntaxError{Format: FormatCSV, Line: 1, Message: "missing " + col + " column"}
+		}
+	}
+
+	field := func(record []string, col string) string {
+		i, ok := index[col]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	s := Statement{Format: FormatCSV}
+	for row := 2; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &SyntaxError{Format: FormatCSV, Line: row, Message: err.Error()}
+		}
+
+		booked, err := time.Parse("2006-01-02", field(record, "date"))
+		if err != nil {
+			return nil, &SyntaxError{Format: FormatCSV, Line: row, Message: "invalid date " + field(record, "date")}
+		}
+		value := booked
+		if v := field(record, "value_date"); v != "" {
+			if value, err = time.Parse("2006-01-02", v); err != nil {
+				return nil, &SyntaxError{Format: FormatCSV, Line: row, Message: "invalid value_date " + v}
+			}
+		}
+		amount, ok := parseAmount(field(record, "amount"), '.')
+		if !ok {
+			return nil, &SyntaxError{Format: FormatCSV, Line: row, Message: "invalid amount " + field(record, "amount")}
+		}
+		currency := strings.ToLower(field(record, "currency"))
+		if len(currency) != 3 {
+			return nil, &SyntaxError{Format: FormatCSV, Line: row, Message: "invalid currency " + currency}
+		}
+		if s.Currency == "" {
+			s.Currency = currency
+		}
+
+		s.Lines = append(s.Lines, Line{
+			BookingDate: booked,
+			ValueDate:   value,
+			Amount:      amount,
+			Currency:    currency,
+			Reference:   field(record, "reference"),
+			Description: field(record, "description"),
+		})
+	}
+	if len(s.Lines) == 0 {
+		return nil, nil
+	}
+	return []Statement{s}, nil
+}
@@ -0,0 +1,136 @@
+package statements
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	mt940Balance = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})([\d,]+)$`)
+	// :61: value date, optional entry date, mark, optional funds code,
+	// amount, transaction type, customer reference and bank reference.
+	mt940Entry = regexp.MustCompile(`^(\d{6})(\d{4})?(R?[CD])([A-Z])?([\d,]+)([NFS][A-Z0-9]{3})([^/]*)(?://(.*))?$`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+	line  int
+}
+
+// splitMT940 groups the file into tagged fields, joining continuation lines
+// onto the field they belong to.
+func splitMT940(data []byte) []mt940Field {
+	var fields []mt940Field
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line := strings.TrimRight(raw, " ")
+		if line == "" || line == "-" || strings.HasPrefix(line, "{") {
+			continue
+		}
+		if len(line) > 3 && line[0] == ':' {
+			if end := strings.Index(line[1:], ":"); end > 0 {
+				fields = append(fields, mt940Field{tag: line[1 : end+1], value: line[end+2:], line: i + 1})
+				continue
+			}
+		}
+		if len(fields) > 0 {
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+	return fields
+}
+
+func parseMT940(data []byte) ([]Statement, error) {
+	var (
+		list []Statement
+		cur  *Statement
+		last *Line
+	)
+	syntaxErr := func(f mt940Field, msg string) error {
+		return &SyntaxError{Format: FormatMT940, Line: f.line, Message: msg}
+	}
+
+	for _, f := range splitMT940(data) {
+		if f.tag != "20" && cur == nil {
+			return nil, syntaxErr(f, "expected :20: before :"+f.tag+":")
+		}
+		switch f.tag {
+		case "20":
+			list = append(list, Statement{Format: FormatMT940, Reference: f.value})
+			cur, last = &list[len(list)-1], nil
+		case "25":
+			cur.Account = f.value
+		case "60F", "60M", "62F", "62M":
+			m := mt940Balance.FindStringSubmatch(f.value)
+			if m == nil {
+				return nil, syntaxErr(f, "invalid balance "+f.value)
+			}
+			v, ok := parseAmount(m[4], ',')
+			if !ok {
+				return nil, syntaxErr(f, "invalid amount "+m[4])
+			}
+			if m[1] == "D" {
+				v = -v
+			}
+			cur.Currency = strings.ToLower(m[3])
+			if f.tag[:2] == "60" {
+				cur.OpeningBalance = v
+			} else {
+				cur.ClosingBalance = v
+				cur.HasBalances = true
+			}
+		case "61":
+			first, rest, _ := strings.Cut(f.value, "\n")
+			m := mt940Entry.FindStringSubmatch(first)
+			if m == nil {
+				return nil, syntaxErr(f, "invalid statement line "+first)
+			}
+			value, err := time.Parse("060102", m[1])
+			if err != nil {
+				return nil, syntaxErr(f, "invalid value date "+m[1])
+			}
+			booked := value
+			if m[2] != "" {
+				booked, err = time.Parse("060102", m[1][:2]+m[2])
+				if err != nil {
+					return nil, syntaxErr(f, "invalid entry date "+m[2])
+				}
+				// An entry date in January on a December value date belongs
+				// to the next year, and the other way round.
+				if d := booked.Sub(value); d > 180*24*time.Hour {
+					booked = booked.AddDate(-1, 0, 0)
+				} else if d < -180*24*time.Hour {
+					booked = booked.AddDate(1, 0, 0)
+				}
+			}
+			amount, ok := parseAmount(m[5], ',')
+			if !ok {
+				return nil, syntaxErr(f, "invalid amount "+m[5])
+			}
+			// A debit, or the reversal of a credit, takes money out.
+			if m[3] == "D" || m[3] == "RC" {
+				amount = -amount
+			}
+			reference := strings.TrimSpace(m[7])
+			if reference == "NONREF" {
+				reference = ""
+			}
+			cur.Lines = append(cur.Lines, Line{
+				BookingDate: booked,
+				ValueDate:   value,
+				Amount:      amount,
+				Currency:    cur.Currency,
+				Reference:   strings.TrimSpace(strings.Join([]string{reference, m[8]}, " ")),
+				Description: strings.TrimSpace(rest),
+			})
+			last = &cur.Lines[len(cur.Lines)-1]
+		case "86":
+			if last != nil {
+				info := strings.TrimSpace(strings.ReplaceAll(f.value, "\n", " "))
+				last.Description = strings.TrimSpace(last.Description + " " + info)
+			}
+		}
+	}
+	return list, nil
+}
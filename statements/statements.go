@@ -0,0 +1,158 @@
+// Package statements parses bank account statements into a common shape so
+// they can be reconciled against what MiniPay expects to see on the account.
+package statements
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCAMT053 = "camt053"
+	FormatMT940   = "mt940"
+	FormatCSV     = "csv"
+)
+
+var Formats = []string{FormatCAMT053, FormatMT940, FormatCSV}
+
+var ErrUnknownFormat = errors.New("statements: unknown format")
+
+// SyntaxError reports a statement that could not be parsed. Line is 1-based
+// and 0 when the position is not known.
+type SyntaxError struct {
+	Format  string
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s line %d: %s", e.Format, e.Line, e.Message)
+	}
+	return e.Format + ": " + e.Message
+}
+
+type Statement struct {
+	Format    string
+	Reference string
+	Account   string
+	Currency  string
+	// Opening and closing balances in minor units; HasBalances is false when
+	// the format does not carry them.
+	OpeningBalance int64
+	ClosingBalance int64
+	HasBalances    bool
+	Lines          []Line
+}
+
+// Line is one booked entry. Amount is in minor units, positive for credits
+// and negative for debits.
+type Line struct {
+	BookingDate time.Time
+	ValueDate   time.Time
+	Amount      int64
+	Currency    string
+	Reference   string
+	Description string
+}
+
+// Period returns the first and last booking date in s.
+func (s Statement) Period() (time.Time, time.Time) {
+	var start, end time.Time
+	for i, l := range s.Lines {
+		if i == 0 || l.BookingDate.Before(start) {
+			start = l.BookingDate
+		}
+		if i == 0 || l.BookingDate.After(end) {
+			end = l.BookingDate
+		}
+	}
+	return start, end
+}
+
+// Balanced reports whether the lines account for the difference between the
+// opening and closing balance.
+func (s Statement) Balanced() bool {
+	if !s.HasBalances {
+		return true
+	}
+	sum := s.OpeningBalance
+	for _, l := range s.Lines {
+		sum += l.Amount
+	}
+	return sum == s.ClosingBalance
+}
+
+// Detect guesses the format of data from its first bytes.
+func Detect(data []byte) string {
+	head := bytes.TrimSpace(data)
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("<")):
+		return FormatCAMT053
+	case bytes.Contains(head, []byte(":20:")):
+		return FormatMT940
+	}
+	return FormatCSV
+}
+
+// Parse reads every statement in data. An empty format is detected.
+func Parse(format string, data []byte) ([]Statement, error) {
+	if format == "" {
+		format = Detect(data)
+	}
+	var (
+		list []Statement
+		err  error
+	)
+	switch format {
+	case FormatCAMT053:
+		list, err = parseCAMT053(data)
+	case FormatMT940:
+		list, err = parseMT940(data)
+	case FormatCSV:
+		list, err = parseCSV(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, &SyntaxError{Format: format, Message: "no statements found"}
+	}
+	return list, nil
+}
+
+// parseAmount converts a decimal amount with the given separator to minor
+// units. It accepts a leading sign and at most two decimals.
+func parseAmount(s string, sep byte) (int64, bool) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, string(sep))
+	if whole == "" && frac == "" || len(frac) > 2 {
+		return 0, false
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	var v int64
+	for _, c := range whole + frac {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int64(c-'0')
+	}
+	if neg {
+		v = -v
+	}
+	return v, true
+}
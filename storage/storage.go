@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+func Root() string {
+	if dir := os.Getenv("FILE_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("data", "files")
+}
+
+// Save writes r to local disk under Root()/purpose and records it as a File.
+// The stored name is the file ID, never the client-supplied filename.
+func Save(db *gorm.DB, purpose, ownerID, filename, contentType string, r io.Reader) (*models.File, error) {
+	id := "file_" + uuid.NewString()
+	dir := filepath.Join(Root(), purpose)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, id)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	file := &models.File{
+		ID:          id,
+		Purpose:     purpose,
+		OwnerID:     ownerID,
+		Filename:    filepath.Base(filename),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Path:        path,
+	}
+	if err := db.Create(file).Error; err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Delete removes a saved file from disk and its File record.
+func Delete(db *gorm.DB, file *models.File) error {
+	if err := db.Delete(file).Error; err != nil {
+		return err
+	}
+	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package taxes
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+// ReportParams selects the tax lines recorded in [From, To).
+type ReportParams struct {
+	From       time.Time
+	To         time.Time
+	MerchantID string
+	Currency   string
+}
+
+// ReportRow sums the tax lines of one jurisdiction and rate. Refunds and
+// voided invoices count against the period they happened in.
+type ReportRow struct {
+	Country       string
+	Region        string
+	Jurisdiction  string
+	Kind          string
+	RateBps       int64
+	ReverseCharge bool
+	Currency      string
+	Count         int64
+	Taxable       int64
+	Tax           int64
+}
+
+func (p ReportParams) query(db *gorm.DB) *gorm.DB {
+	q := db.Model(&models.TaxLine{}).Where("created_at >= ? AND created_at < ?", p.From, p.To)
+	if p.MerchantID != "" {
+		q = q.Where("merchant_id = ?", p.MerchantID)
+	}
+	if p.Currency != "" {
+		q = q.Where("currency = ?", p.Currency)
+	}
+	return q
+}
+
+func Report(db *gorm.DB, p ReportParams) ([]ReportRow, error) {
+	var rows []ReportRow
+	err := p.query(db).
+		Select("country, region, jurisdiction, kind, rate_bps, reverse_charge, currency, COUNT(*) AS count, SUM(taxable) AS taxable, SUM(tax) AS tax").
+		Group("country, region, jurisdiction, kind, rate_bps, reverse_charge, currency").
+		Order("currency, country, region, jurisdiction, rate_bps, reverse_charge").
+		Scan(&rows).Error
+	return rows, err
+}
+
+func ReportLines(db *gorm.DB, p ReportParams) ([]models.TaxLine, error) {
+	var lines []models.TaxLine
+	err := p.query(db).Order("created_at, id").Find(&lines).Error
+	return lines, err
+}
+
+var csvHeader = []string{
+	"date", "source_type", "source_id", "invoice_id", "merchant", "customer", "customer_tax_id",
+	"country", "region", "postal_code", "jurisdiction", "kind", "tax_code", "rate_bps",
+	"behavior", "reverse_charge", "currency", "taxable_amount", "tax_amount",
+}
+
+// WriteCSV writes lines one per row, amounts in minor units.
+func WriteCSV(w io.Writer, lines []models.TaxLine) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		err := cw.Write([]string{
+			l.CreatedAt.UTC().Format(time.RFC3339), l.SourceType, l.SourceID, l.InvoiceID, l.MerchantID, l.CustomerID, l.CustomerTaxID,
+			l.Country, l.Region, l.PostalCode, l.Jurisdiction, l.Kind, l.TaxCode, strconv.FormatInt(l.RateBps, 10),
+			l.Behavior, strconv.FormatBool(l.ReverseCharge), l.Currency, strconv.FormatInt(l.Taxable, 10), strconv.FormatInt(l.Tax, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
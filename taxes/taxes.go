@@ -0,0 +1,253 @@
+// Package taxes works out sales tax and VAT from the local rate table and
+// records what was charged, so tax reports can be built from it.
+package taxes
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	KindVAT      = "vat"
+	KindSalesTax = "sales_tax"
+)
+
+// Exclusive prices have tax added on top; inclusive prices already contain
+// it.
+const (
+	Exclusive = "exclusive"
+	Inclusive = "inclusive"
+)
+
+// Product tax codes. Rates can be limited to one code; sales of exempt
+// products are never taxed.
+const (
+	CodeGeneral = "general"
+	CodeReduced = "reduced"
+	CodeDigital = "digital"
+	CodeExempt  = "exempt"
+)
+
+var Codes = []string{CodeGeneral, CodeReduced, CodeDigital, CodeExempt}
+
+// Source types of tax lines.
+const (
+	SourceCharge      = "charge"
+	SourceRefund      = "refund"
+	SourceInvoiceLine = "invoice_line"
+	SourceInvoiceVoid = "invoice_void"
+	SourceChargeVoid  = "charge_void"
+)
+
+var ErrInvalidTaxID = errors.New("taxes: invalid VAT ID")
+
+func ValidCode(code string) bool {
+	for _, c := range Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Address is where a sale is taxed.
+type Address struct {
+	Country    string
+	Region     string
+	PostalCode string
+}
+
+type Params struct {
+	Amount   int64
+	Behavior string
+	// TaxCode defaults to CodeGeneral.
+	TaxCode string
+	Address Address
+	// CustomerTaxID is the buyer's VAT ID. A valid one from another country
+	// than the merchant's reverse charges VAT.
+	CustomerTaxID   string
+	MerchantCountry string
+}
+
+// Result is the tax on one amount.
+type Result struct {
+	Behavior      string
+	TaxCode       string
+	Address       Address
+	Rate          *models.TaxRate
+	RateBps       int64
+	Taxable       int64
+	Tax           int64
+	ReverseCharge bool
+	CustomerTaxID string
+}
+
+// Total is what the customer pays.
+func (r Result) Total() int64 {
+	return r.Taxable + r.Tax
+}
+
+// Calculate works out the tax on p.Amount. With no matching rate the sale
+// is untaxed.
+func Calculate(db *gorm.DB, p Params) (Result, error) {
+	code := p.TaxCode
+	if code == "" {
+		code = CodeGeneral
+	}
+	addr := Address{
+		Country:    strings.ToUpper(p.Address.Country),
+		Region:     strings.ToUpper(p.Address.Region),
+		PostalCode: strings.ToUpper(strings.ReplaceAll(p.Address.PostalCode, " ", "")),
+	}
+	res := Result{Behavior: p.Behavior, TaxCode: code, Address: addr, Taxable: p.Amount}
+	if p.CustomerTaxID != "" {
+		id, _, err := NormalizeTaxID(p.CustomerTaxID)
+		if err != nil {
+			return res, err
+		}
+		res.CustomerTaxID = id
+	}
+	if code == CodeExempt {
+		return res, nil
+	}
+
+	rate, err := FindRate(db, code, addr)
+	if err != nil || rate == nil {
+		return res, err
+	}
+	res.Rate = rate
+	res.RateBps = rate.RateBps
+	if reverseCharged(*rate, res.CustomerTaxID, p.MerchantCountry) {
+		res.ReverseCharge = true
+		return res, nil
+	}
+
+	if p.Behavior == Inclusive {
+		// Take the tax back out of the price, rounding the net half up.
+		div := 10000 + rate.RateBps
+		res.Taxable = (p.Amount*10000 + div/2) / div
+		res.Tax = p.Amount - res.Taxable
+	} else {
+		res.Tax = (p.Amount*rate.RateBps + 5000) / 10000
+	}
+	return res, nil
+}
+
+// reverseCharged reports whether a business buyer with VAT ID taxID
+// accounts for the VAT of rate itself, which it does when buying from a
+// merchant in another country.
+func reverseCharged(rate models.TaxRate, taxID, merchantCountry string) bool {
+	if rate.Kind != KindVAT || taxID == "" || merchantCountry == "" {
+		return false
+	}
+	country := taxIDCountry(taxID)
+	return country == rate.Country && country != strings.ToUpper(merchantCountry)
+}
+
+// FindRate picks the most specific active rate for a sale of code to addr:
+// a rate for the tax code beats a general one, then the longest matching
+// postal prefix, then a matching region beats the whole country.
+func FindRate(db *gorm.DB, code string, addr Address) (*models.TaxRate, error) {
+	var rates []models.TaxRate
+	err := db.Where("country = ? AND active = ? AND tax_code IN ?", addr.Country, true, []string{"", code}).
+		Order("created_at, id").Find(&rates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var best *models.TaxRate
+	bestScore := -1
+	for i, r := range rates {
+		if r.Region != "" && r.Region != addr.Region {
+			continue
+		}
+		if r.PostalPrefix != "" && !strings.HasPrefix(addr.PostalCode, r.PostalPrefix) {
+			continue
+		}
+		score := len(r.PostalPrefix) * 2
+		if r.Region != "" {
+			score++
+		}
+		if r.TaxCode != "" {
+			score += 1000
+		}
+		if score > bestScore {
+			best, bestScore = &rates[i], score
+		}
+	}
+	return best, nil
+}
+
+// Source is what a tax line is recorded for.
+type Source struct {
+	Type       string
+	ID         string
+	InvoiceID  string
+	MerchantID string
+	CustomerID string
+	Currency   string
+}
+
+// Record stores r as the tax of src.
+func Record(tx *gorm.DB, src Source, r Result) error {
+	line := models.TaxLine{
+		ID:            "txl_" + uuid.NewString(),
+		SourceType:    src.Type,
+		SourceID:      src.ID,
+		InvoiceID:     src.InvoiceID,
+		MerchantID:    src.MerchantID,
+		CustomerID:    src.CustomerID,
+		Currency:      strings.ToLower(src.Currency),
+		Country:       r.Address.Country,
+		Region:        r.Address.Region,
+		PostalCode:    r.Address.PostalCode,
+		TaxCode:       r.TaxCode,
+		RateBps:       r.RateBps,
+		Behavior:      r.Behavior,
+		Taxable:       r.Taxable,
+		Tax:           r.Tax,
+		ReverseCharge: r.ReverseCharge,
+		CustomerTaxID: r.CustomerTaxID,
+	}
+	if r.Rate != nil {
+		line.TaxRateID = r.Rate.ID
+		line.Jurisdiction = r.Rate.Name
+		line.Kind = r.Rate.Kind
+	}
+	return tx.Create(&line).Error
+}
+
+// Reverse records negated copies of lines for a refund or a voided invoice.
+func Reverse(tx *gorm.DB, lines []models.TaxLine, sourceType, sourceID string) error {
+	for _, l := range lines {
+		l.ID = "txl_" + uuid.NewString()
+		l.SourceType = sourceType
+		l.SourceID = sourceID
+		l.Taxable = -l.Taxable
+		l.Tax = -l.Tax
+		// Reversals are reported when they happen.
+		l.CreatedAt = time.Time{}
+		if err := tx.Create(&l).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Lines(db *gorm.DB, sourceType, sourceID string) ([]models.TaxLine, error) {
+	var lines []models.TaxLine
+	err := db.Where("source_type = ? AND source_id = ?", sourceType, sourceID).Order("created_at, id").Find(&lines).Error
+	return lines, err
+}
+
+func InvoiceLines(db *gorm.DB, invoiceID string) ([]models.TaxLine, error) {
+	var lines []models.TaxLine
+	err := db.Where("source_type = ? AND invoice_id = ?", SourceInvoiceLine, invoiceID).Order("created_at, id").Find(&lines).Error
+	return lines, err
+}
@@ -0,0 +1,66 @@
+package taxes
+
+import (
+	"regexp"
+	"strings"
+)
+
+// vatIDFormats are the VAT ID formats of the EU member states, the UK,
+// Switzerland and Norway, keyed by their prefix. Only the format is checked;
+// whether the ID is registered is not.
+var vatIDFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^ATU\d{8}$`),
+	"BE": regexp.MustCompile(`^BE[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^BG\d{9,10}$`),
+	"CH": regexp.MustCompile(`^CHE\d{9}(MWST|TVA|IVA)?$`),
+	"CY": regexp.MustCompile(`^CY\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^CZ\d{8,10}$`),
+	"DE": regexp.MustCompile(`^DE\d{9}$`),
+	"DK": regexp.MustCompile(`^DK\d{8}$`),
+	"EE": regexp.MustCompile(`^EE\d{9}$`),
+	"EL": regexp.MustCompile(`^EL\d{9}$`),
+	"ES": regexp.MustCompile(`^ES[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^FI\d{8}$`),
+	"FR": regexp.MustCompile(`^FR[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"GB": regexp.MustCompile(`^GB(\d{9}|\d{12}|GD\d{3}|HA\d{3})$`),
+	"HR": regexp.MustCompile(`^HR\d{11}$`),
+	"HU": regexp.MustCompile(`^HU\d{8}$`),
+	"IE": regexp.MustCompile(`^IE\d[A-Z0-9+*]\d{5}[A-W][A-I]?$`),
+	"IT": regexp.MustCompile(`^IT\d{11}$`),
+	"LT": regexp.MustCompile(`^LT(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^LU\d{8}$`),
+	"LV": regexp.MustCompile(`^LV\d{11}$`),
+	"MT": regexp.MustCompile(`^MT\d{8}$`),
+	"NL": regexp.MustCompile(`^NL\d{9}B\d{2}$`),
+	"NO": regexp.MustCompile(`^NO\d{9}(MVA)?$`),
+	"PL": regexp.MustCompile(`^PL\d{10}$`),
+	"PT": regexp.MustCompile(`^PT\d{9}$`),
+	"RO": regexp.MustCompile(`^RO\d{2,10}$`),
+	"SE": regexp.MustCompile(`^SE\d{12}$`),
+	"SI": regexp.MustCompile(`^SI\d{8}$`),
+	"SK": regexp.MustCompile(`^SK\d{10}$`),
+}
+
+// NormalizeTaxID upper-cases a VAT ID, drops spaces, dots and dashes, and
+// checks it against its country's format. It returns the normalized ID and
+// the ISO country it belongs to.
+func NormalizeTaxID(id string) (string, string, error) {
+	id = strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(id))
+	if len(id) < 4 {
+		return "", "", ErrInvalidTaxID
+	}
+	format, ok := vatIDFormats[id[:2]]
+	if !ok || !format.MatchString(id) {
+		return "", "", ErrInvalidTaxID
+	}
+	return id, taxIDCountry(id), nil
+}
+
+// taxIDCountry is the ISO country of a normalized VAT ID. Greek VAT IDs use
+// EL rather than GR.
+func taxIDCountry(id string) string {
+	if strings.HasPrefix(id, "EL") {
+		return "GR"
+	}
+	return id[:2]
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/apierror"
 	"github.com/vaidikcode/minipay/config"
 	"github.com/vaidikcode/minipay/models"
 	"github.com/vaidikcode/minipay/routes"
@@ -26,6 +28,12 @@ func setupTestRouter() *gin.Engine {
 	return r
 }
 
+// setupTestHelpersRouter is setupTestRouter with the test helpers enabled.
+func setupTestHelpersRouter(t *testing.T) *gin.Engine {
+	t.Setenv("MINIPAY_TEST_HELPERS", "1")
+	return setupTestRouter()
+}
+
 func TestChargeCreateTransaction(t *testing.T) {
 	setupTestDB(t)
 	r := setupTestRouter()
@@ -97,6 +105,37 @@ func TestChargeIdempotency(t *testing.T) {
 	}
 }
 
+func TestChargeIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	charge := func(amount int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"amount": amount, "currency": "usd", "customer": "cust_456"})
+		req, _ := http.NewRequest("POST", "/api/v1/charges", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "idem-reused")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := charge(2000); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w := charge(3000)
+	if w.Code != http.StatusConflict || decodeError(t, w).Error.Code != apierror.CodeIdempotencyConflict {
+		t.Fatalf("expected idempotency_key_in_use, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := charge(2000); w.Code != http.StatusOK {
+		t.Fatalf("expected the original charge replayed, got %d: %s", w.Code, w.Body.String())
+	}
+	var count int64
+	config.DB.Model(&models.Transaction{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected 1 charge, got %d", count)
+	}
+}
+
 func TestChargeInvalidPayload(t *testing.T) {
 	setupTestDB(t)
 	r := setupTestRouter()
@@ -206,38 +245,48 @@ func TestGetBalance(t *testing.T) {
 	setupTestDB(t)
 	r := setupTestRouter()
 
-	config.DB.Create(&models.Transaction{
-		ID:       "txn_bal_1",
-		Amount:   1000,
-		Currency: "usd",
-		Customer: "cust_bal",
-		Status:   "succeeded",
-		Refunded: false,
-	})
-
-	config.DB.Create(&models.Transaction{
-		ID:       "txn_bal_2",
-		Amount:   500,
-		Currency: "usd",
-		Customer: "cust_bal",
-		Status:   "succeeded",
-		Refunded: true,
-	})
+	chargeWithCard(r, "4242424242424242")
+	w := chargeWithCard(r, "4242424242424242")
+	var refunded map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &refunded)
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": refunded["id"]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
 
 	req, _ := http.NewRequest("GET", "/api/v1/balance", nil)
-	w := httptest.NewRecorder()
+	w = httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 
-	var resp map[string]interface{}
+	var resp struct {
+		Successful int64           `json:"successful_transactions"`
+		Refunded   int64           `json:"refunded_transactions"`
+		Gross      []balanceAmount `json:"gross"`
+		Fees       []balanceAmount `json:"fees"`
+		Balance    []balanceAmount `json:"balance"`
+	}
 	json.Unmarshal(w.Body.Bytes(), &resp)
 
-	balance := resp["balance"].(float64)
-	if balance != 500 {
-		t.Fatalf("expected balance 500, got %v", balance)
+	if resp.Successful != 1 || resp.Refunded != 1 {
+		t.Fatalf("expected 1 successful and 1 refunded transaction, got %s", w.Body.String())
+	}
+	// The refunded charge nets out of gross, but its fee is kept.
+	usd := []balanceAmount{{Amount: 1500, Currency: "usd"}}
+	if !reflect.DeepEqual(resp.Gross, usd) || resp.Fees[0].Amount != 2*74 || resp.Balance[0].Amount != 1500-2*74 {
+		t.Fatalf("expected refunds netted from the balance, got %s", w.Body.String())
+	}
+
+	// Totals in other currencies are kept apart rather than added up.
+	doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": 2000, "currency": "eur", "customer": "cust_eu"})
+	w = doJSON(r, "GET", "/api/v1/balance", nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	want := []balanceAmount{{Amount: 2000, Currency: "eur"}, {Amount: 1500, Currency: "usd"}}
+	if !reflect.DeepEqual(resp.Gross, want) || len(resp.Fees) != 2 || len(resp.Balance) != 2 {
+		t.Fatalf("expected totals per currency, got %s", w.Body.String())
 	}
 }
 
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type errorEnvelope struct {
+	Error struct {
+		Type      string `json:"type"`
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		Param     string `json:"param"`
+		RequestID string `json:"request_id"`
+		DocURL    string `json:"doc_url"`
+	} `json:"error"`
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) errorEnvelope {
+	t.Helper()
+	var env errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("failed to decode error envelope: %v (%s)", err, w.Body.String())
+	}
+	return env
+}
+
+func TestErrorEnvelopeMissingParam(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"currency": "usd", "customer": "cust_1"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	env := decodeError(t, w)
+	if env.Error.Type != "invalid_request_error" || env.Error.Code != "parameter_missing" || env.Error.Param != "amount" {
+		t.Fatalf("unexpected envelope %+v", env.Error)
+	}
+	if env.Error.RequestID == "" || env.Error.RequestID != w.Header().Get("X-Request-Id") {
+		t.Fatalf("expected request id in envelope and header, got %q / %q", env.Error.RequestID, w.Header().Get("X-Request-Id"))
+	}
+	if !strings.HasSuffix(env.Error.DocURL, "#parameter_missing") {
+		t.Fatalf("unexpected doc url %s", env.Error.DocURL)
+	}
+}
+
+func TestErrorEnvelopeNestedParam(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount":   100,
+		"currency": "usd",
+		"customer": "cust_1",
+		"card":     map[string]interface{}{"number": "42424242abcd4242", "exp_month": 1, "exp_year": 2030},
+	})
+
+	env := decodeError(t, w)
+	if env.Error.Code != "parameter_invalid" || env.Error.Param != "card[number]" {
+		t.Fatalf("unexpected envelope %+v", env.Error)
+	}
+	if strings.Contains(env.Error.Message, "Key:") {
+		t.Fatalf("validator message leaked: %s", env.Error.Message)
+	}
+}
+
+func TestErrorEnvelopeInvalidType(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": "ten", "currency": "usd", "customer": "cust_1"})
+	env := decodeError(t, w)
+	if env.Error.Code != "parameter_invalid" || env.Error.Param != "amount" {
+		t.Fatalf("unexpected envelope %+v", env.Error)
+	}
+}
+
+func TestErrorEnvelopeInvalidJSON(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	req, _ := http.NewRequest("POST", "/api/v1/charges", bytes.NewReader([]byte(`{"amount": 10,`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Id", "req_client_supplied")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	env := decodeError(t, w)
+	if env.Error.Code != "invalid_json" {
+		t.Fatalf("expected invalid_json, got %+v", env.Error)
+	}
+	if env.Error.RequestID != "req_client_supplied" {
+		t.Fatalf("expected client request id to be echoed, got %s", env.Error.RequestID)
+	}
+}
+
+func TestErrorEnvelopeResourceMissing(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": "txn_missing"})
+	env := decodeError(t, w)
+	if w.Code != http.StatusNotFound || env.Error.Code != "resource_missing" || env.Error.Param != "transaction_id" {
+		t.Fatalf("unexpected response %d %+v", w.Code, env.Error)
+	}
+}
+
+func TestErrorEnvelopeUnknownRoute(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "GET", "/api/v1/nope", nil)
+	env := decodeError(t, w)
+	if w.Code != http.StatusNotFound || env.Error.Code != "url_invalid" {
+		t.Fatalf("unexpected response %d %+v", w.Code, env.Error)
+	}
+}
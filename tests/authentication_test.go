@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/processor"
+)
+
+type challengedCharge struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	ThreeDSecure string `json:"three_d_secure"`
+	NextAction   *struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"next_action"`
+	Error struct {
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+	} `json:"error"`
+}
+
+func challengeCharge(t *testing.T, r *gin.Engine, number, country, returnURL string) challengedCharge {
+	t.Helper()
+	payload := map[string]interface{}{
+		"amount":   2000,
+		"currency": "usd",
+		"customer": "cust_3ds",
+		"country":  country,
+		"card":     map[string]interface{}{"number": number, "exp_month": 12, "exp_year": 2099},
+	}
+	if returnURL != "" {
+		payload["return_url"] = returnURL
+	}
+	w := doJSON(r, "POST", "/api/v1/charges", payload)
+	var charge challengedCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return charge
+}
+
+func completeChallenge(r *gin.Engine, path, result string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(url.Values{"result": {result}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func getCharge(t *testing.T, r *gin.Engine, id string) challengedCharge {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/charges/"+id, nil)
+	var charge challengedCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return charge
+}
+
+func TestThreeDSecureChallenge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	charge := challengeCharge(t, r, "4000002760003184", "US", "https://shop.example/done?order=42")
+	if charge.Status != "requires_action" || charge.ThreeDSecure != "pending" || charge.NextAction == nil ||
+		charge.NextAction.Type != "redirect_to_url" || charge.NextAction.URL != "/3ds/"+charge.ID {
+		t.Fatalf("expected the charge to wait for a challenge, got %+v", charge)
+	}
+	if got, _ := ledger.Balance(config.DB, ledger.AccountMerchantPending, "usd"); got != 0 {
+		t.Fatalf("expected nothing booked before the challenge, got %d", got)
+	}
+
+	w := doJSON(r, "GET", charge.NextAction.URL, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="authenticated"`) {
+		t.Fatalf("expected the challenge page, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = completeChallenge(r, charge.NextAction.URL, "authenticated")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://shop.example/done?charge="+charge.ID+"&order=42" {
+		t.Fatalf("expected a redirect to the return URL, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	charge = getCharge(t, r, charge.ID)
+	if charge.Status != "succeeded" || charge.ThreeDSecure != "authenticated" || charge.NextAction != nil {
+		t.Fatalf("expected the charge resumed, got %+v", charge)
+	}
+	if got, _ := ledger.Balance(config.DB, ledger.AccountMerchantPending, "usd"); got == 0 {
+		t.Fatal("expected the authenticated charge booked")
+	}
+
+	w = completeChallenge(r, "/3ds/"+charge.ID, "failed")
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "authentication_completed" {
+		t.Fatalf("expected the challenge completed once, got %d: %s", w.Code, w.Body.String())
+	}
+
+	failed := challengeCharge(t, r, "4000002760003184", "US", "")
+	w = completeChallenge(r, failed.NextAction.URL, "failed")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "failed") {
+		t.Fatalf("expected the outcome shown without a return URL, got %d: %s", w.Code, w.Body.String())
+	}
+	failed = getCharge(t, r, failed.ID)
+	if failed.Status != "failed" || failed.ThreeDSecure != "failed" || failed.Error.Code != "authentication_failed" {
+		t.Fatalf("expected a failed challenge to decline the charge, got %+v", failed)
+	}
+
+	declined := challengeCharge(t, r, "4000008260003178", "US", "")
+	completeChallenge(r, declined.NextAction.URL, "authenticated")
+	declined = getCharge(t, r, declined.ID)
+	if declined.Status != "failed" || declined.ThreeDSecure != "authenticated" || declined.Error.DeclineCode != "insufficient_funds" {
+		t.Fatalf("expected the authenticated charge declined by the issuer, got %+v", declined)
+	}
+
+	w = completeChallenge(r, "/3ds/"+charge.ID, "maybe")
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "result" {
+		t.Fatalf("expected an invalid result refused, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestThreeDSecureOnlyWhereRequired(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	if charge := challengeCharge(t, r, "4000002500003155", "US", ""); charge.Status != "succeeded" || charge.ThreeDSecure != "" {
+		t.Fatalf("expected no challenge outside the EEA, got %+v", charge)
+	}
+	if charge := challengeCharge(t, r, "4000002500003155", "DE", ""); charge.Status != "requires_action" {
+		t.Fatalf("expected strong customer authentication in the EEA, got %+v", charge)
+	}
+	if charge := challengeCharge(t, r, "4242424242424242", "DE", ""); charge.Status != "succeeded" {
+		t.Fatalf("expected other cards through, got %+v", charge)
+	}
+
+	sim := processor.NewSimulator("sim")
+	_, err := sim.Charge(processor.ChargeParams{Amount: 100, Currency: "usd", Card: processor.Card{Number: "4000002760003184"}, OffSession: true})
+	perr, ok := err.(*processor.Error)
+	if !ok || perr.DeclineCode != "authentication_required" {
+		t.Fatalf("expected off-session charges declined instead of challenged, got %v", err)
+	}
+}
+
+func TestThreeDSecureChallengeSurvivesRestart(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	charge := challengeCharge(t, r, "4000002760003184", "US", "")
+	var a models.Authentication
+	if err := config.DB.First(&a, "transaction_id = ?", charge.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if a.Challenge == "" || strings.Contains(a.Challenge, "4000002760003184") {
+		t.Fatalf("expected the challenge stored without the card number, got %q", a.Challenge)
+	}
+
+	useProcessor(t, processor.NewSimulator("simulator"))
+	completeChallenge(r, charge.NextAction.URL, "authenticated")
+	if charge = getCharge(t, r, charge.ID); charge.Status != "succeeded" || charge.ThreeDSecure != "authenticated" {
+		t.Fatalf("expected the challenge completed after a restart, got %+v", charge)
+	}
+}
+
+func TestThreeDSecureChallengeExpires(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	createCoupon(t, r, map[string]interface{}{"id": "LAUNCH", "amount_off": 200, "currency": "usd", "duration": "once", "max_redemptions": 1})
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount":   2000,
+		"currency": "usd",
+		"customer": "cust_3ds",
+		"coupon":   "LAUNCH",
+		"card":     map[string]interface{}{"number": "4000002760003184", "exp_month": 12, "exp_year": 2099},
+	})
+	var charge challengedCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.Status != "requires_action" {
+		t.Fatalf("expected the charge to wait for a challenge, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var coupon models.Coupon
+	config.DB.First(&coupon, "id = ?", "LAUNCH")
+	if coupon.TimesRedeemed != 1 {
+		t.Fatalf("expected the waiting charge to redeem the coupon, got %d", coupon.TimesRedeemed)
+	}
+
+	if n, err := payments.ExpireChallenges(config.DB, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing expired yet, got %d, %v", n, err)
+	}
+	// A charge that cannot be canceled does not hold up the others.
+	config.DB.Create(&models.Transaction{ID: "txn_broken", Amount: 2000, Status: "requires_action", ThreeDSecure: "pending", CouponID: "GONE"})
+	if n, err := payments.ExpireChallenges(config.DB, time.Now().Add(payments.ChallengeTimeout+time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected the challenge expired, got %d, %v", n, err)
+	}
+	charge = getCharge(t, r, charge.ID)
+	if charge.Status != "canceled" || charge.ThreeDSecure != "expired" || charge.NextAction != nil {
+		t.Fatalf("expected the charge canceled, got %+v", charge)
+	}
+	config.DB.First(&coupon, "id = ?", "LAUNCH")
+	if coupon.TimesRedeemed != 0 {
+		t.Fatalf("expected the coupon redemption released, got %d", coupon.TimesRedeemed)
+	}
+	if !eventTypes(charge.ID)["payment.canceled"] {
+		t.Fatal("expected a payment.canceled event")
+	}
+
+	w = completeChallenge(r, "/3ds/"+charge.ID, "authenticated")
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "authentication_completed" {
+		t.Fatalf("expected an expired challenge refused, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestThreeDSecureAbandonedAuthenticationExpires(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	charge := challengeCharge(t, r, "4000002760003184", "US", "")
+	if charge.Status != "requires_action" {
+		t.Fatalf("expected the charge to wait for a challenge, got %+v", charge)
+	}
+	// The challenge was completed but the charge was never decided, as if
+	// the server went down while asking the processor.
+	config.DB.Model(&models.Transaction{}).Where("id = ?", charge.ID).UpdateColumn("three_d_secure", "authenticated")
+
+	if n, err := payments.ExpireChallenges(config.DB, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected an authentication in progress left alone, got %d, %v", n, err)
+	}
+	if n, err := payments.ExpireChallenges(config.DB, time.Now().Add(payments.ChallengeTimeout+time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected the abandoned charge canceled, got %d, %v", n, err)
+	}
+	charge = getCharge(t, r, charge.ID)
+	if charge.Status != "canceled" || charge.ThreeDSecure != "authenticated" {
+		t.Fatalf("expected the charge canceled with its challenge outcome kept, got %+v", charge)
+	}
+	if !eventTypes(charge.ID)["payment.canceled"] {
+		t.Fatal("expected a payment.canceled event")
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type balanceTxnResp struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Amount      int64  `json:"amount"`
+	Fee         int64  `json:"fee"`
+	Net         int64  `json:"net"`
+	Currency    string `json:"currency"`
+	AvailableOn string `json:"available_on"`
+}
+
+func listBalanceTransactions(t *testing.T, r *gin.Engine, query string) []balanceTxnResp {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/balance_transactions"+query, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list struct {
+		Data []balanceTxnResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	return list.Data
+}
+
+func TestBalanceTransactionsTraceBalance(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+
+	w := chargeWithCard(r, "4242424242424242")
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	settleFunds(t)
+	p := createPayout(t, r, 300)
+	doJSON(r, "POST", "/api/v1/payouts/"+p.ID+"/cancel", nil)
+	doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge["id"]})
+	disputedCharge(t, r)
+	w = doJSON(r, "POST", "/api/v1/balance_adjustments", map[string]interface{}{"amount": -25, "currency": "usd", "description": "Chargeback correction"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	settleFunds(t)
+
+	entries := listBalanceTransactions(t, r, "?limit=100")
+	types := map[string]int{}
+	var net int64
+	for _, e := range entries {
+		types[e.Type]++
+		net += e.Net
+		if e.Amount-e.Fee != e.Net || e.AvailableOn == "" {
+			t.Errorf("inconsistent entry %+v", e)
+		}
+	}
+	for typ, n := range map[string]int{"charge": 2, "refund": 1, "payout": 1, "payout_cancel": 1, "dispute": 1, "fee": 1, "adjustment": 1} {
+		if types[typ] != n {
+			t.Errorf("expected %d %s entries, got %v", n, typ, types)
+		}
+	}
+	if net != availableUSD(t) {
+		t.Fatalf("expected the entries to add up to the available balance %d, got %d", availableUSD(t), net)
+	}
+
+	bySource := listBalanceTransactions(t, r, "?source="+charge["id"].(string))
+	if len(bySource) != 1 || bySource[0].Type != "charge" || bySource[0].Net != chargeNet {
+		t.Fatalf("unexpected entries for the charge %+v", bySource)
+	}
+	w = doJSON(r, "GET", "/api/v1/balance_transactions/"+bySource[0].ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/balance_transactions?type=bogus", nil)
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "type" {
+		t.Fatalf("expected an invalid type to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+	"github.com/vaidikcode/minipay/risk"
+)
+
+// chargeFrom charges amount to customer from a client at ip.
+func chargeFrom(r *gin.Engine, ip, customer string, amount int64) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"amount": amount, "currency": "usd", "customer": customer})
+	req, _ := http.NewRequest("POST", "/api/v1/charges", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func declineCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var charge riskCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if w.Code != http.StatusPaymentRequired {
+		return ""
+	}
+	return charge.Error.DeclineCode
+}
+
+func blocklist(t *testing.T, r *gin.Engine, typ, value string) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/blocklist_entries", map[string]interface{}{"type": typ, "value": value})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var entry struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &entry)
+	return entry.ID
+}
+
+func velocityRule(t *testing.T, r *gin.Engine, payload map[string]interface{}) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/velocity_rules", payload)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	rangeID := blocklist(t, r, "ip", "203.0.113.0/24")
+	w := chargeFrom(r, "203.0.113.9", "cust_ip", 1000)
+	if got := declineCode(t, w); got != "merchant_blacklist" {
+		t.Fatalf("expected the IP range blocked, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := chargeFrom(r, "198.51.100.1", "cust_ip", 1000); w.Code != http.StatusCreated {
+		t.Fatalf("expected other addresses through, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/blocklist_entries/"+rangeID+"/disable", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := chargeFrom(r, "203.0.113.9", "cust_ip", 1000); w.Code != http.StatusCreated {
+		t.Fatalf("expected the disabled entry to be skipped, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": "cust_mail", "email": "Fraud@Example.com"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	blocklist(t, r, "email", "fraud@example.COM")
+	if got := declineCode(t, chargeFrom(r, "198.51.100.1", "cust_mail", 1000)); got != "merchant_blacklist" {
+		t.Fatalf("expected the email blocked, got %q", got)
+	}
+
+	w = chargeWithCard(r, "4242424242424242")
+	var charge struct {
+		CardFingerprint string `json:"card_fingerprint"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.CardFingerprint == "" {
+		t.Fatalf("expected the charge to carry a card fingerprint, got %s", w.Body.String())
+	}
+	cardID := blocklist(t, r, "card_fingerprint", charge.CardFingerprint)
+	w = chargeWithCard(r, "4242424242424242")
+	var blocked riskCharge
+	json.Unmarshal(w.Body.Bytes(), &blocked)
+	if blocked.Error.DeclineCode != "merchant_blacklist" || len(blocked.Risk.Rules) != 1 || blocked.Risk.Rules[0] != cardID {
+		t.Fatalf("expected the card blocked by its entry, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/blocklist_entries", map[string]interface{}{"type": "card_fingerprint", "value": charge.CardFingerprint})
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "resource_already_exists" {
+		t.Fatalf("expected a duplicate entry to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/blocklist_entries", map[string]interface{}{"type": "ip", "value": "300.1.1.1"})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "value" {
+		t.Fatalf("expected an invalid address to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCardFingerprintIsKeyed(t *testing.T) {
+	const number = "4242424242424242"
+	unkeyed := sha256.Sum256([]byte("minipay:" + number))
+	dev := processor.Fingerprint(number)
+	if dev == "" || dev == hex.EncodeToString(unkeyed[:8]) {
+		t.Fatalf("expected a keyed fingerprint, got %q", dev)
+	}
+
+	t.Cleanup(func() { processor.LoadFingerprintKey() })
+	t.Setenv("CARD_FINGERPRINT_KEY", "s3cret")
+	if !processor.LoadFingerprintKey() {
+		t.Fatal("expected CARD_FINGERPRINT_KEY to be loaded")
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(number))
+	if got := processor.Fingerprint(number); got == dev || got != hex.EncodeToString(mac.Sum(nil)[:8]) {
+		t.Fatalf("expected the fingerprint to change with the key, got %q", got)
+	}
+}
+
+func TestBlocklistIgnoresSpoofedForwardedFor(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	blocklist(t, r, "ip", "203.0.113.0/24")
+
+	forwarded := func(r *gin.Engine, remote, forwardedFor string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"amount": 1000, "currency": "usd", "customer": "cust_ip"})
+		req, _ := http.NewRequest("POST", "/api/v1/charges", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.RemoteAddr = remote + ":40000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := forwarded(r, "203.0.113.9", "198.51.100.1")
+	if w.Code != http.StatusPaymentRequired || declineCode(t, w) != "merchant_blacklist" {
+		t.Fatalf("expected a spoofed X-Forwarded-For to be ignored, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1")
+	r = setupTestRouter()
+	w = forwarded(r, "10.0.0.1", "203.0.113.9")
+	if w.Code != http.StatusPaymentRequired || declineCode(t, w) != "merchant_blacklist" {
+		t.Fatalf("expected the client IP from a trusted proxy, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVelocityLimitsCharges(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	velocityRule(t, r, map[string]interface{}{"event": "charge", "scope": "customer", "metric": "count", "limit": 2, "window_seconds": 3600})
+	velocityRule(t, r, map[string]interface{}{"event": "charge", "scope": "ip", "metric": "amount", "currency": "usd", "limit": 10000, "window_seconds": 86400})
+
+	for i := 0; i < 2; i++ {
+		if w := chargeFrom(r, "198.51.100.1", "cust_fast", 100); w.Code != http.StatusCreated {
+			t.Fatalf("expected charge %d through, got %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	if got := declineCode(t, chargeFrom(r, "198.51.100.1", "cust_fast", 100)); got != "card_velocity_exceeded" {
+		t.Fatalf("expected the third charge in an hour refused, got %q", got)
+	}
+	if w := chargeFrom(r, "198.51.100.1", "cust_slow", 100); w.Code != http.StatusCreated {
+		t.Fatalf("expected other customers through, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := chargeFrom(r, "192.0.2.7", "cust_a", 6000); w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := declineCode(t, chargeFrom(r, "192.0.2.7", "cust_b", 5000)); got != "card_velocity_exceeded" {
+		t.Fatalf("expected the address's daily amount capped, got %q", got)
+	}
+	if w := chargeFrom(r, "192.0.2.7", "cust_c", 4000); w.Code != http.StatusCreated {
+		t.Fatalf("expected the rest of the amount through, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Events that have left the window no longer count, and are pruned.
+	config.DB.Model(&models.VelocityEvent{}).Where("scope = ? AND value = ?", risk.ScopeCustomer, "cust_fast").
+		Update("created_at", time.Now().Add(-2*time.Hour))
+	if w := chargeFrom(r, "198.51.100.1", "cust_fast", 100); w.Code != http.StatusCreated {
+		t.Fatalf("expected the window to have slid, got %d: %s", w.Code, w.Body.String())
+	}
+	config.DB.Model(&models.VelocityEvent{}).Where("scope = ? AND value = ?", risk.ScopeCustomer, "cust_fast").
+		Update("created_at", time.Now().Add(-risk.MaxWindow-time.Hour))
+	if n, err := risk.PruneVelocity(config.DB, time.Now()); err != nil || n != 3 {
+		t.Fatalf("expected the three old events pruned, got %d, %v", n, err)
+	}
+}
+
+func TestVelocityLimitsRefunds(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	velocityRule(t, r, map[string]interface{}{"event": "refund", "scope": "merchant", "metric": "count", "limit": 1, "window_seconds": 86400})
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		w := chargeFrom(r, "198.51.100.1", "cust_refund", 1000)
+		var charge riskCharge
+		json.Unmarshal(w.Body.Bytes(), &charge)
+		ids = append(ids, charge.ID)
+	}
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": ids[0]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": ids[1]})
+	if e := decodeError(t, w); w.Code != http.StatusTooManyRequests || e.Error.Code != "velocity_limit_exceeded" {
+		t.Fatalf("expected the second refund of the day refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "GET", "/api/v1/charges/"+ids[1], nil)
+	var charge riskCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.Status != "succeeded" {
+		t.Fatalf("expected the refused refund rolled back, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/velocity_rules", map[string]interface{}{"event": "charge", "scope": "card_fingerprint", "metric": "amount", "limit": 100, "window_seconds": 3600})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "currency" {
+		t.Fatalf("expected amount rules to need a currency, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+)
+
+func doJSON(r *gin.Engine, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+	req, _ := http.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func chargeWithCard(r *gin.Engine, number string) *httptest.ResponseRecorder {
+	return doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount":   1500,
+		"currency": "usd",
+		"customer": "cust_card",
+		"card": map[string]interface{}{
+			"number":    number,
+			"exp_month": 12,
+			"exp_year":  2099,
+			"cvc":       "123",
+		},
+	})
+}
+
+func TestChargeSucceedsWithTestCard(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := chargeWithCard(r, "4242424242424242")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp["status"] != "succeeded" {
+		t.Fatalf("expected status succeeded, got %v", resp["status"])
+	}
+	if resp["card_brand"] != "visa" || resp["card_last4"] != "4242" {
+		t.Fatalf("expected visa 4242, got %v %v", resp["card_brand"], resp["card_last4"])
+	}
+	if resp["error"] != nil {
+		t.Fatalf("expected no error object, got %v", resp["error"])
+	}
+}
+
+func TestChargeDeclineCodes(t *testing.T) {
+	cases := []struct {
+		number      string
+		code        string
+		declineCode string
+	}{
+		{"4000000000000002", "card_declined", "generic_decline"},
+		{"4000000000009995", "insufficient_funds", "insufficient_funds"},
+		{"4000000000000069", "expired_card", "expired_card"},
+	}
+
+	for _, tc := range cases {
+		setupTestDB(t)
+		r := setupTestRouter()
+
+		w := chargeWithCard(r, tc.number)
+		if w.Code != http.StatusPaymentRequired {
+			t.Fatalf("%s: expected status 402, got %d", tc.number, w.Code)
+		}
+
+		var resp struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  struct {
+				Type        string `json:"type"`
+				Code        string `json:"code"`
+				DeclineCode string `json:"decline_code"`
+				Message     string `json:"message"`
+				RequestID   string `json:"request_id"`
+				DocURL      string `json:"doc_url"`
+			} `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		if resp.Status != "failed" {
+			t.Fatalf("%s: expected status failed, got %s", tc.number, resp.Status)
+		}
+		if resp.Error.Type != "card_error" || resp.Error.Code != tc.code || resp.Error.DeclineCode != tc.declineCode {
+			t.Fatalf("%s: unexpected error object %+v", tc.number, resp.Error)
+		}
+		if resp.Error.Message == "" {
+			t.Fatalf("%s: expected error message", tc.number)
+		}
+		if resp.Error.RequestID == "" || resp.Error.RequestID != w.Header().Get("X-Request-Id") || !strings.HasSuffix(resp.Error.DocURL, "#declined-charges") {
+			t.Fatalf("%s: expected the request ID and doc URL of the envelope, got %+v", tc.number, resp.Error)
+		}
+
+		var txn models.Transaction
+		config.DB.First(&txn, "id = ?", resp.ID)
+		if txn.Status != "failed" || txn.FailureCode != tc.code {
+			t.Fatalf("%s: expected stored failure, got status=%s code=%s", tc.number, txn.Status, txn.FailureCode)
+		}
+
+		var event models.WebhookEvent
+		if err := config.DB.First(&event, "transaction_id = ?", resp.ID).Error; err != nil {
+			t.Fatalf("%s: expected webhook event: %v", tc.number, err)
+		}
+		if event.EventType != "charge.failed" {
+			t.Fatalf("%s: expected charge.failed event, got %s", tc.number, event.EventType)
+		}
+	}
+}
+
+func TestChargeExpiredByDate(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount":   1500,
+		"currency": "usd",
+		"customer": "cust_card",
+		"card": map[string]interface{}{
+			"number":    "4242424242424242",
+			"exp_month": 1,
+			"exp_year":  2001,
+		},
+	})
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d", w.Code)
+	}
+}
+
+func TestFailedChargeNotRefundable(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := chargeWithCard(r, "4000000000000002")
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": resp["id"]})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+}
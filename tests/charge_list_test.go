@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+)
+
+type chargeList struct {
+	Object  string `json:"object"`
+	HasMore bool   `json:"has_more"`
+	Data    []struct {
+		ID       string `json:"id"`
+		Amount   int64  `json:"amount"`
+		Customer string `json:"customer"`
+		Status   string `json:"status"`
+	} `json:"data"`
+}
+
+func seedTransactions(t *testing.T, n int) []string {
+	t.Helper()
+	base := time.Now().Add(-time.Hour)
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		status := "succeeded"
+		if i%3 == 0 {
+			status = "failed"
+		}
+		txn := models.Transaction{
+			ID:        fmt.Sprintf("txn_list_%02d", i),
+			Amount:    int64(100 * (i + 1)),
+			Currency:  "usd",
+			Customer:  fmt.Sprintf("cust_%d", i%2),
+			Status:    status,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := config.DB.Create(&txn).Error; err != nil {
+			t.Fatalf("failed to seed transaction: %v", err)
+		}
+		ids = append(ids, txn.ID)
+	}
+	return ids
+}
+
+func listCharges(t *testing.T, query string) chargeList {
+	t.Helper()
+	r := setupTestRouter()
+	w := doJSON(r, "GET", "/api/v1/charges"+query, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for %s, got %d: %s", query, w.Code, w.Body.String())
+	}
+	var list chargeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	return list
+}
+
+func TestGetCharge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	seedTransactions(t, 1)
+
+	w := doJSON(r, "GET", "/api/v1/charges/txn_list_00", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/charges/txn_missing", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestListChargesCursorPagination(t *testing.T) {
+	setupTestDB(t)
+	ids := seedTransactions(t, 7)
+
+	page1 := listCharges(t, "?limit=3")
+	if page1.Object != "list" || len(page1.Data) != 3 || !page1.HasMore {
+		t.Fatalf("unexpected first page %+v", page1)
+	}
+	if page1.Data[0].ID != ids[6] || page1.Data[2].ID != ids[4] {
+		t.Fatalf("expected newest first, got %s..%s", page1.Data[0].ID, page1.Data[2].ID)
+	}
+
+	page2 := listCharges(t, "?limit=3&starting_after="+page1.Data[2].ID)
+	if len(page2.Data) != 3 || page2.Data[0].ID != ids[3] || !page2.HasMore {
+		t.Fatalf("unexpected second page %+v", page2)
+	}
+
+	page3 := listCharges(t, "?limit=3&starting_after="+page2.Data[2].ID)
+	if len(page3.Data) != 1 || page3.Data[0].ID != ids[0] || page3.HasMore {
+		t.Fatalf("unexpected last page %+v", page3)
+	}
+
+	back := listCharges(t, "?limit=3&ending_before="+page3.Data[0].ID)
+	if len(back.Data) != 3 || back.Data[0].ID != ids[3] || back.Data[2].ID != ids[1] || !back.HasMore {
+		t.Fatalf("unexpected ending_before page %+v", back)
+	}
+}
+
+func TestListChargesFilters(t *testing.T) {
+	setupTestDB(t)
+	seedTransactions(t, 9)
+
+	list := listCharges(t, "?status=failed")
+	if len(list.Data) != 3 {
+		t.Fatalf("expected 3 failed charges, got %d", len(list.Data))
+	}
+
+	list = listCharges(t, "?customer=cust_1&limit=100")
+	for _, d := range list.Data {
+		if d.Customer != "cust_1" {
+			t.Fatalf("unexpected customer %s", d.Customer)
+		}
+	}
+	if len(list.Data) != 4 {
+		t.Fatalf("expected 4 charges for cust_1, got %d", len(list.Data))
+	}
+
+	list = listCharges(t, "?amount[gte]=300&amount[lte]=500")
+	if len(list.Data) != 3 {
+		t.Fatalf("expected 3 charges in amount range, got %d", len(list.Data))
+	}
+
+	since := time.Now().Add(-time.Hour).Add(150 * time.Second).Unix()
+	list = listCharges(t, fmt.Sprintf("?created[gte]=%d&limit=100", since))
+	if len(list.Data) != 6 {
+		t.Fatalf("expected 6 charges created after cutoff, got %d", len(list.Data))
+	}
+}
+
+func TestListChargesByStatus(t *testing.T) {
+	setupTestDB(t)
+	statuses := []string{"pending", "requires_action", "requires_capture", "succeeded", "failed", "refunded", "canceled"}
+	for i, status := range statuses {
+		txn := models.Transaction{ID: fmt.Sprintf("txn_status_%d", i), Amount: 1000, Currency: "usd", Customer: "cust_status", Status: status}
+		if err := config.DB.Create(&txn).Error; err != nil {
+			t.Fatalf("failed to seed transaction: %v", err)
+		}
+	}
+
+	for _, status := range statuses {
+		list := listCharges(t, "?status="+status)
+		if len(list.Data) != 1 || list.Data[0].Status != status {
+			t.Fatalf("expected one %s charge, got %+v", status, list.Data)
+		}
+	}
+}
+
+func TestListChargesInvalidParams(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "GET", "/api/v1/charges?limit=500", nil)
+	env := decodeError(t, w)
+	if w.Code != http.StatusBadRequest || env.Error.Param != "limit" {
+		t.Fatalf("unexpected response %d %+v", w.Code, env.Error)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/charges?starting_after=txn_missing", nil)
+	env = decodeError(t, w)
+	if w.Code != http.StatusNotFound || env.Error.Param != "starting_after" {
+		t.Fatalf("unexpected response %d %+v", w.Code, env.Error)
+	}
+}
@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/transfers"
+)
+
+type transferResp struct {
+	ID             string `json:"id"`
+	Destination    string `json:"destination"`
+	Amount         int64  `json:"amount"`
+	AmountReversed int64  `json:"amount_reversed"`
+	Reversals      []struct {
+		Amount int64  `json:"amount"`
+		Refund string `json:"refund"`
+	} `json:"reversals"`
+}
+
+// marketplace makes acct_default a platform paying out at once, with a
+// connected account for each of sellers.
+func marketplace(t *testing.T, r *gin.Engine, sellers ...string) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/merchants/acct_default", map[string]interface{}{"settlement_delay_days": 0})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, id := range sellers {
+		w := doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": id, "platform": "acct_default"})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func splitCharge(t *testing.T, r *gin.Engine, payload map[string]interface{}) string {
+	t.Helper()
+	payload["amount"], payload["currency"], payload["customer"] = 10000, "usd", "cust_market"
+	w := doJSON(r, "POST", "/api/v1/charges", payload)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return charge.ID
+}
+
+func chargeTransfers(t *testing.T, r *gin.Engine, chargeID string) []transferResp {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/transfers?charge="+chargeID, nil)
+	var list struct {
+		Data []transferResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	return list.Data
+}
+
+func merchantAvailable(t *testing.T, r *gin.Engine, merchantID string) int64 {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/merchants/"+merchantID+"/balance", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var b struct {
+		Available []struct {
+			Amount   int64  `json:"amount"`
+			Currency string `json:"currency"`
+		} `json:"available"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &b)
+	for _, a := range b.Available {
+		if a.Currency == "usd" {
+			return a.Amount
+		}
+	}
+	return 0
+}
+
+func TestDestinationChargeWithApplicationFee(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	marketplace(t, r, "acct_seller")
+
+	chargeID := splitCharge(t, r, map[string]interface{}{
+		"transfer_data":          map[string]interface{}{"destination": "acct_seller"},
+		"application_fee_amount": 1000,
+	})
+	list := chargeTransfers(t, r, chargeID)
+	if len(list) != 1 || list[0].Destination != "acct_seller" || list[0].Amount != 9000 {
+		t.Fatalf("expected 90.00 sent to the seller, got %+v", list)
+	}
+	if got := merchantAvailable(t, r, "acct_seller"); got != 9000 {
+		t.Fatalf("expected the seller to hold 9000, got %d", got)
+	}
+	// The platform keeps the application fee and pays the 2.9% + 30 fee.
+	if got := merchantAvailable(t, r, "acct_default"); got != 1000-320 {
+		t.Fatalf("expected the platform to hold 680, got %d", got)
+	}
+
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": chargeID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	list = chargeTransfers(t, r, chargeID)
+	if list[0].AmountReversed != 9000 || len(list[0].Reversals) != 1 || list[0].Reversals[0].Refund == "" {
+		t.Fatalf("expected the transfer reversed with the refund, got %+v", list[0])
+	}
+	if got := merchantAvailable(t, r, "acct_seller"); got != 0 {
+		t.Fatalf("expected the seller to give the transfer back, got %d", got)
+	}
+	if got := merchantAvailable(t, r, "acct_default"); got != -320 {
+		t.Fatalf("expected the platform to be left with the kept fee, got %d", got)
+	}
+}
+
+func TestSplitsReversedProportionally(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	marketplace(t, r, "acct_books", "acct_music")
+
+	chargeID := splitCharge(t, r, map[string]interface{}{
+		"splits": []map[string]interface{}{
+			{"account": "acct_books", "amount": 3000},
+			{"account": "acct_music", "amount": 5000},
+		},
+	})
+	w := doJSON(r, "GET", "/api/v1/charges/"+chargeID, nil)
+	var charge struct {
+		ApplicationFeeAmount int64 `json:"application_fee_amount"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.ApplicationFeeAmount != 2000 {
+		t.Fatalf("expected the platform to keep 2000, got %s", w.Body.String())
+	}
+
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", chargeID)
+	if err := transfers.Reverse(config.DB, &txn, &models.Refund{ID: "re_quarter", Amount: 2500}); err != nil {
+		t.Fatal(err)
+	}
+	if books, music := merchantAvailable(t, r, "acct_books"), merchantAvailable(t, r, "acct_music"); books != 2250 || music != 3750 {
+		t.Fatalf("expected a quarter of each split back, got %d and %d", books, music)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": chargeID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, tr := range chargeTransfers(t, r, chargeID) {
+		if tr.AmountReversed != tr.Amount || len(tr.Reversals) != 2 {
+			t.Fatalf("expected the rest reversed by the full refund, got %+v", tr)
+		}
+	}
+	if books, music := merchantAvailable(t, r, "acct_books"), merchantAvailable(t, r, "acct_music"); books != 0 || music != 0 {
+		t.Fatalf("expected both sellers back at zero, got %d and %d", books, music)
+	}
+}
+
+func TestSplitValidation(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	marketplace(t, r, "acct_seller")
+	config.DB.Create(&models.Merchant{ID: "acct_other", PricingPlanID: "plan_standard"})
+
+	for _, tc := range []struct {
+		payload map[string]interface{}
+		param   string
+	}{
+		{map[string]interface{}{"splits": []map[string]interface{}{{"account": "acct_seller", "amount": 10001}}}, "splits"},
+		{map[string]interface{}{"splits": []map[string]interface{}{{"account": "acct_other", "amount": 100}}}, "splits[0][account]"},
+		{map[string]interface{}{"transfer_data": map[string]interface{}{"destination": "acct_seller"}, "application_fee_amount": 10000}, "application_fee_amount"},
+		{map[string]interface{}{"transfer_data": map[string]interface{}{"destination": "acct_seller", "amount": 500}, "application_fee_amount": 100}, "application_fee_amount"},
+		{map[string]interface{}{"application_fee_amount": 100}, "application_fee_amount"},
+	} {
+		tc.payload["amount"], tc.payload["currency"], tc.payload["customer"] = 10000, "usd", "cust_market"
+		w := doJSON(r, "POST", "/api/v1/charges", tc.payload)
+		if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != tc.param {
+			t.Fatalf("expected %s to be rejected, got %d: %s", tc.param, w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": "acct_nested", "platform": "acct_seller"})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "platform" {
+		t.Fatalf("expected connected accounts not to have their own, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMerchantsMustBeConnectedAccounts(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": "acct_top"})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Code != "parameter_missing" || e.Error.Param != "platform" {
+		t.Fatalf("expected a merchant without a platform to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": "acct_shop", "platform": "acct_default", "settlement_delay_days": 0})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": 1000, "currency": "usd", "customer": "cust_shop", "merchant": "acct_shop"})
+	if shop, platform := merchantAvailable(t, r, "acct_shop"), merchantAvailable(t, r, "acct_default"); shop != 1000-59 || platform != 0 {
+		t.Fatalf("expected the charge in the merchant's own balance, got %d and the platform %d", shop, platform)
+	}
+}
+
+func TestConnectedAccountPayout(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	marketplace(t, r, "acct_seller")
+	splitCharge(t, r, map[string]interface{}{"transfer_data": map[string]interface{}{"destination": "acct_seller", "amount": 6000}})
+
+	w := doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency": "usd", "country": "US", "account_holder_name": "Seller",
+		"routing_number": "110000000", "account_number": "000123456789", "merchant": "acct_seller",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	platformBA := usBankAccount(t, r, "000123456789")
+
+	w = doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 1000, "currency": "usd", "merchant": "acct_seller", "bank_account": platformBA})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "bank_account" {
+		t.Fatalf("expected the platform's bank account to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 6001, "currency": "usd", "merchant": "acct_seller"})
+	if e := decodeError(t, w); w.Code != http.StatusPaymentRequired || e.Error.Code != "balance_insufficient" {
+		t.Fatalf("expected more than the seller holds to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 6000, "currency": "usd", "merchant": "acct_seller"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var payout struct {
+		Merchant string `json:"merchant"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &payout)
+	if payout.Merchant != "acct_seller" {
+		t.Fatalf("expected the payout to name the seller, got %s", w.Body.String())
+	}
+	if got := merchantAvailable(t, r, "acct_seller"); got != 0 {
+		t.Fatalf("expected the seller's balance paid out, got %d", got)
+	}
+	// 100.00 less the 3.20 fee and the seller's 60.00.
+	if got := merchantAvailable(t, r, "acct_default"); got != 10000-320-6000 {
+		t.Fatalf("expected the platform's balance untouched, got %d", got)
+	}
+}
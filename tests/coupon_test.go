@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/discounts"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+)
+
+type discountResp struct {
+	Coupon        string `json:"coupon"`
+	PromotionCode string `json:"promotion_code"`
+	Amount        int64  `json:"amount"`
+}
+
+func createCoupon(t *testing.T, r *gin.Engine, body map[string]interface{}) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/coupons", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func createPromotionCode(t *testing.T, r *gin.Engine, body map[string]interface{}) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/promotion_codes", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var p struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return p.ID
+}
+
+func discountedCharge(r *gin.Engine, amount int64, customer string, discount map[string]interface{}) *httptest.ResponseRecorder {
+	body := map[string]interface{}{
+		"amount":   amount,
+		"currency": "usd",
+		"customer": customer,
+		"card":     map[string]interface{}{"number": "4242424242424242", "exp_month": 12, "exp_year": 2099},
+	}
+	for k, v := range discount {
+		body[k] = v
+	}
+	return doJSON(r, "POST", "/api/v1/charges", body)
+}
+
+func TestCouponDiscountsCharge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	createCoupon(t, r, map[string]interface{}{"id": "SPRING25", "percent_off_bps": 2500, "duration": "once"})
+
+	w := discountedCharge(r, 1500, "cust_card", map[string]interface{}{"coupon": "SPRING25"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge struct {
+		ID       string        `json:"id"`
+		Amount   int64         `json:"amount"`
+		Fee      int64         `json:"fee"`
+		Discount *discountResp `json:"discount"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.Amount != 1125 || charge.Discount == nil || charge.Discount.Coupon != "SPRING25" || charge.Discount.Amount != 375 {
+		t.Fatalf("expected 375 off, got %s", w.Body.String())
+	}
+
+	entries := listBalanceTransactions(t, r, "?source="+charge.ID)
+	var net int64
+	var discount *balanceTxnResp
+	for i, e := range entries {
+		net += e.Net
+		if e.Type == "discount" {
+			discount = &entries[i]
+		}
+	}
+	if discount == nil || discount.Amount != -375 || net != 1125-charge.Fee {
+		t.Fatalf("expected an itemized discount netting to the amount charged, got %+v", entries)
+	}
+	if bal, _ := ledger.Balance(config.DB, ledger.AccountDiscounts, "usd"); bal != 0 {
+		t.Errorf("expected the discounts account to net to zero, got %d", bal)
+	}
+
+	var evt models.WebhookEvent
+	config.DB.Where("transaction_id = ? AND event_type = ?", charge.ID, "payment.succeeded").First(&evt)
+	var payload struct {
+		Discount discountResp `json:"discount"`
+	}
+	json.Unmarshal([]byte(evt.Payload), &payload)
+	if payload.Discount.Amount != 375 {
+		t.Errorf("expected the discount in the webhook payload, got %s", evt.Payload)
+	}
+
+	createCoupon(t, r, map[string]interface{}{"id": "TENEUR", "amount_off": 1000, "currency": "eur", "duration": "once"})
+	w = discountedCharge(r, 1500, "cust_card", map[string]interface{}{"coupon": "TENEUR"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Code != "coupon_not_redeemable" || env.Error.Param != "coupon" {
+		t.Fatalf("expected a eur coupon to be rejected on a usd charge, got %d: %s", w.Code, w.Body.String())
+	}
+	createCoupon(t, r, map[string]interface{}{"id": "TENUSD", "amount_off": 1000, "currency": "usd", "duration": "once"})
+	w = discountedCharge(r, 1000, "cust_card", map[string]interface{}{"coupon": "TENUSD"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "amount" {
+		t.Fatalf("expected a charge with nothing left to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = discountedCharge(r, 1500, "cust_card", map[string]interface{}{"coupon": "TENUSD", "card": map[string]interface{}{
+		"number": "4000000000000002", "exp_month": 12, "exp_year": 2099,
+	}})
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected status 402, got %d: %s", w.Code, w.Body.String())
+	}
+	var coupon models.Coupon
+	config.DB.First(&coupon, "id = ?", "TENUSD")
+	if coupon.TimesRedeemed != 0 {
+		t.Errorf("expected a declined charge not to redeem the coupon, got %d", coupon.TimesRedeemed)
+	}
+}
+
+func TestPromotionCodeRestrictions(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	createCoupon(t, r, map[string]interface{}{"id": "VIP", "percent_off_bps": 1000, "duration": "forever"})
+	createPromotionCode(t, r, map[string]interface{}{"coupon": "VIP", "code": "vipjo", "customer": "cus_jo"})
+	past := time.Now().Add(-time.Hour)
+	createPromotionCode(t, r, map[string]interface{}{"coupon": "VIP", "code": "LASTWEEK", "expires_at": past})
+	promo := createPromotionCode(t, r, map[string]interface{}{"coupon": "VIP", "code": "RETIRED"})
+	doJSON(r, "POST", "/api/v1/promotion_codes/"+promo+"/disable", nil)
+
+	w := doJSON(r, "POST", "/api/v1/promotion_codes", map[string]interface{}{"coupon": "VIP", "code": "VIPJO"})
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Param != "code" {
+		t.Fatalf("expected codes to be unique regardless of case, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := discountedCharge(r, 1000, "cus_jo", map[string]interface{}{"promotion_code": "VIPJO"}); w.Code != http.StatusCreated {
+		t.Fatalf("expected the code to work for its customer, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, tc := range []struct {
+		customer, code string
+	}{
+		{"cus_other", "VIPJO"},
+		{"cus_jo", "LASTWEEK"},
+		{"cus_jo", "RETIRED"},
+	} {
+		w := discountedCharge(r, 1000, tc.customer, map[string]interface{}{"promotion_code": tc.code})
+		if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Code != "coupon_not_redeemable" || env.Error.Param != "promotion_code" {
+			t.Errorf("expected %s to be rejected for %s, got %d: %s", tc.code, tc.customer, w.Code, w.Body.String())
+		}
+	}
+	w = discountedCharge(r, 1000, "cus_jo", map[string]interface{}{"promotion_code": "NOPE"})
+	if env := decodeError(t, w); w.Code != http.StatusNotFound || env.Error.Param != "promotion_code" {
+		t.Fatalf("expected an unknown code to be missing, got %d: %s", w.Code, w.Body.String())
+	}
+
+	createCoupon(t, r, map[string]interface{}{"id": "GONE", "percent_off_bps": 1000, "duration": "once", "redeem_by": past})
+	w = discountedCharge(r, 1000, "cus_jo", map[string]interface{}{"coupon": "GONE"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Code != "coupon_not_redeemable" {
+		t.Fatalf("expected an expired coupon to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/coupons", map[string]interface{}{"percent_off_bps": 1000, "duration": "repeating"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "duration_in_months" {
+		t.Fatalf("expected repeating coupons to need a duration, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPromotionCodeRedemptionLimit(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	createCoupon(t, r, map[string]interface{}{"id": "LAUNCH", "amount_off": 200, "currency": "usd", "duration": "once"})
+	createPromotionCode(t, r, map[string]interface{}{"coupon": "LAUNCH", "code": "FIRST3", "max_redemptions": 3})
+
+	d, err := discounts.Lookup(config.DB, "", "first3", "cus_any", "usd", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	redeemed, rejected := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := discounts.Redeem(config.DB, d)
+			var notRedeemable *discounts.NotRedeemableError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				redeemed++
+			case errors.As(err, &notRedeemable):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 3 || rejected != 7 {
+		t.Fatalf("expected exactly 3 redemptions, got %d (%d rejected)", redeemed, rejected)
+	}
+
+	w := discountedCharge(r, 1000, "cus_late", map[string]interface{}{"promotion_code": "FIRST3"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Code != "coupon_not_redeemable" {
+		t.Fatalf("expected a fully redeemed code to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	var coupon models.Coupon
+	config.DB.First(&coupon, "id = ?", "LAUNCH")
+	if coupon.TimesRedeemed != 3 {
+		t.Fatalf("expected the coupon to count 3 redemptions, got %d", coupon.TimesRedeemed)
+	}
+}
+
+func TestInvoiceCouponDiscountsBeforeTax(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_acme", "4242424242424242")
+	createCoupon(t, r, map[string]interface{}{"id": "TENOFF", "percent_off_bps": 1000, "duration": "once"})
+
+	inv := draftInvoice(t, r, map[string]interface{}{"coupon": "TENOFF"})
+	if inv.Subtotal != 8500 || inv.Tax != 675 || inv.Total != 8325 {
+		t.Fatalf("expected tax on the discounted lines, got %+v", inv)
+	}
+	var resp struct {
+		Discount discountResp `json:"discount"`
+		Lines    []struct {
+			DiscountAmount int64 `json:"discount_amount"`
+		} `json:"lines"`
+	}
+	w := doJSON(r, "GET", "/api/v1/invoices/"+inv.ID, nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Discount.Amount != 850 || resp.Lines[0].DiscountAmount != 750 || resp.Lines[1].DiscountAmount != 100 {
+		t.Fatalf("expected the discount spread over the lines, got %s", w.Body.String())
+	}
+
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", inv.Transaction)
+	if inv.Status != "paid" || txn.Amount != 8325 || txn.DiscountAmount != 850 || txn.CouponID != "TENOFF" {
+		t.Fatalf("expected the discounted total to be charged, got %+v / %+v", inv, txn)
+	}
+	var coupon models.Coupon
+	config.DB.First(&coupon, "id = ?", "TENOFF")
+	if coupon.TimesRedeemed != 1 {
+		t.Fatalf("expected finalizing to redeem the coupon, got %d", coupon.TimesRedeemed)
+	}
+}
+
+func TestSubscriptionCouponDuration(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, nil)
+	createCoupon(t, r, map[string]interface{}{"id": "HALF1", "percent_off_bps": 5000, "duration": "once"})
+	createCoupon(t, r, map[string]interface{}{"id": "THREEOFF", "amount_off": 300, "currency": "usd", "duration": "repeating", "duration_in_months": 2})
+
+	once := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price, "coupon": "HALF1"})
+	repeating := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price, "coupon": "THREEOFF"})
+
+	charged := func(s subscriptionResp) int64 {
+		var txn models.Transaction
+		config.DB.First(&txn, "id = ?", s.LatestTransaction)
+		return txn.Amount
+	}
+	got := [][2]int64{{charged(once), charged(repeating)}}
+	for month := 1; month <= 2; month++ {
+		renewAt(t, time.Now().AddDate(0, month, 1))
+		got = append(got, [2]int64{charged(getSubscription(t, r, once.ID)), charged(getSubscription(t, r, repeating.ID))})
+	}
+	want := [][2]int64{{500, 700}, {1000, 700}, {1000, 1000}}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected charges %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/disputes"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"gorm.io/gorm"
+)
+
+type disputeResp struct {
+	ID            string            `json:"id"`
+	TransactionID string            `json:"transaction_id"`
+	Amount        int64             `json:"amount"`
+	Fee           int64             `json:"fee"`
+	Reason        string            `json:"reason"`
+	Status        string            `json:"status"`
+	Evidence      map[string]string `json:"evidence"`
+	EvidenceFiles []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	} `json:"evidence_files"`
+}
+
+func disputedCharge(t *testing.T, r *gin.Engine) (string, disputeResp) {
+	t.Helper()
+	w := chargeWithCard(r, "4000000000000259")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected charge to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	txnID := charge["id"].(string)
+
+	w = doJSON(r, "GET", "/api/v1/disputes?transaction_id="+txnID, nil)
+	var list struct {
+		Data []disputeResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 {
+		t.Fatalf("expected the simulator to open a dispute, got %s", w.Body.String())
+	}
+	return txnID, list.Data[0]
+}
+
+func postEvidence(r *gin.Engine, disputeID string, fields map[string]string, files map[string][]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for name, content := range files {
+		fw, _ := mw.CreateFormFile("files", name)
+		fw.Write(content)
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/disputes/"+disputeID+"/evidence", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func eventTypes(txnID string) map[string]bool {
+	var evts []models.WebhookEvent
+	config.DB.Where("transaction_id = ?", txnID).Find(&evts)
+	types := map[string]bool{}
+	for _, e := range evts {
+		types[e.EventType] = true
+	}
+	return types
+}
+
+func TestSimulatorOpensDispute(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	txnID, d := disputedCharge(t, r)
+	if d.Status != "needs_response" || d.Reason != "fraudulent" || d.Amount != 1500 || d.Fee != disputes.Fee {
+		t.Fatalf("unexpected dispute %+v", d)
+	}
+
+	settleFunds(t)
+	balance, _ := ledger.Balance(config.DB, ledger.AccountMerchantAvailable, "usd")
+	if balance != chargeNet-1500-disputes.Fee {
+		t.Fatalf("expected the dispute to withdraw the amount and fee from the charge's net, got %d", balance)
+	}
+
+	types := eventTypes(txnID)
+	if !types["charge.dispute.created"] || !types["charge.dispute.funds_withdrawn"] {
+		t.Fatalf("expected dispute webhooks, got %v", types)
+	}
+
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": txnID})
+	env := decodeError(t, w)
+	if w.Code != http.StatusConflict || env.Error.Code != "charge_disputed" {
+		t.Fatalf("expected refund of disputed charge to be refused, got %d %+v", w.Code, env.Error)
+	}
+}
+
+func TestDisputeEvidenceWins(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("FILE_STORAGE_DIR", t.TempDir())
+	r := setupTestRouter()
+
+	txnID, d := disputedCharge(t, r)
+
+	pdf := []byte("%PDF-1.4\n1 0 obj << >> endobj\ntrailer << >>\n%%EOF\n")
+	w := postEvidence(r, d.ID, map[string]string{
+		"evidence[product_description]": "Blue widget",
+		"evidence[uncategorized_text]":  "winning_evidence",
+	}, map[string][]byte{"receipt.pdf": pdf})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp disputeResp
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "needs_response" || resp.Evidence["product_description"] != "Blue widget" || len(resp.EvidenceFiles) != 1 {
+		t.Fatalf("unexpected dispute after draft evidence %+v", resp)
+	}
+
+	var file models.File
+	config.DB.First(&file, "id = ?", resp.EvidenceFiles[0].ID)
+	stored, err := os.ReadFile(file.Path)
+	if err != nil || !bytes.Equal(stored, pdf) {
+		t.Fatalf("expected evidence stored on disk, got %v", err)
+	}
+
+	dl := doJSON(r, "GET", resp.EvidenceFiles[0].URL, nil)
+	if dl.Code != http.StatusOK || !bytes.Equal(dl.Body.Bytes(), pdf) {
+		t.Fatalf("expected evidence download, got %d", dl.Code)
+	}
+
+	w = postEvidence(r, d.ID, map[string]string{"submit": "true"}, nil)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "won" {
+		t.Fatalf("expected dispute to be won, got %s", resp.Status)
+	}
+
+	settleFunds(t)
+	balance, _ := ledger.Balance(config.DB, ledger.AccountMerchantAvailable, "usd")
+	if balance != chargeNet-disputes.Fee {
+		t.Fatalf("expected amount reinstated minus fee, got %d", balance)
+	}
+
+	types := eventTypes(txnID)
+	if !types["charge.dispute.updated"] || !types["charge.dispute.funds_reinstated"] || !types["charge.dispute.closed"] {
+		t.Fatalf("expected dispute lifecycle webhooks, got %v", types)
+	}
+
+	w = postEvidence(r, d.ID, map[string]string{"evidence[refund_policy]": "late"}, nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected closed dispute to refuse evidence, got %d", w.Code)
+	}
+}
+
+func TestDisputeEvidenceValidation(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("FILE_STORAGE_DIR", t.TempDir())
+	r := setupTestRouter()
+
+	_, d := disputedCharge(t, r)
+
+	w := postEvidence(r, d.ID, map[string]string{"evidence[favourite_colour]": "blue"}, nil)
+	env := decodeError(t, w)
+	if w.Code != http.StatusBadRequest || env.Error.Param != "evidence[favourite_colour]" {
+		t.Fatalf("expected unknown evidence field to be refused, got %d %+v", w.Code, env.Error)
+	}
+
+	w = postEvidence(r, d.ID, nil, map[string][]byte{"script.sh": []byte("#!/bin/sh\necho hi\n")})
+	env = decodeError(t, w)
+	if w.Code != http.StatusBadRequest || env.Error.Param != "files" {
+		t.Fatalf("expected non-document upload to be refused, got %d %+v", w.Code, env.Error)
+	}
+}
+
+func TestDisputeEvidenceFilesDeletedWhenSubmitFails(t *testing.T) {
+	setupTestDB(t)
+	dir := t.TempDir()
+	t.Setenv("FILE_STORAGE_DIR", dir)
+	r := setupTestRouter()
+
+	_, d := disputedCharge(t, r)
+
+	config.DB.Callback().Update().Before("gorm:update").Register("fail_disputes", func(db *gorm.DB) {
+		if db.Statement.Table == "disputes" {
+			db.AddError(errors.New("disk full"))
+		}
+	})
+	pdf := []byte("%PDF-1.4\n1 0 obj << >> endobj\ntrailer << >>\n%%EOF\n")
+	w := postEvidence(r, d.ID, map[string]string{"evidence[product_description]": "Blue widget"}, map[string][]byte{"receipt.pdf": pdf})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var count int64
+	config.DB.Model(&models.File{}).Count(&count)
+	stored, _ := os.ReadDir(filepath.Join(dir, "dispute_evidence"))
+	if count != 0 || len(stored) != 0 {
+		t.Fatalf("expected the uploads deleted, got %d records and %d files", count, len(stored))
+	}
+}
+
+func TestDisputeUnderReviewClosedBySimulator(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestHelpersRouter(t)
+
+	_, d := disputedCharge(t, r)
+
+	w := postEvidence(r, d.ID, map[string]string{"evidence[uncategorized_text]": "see attached", "submit": "true"}, nil)
+	var resp disputeResp
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "under_review" {
+		t.Fatalf("expected under_review, got %s", resp.Status)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/test_helpers/disputes/"+d.ID+"/close", map[string]string{"status": "lost"})
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Status != "lost" {
+		t.Fatalf("expected dispute lost, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTestHelperOpensDispute(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestHelpersRouter(t)
+
+	w := chargeWithCard(r, "4242424242424242")
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	txnID := charge["id"].(string)
+
+	w = doJSON(r, "POST", "/api/v1/test_helpers/charges/"+txnID+"/dispute", map[string]interface{}{"reason": "duplicate", "amount": 500})
+	var d disputeResp
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if w.Code != http.StatusCreated || d.Reason != "duplicate" || d.Amount != 500 {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/test_helpers/charges/"+txnID+"/dispute", nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected second dispute to be refused, got %d", w.Code)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/disputes/"+d.ID+"/close", nil)
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if d.Status != "lost" {
+		t.Fatalf("expected accepted dispute to be lost, got %s", d.Status)
+	}
+}
+
+func TestDisputeTestHelpersRequireTestMode(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := chargeWithCard(r, "4242424242424242")
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	txnID := charge["id"].(string)
+
+	for _, path := range []string{"/api/v1/test_helpers/charges/" + txnID + "/dispute", "/api/v1/test_helpers/disputes/dp_123/close"} {
+		if w := doJSON(r, "POST", path, nil); w.Code != http.StatusNotFound {
+			t.Fatalf("expected %s to 404 without MINIPAY_TEST_HELPERS, got %d", path, w.Code)
+		}
+	}
+	var count int64
+	config.DB.Model(&models.Dispute{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no dispute, got %d", count)
+	}
+}
+
+func TestOverdueDisputesAreLost(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	txnID, d := disputedCharge(t, r)
+	config.DB.Model(&models.Dispute{}).Where("id = ?", d.ID).Update("evidence_due_by", time.Now().Add(-time.Minute))
+
+	n, err := disputes.ExpireOverdue(config.DB, time.Now())
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 dispute expired, got %d %v", n, err)
+	}
+
+	var stored models.Dispute
+	config.DB.First(&stored, "id = ?", d.ID)
+	if stored.Status != "lost" || stored.ClosedAt == nil {
+		t.Fatalf("expected dispute lost, got %+v", stored)
+	}
+	if !eventTypes(txnID)["charge.dispute.closed"] {
+		t.Fatal("expected charge.dispute.closed webhook")
+	}
+
+	n, _ = disputes.ExpireOverdue(config.DB, time.Now())
+	if n != 0 {
+		t.Fatalf("expected no further expiries, got %d", n)
+	}
+}
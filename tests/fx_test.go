@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+)
+
+type fxChargeResp struct {
+	ID         string `json:"id"`
+	Amount     int64  `json:"amount"`
+	Currency   string `json:"currency"`
+	Fee        int64  `json:"fee"`
+	Settlement *struct {
+		Amount       int64  `json:"amount"`
+		Currency     string `json:"currency"`
+		ExchangeRate string `json:"exchange_rate"`
+		FXMarkup     int64  `json:"fx_markup"`
+	} `json:"settlement"`
+}
+
+func setFXRate(t *testing.T, r *gin.Engine, base, quote, rate string) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/fx_rates", map[string]interface{}{"base": base, "quote": quote, "rate": rate})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func settleIn(t *testing.T, r *gin.Engine, currency string) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/merchants/acct_default", map[string]interface{}{"settlement_currency": currency})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func fxCharge(t *testing.T, r *gin.Engine, amount int64, currency string) fxChargeResp {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount": amount, "currency": currency, "customer": "cust_fx",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge fxChargeResp
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return charge
+}
+
+func uploadFXRates(r *gin.Engine, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/fx_rates/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFXChargeSettlesInMerchantCurrency(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setFXRate(t, r, "eur", "usd", "1.1")
+	settleIn(t, r, "USD")
+
+	charge := fxCharge(t, r, 10000, "eur")
+	if charge.Amount != 10000 || charge.Currency != "eur" {
+		t.Fatalf("expected the charge to stay in eur, got %+v", charge)
+	}
+	// 1.1 less the standard plan's 1% markup.
+	s := charge.Settlement
+	if s == nil || s.Currency != "usd" || s.ExchangeRate != "1.089" || s.Amount != 10890 || s.FXMarkup != 110 {
+		t.Fatalf("unexpected settlement %+v", s)
+	}
+	// 2.9% + 30 on the usd settlement amount.
+	if charge.Fee != 346 {
+		t.Fatalf("expected the usd fee on the settlement amount, got %d", charge.Fee)
+	}
+
+	bts := listBalanceTransactions(t, r, "?source="+charge.ID)
+	if len(bts) != 1 || bts[0].Currency != "usd" || bts[0].Amount != 10890 || bts[0].Fee != 346 || bts[0].Net != 10890-346 {
+		t.Fatalf("expected a usd balance transaction, got %+v", bts)
+	}
+	if revenue, _ := ledger.Balance(config.DB, ledger.AccountFXRevenue, "usd"); revenue != 110 {
+		t.Fatalf("expected 110 fx revenue, got %d", revenue)
+	}
+	if clearing, _ := ledger.Balance(config.DB, ledger.AccountProcessorClearing, "usd"); clearing != 0 {
+		t.Fatalf("expected the processor to be owed eur only, got %d usd", clearing)
+	}
+}
+
+func TestFXRefundPostsGainOrLoss(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setFXRate(t, r, "eur", "usd", "1.1")
+	settleIn(t, r, "usd")
+	charge := fxCharge(t, r, 10000, "eur")
+
+	setFXRate(t, r, "eur", "usd", "1.2")
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var refund struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &refund)
+
+	bts := listBalanceTransactions(t, r, "?source="+refund.ID)
+	if len(bts) != 1 || bts[0].Currency != "usd" || bts[0].Amount != -10890 {
+		t.Fatalf("expected the merchant to give back the settlement amount, got %+v", bts)
+	}
+	// Buying back 100 eur at 1.2 costs 120 usd, 11.10 more than the merchant gave back.
+	if gl, _ := ledger.Balance(config.DB, ledger.AccountFXGainsLosses, "usd"); gl != 10890-12000 {
+		t.Fatalf("expected an fx loss of 1110, got %d", gl)
+	}
+}
+
+func TestFXZeroDecimalCurrencySettles(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setFXRate(t, r, "usd", "jpy", "150")
+	settleIn(t, r, "usd")
+
+	// ¥1000 is $6.67 at the mid rate, and $6.60 less the 1% markup.
+	charge := fxCharge(t, r, 1000, "jpy")
+	s := charge.Settlement
+	if s == nil || s.Currency != "usd" || s.Amount != 660 || s.FXMarkup != 7 {
+		t.Fatalf("unexpected settlement %+v", s)
+	}
+	bts := listBalanceTransactions(t, r, "?source="+charge.ID)
+	if len(bts) != 1 || bts[0].Currency != "usd" || bts[0].Amount != 660 {
+		t.Fatalf("expected a usd balance transaction of 660, got %+v", bts)
+	}
+
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	// Buying back ¥1000 at the mid rate costs $6.67, 7 cents more than the merchant gave back.
+	if gl, _ := ledger.Balance(config.DB, ledger.AccountFXGainsLosses, "usd"); gl != -7 {
+		t.Fatalf("expected an fx loss of 7, got %d", gl)
+	}
+}
+
+func TestFXRatesImportAndInverse(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := uploadFXRates(r, "rates.csv", []byte("base,quote,rate\nusd,eur,0.8\ngbp,usd,1.25\n"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "GET", "/api/v1/fx_rates?base=usd", nil)
+	var list struct {
+		Data []struct {
+			Quote  string `json:"quote"`
+			Rate   string `json:"rate"`
+			Source string `json:"source"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Quote != "eur" || list.Data[0].Rate != "0.8" || list.Data[0].Source != "rates.csv" {
+		t.Fatalf("unexpected rates %s", w.Body.String())
+	}
+
+	// Only usd to eur is loaded, so eur to usd is its inverse.
+	w = doJSON(r, "GET", "/api/v1/fx_quote?from=eur&to=usd&amount=1000", nil)
+	var quote struct {
+		MidRate         string `json:"mid_rate"`
+		Rate            string `json:"rate"`
+		MarkupBps       int64  `json:"markup_bps"`
+		ConvertedAmount int64  `json:"converted_amount"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &quote)
+	if quote.MidRate != "1.25" || quote.Rate != "1.2375" || quote.MarkupBps != 100 || quote.ConvertedAmount != 1238 {
+		t.Fatalf("unexpected quote %s", w.Body.String())
+	}
+
+	w = uploadFXRates(r, "bad.csv", []byte("usd,jpy,150\nusd,chf,abc\n"))
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "file" {
+		t.Fatalf("expected the bad file to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "GET", "/api/v1/fx_rates?base=usd&quote=jpy", nil)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 0 {
+		t.Fatalf("expected no rates from the rejected file, got %s", w.Body.String())
+	}
+}
+
+func TestFXChargeWithoutRate(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	settleIn(t, r, "usd")
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount": 1000, "currency": "jpy", "customer": "cust_fx",
+	})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Code != "fx_rate_unavailable" || e.Error.Param != "currency" {
+		t.Fatalf("expected fx_rate_unavailable, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Charges already in the settlement currency need no rate.
+	charge := fxCharge(t, r, 1000, "usd")
+	if charge.Settlement != nil {
+		t.Fatalf("expected no conversion, got %+v", charge.Settlement)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/fx_rates", map[string]interface{}{"base": "usd", "quote": "eur", "rate": "0.123456789"})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "rate" {
+		t.Fatalf("expected a rate with nine decimals to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/invoices"
+	"github.com/vaidikcode/minipay/models"
+)
+
+type invoiceResp struct {
+	ID          string `json:"id"`
+	Number      string `json:"number"`
+	Status      string `json:"status"`
+	Subtotal    int64  `json:"subtotal"`
+	Tax         int64  `json:"tax"`
+	Total       int64  `json:"total"`
+	AmountPaid  int64  `json:"amount_paid"`
+	AmountDue   int64  `json:"amount_due"`
+	Attempts    int    `json:"attempts"`
+	Transaction string `json:"transaction"`
+	DueDate     string `json:"due_date"`
+	PDF         string `json:"invoice_pdf"`
+	Lines       []struct {
+		Amount int64 `json:"amount"`
+		Tax    int64 `json:"tax"`
+	} `json:"lines"`
+}
+
+func invoiceRequest(t *testing.T, r *gin.Engine, method, path string, body interface{}, status int) invoiceResp {
+	t.Helper()
+	w := doJSON(r, method, path, body)
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	var inv invoiceResp
+	json.Unmarshal(w.Body.Bytes(), &inv)
+	return inv
+}
+
+func draftInvoice(t *testing.T, r *gin.Engine, body map[string]interface{}) invoiceResp {
+	t.Helper()
+	req := map[string]interface{}{
+		"customer": "cus_acme",
+		"currency": "usd",
+		"lines": []map[string]interface{}{
+			{"description": "Consulting", "quantity": 3, "unit_amount": 2500, "tax_rate_bps": 1000},
+			{"description": "Setup fee", "unit_amount": 1000},
+		},
+	}
+	for k, v := range body {
+		req[k] = v
+	}
+	return invoiceRequest(t, r, "POST", "/api/v1/invoices", req, http.StatusCreated)
+}
+
+func TestInvoiceChargedAutomatically(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_acme", "4242424242424242")
+
+	inv := draftInvoice(t, r, nil)
+	if inv.Status != "draft" || inv.Number != "" || inv.Subtotal != 8500 || inv.Tax != 750 || inv.Total != 9250 {
+		t.Fatalf("unexpected draft %+v", inv)
+	}
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/lines",
+		map[string]interface{}{"description": "Support", "unit_amount": 750}, http.StatusOK)
+	if len(inv.Lines) != 3 || inv.Total != 10000 {
+		t.Fatalf("expected the line to be added, got %+v", inv)
+	}
+
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	if inv.Status != "paid" || inv.Number != "DEFAULT-0001" || inv.AmountPaid != 10000 || inv.AmountDue != 0 {
+		t.Fatalf("expected the invoice to be paid, got %+v", inv)
+	}
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", inv.Transaction)
+	if txn.Amount != 10000 || txn.Status != "succeeded" || txn.Metadata["invoice"] != inv.ID {
+		t.Fatalf("unexpected transaction %+v", txn)
+	}
+	types := eventTypes(inv.ID)
+	for _, typ := range []string{"invoice.created", "invoice.finalized", "invoice.paid"} {
+		if !types[typ] {
+			t.Errorf("expected a %s event, got %v", typ, types)
+		}
+	}
+
+	w := doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/lines", map[string]interface{}{"description": "Extra", "unit_amount": 100})
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Code != "invoice_not_editable" {
+		t.Fatalf("expected a finalized invoice to be locked, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", nil)
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Code != "invoice_not_open" {
+		t.Fatalf("expected a paid invoice to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	second := draftInvoice(t, r, nil)
+	second = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+second.ID+"/finalize", nil, http.StatusOK)
+	if second.Number != "DEFAULT-0002" {
+		t.Fatalf("expected sequential numbers, got %s", second.Number)
+	}
+}
+
+func TestInvoiceAutomaticChargeFails(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_acme", "4000000000000002")
+
+	inv := draftInvoice(t, r, nil)
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	if inv.Status != "open" || inv.Attempts != 1 || inv.Transaction != "" {
+		t.Fatalf("expected a failed charge to leave the invoice open, got %+v", inv)
+	}
+	if !eventTypes(inv.ID)["invoice.payment_failed"] {
+		t.Fatalf("expected an invoice.payment_failed event")
+	}
+
+	w := doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", nil)
+	if env := decodeError(t, w); w.Code != http.StatusPaymentRequired || env.Error.Code != "payment_failed" {
+		t.Fatalf("expected the card on file to be declined again, got %d: %s", w.Code, w.Body.String())
+	}
+
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", map[string]interface{}{
+		"card": map[string]interface{}{"number": "5555555555554444", "exp_month": 12, "exp_year": 2099},
+	}, http.StatusOK)
+	if inv.Status != "paid" || inv.Attempts != 3 || inv.Transaction == "" {
+		t.Fatalf("expected the invoice to be paid with the new card, got %+v", inv)
+	}
+}
+
+func TestInvoiceSentForPayment(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": "cus_acme", "email": "ap@acme.test"})
+
+	w := doJSON(r, "POST", "/api/v1/invoices", map[string]interface{}{"customer": "cus_acme", "currency": "usd", "days_until_due": 10})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "days_until_due" {
+		t.Fatalf("expected days_until_due to need send_invoice, got %d: %s", w.Code, w.Body.String())
+	}
+	empty := draftInvoice(t, r, map[string]interface{}{"lines": []interface{}{}})
+	w = doJSON(r, "POST", "/api/v1/invoices/"+empty.ID+"/finalize", nil)
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "lines" {
+		t.Fatalf("expected an empty invoice to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	inv := draftInvoice(t, r, map[string]interface{}{"collection_method": "send_invoice", "days_until_due": 14})
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	due, _ := time.Parse(time.RFC3339, inv.DueDate)
+	if inv.Status != "open" || inv.Attempts != 0 || due.Before(time.Now().AddDate(0, 0, 13)) {
+		t.Fatalf("expected an open invoice due in 14 days, got %+v", inv)
+	}
+	if !eventTypes(inv.ID)["invoice.sent"] {
+		t.Fatalf("expected an invoice.sent event")
+	}
+
+	if n, _ := invoices.MarkOverdue(config.DB, time.Now()); n != 0 {
+		t.Fatalf("expected nothing overdue yet, got %d", n)
+	}
+	if n, _ := invoices.MarkOverdue(config.DB, time.Now().AddDate(0, 0, 15)); n != 1 {
+		t.Fatalf("expected the invoice to be overdue, got %d", n)
+	}
+	if n, _ := invoices.MarkOverdue(config.DB, time.Now().AddDate(0, 0, 16)); n != 0 {
+		t.Fatalf("expected invoice.overdue only once, got %d", n)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", nil)
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "card" {
+		t.Fatalf("expected a card to be required, got %d: %s", w.Code, w.Body.String())
+	}
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/void", nil, http.StatusOK)
+	if inv.Status != "void" || inv.AmountDue != 0 {
+		t.Fatalf("expected the invoice to be void, got %+v", inv)
+	}
+
+	w = doJSON(r, "GET", inv.PDF, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" || !bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")) {
+		t.Fatalf("expected a PDF, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestInvoiceInterruptedPaymentExpires(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": "cus_acme", "email": "ap@acme.test"})
+	inv := draftInvoice(t, r, map[string]interface{}{"collection_method": "send_invoice"})
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	config.DB.Model(&models.Invoice{}).Where("id = ?", inv.ID).Update("status", invoices.StatusPaying)
+
+	card := map[string]interface{}{"number": "4242424242424242", "exp_month": 12, "exp_year": 2099, "cvc": "123"}
+	w := doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", map[string]interface{}{"card": card})
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Code != "invoice_not_open" {
+		t.Fatalf("expected a paying invoice to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/invoices/"+inv.ID+"/void", nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected a paying invoice not to be voided, got %d: %s", w.Code, w.Body.String())
+	}
+
+	config.DB.Model(&models.Invoice{}).Where("id = ?", inv.ID).UpdateColumn("updated_at", time.Now().Add(-invoices.PaymentTimeout-time.Minute))
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/pay", map[string]interface{}{"card": card}, http.StatusOK)
+	if inv.Status != "paid" || inv.Transaction == "" {
+		t.Fatalf("expected an interrupted payment to be retried, got %+v", inv)
+	}
+}
+
+func TestInvoicePDFGolden(t *testing.T) {
+	issued := goldenTime
+	due := goldenTime.AddDate(0, 0, 30)
+	inv := models.Invoice{
+		ID:               "in_golden",
+		Number:           "ACME-0042",
+		MerchantID:       "acct_acme",
+		CustomerID:       "cus_golden",
+		Currency:         "eur",
+		Status:           invoices.StatusOpen,
+		CollectionMethod: invoices.SendInvoice,
+		Description:      "Services for March (2024)",
+		Subtotal:         123450,
+		Tax:              23455,
+		Total:            146905,
+		FinalizedAt:      &issued,
+		DueDate:          &due,
+	}
+	lines := []models.InvoiceLine{
+		{Description: "Platform fee", Quantity: 1, UnitAmount: 99900, Amount: 99900, TaxRateBps: 1900, Tax: 18981},
+		{Description: "Extra seats", Quantity: 5, UnitAmount: 4710, Amount: 23550, TaxRateBps: 1900, Tax: 4475},
+		{Description: "Café visit", Quantity: 1, UnitAmount: 0, Amount: 0},
+	}
+	for i := 0; i < 40; i++ {
+		lines = append(lines, models.InvoiceLine{Description: fmt.Sprintf("Usage day %d", i+1), Quantity: 1})
+	}
+	merchant := models.Merchant{ID: "acct_acme", Name: "Acme GmbH"}
+	cust := models.Customer{ID: "cus_golden", Name: "Jo Example", Email: "jo@example.com"}
+
+	var buf bytes.Buffer
+	if err := invoices.Render(&buf, inv, lines, merchant, cust); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+	if !bytes.Contains(got, []byte("/Count 2")) || !bytes.Contains(got, []byte("(Caf\xe9 visit)")) {
+		t.Errorf("expected two pages with Latin-1 text")
+	}
+
+	path := filepath.Join("testdata", "invoices", "invoice.pdf")
+	if *updateGolden {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run go test -update: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("invoice.pdf differs from golden file")
+	}
+}
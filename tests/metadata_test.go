@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+)
+
+func chargeWithMetadata(t *testing.T, md map[string]string) map[string]interface{} {
+	t.Helper()
+	r := setupTestRouter()
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount":   2500,
+		"currency": "usd",
+		"customer": "cust_md",
+		"metadata": md,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp
+}
+
+func TestChargeMetadataRoundTrip(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	resp := chargeWithMetadata(t, map[string]string{"order_id": "42", "channel": "web"})
+	md := resp["metadata"].(map[string]interface{})
+	if md["order_id"] != "42" || md["channel"] != "web" {
+		t.Fatalf("unexpected metadata %v", md)
+	}
+
+	w := doJSON(r, "GET", "/api/v1/charges/"+resp["id"].(string), nil)
+	var fetched map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &fetched)
+	if fetched["metadata"].(map[string]interface{})["order_id"] != "42" {
+		t.Fatalf("expected metadata on retrieve, got %v", fetched["metadata"])
+	}
+
+	var event models.WebhookEvent
+	config.DB.First(&event, "transaction_id = ?", resp["id"])
+	if !strings.Contains(event.Payload, `"order_id":"42"`) {
+		t.Fatalf("expected metadata in webhook payload, got %s", event.Payload)
+	}
+}
+
+func TestChargeMetadataLimits(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	tooMany := map[string]string{}
+	for i := 0; i < 51; i++ {
+		tooMany[fmt.Sprintf("k%d", i)] = "v"
+	}
+
+	cases := []struct {
+		md    map[string]string
+		param string
+	}{
+		{tooMany, "metadata"},
+		{map[string]string{strings.Repeat("k", 41): "v"}, "metadata[" + strings.Repeat("k", 41) + "]"},
+		{map[string]string{"note": strings.Repeat("v", 501)}, "metadata[note]"},
+	}
+
+	for _, tc := range cases {
+		w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+			"amount": 100, "currency": "usd", "customer": "cust_md", "metadata": tc.md,
+		})
+		env := decodeError(t, w)
+		if w.Code != http.StatusBadRequest || env.Error.Param != tc.param {
+			t.Fatalf("expected 400 on %s, got %d %+v", tc.param, w.Code, env.Error)
+		}
+	}
+}
+
+func TestChargeMetadataFilterAndSearch(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	first := chargeWithMetadata(t, map[string]string{"order_id": "42"})
+	chargeWithMetadata(t, map[string]string{"order_id": "43"})
+	chargeWithMetadata(t, nil)
+
+	list := listCharges(t, "?metadata[order_id]=42")
+	if len(list.Data) != 1 || list.Data[0].ID != first["id"] {
+		t.Fatalf("expected only the order 42 charge, got %+v", list.Data)
+	}
+
+	query := url.QueryEscape(`metadata["order_id"]:"42" AND amount>1000`)
+	w := doJSON(r, "GET", "/api/v1/charges/search?query="+query, nil)
+	var found chargeList
+	json.Unmarshal(w.Body.Bytes(), &found)
+	if len(found.Data) != 1 || found.Data[0].ID != first["id"] {
+		t.Fatalf("expected search to find order 42, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/charges/search?query="+url.QueryEscape(`metadata:"42"`), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for metadata without key, got %d", w.Code)
+	}
+}
+
+func TestRefundMetadata(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	charge := chargeWithMetadata(t, map[string]string{"order_id": "7"})
+
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{
+		"transaction_id": charge["id"],
+		"metadata":       map[string]string{"reason": "damaged"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var refund map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &refund)
+	if !strings.HasPrefix(refund["id"].(string), "re_") || refund["transaction_id"] != charge["id"] {
+		t.Fatalf("unexpected refund %v", refund)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/refunds?metadata[reason]=damaged", nil)
+	var list struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0]["id"] != refund["id"] {
+		t.Fatalf("expected refund in filtered list, got %s", w.Body.String())
+	}
+
+	var event models.WebhookEvent
+	config.DB.First(&event, "transaction_id = ? AND event_type = ?", charge["id"], "charge.refunded")
+	if !strings.Contains(event.Payload, `"reason":"damaged"`) || !strings.Contains(event.Payload, `"order_id":"7"`) {
+		t.Fatalf("expected refund and charge metadata in webhook payload, got %s", event.Payload)
+	}
+}
+
+func TestCustomerMetadata(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{
+		"id":       "cust_meta",
+		"email":    "jo@example.com",
+		"country":  "de",
+		"metadata": map[string]string{"tier": "gold", "crm_id": "c-1"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/customers/cust_meta", map[string]interface{}{
+		"metadata": map[string]string{"tier": "platinum", "crm_id": ""},
+	})
+	var cust map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &cust)
+	md := cust["metadata"].(map[string]interface{})
+	if md["tier"] != "platinum" || md["crm_id"] != nil {
+		t.Fatalf("expected merged metadata, got %v", md)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/customers?metadata[tier]=platinum", nil)
+	var list struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 {
+		t.Fatalf("expected 1 customer, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/customers?metadata[crm_id]=c-1", nil)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 0 {
+		t.Fatalf("expected removed key to no longer match, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": 100, "currency": "usd", "customer": "cust_meta"})
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", charge["id"])
+	if txn.Country != "DE" {
+		t.Fatalf("expected charge country from customer, got %q", txn.Country)
+	}
+}
@@ -0,0 +1,299 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/payouts"
+	"github.com/vaidikcode/minipay/reserves"
+)
+
+type payoutResp struct {
+	ID          string `json:"id"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	BankAccount string `json:"bank_account"`
+	Status      string `json:"status"`
+	Automatic   bool   `json:"automatic"`
+	FailureCode string `json:"failure_code"`
+}
+
+func usBankAccount(t *testing.T, r *gin.Engine, accountNumber string) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency":            "usd",
+		"country":             "US",
+		"account_holder_name": "Acme Inc",
+		"routing_number":      "110000000",
+		"account_number":      accountNumber,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp["id"].(string)
+}
+
+func createPayout(t *testing.T, r *gin.Engine, amount int64) payoutResp {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": amount, "currency": "usd"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var p payoutResp
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return p
+}
+
+func availableUSD(t *testing.T) int64 {
+	t.Helper()
+	bal, err := ledger.Balance(config.DB, ledger.AccountMerchantAvailable, "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bal
+}
+
+// settleFunds makes every pending charge available, as the settlement worker
+// would once the settlement delay has passed.
+func settleFunds(t *testing.T) {
+	t.Helper()
+	if _, err := reserves.Settle(config.DB, time.Now().AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBankAccountValidation(t *testing.T) {
+	if !payouts.ValidIBAN(payouts.NormalizeIBAN("de89 3704 0044 0532 0130 00")) {
+		t.Error("expected a valid German IBAN")
+	}
+	if payouts.ValidIBAN("DE89370400440532013001") {
+		t.Error("expected a bad checksum to be rejected")
+	}
+	if !payouts.ValidABARouting("110000000") || payouts.ValidABARouting("110000001") {
+		t.Error("unexpected routing number checksum result")
+	}
+
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency":            "eur",
+		"country":             "DE",
+		"account_holder_name": "Acme GmbH",
+		"iban":                "DE89 3704 0044 0532 0130 00",
+		"bic":                 "COBADEFFXXX",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var ba map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &ba)
+	if ba["last4"] != "3000" || ba["iban"] != nil {
+		t.Fatalf("expected only last4 to be exposed, got %v", ba)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency":            "usd",
+		"country":             "US",
+		"account_holder_name": "Acme Inc",
+		"routing_number":      "110000001",
+		"account_number":      "000123456789",
+	})
+	env := decodeError(t, w)
+	if w.Code != http.StatusBadRequest || env.Error.Param != "routing_number" {
+		t.Fatalf("expected invalid routing_number, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPayoutLifecycle(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+
+	w := doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 1000, "currency": "usd"})
+	env := decodeError(t, w)
+	if w.Code != http.StatusPaymentRequired || env.Error.Code != "balance_insufficient" {
+		t.Fatalf("expected balance_insufficient, got %d: %s", w.Code, w.Body.String())
+	}
+
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+	p := createPayout(t, r, 1000)
+	if p.Status != "pending" || availableUSD(t) != chargeNet-1000 {
+		t.Fatalf("expected a pending payout and %d available, got %s and %d", chargeNet-1000, p.Status, availableUSD(t))
+	}
+
+	if err := payouts.Process(config.DB, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	w = doJSON(r, "GET", "/api/v1/payouts/"+p.ID, nil)
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if p.Status != "in_transit" {
+		t.Fatalf("expected in_transit, got %s", p.Status)
+	}
+
+	if err := payouts.Process(config.DB, time.Now().Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	w = doJSON(r, "GET", "/api/v1/payouts/"+p.ID, nil)
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if p.Status != "paid" {
+		t.Fatalf("expected paid, got %s", p.Status)
+	}
+
+	paid, _ := ledger.Balance(config.DB, ledger.AccountPayoutsPaid, "usd")
+	if paid != 1000 {
+		t.Fatalf("expected 1000 paid out, got %d", paid)
+	}
+	types := eventTypes(p.ID)
+	for _, typ := range []string{"payout.created", "payout.updated", "payout.paid"} {
+		if !types[typ] {
+			t.Errorf("expected %s event, got %v", typ, types)
+		}
+	}
+}
+
+func TestPayoutFailureReturnsFunds(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000111111116")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	p := createPayout(t, r, chargeNet)
+	payouts.Process(config.DB, time.Now())
+	payouts.Process(config.DB, time.Now().Add(48*time.Hour))
+
+	w := doJSON(r, "GET", "/api/v1/payouts/"+p.ID, nil)
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if p.Status != "failed" || p.FailureCode != "no_account" {
+		t.Fatalf("expected failed with no_account, got %+v", p)
+	}
+	if availableUSD(t) != chargeNet {
+		t.Fatalf("expected funds to return to available, got %d", availableUSD(t))
+	}
+	if !eventTypes(p.ID)["payout.failed"] {
+		t.Error("expected payout.failed event")
+	}
+}
+
+func TestCancelPayout(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestHelpersRouter(t)
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	p := createPayout(t, r, 700)
+	w := doJSON(r, "POST", "/api/v1/payouts/"+p.ID+"/cancel", nil)
+	if w.Code != http.StatusOK || availableUSD(t) != chargeNet {
+		t.Fatalf("expected cancel to restore the balance, got %d: %s", w.Code, w.Body.String())
+	}
+
+	p = createPayout(t, r, 700)
+	doJSON(r, "POST", "/api/v1/test_helpers/payouts/"+p.ID+"/paid", nil)
+	w = doJSON(r, "POST", "/api/v1/payouts/"+p.ID+"/cancel", nil)
+	env := decodeError(t, w)
+	if w.Code != http.StatusConflict || env.Error.Code != "payout_not_cancelable" {
+		t.Fatalf("expected payout_not_cancelable, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPayoutTestHelpersRequireTestMode(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	p := createPayout(t, r, 700)
+	for _, action := range []string{"paid", "fail"} {
+		if w := doJSON(r, "POST", "/api/v1/test_helpers/payouts/"+p.ID+"/"+action, nil); w.Code != http.StatusNotFound {
+			t.Fatalf("expected %s to 404 without MINIPAY_TEST_HELPERS, got %d", action, w.Code)
+		}
+	}
+	var payout payoutResp
+	json.Unmarshal(doJSON(r, "GET", "/api/v1/payouts/"+p.ID, nil).Body.Bytes(), &payout)
+	if payout.Status != p.Status {
+		t.Fatalf("expected the payout left %s, got %s", p.Status, payout.Status)
+	}
+}
+
+func TestConcurrentPayoutsCannotOverdraw(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	amount := int64(chargeNet/3 + 1)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	created, refused := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": amount, "currency": "usd"})
+			mu.Lock()
+			defer mu.Unlock()
+			switch w.Code {
+			case http.StatusCreated:
+				created++
+			case http.StatusPaymentRequired:
+				refused++
+			default:
+				t.Errorf("unexpected status %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 2 || refused != 8 {
+		t.Fatalf("expected exactly 2 payouts, got %d (%d refused)", created, refused)
+	}
+	if got := availableUSD(t); got != chargeNet-2*amount {
+		t.Fatalf("expected %d left, got %d", chargeNet-2*amount, got)
+	}
+}
+
+func TestAutomaticPayoutSchedule(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	w := doJSON(r, "POST", "/api/v1/payout_schedule", map[string]interface{}{"interval": "weekly"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected weekly_anchor to be required, got %d", w.Code)
+	}
+	anchor := map[time.Weekday]string{time.Monday: "monday", time.Tuesday: "tuesday", time.Wednesday: "wednesday",
+		time.Thursday: "thursday", time.Friday: "friday", time.Saturday: "saturday", time.Sunday: "sunday"}
+	now := time.Now()
+	w = doJSON(r, "POST", "/api/v1/payout_schedule", map[string]interface{}{"interval": "weekly", "weekly_anchor": anchor[now.Weekday()]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if created, _ := payouts.RunSchedule(config.DB, now.Add(24*time.Hour)); len(created) != 0 {
+		t.Fatalf("expected no payout off the anchor day, got %d", len(created))
+	}
+	created, err := payouts.RunSchedule(config.DB, now)
+	if err != nil || len(created) != 1 {
+		t.Fatalf("expected one automatic payout, got %d (%v)", len(created), err)
+	}
+	if created[0].Amount != chargeNet || !created[0].Automatic {
+		t.Fatalf("expected the full balance paid out automatically, got %+v", created[0])
+	}
+	if again, _ := payouts.RunSchedule(config.DB, now); len(again) != 0 {
+		t.Fatal("expected at most one run per day")
+	}
+}
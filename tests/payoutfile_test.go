@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payoutfile"
+	"github.com/vaidikcode/minipay/payouts"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+var goldenTime = time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)
+
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "payoutfile", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run go test -update: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file:\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+func TestNACHAGolden(t *testing.T) {
+	cfg := payoutfile.NACHAConfig{
+		ImmediateDestination:     "110000000",
+		ImmediateDestinationName: "MiniPay Test Bank",
+		ImmediateOrigin:          "1234567890",
+		ImmediateOriginName:      "MiniPay",
+		CompanyName:              "MiniPay",
+		CompanyID:                "1234567890",
+		ODFIRouting:              "110000000",
+	}
+	payments := []payoutfile.Payment{
+		{ID: "po_1", Amount: 125000, Name: "Acme Inc", RoutingNumber: "110000000", AccountNumber: "000123456789", Reference: "po_1"},
+		{ID: "po_2", Amount: 9950, Name: "Jo Müller & Sons", RoutingNumber: "021000021", AccountNumber: "987654321", Reference: "po_2"},
+	}
+
+	var buf bytes.Buffer
+	totals, err := payoutfile.WriteNACHA(&buf, cfg, payments, goldenTime, goldenTime.Add(24*time.Hour), 'A')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Count != 2 || totals.Total != 134950 || totals.Hash != "0013100002" {
+		t.Fatalf("unexpected control totals %+v", totals)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines)%10 != 0 {
+		t.Fatalf("expected the file to be padded to a block of 10 records, got %d", len(lines))
+	}
+	for i, l := range lines {
+		if len(l) != 94 {
+			t.Fatalf("record %d is %d characters", i+1, len(l))
+		}
+	}
+	assertGolden(t, "ach.txt", buf.Bytes())
+
+	_, err = payoutfile.WriteNACHA(&buf, cfg, []payoutfile.Payment{{ID: "po_3", Amount: 100, IBAN: "DE89370400440532013000"}}, goldenTime, goldenTime, 'A')
+	if err == nil {
+		t.Fatal("expected a payment without a routing number to be rejected")
+	}
+}
+
+func TestPain001Golden(t *testing.T) {
+	cfg := payoutfile.SEPAConfig{
+		InitiatingParty: "MiniPay",
+		DebtorName:      "MiniPay",
+		DebtorIBAN:      "DE89370400440532013000",
+		DebtorBIC:       "COBADEFFXXX",
+	}
+	payments := []payoutfile.Payment{
+		{ID: "po_1", Amount: 50000, Name: "Acme GmbH", IBAN: "FR1420041010050500013M02606", BIC: "PSSTFRPPLIL", Reference: "Payout March"},
+		{ID: "po_2", Amount: 1234, Name: "Café <Noir>", IBAN: "NL91ABNA0417164300", Reference: "po_2"},
+	}
+
+	var buf bytes.Buffer
+	totals, err := payoutfile.WritePain001(&buf, cfg, payments, "MP20240315093000", goldenTime, goldenTime.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.Count != 2 || totals.Total != 51234 {
+		t.Fatalf("unexpected control totals %+v", totals)
+	}
+
+	var doc struct {
+		CtrlSum string `xml:"CstmrCdtTrfInitn>GrpHdr>CtrlSum"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil || doc.CtrlSum != "512.34" {
+		t.Fatalf("expected well-formed XML with CtrlSum 512.34, got %q (%v)", doc.CtrlSum, err)
+	}
+	assertGolden(t, "sepa.xml", buf.Bytes())
+}
+
+func TestPayoutsSubmittedInBankFile(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	first := createPayout(t, r, 500)
+	second := createPayout(t, r, 700)
+	if err := payouts.Process(config.DB, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var list []models.Payout
+	config.DB.Where("id IN ?", []string{first.ID, second.ID}).Find(&list)
+	if len(list) != 2 || list[0].FileID == "" || list[0].FileID != list[1].FileID {
+		t.Fatalf("expected both payouts in one bank file, got %+v", list)
+	}
+
+	w := doJSON(r, "GET", "/api/v1/files/"+list[0].FileID+"/contents", nil)
+	body := w.Body.String()
+	if w.Code != 200 || !strings.HasPrefix(body, "101 110000000") || !strings.Contains(body, "00000001200") {
+		t.Fatalf("expected a NACHA file with a 12.00 credit total, got %d:\n%s", w.Code, body)
+	}
+}
+
+func TestSEPAFilesCreatedInTheSameSecondHaveDistinctMessageIDs(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("FILE_STORAGE_DIR", t.TempDir())
+
+	accounts := map[string]models.BankAccount{"ba_1": {ID: "ba_1", Currency: "eur", AccountHolderName: "Acme GmbH", IBAN: "FR1420041010050500013M02606"}}
+	list := []models.Payout{{ID: "po_1", Amount: 500, Currency: "eur", BankAccountID: "ba_1"}}
+	now := time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		file, err := payouts.WriteFile(config.DB, "sepa", list, accounts, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(file.Path)
+		var doc struct {
+			MsgID string `xml:"CstmrCdtTrfInitn>GrpHdr>MsgId"`
+		}
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.MsgID == "" || len(doc.MsgID) > 35 || seen[doc.MsgID] {
+			t.Fatalf("expected a new MsgId of at most 35 characters, got %q after %v", doc.MsgID, seen)
+		}
+		seen[doc.MsgID] = true
+	}
+}
+
+func TestACHPayoutsBatchedBySECCode(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("FILE_STORAGE_DIR", t.TempDir())
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+	business := createPayout(t, r, 500)
+
+	wallet := walletFor(t, r, "cus_alice", "basic")
+	topUp(t, r, wallet, 5000)
+	w := doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency": "usd", "country": "US", "account_holder_name": "Alice",
+		"routing_number": "110000000", "account_number": "000987654321", "customer": "cus_alice",
+	})
+	var ba struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &ba)
+	w = doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/withdraw", map[string]interface{}{"amount": 1500, "bank_account": ba.ID})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var withdrawal struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &withdrawal)
+
+	if err := payouts.Process(config.DB, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{business.ID: "CCD", withdrawal.ID: "PPD"} {
+		var p models.Payout
+		config.DB.First(&p, "id = ?", id)
+		var file models.File
+		config.DB.First(&file, "id = ?", p.FileID)
+		data, _ := os.ReadFile(file.Path)
+		lines := strings.Split(string(data), "\n")
+		if len(lines) < 2 || len(lines[1]) < 53 || lines[1][50:53] != want {
+			t.Fatalf("expected payout %s in a %s batch, got:\n%s", id, want, data)
+		}
+	}
+}
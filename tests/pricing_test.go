@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/pricing"
+)
+
+// chargeNet is what the standard plan leaves of chargeWithCard's 1500 usd
+// Visa charge: 2.9% (44) plus 30 in fees.
+const chargeNet = 1500 - 74
+
+func TestChargeFeeComponents(t *testing.T) {
+	plan := pricing.StandardPlan()
+
+	fee := pricing.ChargeFee(plan, 1500, "usd", "visa", false)
+	if fee.Amount != 74 || len(fee.Details) != 1 {
+		t.Fatalf("expected a 74 processing fee, got %+v", fee)
+	}
+
+	fee = pricing.ChargeFee(plan, 10000, "gbp", "amex", true)
+	// 2.9% + 30 from the "*" rate, 0.6% amex and 1.5% international.
+	if fee.Amount != 290+30+60+150 || len(fee.Details) != 3 {
+		t.Fatalf("unexpected fee %+v", fee)
+	}
+
+	fee = pricing.ChargeFee(plan, 20, "usd", "visa", false)
+	if fee.Amount != 20 {
+		t.Fatalf("expected the fee to be capped at the amount, got %+v", fee)
+	}
+
+	plan.RefundPolicy = pricing.RefundReturnFee
+	plan.RefundFixedFee = 15
+	fee = pricing.RefundFee(plan, 750, 1500, 74)
+	if fee.Amount != -37+15 {
+		t.Fatalf("expected half the charge fee back less the refund fee, got %+v", fee)
+	}
+}
+
+func TestChargeRecordsBalanceTransaction(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := chargeWithCard(r, "4242424242424242")
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge["fee"].(float64) != 74 || charge["merchant"] != pricing.DefaultMerchantID {
+		t.Fatalf("unexpected charge %v", charge)
+	}
+
+	var bt models.BalanceTransaction
+	if err := config.DB.First(&bt, "id = ?", charge["balance_transaction"]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bt.Amount != 1500 || bt.Fee != 74 || bt.Net != chargeNet || bt.SourceID != charge["id"] {
+		t.Fatalf("unexpected balance transaction %+v", bt)
+	}
+	revenue, _ := ledger.Balance(config.DB, ledger.AccountFeeRevenue, "usd")
+	if revenue != 74 {
+		t.Fatalf("expected 74 fee revenue, got %d", revenue)
+	}
+
+	settleFunds(t)
+	w = doJSON(r, "GET", "/api/v1/balance", nil)
+	var bal struct {
+		Gross     []balanceAmount `json:"gross"`
+		Fees      []balanceAmount `json:"fees"`
+		Balance   []balanceAmount `json:"balance"`
+		Available []balanceAmount `json:"available"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &bal)
+	if len(bal.Gross) != 1 || bal.Gross[0].Amount != 1500 || bal.Fees[0].Amount != 74 || bal.Balance[0].Amount != chargeNet || len(bal.Available) != 1 || bal.Available[0].Amount != chargeNet {
+		t.Fatalf("expected net figures, got %s", w.Body.String())
+	}
+}
+
+func TestMerchantPricingPlan(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/pricing_plans", map[string]interface{}{
+		"id":               "plan_enterprise",
+		"name":             "Enterprise",
+		"rates":            map[string]interface{}{"*": map[string]int64{"percent_bps": 100, "fixed": 10}},
+		"refund_policy":    "return_fee",
+		"refund_fixed_fee": 5,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": "acct_big", "country": "US", "pricing_plan": "plan_enterprise", "platform": "acct_default"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount": 10000, "currency": "usd", "customer": "cust_big", "merchant": "acct_big", "country": "DE",
+	})
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge["fee"].(float64) != 110 {
+		t.Fatalf("expected the enterprise rate without surcharges, got %v", charge)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge["id"]})
+	var refund map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &refund)
+	if refund["fee"].(float64) != -110+5 {
+		t.Fatalf("expected the charge fee back less the refund fee, got %v", refund)
+	}
+	revenue, _ := ledger.Balance(config.DB, ledger.AccountFeeRevenue, "usd")
+	if revenue != 5 {
+		t.Fatalf("expected only the refund fee as revenue, got %d", revenue)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount": 1000, "currency": "usd", "customer": "cust_big", "merchant": "acct_missing",
+	})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown merchant to be rejected, got %d", w.Code)
+	}
+}
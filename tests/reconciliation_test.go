@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/payouts"
+	"github.com/vaidikcode/minipay/statements"
+)
+
+type statementLine struct {
+	Amount int64  `json:"amount"`
+	Status string `json:"status"`
+	Match  *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"match"`
+	Note string `json:"note"`
+}
+
+type reconciliationReport struct {
+	Statement struct {
+		ID string `json:"id"`
+	} `json:"statement"`
+	Matched    []statementLine `json:"matched"`
+	Unmatched  []statementLine `json:"unmatched"`
+	Suspicious []statementLine `json:"suspicious"`
+	Missing    []struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"missing"`
+}
+
+func uploadStatement(r *gin.Engine, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/bank_statements", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func importedReport(t *testing.T, r *gin.Engine, filename string, content []byte) reconciliationReport {
+	t.Helper()
+	w := uploadStatement(r, filename, content)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 {
+		t.Fatalf("expected one statement, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/bank_statements/"+list.Data[0].ID+"/reconciliation", nil)
+	var report reconciliationReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return report
+}
+
+func TestParseStatements(t *testing.T) {
+	for _, tc := range []struct {
+		file, format, currency string
+	}{
+		{"camt053.xml", statements.FormatCAMT053, "eur"},
+		{"mt940.sta", statements.FormatMT940, "usd"},
+		{"statement.csv", statements.FormatCSV, "usd"},
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", "statements", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := statements.Detect(data); got != tc.format {
+			t.Errorf("%s: detected %s, want %s", tc.file, got, tc.format)
+		}
+		list, err := statements.Parse("", data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		s := list[0]
+		if len(s.Lines) != 2 || !s.Balanced() || s.Currency != tc.currency {
+			t.Fatalf("%s: unexpected statement %+v", tc.file, s)
+		}
+		if s.Lines[0].Amount != 98766 || s.Lines[1].Amount != -50000 {
+			t.Errorf("%s: unexpected amounts %d, %d", tc.file, s.Lines[0].Amount, s.Lines[1].Amount)
+		}
+		if got := s.Lines[1].BookingDate.Format("2006-01-02"); got != "2024-03-18" {
+			t.Errorf("%s: unexpected booking date %s", tc.file, got)
+		}
+	}
+
+	_, err := statements.Parse(statements.FormatMT940, []byte(":20:X\n:61:240316C12,3456NTRF\n"))
+	if _, ok := err.(*statements.SyntaxError); !ok {
+		t.Fatalf("expected a syntax error, got %v", err)
+	}
+}
+
+func TestReconcileStatement(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	first := createPayout(t, r, 500)
+	second := createPayout(t, r, 700)
+	if err := payouts.Process(config.DB, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	csv := "date,amount,currency,reference,description\n" +
+		fmt.Sprintf("%s,-5.00,usd,%s,ACH PAYOUT\n", day, first.ID) +
+		fmt.Sprintf("%s,-7.00,usd,,ACH PAYOUT\n", day) +
+		fmt.Sprintf("%s,-5.00,usd,%s,ACH PAYOUT\n", day, first.ID) +
+		fmt.Sprintf("%s,15.00,usd,,MINIPAY SETTLEMENT\n", day) +
+		fmt.Sprintf("%s,99.99,usd,,UNKNOWN DEPOSIT\n", day)
+
+	report := importedReport(t, r, "march.csv", []byte(csv))
+	if len(report.Matched) != 3 || len(report.Suspicious) != 1 || len(report.Unmatched) != 1 || len(report.Missing) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	matches := map[string]string{}
+	for _, l := range report.Matched {
+		matches[l.Match.ID] = l.Match.Type
+	}
+	settlement := "stl_usd_" + time.Now().UTC().Format("20060102")
+	if matches[first.ID] != "payout" || matches[second.ID] != "payout" || matches[settlement] != "settlement" {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if s := report.Suspicious[0]; s.Match == nil || s.Match.ID != first.ID {
+		t.Fatalf("expected the duplicate payout line to be suspicious, got %+v", s)
+	}
+
+	w := uploadStatement(r, "march.csv", []byte(csv))
+	env := decodeError(t, w)
+	if w.Code != http.StatusConflict || env.Error.Code != "resource_already_exists" {
+		t.Fatalf("expected a duplicate import to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReconciliationReportsMissingPayouts(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	p := createPayout(t, r, 500)
+	payouts.Process(config.DB, time.Now())
+
+	day := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	csv := "date,amount,currency,reference,description\n" +
+		fmt.Sprintf("%s,-4.00,usd,%s,ACH PAYOUT\n", day, p.ID)
+
+	report := importedReport(t, r, "short.csv", []byte(csv))
+	if len(report.Suspicious) != 1 || report.Suspicious[0].Note == "" {
+		t.Fatalf("expected an amount mismatch to be suspicious, got %+v", report)
+	}
+	missing := map[string]bool{}
+	for _, m := range report.Missing {
+		missing[m.ID] = true
+	}
+	if !missing[p.ID] {
+		t.Fatalf("expected payout %s to be reported missing, got %+v", p.ID, report.Missing)
+	}
+
+	w := uploadStatement(r, "bad.sta", []byte(":20:X\n:60F:C240315USD10,00\n:61:240316C1,00NTRFNONREF\n:62F:C240316USD12,00\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unbalanced statement to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/reserves"
+)
+
+func reservedUSD(t *testing.T) int64 {
+	t.Helper()
+	bal, err := ledger.Balance(config.DB, ledger.AccountMerchantReserved, "usd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bal
+}
+
+func TestRollingReserve(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/reserve_rules", map[string]interface{}{"type": "rolling", "percent_bps": 1000})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "hold_days" {
+		t.Fatalf("expected hold_days to be required, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/reserve_rules", map[string]interface{}{"type": "rolling", "percent_bps": 1000, "hold_days": 90})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	chargeWithCard(r, "4242424242424242")
+	settled := time.Now().AddDate(0, 0, 10)
+	if _, err := reserves.Settle(config.DB, settled); err != nil {
+		t.Fatal(err)
+	}
+	// 10% of the 1426 net, rounded half up.
+	if reservedUSD(t) != 143 || availableUSD(t) != chargeNet-143 {
+		t.Fatalf("expected 143 reserved, got %d reserved and %d available", reservedUSD(t), availableUSD(t))
+	}
+
+	w = doJSON(r, "GET", "/api/v1/balance", nil)
+	var bal struct {
+		Reserved []balanceAmount `json:"reserved"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &bal)
+	if len(bal.Reserved) != 1 || bal.Reserved[0].Amount != 143 {
+		t.Fatalf("expected the reserve in the balance, got %s", w.Body.String())
+	}
+
+	if n, _ := reserves.ReleaseDue(config.DB, settled.AddDate(0, 0, 30)); n != 0 {
+		t.Fatalf("expected the reserve to be held for 90 days, released %d", n)
+	}
+	if n, err := reserves.ReleaseDue(config.DB, settled.AddDate(0, 0, 91)); err != nil || n != 1 {
+		t.Fatalf("expected the reserve released, got %d (%v)", n, err)
+	}
+	if reservedUSD(t) != 0 || availableUSD(t) != chargeNet {
+		t.Fatalf("expected all funds available, got %d reserved and %d available", reservedUSD(t), availableUSD(t))
+	}
+	if len(listBalanceTransactions(t, r, "?type=reserve_release")) != 1 {
+		t.Fatal("expected the release in the balance transactions")
+	}
+}
+
+func TestFixedReserve(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/reserve_rules", map[string]interface{}{"type": "fixed", "amount": 1000, "currency": "usd"})
+	var rule map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &rule)
+
+	chargeWithCard(r, "4242424242424242")
+	chargeWithCard(r, "5555555555554444")
+	settleFunds(t)
+	if reservedUSD(t) != 1000 || availableUSD(t) != 2*chargeNet-1000 {
+		t.Fatalf("expected 1000 reserved once, got %d reserved and %d available", reservedUSD(t), availableUSD(t))
+	}
+
+	w = doJSON(r, "POST", "/api/v1/reserve_rules/"+rule["id"].(string)+"/disable", nil)
+	json.Unmarshal(w.Body.Bytes(), &rule)
+	if w.Code != http.StatusOK || rule["active"] != false || reservedUSD(t) != 0 {
+		t.Fatalf("expected disabling to release the reserve, got %d reserved: %s", reservedUSD(t), w.Body.String())
+	}
+}
+
+func TestManualHold(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	chargeWithCard(r, "4242424242424242")
+	settleFunds(t)
+
+	w := doJSON(r, "POST", "/api/v1/reserves", map[string]interface{}{"amount": 5000, "currency": "usd", "reason": "Fraud review"})
+	if env := decodeError(t, w); w.Code != http.StatusPaymentRequired || env.Error.Code != "balance_insufficient" {
+		t.Fatalf("expected balance_insufficient, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/reserves", map[string]interface{}{"amount": 400, "currency": "usd", "reason": "Fraud review"})
+	var hold struct {
+		ID        string  `json:"id"`
+		Type      string  `json:"type"`
+		Status    string  `json:"status"`
+		ReleaseOn *string `json:"release_on"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &hold)
+	if w.Code != http.StatusCreated || hold.Type != "manual" || hold.Status != "held" || hold.ReleaseOn != nil {
+		t.Fatalf("unexpected hold %d: %s", w.Code, w.Body.String())
+	}
+	if reservedUSD(t) != 400 || availableUSD(t) != chargeNet-400 {
+		t.Fatalf("expected 400 held, got %d", reservedUSD(t))
+	}
+	if n, _ := reserves.ReleaseDue(config.DB, time.Now().AddDate(10, 0, 0)); n != 0 {
+		t.Fatal("expected a hold without release_on to stay held")
+	}
+
+	w = doJSON(r, "POST", "/api/v1/reserves/"+hold.ID+"/release", nil)
+	json.Unmarshal(w.Body.Bytes(), &hold)
+	if w.Code != http.StatusOK || hold.Status != "released" || availableUSD(t) != chargeNet {
+		t.Fatalf("expected the hold released, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/reserves/"+hold.ID+"/release", nil)
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Code != "reserve_released" {
+		t.Fatalf("expected reserve_released, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/reviews"
+	"github.com/vaidikcode/minipay/risk"
+)
+
+type riskCharge struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Risk   struct {
+		Score   int      `json:"score"`
+		Outcome string   `json:"outcome"`
+		Rules   []string `json:"rules"`
+	} `json:"risk"`
+	Error struct {
+		DeclineCode string `json:"decline_code"`
+	} `json:"error"`
+}
+
+func riskRule(t *testing.T, r *gin.Engine, expression, action string) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/risk_rules", map[string]interface{}{"expression": expression, "action": action})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var rule struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rule)
+	return rule.ID
+}
+
+func screenedCharge(t *testing.T, r *gin.Engine, customer string, amount int64) (int, riskCharge) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": amount, "currency": "usd", "customer": customer})
+	var charge riskCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return w.Code, charge
+}
+
+func TestRiskExpressionCompile(t *testing.T) {
+	for _, src := range []string{
+		"amount > 100 and (country in ['US', 'CA'] or not customer.known)",
+		"card.brand != 'amex' and customer.declines_24h >= 3",
+		"risk_score > 1_000 or currency not in [\"eur\"]",
+	} {
+		if _, err := risk.Compile(src); err != nil {
+			t.Fatalf("expected %q to compile, got %v", src, err)
+		}
+	}
+	for _, src := range []string{
+		"amount",
+		"amount > 'ten'",
+		"country < 'US'",
+		"amout > 100",
+		"amount > 100 and",
+		"country in ['US', 1]",
+		"currency == 'usd",
+		"amount = 100",
+	} {
+		if _, err := risk.Compile(src); err == nil {
+			t.Fatalf("expected %q not to compile", src)
+		}
+	}
+
+	expr, _ := risk.Compile("amount >= 5000 and currency in ['USD']")
+	if !expr.Match(risk.Attributes{"amount": float64(5000), "currency": "usd"}) {
+		t.Fatal("expected the expression to match")
+	}
+	if expr.Match(risk.Attributes{"amount": float64(4999), "currency": "usd"}) {
+		t.Fatal("expected the expression not to match")
+	}
+
+	setupTestDB(t)
+	r := setupTestRouter()
+	w := doJSON(r, "POST", "/api/v1/risk_rules", map[string]interface{}{"expression": "amount >", "action": "block"})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "expression" {
+		t.Fatalf("expected the expression to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRiskRuleBlocksCharge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	blockID := riskRule(t, r, "amount >= 50000", "block")
+
+	code, charge := screenedCharge(t, r, "cust_risky", 60000)
+	if code != http.StatusPaymentRequired || charge.Error.DeclineCode != "fraudulent" {
+		t.Fatalf("expected the charge to be blocked, got %d: %+v", code, charge)
+	}
+	if charge.Risk.Outcome != risk.ActionBlock || len(charge.Risk.Rules) != 1 || charge.Risk.Rules[0] != blockID {
+		t.Fatalf("expected the block rule to be recorded, got %+v", charge.Risk)
+	}
+	if got, _ := ledger.Balance(config.DB, ledger.AccountMerchantPending, "usd"); got != 0 {
+		t.Fatalf("expected nothing booked for a blocked charge, got %d", got)
+	}
+
+	code, charge = screenedCharge(t, r, "cust_risky", 100)
+	if code != http.StatusCreated || charge.Risk.Outcome != risk.OutcomeNormal || len(charge.Risk.Rules) != 0 {
+		t.Fatalf("expected a small charge through, got %d: %+v", code, charge)
+	}
+
+	riskRule(t, r, "customer.id == 'cust_vip'", "allow")
+	code, charge = screenedCharge(t, r, "cust_vip", 60000)
+	if code != http.StatusCreated || charge.Risk.Outcome != risk.ActionAllow || len(charge.Risk.Rules) != 2 {
+		t.Fatalf("expected the allow rule to win, got %d: %+v", code, charge)
+	}
+
+	w := doJSON(r, "POST", "/api/v1/risk_rules/"+blockID, map[string]interface{}{"active": false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	code, _ = screenedCharge(t, r, "cust_risky", 60000)
+	if code != http.StatusCreated {
+		t.Fatalf("expected the inactive rule to be skipped, got %d", code)
+	}
+}
+
+func TestRiskReviewApproveAndReject(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	riskRule(t, r, "amount > 1000", "review")
+
+	_, kept := screenedCharge(t, r, "cust_review", 5000)
+	_, rejected := screenedCharge(t, r, "cust_review", 7000)
+	if kept.Status != "requires_capture" || kept.Risk.Outcome != risk.ActionReview {
+		t.Fatalf("expected the charge authorized and held for review, got %+v", kept)
+	}
+	if got, _ := ledger.Balance(config.DB, ledger.AccountMerchantPending, "usd"); got != 0 {
+		t.Fatalf("expected nothing booked for held charges, got %d", got)
+	}
+
+	w := doJSON(r, "GET", "/api/v1/reviews?status=open", nil)
+	var list struct {
+		Data []struct {
+			ID     string `json:"id"`
+			Charge string `json:"charge"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 2 {
+		t.Fatalf("expected two open reviews, got %s", w.Body.String())
+	}
+	byCharge := map[string]string{}
+	for _, rv := range list.Data {
+		byCharge[rv.Charge] = rv.ID
+	}
+
+	w = doJSON(r, "POST", "/api/v1/reviews/"+byCharge[kept.ID]+"/approve", map[string]interface{}{"analyst": "ana@example.com", "note": "Known buyer."})
+	var approved struct {
+		Status   string `json:"status"`
+		ClosedBy string `json:"closed_by"`
+		Note     string `json:"note"`
+		Details  struct {
+			Status string `json:"status"`
+		} `json:"charge_details"`
+		History []struct {
+			Action string `json:"action"`
+			Actor  string `json:"actor"`
+		} `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &approved)
+	if w.Code != http.StatusOK || approved.Status != "approved" || approved.Details.Status != "succeeded" {
+		t.Fatalf("expected the approved charge captured, got %d: %s", w.Code, w.Body.String())
+	}
+	if approved.ClosedBy != "ana@example.com" || approved.Note != "Known buyer." || len(approved.History) != 2 ||
+		approved.History[0].Action != "opened" || approved.History[1].Action != "approved" || approved.History[1].Actor != "ana@example.com" {
+		t.Fatalf("expected the decision in the audit trail, got %s", w.Body.String())
+	}
+	if got, _ := ledger.Balance(config.DB, ledger.AccountMerchantPending, "usd"); got == 0 {
+		t.Fatal("expected the captured charge booked")
+	}
+	w = doJSON(r, "POST", "/api/v1/reviews/"+byCharge[kept.ID]+"/reject", nil)
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "review_closed" {
+		t.Fatalf("expected the closed review to stay approved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/reviews/"+byCharge[rejected.ID]+"/reject", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "GET", "/api/v1/charges/"+rejected.ID, nil)
+	var charge riskCharge
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.Status != "canceled" {
+		t.Fatalf("expected the rejected charge voided, got %s", w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": rejected.ID})
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "charge_not_refundable" {
+		t.Fatalf("expected the voided charge not refundable, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Wallet top-ups cannot wait for a review.
+	wallet := walletFor(t, r, "cust_topup", "basic")
+	w = doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/top_up", map[string]interface{}{"amount": 5000})
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected the top-up to be blocked, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRejectedReviewGivesBackCoupon(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	riskRule(t, r, "amount > 1000", "review")
+	createCoupon(t, r, map[string]interface{}{"id": "LAUNCH", "amount_off": 200, "currency": "usd", "duration": "once", "max_redemptions": 1})
+
+	w := doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{"amount": 5000, "currency": "usd", "customer": "cust_review", "coupon": "LAUNCH"})
+	var held riskCharge
+	json.Unmarshal(w.Body.Bytes(), &held)
+	var rv models.Review
+	if err := config.DB.First(&rv, "transaction_id = ?", held.ID).Error; err != nil {
+		t.Fatalf("expected the charge held for review, got %s", w.Body.String())
+	}
+	if w := doJSON(r, "POST", "/api/v1/reviews/"+rv.ID+"/reject", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var coupon models.Coupon
+	config.DB.First(&coupon, "id = ?", "LAUNCH")
+	if coupon.TimesRedeemed != 0 {
+		t.Fatalf("expected the voided charge's redemption given back, got %d", coupon.TimesRedeemed)
+	}
+}
+
+func TestRiskReviewExpires(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	riskRule(t, r, "amount > 1000", "review")
+	t.Setenv("REVIEW_EXPIRY_HOURS", "24")
+	t.Setenv("REVIEW_DEFAULT_ACTION", "approve")
+
+	_, approved := screenedCharge(t, r, "cust_expiry", 5000)
+	t.Setenv("REVIEW_DEFAULT_ACTION", "reject")
+	_, rejected := screenedCharge(t, r, "cust_expiry", 6000)
+
+	if n, err := reviews.ExpireOverdue(config.DB, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected no review overdue yet, got %d, %v", n, err)
+	}
+	// A review that cannot be closed does not hold up the others.
+	config.DB.Create(&models.Review{ID: "rv_broken", TransactionID: "txn_missing", Status: risk.ReviewOpen, DefaultAction: risk.DecisionReject, ExpiresAt: time.Now().Add(-time.Hour)})
+	if n, err := reviews.ExpireOverdue(config.DB, time.Now().Add(25*time.Hour)); err != nil || n != 2 {
+		t.Fatalf("expected both reviews expired, got %d, %v", n, err)
+	}
+
+	for id, want := range map[string]string{approved.ID: "succeeded", rejected.ID: "canceled"} {
+		w := doJSON(r, "GET", "/api/v1/charges/"+id, nil)
+		var charge riskCharge
+		json.Unmarshal(w.Body.Bytes(), &charge)
+		if charge.Status != want {
+			t.Fatalf("expected the expired review's default action, got %s", w.Body.String())
+		}
+	}
+
+	var rv models.Review
+	config.DB.First(&rv, "transaction_id = ?", rejected.ID)
+	if rv.Status != risk.ReviewRejected || rv.ClosedBy != risk.ActorSystem || rv.Note != "The review expired after 24 hours." {
+		t.Fatalf("expected the review closed by the system, got %+v", rv)
+	}
+	var events []models.ReviewEvent
+	config.DB.Where("review_id = ?", rv.ID).Order("created_at, id").Find(&events)
+	if len(events) != 2 || events[1].Action != risk.ReviewActionExpired {
+		t.Fatalf("expected the expiry in the audit trail, got %+v", events)
+	}
+}
+
+func TestRiskScore(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	_, charge := screenedCharge(t, r, "cust_unknown", 1000)
+	if charge.Risk.Score != 15 {
+		t.Fatalf("expected 15 for an unknown customer, got %+v", charge.Risk)
+	}
+	_, charge = screenedCharge(t, r, "cust_unknown", 500000)
+	if charge.Risk.Score != 50 {
+		t.Fatalf("expected 50 for a large charge of an unknown customer, got %+v", charge.Risk)
+	}
+
+	riskRule(t, r, "risk_score >= 50", "block")
+	code, charge := screenedCharge(t, r, "cust_unknown", 500000)
+	if code != http.StatusPaymentRequired || charge.Risk.Outcome != risk.ActionBlock {
+		t.Fatalf("expected rules to see the score, got %d: %+v", code, charge)
+	}
+}
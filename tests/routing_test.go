@@ -0,0 +1,269 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/processor"
+)
+
+func newTestRouter(t *testing.T, rules []processor.Rule, defaults processor.Rule, timeout time.Duration) (*processor.Router, *processor.Simulator, *processor.Simulator) {
+	simA := processor.NewSimulator("sim_a")
+	simB := processor.NewSimulator("sim_b")
+	router, err := processor.NewRouter([]processor.Processor{simA, simB}, rules, defaults, timeout)
+	if err != nil {
+		t.Fatalf("failed to build router: %v", err)
+	}
+	return router, simA, simB
+}
+
+func useProcessor(t *testing.T, p processor.Processor) {
+	previous := processor.Default
+	processor.Default = p
+	t.Cleanup(func() { processor.Default = previous })
+}
+
+func TestRoutingRulesSelectProcessor(t *testing.T) {
+	rules := []processor.Rule{
+		{
+			Name:    "eur",
+			Match:   processor.Match{Currencies: []string{"eur"}},
+			Targets: []processor.Target{{Processor: "sim_b", Weight: 1}},
+		},
+		{
+			Name:    "large_amex_de",
+			Match:   processor.Match{CardBrands: []string{"amex"}, Countries: []string{"DE"}, MinAmount: 10000},
+			Targets: []processor.Target{{Processor: "sim_b", Weight: 1}},
+		},
+	}
+	defaults := processor.Rule{Targets: []processor.Target{{Processor: "sim_a", Weight: 1}}}
+	router, _, _ := newTestRouter(t, rules, defaults, 0)
+
+	cases := []struct {
+		params processor.ChargeParams
+		want   string
+	}{
+		{processor.ChargeParams{Amount: 100, Currency: "EUR"}, "sim_b"},
+		{processor.ChargeParams{Amount: 100, Currency: "usd"}, "sim_a"},
+		{processor.ChargeParams{Amount: 20000, Currency: "usd", Country: "DE", Card: processor.Card{Number: "378282246310005"}}, "sim_b"},
+		{processor.ChargeParams{Amount: 500, Currency: "usd", Country: "DE", Card: processor.Card{Number: "378282246310005"}}, "sim_a"},
+		{processor.ChargeParams{Amount: 20000, Currency: "usd", Country: "FR", Card: processor.Card{Number: "378282246310005"}}, "sim_a"},
+	}
+
+	for i, tc := range cases {
+		got := router.Candidates(tc.params)[0]
+		if got != tc.want {
+			t.Fatalf("case %d: expected %s, got %s", i, tc.want, got)
+		}
+	}
+}
+
+func TestRoutingWeightedSplit(t *testing.T) {
+	defaults := processor.Rule{Targets: []processor.Target{
+		{Processor: "sim_a", Weight: 80},
+		{Processor: "sim_b", Weight: 20},
+	}}
+	router, _, _ := newTestRouter(t, nil, defaults, 0)
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		counts[router.Candidates(processor.ChargeParams{Amount: 100, Currency: "usd"})[0]]++
+	}
+
+	if counts["sim_a"] < 1400 || counts["sim_a"] > 1800 {
+		t.Fatalf("expected roughly 80%% on sim_a, got %v", counts)
+	}
+}
+
+func TestRoutingFailoverOnUnavailable(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	defaults := processor.Rule{
+		Targets:  []processor.Target{{Processor: "sim_a", Weight: 1}},
+		Fallback: []string{"sim_b"},
+	}
+	router, simA, _ := newTestRouter(t, nil, defaults, 0)
+	simA.SetUnavailable(true)
+	useProcessor(t, router)
+
+	w := chargeWithCard(r, "4242424242424242")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", resp["id"])
+	if txn.Processor != "sim_b" {
+		t.Fatalf("expected failover to sim_b, got %q", txn.Processor)
+	}
+	if txn.Status != "succeeded" {
+		t.Fatalf("expected succeeded, got %s", txn.Status)
+	}
+}
+
+func TestRoutingFailoverOnTimeout(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	defaults := processor.Rule{
+		Targets:  []processor.Target{{Processor: "sim_a", Weight: 1}},
+		Fallback: []string{"sim_b"},
+	}
+	router, simA, _ := newTestRouter(t, nil, defaults, 50*time.Millisecond)
+	simA.SetLatency(200 * time.Millisecond)
+	useProcessor(t, router)
+
+	w := chargeWithCard(r, "4242424242424242")
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp["processor"] != "sim_b" {
+		t.Fatalf("expected failover to sim_b after timeout, got %v", resp["processor"])
+	}
+}
+
+func TestRoutingVoidsLateChargeAfterTimeout(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	defaults := processor.Rule{
+		Targets:  []processor.Target{{Processor: "sim_a", Weight: 1}},
+		Fallback: []string{"sim_b"},
+	}
+	router, simA, simB := newTestRouter(t, nil, defaults, 50*time.Millisecond)
+	simA.SetLatency(150 * time.Millisecond)
+	useProcessor(t, router)
+
+	w := chargeWithCard(r, "4242424242424242")
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	id, _ := resp["id"].(string)
+	if resp["processor"] != "sim_b" || simB.Status(processor.AttemptKey(id, "sim_b")) != "succeeded" {
+		t.Fatalf("expected the charge on sim_b, got %s", w.Body.String())
+	}
+
+	// The primary charges the card once it catches up; the router voids it.
+	deadline := time.Now().Add(2 * time.Second)
+	for simA.Status(processor.AttemptKey(id, "sim_a")) != "voided" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the late charge on sim_a voided, got %q", simA.Status(processor.AttemptKey(id, "sim_a")))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Sending the same attempt again does not charge the card twice.
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", id)
+	again, err := simB.Charge(processor.ChargeParams{IdempotencyKey: processor.AttemptKey(id, "sim_b"), Amount: 1500, Currency: "usd"})
+	if err != nil || again.Reference != txn.ProcessorRef {
+		t.Fatalf("expected the attempt replayed as %s, got %+v, %v", txn.ProcessorRef, again, err)
+	}
+}
+
+func TestSimulatorForgetsChargesAfterIdempotencyWindow(t *testing.T) {
+	sim := processor.NewSimulator("sim_a")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim.SetClock(func() time.Time { return now })
+
+	if _, err := sim.Charge(processor.ChargeParams{IdempotencyKey: "attempt_old", Amount: 1500, Currency: "usd"}); err != nil {
+		t.Fatalf("charge failed: %v", err)
+	}
+	now = now.Add(processor.IdempotencyWindow - time.Minute)
+	if _, err := sim.Charge(processor.ChargeParams{IdempotencyKey: "attempt_recent", Amount: 1500, Currency: "usd"}); err != nil {
+		t.Fatalf("charge failed: %v", err)
+	}
+	if sim.Status("attempt_old") != "succeeded" {
+		t.Fatalf("expected the charge remembered within the window, got %q", sim.Status("attempt_old"))
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := sim.Charge(processor.ChargeParams{IdempotencyKey: "attempt_new", Amount: 1500, Currency: "usd"}); err != nil {
+		t.Fatalf("charge failed: %v", err)
+	}
+	if sim.Status("attempt_old") != "" {
+		t.Fatalf("expected the charge forgotten after the window, got %q", sim.Status("attempt_old"))
+	}
+	if sim.Status("attempt_recent") != "succeeded" {
+		t.Fatalf("expected the recent charge remembered, got %q", sim.Status("attempt_recent"))
+	}
+}
+
+func TestRoutingNoFailoverOnDecline(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	defaults := processor.Rule{
+		Targets:  []processor.Target{{Processor: "sim_a", Weight: 1}},
+		Fallback: []string{"sim_b"},
+	}
+	router, _, _ := newTestRouter(t, nil, defaults, 0)
+	useProcessor(t, router)
+
+	w := chargeWithCard(r, "4000000000000002")
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if w.Code != http.StatusPaymentRequired || resp["processor"] != "sim_a" {
+		t.Fatalf("expected decline on sim_a without failover, got %d %v", w.Code, resp["processor"])
+	}
+}
+
+func TestRoutingAllProcessorsUnavailable(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	defaults := processor.Rule{
+		Targets:  []processor.Target{{Processor: "sim_a", Weight: 1}},
+		Fallback: []string{"sim_b"},
+	}
+	router, simA, simB := newTestRouter(t, nil, defaults, 0)
+	simA.SetUnavailable(true)
+	simB.SetUnavailable(true)
+	useProcessor(t, router)
+
+	w := chargeWithCard(r, "4242424242424242")
+	var resp struct {
+		Status string `json:"status"`
+		Error  struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp.Status != "failed" || resp.Error.Code != "processor_unavailable" {
+		t.Fatalf("expected processor_unavailable failure, got %+v", resp)
+	}
+}
+
+func TestLoadRouterConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.json")
+	os.WriteFile(path, []byte(`{
+		"processors": [{"name": "sim_a", "type": "simulator"}, {"name": "sim_b", "type": "simulator"}],
+		"rules": [{"name": "gbp", "match": {"currencies": ["gbp"]}, "targets": [{"processor": "sim_b", "weight": 1}]}],
+		"default": {"targets": [{"processor": "sim_a", "weight": 1}], "fallback": ["sim_b"]},
+		"timeout_ms": 2000
+	}`), 0o644)
+
+	router, err := processor.LoadRouter(path)
+	if err != nil {
+		t.Fatalf("failed to load router: %v", err)
+	}
+	if got := router.Candidates(processor.ChargeParams{Currency: "gbp"}); got[0] != "sim_b" {
+		t.Fatalf("expected sim_b for gbp, got %v", got)
+	}
+
+	os.WriteFile(path, []byte(`{"processors": [{"name": "sim_a"}], "default": {"targets": [{"processor": "missing", "weight": 1}]}}`), 0o644)
+	if _, err := processor.LoadRouter(path); err == nil {
+		t.Fatal("expected error for unknown processor")
+	}
+}
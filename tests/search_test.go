@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/search"
+)
+
+var testSchema = search.Schema{
+	Fields: map[string]search.Field{
+		"status":   {Column: "status", Type: search.String},
+		"customer": {Column: "customer", Type: search.String},
+		"amount":   {Column: "amount", Type: search.Number},
+	},
+}
+
+func TestSearchCompile(t *testing.T) {
+	cases := []struct {
+		query string
+		sql   string
+		args  int
+	}{
+		{`status:"succeeded"`, "status = ?", 1},
+		{`status:"succeeded" AND amount>1000`, "(status = ? AND amount > ?)", 2},
+		{`status:"a" OR status:"b" AND amount<=5`, "(status = ? OR (status = ? AND amount <= ?))", 3},
+		{`(status:"a" OR status:"b") and -customer:"c"`, "((status = ? OR status = ?) AND NOT (customer = ?))", 3},
+		{`customer~"cust_1"`, `customer LIKE ? ESCAPE '\'`, 1},
+	}
+
+	for _, tc := range cases {
+		q, err := search.Compile(tc.query, testSchema)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.query, err)
+		}
+		if q.SQL != tc.sql || len(q.Args) != tc.args {
+			t.Fatalf("%s: expected %q with %d args, got %q with %v", tc.query, tc.sql, tc.args, q.SQL, q.Args)
+		}
+	}
+}
+
+func TestSearchSyntaxErrors(t *testing.T) {
+	cases := []struct {
+		query string
+		pos   int
+		want  string
+	}{
+		{``, 1, "empty"},
+		{`status:`, 8, "expected a value"},
+		{`status:succeeded`, 8, "must be quoted"},
+		{`status:"succeeded`, 8, "unterminated string"},
+		{`status:"a" customer:"b"`, 12, "expected AND or OR"},
+		{`(status:"a"`, 12, `expected ")"`},
+		{`status:"a")`, 11, `unmatched ")"`},
+		{`status="a"`, 7, "unexpected character"},
+		{`amount>"10"`, 1, "unquoted number"},
+		{`status>1`, 1, "text field"},
+		{`email:"x"`, 1, "unknown field"},
+		{`status["k"]:"x"`, 1, "does not take a [key]"},
+		{`AND status:"a"`, 1, "expected a field name"},
+	}
+
+	for _, tc := range cases {
+		_, err := search.Compile(tc.query, testSchema)
+		var syntaxErr *search.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("%q: expected syntax error, got %v", tc.query, err)
+		}
+		if syntaxErr.Pos != tc.pos || !strings.Contains(syntaxErr.Message, tc.want) {
+			t.Fatalf("%q: expected %q at %d, got %q at %d", tc.query, tc.want, tc.pos, syntaxErr.Message, syntaxErr.Pos)
+		}
+	}
+}
+
+func TestSearchChargesEndpoint(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	seedTransactions(t, 9)
+
+	query := url.QueryEscape(`status:"succeeded" AND amount>500 AND customer:"cust_1"`)
+	w := doJSON(r, "GET", "/api/v1/charges/search?limit=100&query="+query, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var list chargeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Object != "search_result" {
+		t.Fatalf("expected search_result object, got %s", list.Object)
+	}
+	// cust_1 has odd indexes; 3 is failed, leaving 5 and 7 (amounts 600, 800).
+	if len(list.Data) != 2 {
+		t.Fatalf("expected 2 results, got %+v", list.Data)
+	}
+	for _, d := range list.Data {
+		if d.Status != "succeeded" || d.Amount <= 500 || d.Customer != "cust_1" {
+			t.Fatalf("unexpected result %+v", d)
+		}
+	}
+}
+
+func TestSearchChargesRejectsInjection(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	seedTransactions(t, 3)
+
+	query := url.QueryEscape(`customer:"x' OR '1'='1"`)
+	w := doJSON(r, "GET", "/api/v1/charges/search?query="+query, nil)
+	var list chargeList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Data) != 0 {
+		t.Fatalf("expected no results, got %d %+v", w.Code, list.Data)
+	}
+}
+
+func TestSearchChargesSyntaxError(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "GET", "/api/v1/charges/search?query="+url.QueryEscape(`amount>>5`), nil)
+	env := decodeError(t, w)
+	if w.Code != http.StatusBadRequest || env.Error.Param != "query" || !strings.Contains(env.Error.Message, "position 8") {
+		t.Fatalf("unexpected response %d %+v", w.Code, env.Error)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/charges/search", nil)
+	env = decodeError(t, w)
+	if env.Error.Code != "parameter_missing" || env.Error.Param != "query" {
+		t.Fatalf("unexpected response %+v", env.Error)
+	}
+}
+
+func TestSearchEventsEndpoint(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	config.DB.Create(&models.WebhookEvent{TransactionID: "txn_a", EventType: "charge.failed", Payload: `{}`, TargetURL: "http://x", Status: "failed"})
+	config.DB.Create(&models.WebhookEvent{TransactionID: "txn_b", EventType: "payment.succeeded", Payload: `{}`, TargetURL: "http://x", Status: "delivered"})
+
+	w := doJSON(r, "GET", "/api/v1/events/search?query="+url.QueryEscape(`type:"charge.failed" OR transaction:"txn_none"`), nil)
+	var resp struct {
+		Data []struct {
+			Type          string `json:"type"`
+			TransactionID string `json:"transaction_id"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || len(resp.Data) != 1 || resp.Data[0].TransactionID != "txn_a" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+}
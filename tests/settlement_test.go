@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/settlement"
+)
+
+type balanceAmount struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func TestSettlementCalendar(t *testing.T) {
+	t.Setenv("SETTLEMENT_HOLIDAYS", "2026-12-25, not-a-date")
+	cal := settlement.LoadCalendar()
+
+	cases := []struct {
+		at    string
+		delay int
+		want  string
+	}{
+		{"2026-10-14T15:00:00Z", 2, "2026-10-16T00:00:00Z"}, // Wednesday → Friday
+		{"2026-10-16T15:00:00Z", 2, "2026-10-20T00:00:00Z"}, // Friday → Tuesday
+		{"2026-10-17T09:00:00Z", 1, "2026-10-19T00:00:00Z"}, // Saturday → Monday
+		{"2026-12-23T10:00:00Z", 2, "2026-12-28T00:00:00Z"}, // over Christmas
+	}
+	for _, c := range cases {
+		at, _ := time.Parse(time.RFC3339, c.at)
+		if got := cal.AvailableOn(at, c.delay).Format(time.RFC3339); got != c.want {
+			t.Errorf("AvailableOn(%s, %d) = %s, want %s", c.at, c.delay, got, c.want)
+		}
+	}
+
+	at := time.Now()
+	if !cal.AvailableOn(at, 0).Equal(at) {
+		t.Error("expected no delay to make funds available at once")
+	}
+}
+
+func TestChargeFundsPendingUntilSettled(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	usBankAccount(t, r, "000123456789")
+
+	w := chargeWithCard(r, "4242424242424242")
+	var charge map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+
+	var bt models.BalanceTransaction
+	config.DB.First(&bt, "id = ?", charge["balance_transaction"])
+	if bt.Status != balance.StatusPending || !bt.AvailableOn.After(time.Now()) {
+		t.Fatalf("expected the charge to settle later, got %+v", bt)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/balance", nil)
+	var bal struct {
+		Available []balanceAmount `json:"available"`
+		Pending   []balanceAmount `json:"pending"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &bal)
+	if len(bal.Available) != 0 || len(bal.Pending) != 1 || bal.Pending[0].Amount != chargeNet {
+		t.Fatalf("expected the net to be pending, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 100, "currency": "usd"})
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected pending funds not to be payable, got %d", w.Code)
+	}
+
+	if n, _ := balance.Release(config.DB, bt.AvailableOn.Add(-time.Minute)); n != 0 {
+		t.Fatalf("expected nothing released early, got %d", n)
+	}
+	if n, err := balance.Release(config.DB, bt.AvailableOn); err != nil || n != 1 {
+		t.Fatalf("expected the charge released, got %d (%v)", n, err)
+	}
+	if n, _ := balance.Release(config.DB, bt.AvailableOn); n != 0 {
+		t.Fatalf("expected a release to happen once, got %d", n)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/balance", nil)
+	json.Unmarshal(w.Body.Bytes(), &bal)
+	if len(bal.Available) != 1 || bal.Available[0].Amount != chargeNet || bal.Pending[0].Amount != 0 {
+		t.Fatalf("expected the net to be available, got %s", w.Body.String())
+	}
+	createPayout(t, r, chargeNet)
+}
+
+func TestMerchantWithoutSettlementDelay(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/merchants", map[string]interface{}{"id": "acct_fast", "settlement_delay_days": 0, "platform": "acct_default"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var m map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &m)
+	if m["settlement_delay_days"].(float64) != 0 {
+		t.Fatalf("expected no settlement delay, got %v", m)
+	}
+
+	doJSON(r, "POST", "/api/v1/charges", map[string]interface{}{
+		"amount": 1000, "currency": "usd", "customer": "cust_fast", "merchant": "acct_fast",
+	})
+	if available := merchantAvailable(t, r, "acct_fast"); available != 1000-59 {
+		t.Fatalf("expected the charge available at once, got %d", available)
+	}
+}
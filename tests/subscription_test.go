@@ -0,0 +1,386 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/billing"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/models"
+	"github.com/vaidikcode/minipay/payments"
+	"github.com/vaidikcode/minipay/processor"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type subscriptionResp struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	Quantity          int64  `json:"quantity"`
+	Price             string `json:"price"`
+	CurrentPeriodEnd  string `json:"current_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	ProrationAmount   int64  `json:"proration_amount"`
+	AmountDue         int64  `json:"amount_due"`
+	Attempts          int    `json:"attempts"`
+	LatestTransaction string `json:"latest_transaction"`
+}
+
+func cardCustomer(t *testing.T, r *gin.Engine, id, number string) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{
+		"id":   id,
+		"card": map[string]interface{}{"number": number, "exp_month": 12, "exp_year": 2099},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func createPrice(t *testing.T, r *gin.Engine, params map[string]interface{}) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/products", map[string]interface{}{"name": "Team plan"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var product struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &product)
+
+	body := map[string]interface{}{"product": product.ID, "currency": "usd", "unit_amount": 1000, "interval": "month"}
+	for k, v := range params {
+		body[k] = v
+	}
+	w = doJSON(r, "POST", "/api/v1/prices", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var price struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &price)
+	return price.ID
+}
+
+func subscribe(t *testing.T, r *gin.Engine, body map[string]interface{}) subscriptionResp {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/subscriptions", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var s subscriptionResp
+	json.Unmarshal(w.Body.Bytes(), &s)
+	return s
+}
+
+func getSubscription(t *testing.T, r *gin.Engine, id string) subscriptionResp {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/subscriptions/"+id, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var s subscriptionResp
+	json.Unmarshal(w.Body.Bytes(), &s)
+	return s
+}
+
+func renewAt(t *testing.T, at time.Time) {
+	t.Helper()
+	if _, err := billing.Renew(config.DB, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscriptionChargesFirstPeriodAndRenews(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, map[string]interface{}{"billing_scheme": "per_unit"})
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price, "quantity": 3})
+	if s.Status != "active" || s.LatestTransaction == "" {
+		t.Fatalf("unexpected subscription %+v", s)
+	}
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", s.LatestTransaction)
+	if txn.Amount != 3000 || txn.Status != "succeeded" || txn.Metadata["subscription"] != s.ID {
+		t.Fatalf("unexpected first charge %+v", txn)
+	}
+
+	renewAt(t, time.Now().AddDate(0, 0, 20))
+	if got := getSubscription(t, r, s.ID); got.LatestTransaction != s.LatestTransaction {
+		t.Fatalf("expected no renewal before the period ends, got %+v", got)
+	}
+
+	renewAt(t, time.Now().AddDate(0, 1, 1))
+	renewed := getSubscription(t, r, s.ID)
+	if renewed.LatestTransaction == s.LatestTransaction || renewed.Status != "active" || renewed.CurrentPeriodEnd <= s.CurrentPeriodEnd {
+		t.Fatalf("expected the subscription to renew, got %+v", renewed)
+	}
+	types := eventTypes(s.ID)
+	for _, typ := range []string{"subscription.created", "subscription.payment_succeeded", "subscription.renewed"} {
+		if !types[typ] {
+			t.Errorf("expected a %s event, got %v", typ, types)
+		}
+	}
+
+	cardCustomer(t, r, "cus_declined", "4000000000000002")
+	w := doJSON(r, "POST", "/api/v1/subscriptions", map[string]interface{}{"customer": "cus_declined", "price": price})
+	if env := decodeError(t, w); w.Code != http.StatusPaymentRequired || env.Error.Code != "payment_failed" {
+		t.Fatalf("expected a declined first payment to fail, got %d: %s", w.Code, w.Body.String())
+	}
+
+	doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": "cus_nocard"})
+	w = doJSON(r, "POST", "/api/v1/subscriptions", map[string]interface{}{"customer": "cus_nocard", "price": price})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "customer" {
+		t.Fatalf("expected a customer without a card to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubscriptionTrialEndsWithFirstCharge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, map[string]interface{}{"trial_days": 14})
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price})
+	if s.Status != "trialing" || s.LatestTransaction != "" {
+		t.Fatalf("expected a trial without a charge, got %+v", s)
+	}
+
+	renewAt(t, time.Now().AddDate(0, 0, 15))
+	s = getSubscription(t, r, s.ID)
+	if s.Status != "active" || s.LatestTransaction == "" {
+		t.Fatalf("expected the trial to end with a charge, got %+v", s)
+	}
+	if !eventTypes(s.ID)["subscription.trial_ended"] {
+		t.Fatalf("expected a subscription.trial_ended event")
+	}
+
+	noTrial := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price, "trial_days": 0})
+	if noTrial.Status != "active" {
+		t.Fatalf("expected trial_days 0 to skip the trial, got %+v", noTrial)
+	}
+}
+
+func TestSubscriptionProratesPriceChange(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	basic := createPrice(t, r, map[string]interface{}{"unit_amount": 1000})
+	pro := createPrice(t, r, map[string]interface{}{"unit_amount": 3000})
+	yearly := createPrice(t, r, map[string]interface{}{"interval": "year"})
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": basic})
+
+	w := doJSON(r, "POST", "/api/v1/subscriptions/"+s.ID, map[string]interface{}{"price": pro})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated subscriptionResp
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	// Switching right away owes nearly the whole difference.
+	if updated.Price != pro || updated.ProrationAmount < 1990 || updated.ProrationAmount > 2000 {
+		t.Fatalf("unexpected proration %+v", updated)
+	}
+
+	renewAt(t, time.Now().AddDate(0, 1, 1))
+	s = getSubscription(t, r, s.ID)
+	var txn models.Transaction
+	config.DB.First(&txn, "id = ?", s.LatestTransaction)
+	if txn.Amount != 3000+updated.ProrationAmount || s.ProrationAmount != 0 {
+		t.Fatalf("expected the renewal to include the proration, got %d (%+v)", txn.Amount, s)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/subscriptions/"+s.ID, map[string]interface{}{"price": basic, "proration_behavior": "none"})
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	if w.Code != http.StatusOK || updated.ProrationAmount != 0 {
+		t.Fatalf("expected no proration, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/subscriptions/"+s.ID, map[string]interface{}{"price": yearly})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "price" {
+		t.Fatalf("expected a price with another interval to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubscriptionDunning(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, nil)
+
+	w := doJSON(r, "POST", "/api/v1/dunning_settings", map[string]interface{}{"retry_days": []int{3, 1}, "final_action": "canceled"})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "retry_days" {
+		t.Fatalf("expected unordered retry days to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/dunning_settings", map[string]interface{}{"retry_days": []int{1, 3}, "final_action": "canceled"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price})
+	doJSON(r, "POST", "/api/v1/customers/cus_saas", map[string]interface{}{
+		"card": map[string]interface{}{"number": "4000000000009995", "exp_month": 12, "exp_year": 2099},
+	})
+
+	due := time.Now().AddDate(0, 1, 1)
+	renewAt(t, due)
+	s = getSubscription(t, r, s.ID)
+	if s.Status != "past_due" || s.AmountDue != 1000 || s.Attempts != 1 {
+		t.Fatalf("expected a failed renewal to leave the subscription past_due, got %+v", s)
+	}
+
+	renewAt(t, due.AddDate(0, 0, 1))
+	if s = getSubscription(t, r, s.ID); s.Status != "past_due" || s.Attempts != 2 {
+		t.Fatalf("expected the first retry to fail, got %+v", s)
+	}
+	renewAt(t, due.AddDate(0, 0, 2))
+	if s = getSubscription(t, r, s.ID); s.Attempts != 2 {
+		t.Fatalf("expected no retry before the next retry day, got %+v", s)
+	}
+	renewAt(t, due.AddDate(0, 0, 3))
+	if s = getSubscription(t, r, s.ID); s.Status != "canceled" {
+		t.Fatalf("expected the subscription to be canceled after the last retry, got %+v", s)
+	}
+	types := eventTypes(s.ID)
+	if !types["subscription.payment_failed"] || !types["subscription.canceled"] {
+		t.Fatalf("unexpected events %v", types)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/subscriptions/"+s.ID+"/cancel", nil)
+	if env := decodeError(t, w); w.Code != http.StatusConflict || env.Error.Code != "subscription_ended" {
+		t.Fatalf("expected an ended subscription to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubscriptionRecoversOnRetry(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, nil)
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price})
+	doJSON(r, "POST", "/api/v1/customers/cus_saas", map[string]interface{}{
+		"card": map[string]interface{}{"number": "4000000000000002", "exp_month": 12, "exp_year": 2099},
+	})
+	due := time.Now().AddDate(0, 1, 1)
+	renewAt(t, due)
+
+	doJSON(r, "POST", "/api/v1/customers/cus_saas", map[string]interface{}{
+		"card": map[string]interface{}{"number": "5555555555554444", "exp_month": 12, "exp_year": 2099},
+	})
+	renewAt(t, due.AddDate(0, 0, 1))
+	if s = getSubscription(t, r, s.ID); s.Status != "active" || s.AmountDue != 0 || s.Attempts != 0 {
+		t.Fatalf("expected the retry to recover the subscription, got %+v", s)
+	}
+}
+
+func TestSubscriptionCancelAtPeriodEnd(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	price := createPrice(t, r, nil)
+
+	s := subscribe(t, r, map[string]interface{}{"customer": "cus_saas", "price": price})
+	w := doJSON(r, "POST", "/api/v1/subscriptions/"+s.ID+"/cancel", map[string]interface{}{"at_period_end": true})
+	var canceled subscriptionResp
+	json.Unmarshal(w.Body.Bytes(), &canceled)
+	if w.Code != http.StatusOK || canceled.Status != "active" || !canceled.CancelAtPeriodEnd {
+		t.Fatalf("expected the subscription to stay active until the period ends, got %d: %s", w.Code, w.Body.String())
+	}
+
+	renewAt(t, time.Now().AddDate(0, 1, 1))
+	if got := getSubscription(t, r, s.ID); got.Status != "canceled" || got.LatestTransaction != s.LatestTransaction {
+		t.Fatalf("expected the subscription to end without a charge, got %+v", got)
+	}
+
+	w = doJSON(r, "GET", "/api/v1/subscriptions?status=canceled&customer=cus_saas", nil)
+	var list struct {
+		Data []subscriptionResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].ID != s.ID {
+		t.Fatalf("unexpected list %s", w.Body.String())
+	}
+}
+
+func TestCustomerCardOnFile(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+
+	w := doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{
+		"card": map[string]interface{}{"number": "4242424242424241", "exp_month": 12, "exp_year": 2099},
+	})
+	if env := decodeError(t, w); w.Code != http.StatusBadRequest || env.Error.Param != "card[number]" {
+		t.Fatalf("expected an invalid card number to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+
+	cardCustomer(t, r, "cus_saas", "4242424242424242")
+	w = doJSON(r, "GET", "/api/v1/customers/cus_saas", nil)
+	var cust struct {
+		Card struct {
+			Brand  string `json:"brand"`
+			Last4  string `json:"last4"`
+			Number string `json:"number"`
+		} `json:"card"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &cust)
+	if cust.Card.Brand != "visa" || cust.Card.Last4 != "4242" || cust.Card.Number != "" {
+		t.Fatalf("unexpected card %s", w.Body.String())
+	}
+
+	var stored models.Customer
+	config.DB.First(&stored, "id = ?", "cus_saas")
+	if stored.CardToken != processor.Token("4242424242424242") || strings.Contains(stored.CardToken, "4242424242424242") {
+		t.Fatalf("expected only the card's token stored, got %q", stored.CardToken)
+	}
+	if config.DB.Migrator().HasColumn(&models.Customer{}, "card_number") {
+		t.Fatal("expected no card_number column")
+	}
+}
+
+func TestCardNumbersOnFileAreTokenized(t *testing.T) {
+	os.Remove("test_api.db")
+	db, err := gorm.Open(sqlite.Open("test_api.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type legacyCustomer struct {
+		ID         string `gorm:"primaryKey"`
+		CardNumber string `gorm:"size:19"`
+		CardBrand  string `gorm:"size:16"`
+		CardLast4  string `gorm:"size:4"`
+	}
+	legacy := db.Table("customers")
+	legacy.AutoMigrate(&legacyCustomer{})
+	legacy.Create(&[]legacyCustomer{{ID: "cus_old", CardNumber: "4000000000000002", CardBrand: "visa", CardLast4: "0002"}, {ID: "cus_none"}})
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	config.InitDB("test_api.db")
+	if config.DB.Migrator().HasColumn(&models.Customer{}, "card_number") {
+		t.Fatal("expected the card numbers dropped")
+	}
+	var old, none models.Customer
+	config.DB.First(&old, "id = ?", "cus_old")
+	config.DB.First(&none, "id = ?", "cus_none")
+	if old.CardToken != processor.Token("4000000000000002") || none.CardToken != "" {
+		t.Fatalf("expected the card on file tokenized, got %q and %q", old.CardToken, none.CardToken)
+	}
+
+	// The simulator still declines the test card by its token.
+	_, err = processor.Default.Charge(processor.ChargeParams{Amount: 1500, Currency: "usd", Card: payments.CardOnFile(old), OffSession: true})
+	var perr *processor.Error
+	if !errors.As(err, &perr) || perr.DeclineCode != "generic_decline" {
+		t.Fatalf("expected the card on file declined, got %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package tests
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type taxLineResp struct {
+	SourceType    string `json:"source_type"`
+	Source        string `json:"source"`
+	Invoice       string `json:"invoice"`
+	Country       string `json:"country"`
+	Jurisdiction  string `json:"jurisdiction"`
+	RateBps       int64  `json:"rate_bps"`
+	TaxableAmount int64  `json:"taxable_amount"`
+	TaxAmount     int64  `json:"tax_amount"`
+	ReverseCharge bool   `json:"reverse_charge"`
+	CustomerTaxID string `json:"customer_tax_id"`
+}
+
+type taxedChargeResp struct {
+	ID     string `json:"id"`
+	Amount int64  `json:"amount"`
+	Tax    *struct {
+		Amount   int64  `json:"amount"`
+		Behavior string `json:"behavior"`
+	} `json:"tax"`
+}
+
+// setupTaxRates loads a small rate table: California with a higher rate for
+// San Francisco postal codes, and German VAT with a reduced rate.
+func setupTaxRates(t *testing.T, r *gin.Engine) {
+	t.Helper()
+	rates := []map[string]interface{}{
+		{"id": "txr_ca", "name": "California", "kind": "sales_tax", "country": "US", "region": "CA", "rate_bps": 725},
+		{"id": "txr_sf", "name": "San Francisco", "kind": "sales_tax", "country": "US", "region": "CA", "postal_prefix": "94", "rate_bps": 863},
+		{"id": "txr_de", "name": "Germany VAT", "kind": "vat", "country": "DE", "rate_bps": 1900},
+		{"id": "txr_de_reduced", "name": "Germany VAT reduced", "kind": "vat", "country": "DE", "tax_code": "reduced", "rate_bps": 700},
+	}
+	for _, rate := range rates {
+		w := doJSON(r, "POST", "/api/v1/tax_rates", rate)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func taxCustomer(t *testing.T, r *gin.Engine, body map[string]interface{}) {
+	t.Helper()
+	body["card"] = map[string]interface{}{"number": "4242424242424242", "exp_month": 12, "exp_year": 2099}
+	w := doJSON(r, "POST", "/api/v1/customers", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func taxedCharge(t *testing.T, r *gin.Engine, amount int64, customer string, tax map[string]interface{}) taxedChargeResp {
+	t.Helper()
+	w := discountedCharge(r, amount, customer, map[string]interface{}{"tax": tax})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge taxedChargeResp
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	return charge
+}
+
+func listTaxLines(t *testing.T, r *gin.Engine, query string) []taxLineResp {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/tax_lines"+query, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list struct {
+		Data []taxLineResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	return list.Data
+}
+
+func TestTaxExclusiveAndInclusiveCharges(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_sf", "country": "us", "region": "ca", "postal_code": "94103"})
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_la", "country": "US", "region": "CA", "postal_code": "90012"})
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_berlin", "country": "DE", "postal_code": "10115"})
+
+	tests := []struct {
+		name     string
+		customer string
+		tax      map[string]interface{}
+		amount   int64
+		taxed    int64
+		rateBps  int64
+	}{
+		{"postal prefix beats region", "cus_sf", map[string]interface{}{"behavior": "exclusive"}, 1086, 86, 863},
+		{"region", "cus_la", map[string]interface{}{"behavior": "exclusive"}, 1073, 73, 725},
+		{"inclusive", "cus_berlin", map[string]interface{}{"behavior": "inclusive"}, 1000, 160, 1900},
+		{"tax code", "cus_berlin", map[string]interface{}{"behavior": "inclusive", "tax_code": "reduced"}, 1000, 65, 700},
+		{"exempt", "cus_berlin", map[string]interface{}{"behavior": "exclusive", "tax_code": "exempt"}, 1000, 0, 0},
+		{"address override", "cus_berlin", map[string]interface{}{"behavior": "exclusive", "country": "US", "region": "CA"}, 1073, 73, 725},
+		{"no rate", "cus_berlin", map[string]interface{}{"behavior": "exclusive", "country": "JP"}, 1000, 0, 0},
+	}
+	for _, tt := range tests {
+		charge := taxedCharge(t, r, 1000, tt.customer, tt.tax)
+		if charge.Amount != tt.amount || charge.Tax == nil || charge.Tax.Amount != tt.taxed {
+			t.Fatalf("%s: expected amount %d with %d tax, got %+v", tt.name, tt.amount, tt.taxed, charge)
+		}
+		lines := listTaxLines(t, r, "?source="+charge.ID)
+		if len(lines) != 1 || lines[0].RateBps != tt.rateBps || lines[0].TaxAmount != tt.taxed || lines[0].TaxableAmount+lines[0].TaxAmount != tt.amount {
+			t.Fatalf("%s: unexpected tax lines %+v", tt.name, lines)
+		}
+	}
+
+	// Charges without tax are not taxed, whatever the customer's address.
+	w := discountedCharge(r, 1000, "cus_sf", nil)
+	var plain taxedChargeResp
+	json.Unmarshal(w.Body.Bytes(), &plain)
+	if plain.Amount != 1000 || plain.Tax != nil || len(listTaxLines(t, r, "?source="+plain.ID)) != 0 {
+		t.Fatalf("expected an untaxed charge, got %s", w.Body.String())
+	}
+
+	w = discountedCharge(r, 1000, "cus_sf", map[string]interface{}{"tax": map[string]interface{}{"behavior": "exclusive", "tax_code": "luxury"}})
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Param != "tax[tax_code]" {
+		t.Fatalf("expected invalid tax code, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTaxReverseCharge(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+	if w := doJSON(r, "POST", "/api/v1/merchants/acct_default", map[string]interface{}{"country": "FR"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": "cus_bad", "country": "DE", "tax_id": "DE12345"})
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Param != "tax_id" {
+		t.Fatalf("expected invalid VAT ID, got %d: %s", w.Code, w.Body.String())
+	}
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_gmbh", "country": "DE", "tax_id": "de 123.456.789"})
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_private", "country": "DE"})
+
+	w = doJSON(r, "GET", "/api/v1/customers/cus_gmbh", nil)
+	var cust struct {
+		TaxID string `json:"tax_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &cust)
+	if cust.TaxID != "DE123456789" {
+		t.Fatalf("expected normalized VAT ID, got %q", cust.TaxID)
+	}
+
+	b2b := taxedCharge(t, r, 1000, "cus_gmbh", map[string]interface{}{"behavior": "exclusive"})
+	if b2b.Amount != 1000 || b2b.Tax.Amount != 0 {
+		t.Fatalf("expected no VAT on a reverse charged sale, got %+v", b2b)
+	}
+	lines := listTaxLines(t, r, "?source="+b2b.ID)
+	if len(lines) != 1 || !lines[0].ReverseCharge || lines[0].CustomerTaxID != "DE123456789" || lines[0].RateBps != 1900 {
+		t.Fatalf("expected a reverse charge line, got %+v", lines)
+	}
+
+	b2c := taxedCharge(t, r, 1000, "cus_private", map[string]interface{}{"behavior": "exclusive"})
+	if b2c.Amount != 1190 || b2c.Tax.Amount != 190 {
+		t.Fatalf("expected VAT without a VAT ID, got %+v", b2c)
+	}
+
+	// A domestic business buyer pays VAT as usual.
+	if w := doJSON(r, "POST", "/api/v1/merchants/acct_default", map[string]interface{}{"country": "DE"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	domestic := taxedCharge(t, r, 1000, "cus_gmbh", map[string]interface{}{"behavior": "exclusive"})
+	if domestic.Amount != 1190 {
+		t.Fatalf("expected VAT on a domestic sale, got %+v", domestic)
+	}
+
+	w = discountedCharge(r, 1000, "cus_private", map[string]interface{}{"tax": map[string]interface{}{"behavior": "exclusive", "tax_id": "FR123"}})
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Param != "tax[tax_id]" {
+		t.Fatalf("expected invalid VAT ID, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTaxCalculationPreview(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+
+	w := doJSON(r, "POST", "/api/v1/tax_calculations", map[string]interface{}{
+		"amount": 2000, "currency": "usd", "behavior": "exclusive", "country": "US", "region": "CA", "postal_code": "94 105",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var calc struct {
+		TaxRate   string `json:"tax_rate"`
+		TaxAmount int64  `json:"tax_amount"`
+		Total     int64  `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &calc)
+	if calc.TaxRate != "txr_sf" || calc.TaxAmount != 173 || calc.Total != 2173 {
+		t.Fatalf("unexpected calculation %s", w.Body.String())
+	}
+	if n := len(listTaxLines(t, r, "")); n != 0 {
+		t.Fatalf("expected a preview to record nothing, got %d tax lines", n)
+	}
+
+	// A disabled rate no longer applies.
+	if w := doJSON(r, "POST", "/api/v1/tax_rates/txr_sf/disable", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/tax_calculations", map[string]interface{}{
+		"amount": 2000, "currency": "usd", "behavior": "exclusive", "country": "US", "region": "CA", "postal_code": "94105",
+	})
+	json.Unmarshal(w.Body.Bytes(), &calc)
+	if calc.TaxRate != "txr_ca" || calc.TaxAmount != 145 {
+		t.Fatalf("expected the state rate, got %s", w.Body.String())
+	}
+}
+
+func TestTaxRefundReversesTaxLines(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_la", "country": "US", "region": "CA"})
+
+	charge := taxedCharge(t, r, 1000, "cus_la", map[string]interface{}{"behavior": "exclusive"})
+	w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var refund struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &refund)
+
+	lines := listTaxLines(t, r, "?source="+refund.ID)
+	if len(lines) != 1 || lines[0].SourceType != "refund" || lines[0].TaxAmount != -73 || lines[0].TaxableAmount != -1000 {
+		t.Fatalf("expected the refund to reverse the tax, got %+v", lines)
+	}
+}
+
+func TestInvoiceAutomaticTax(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_acme", "country": "DE"})
+
+	lines := []map[string]interface{}{
+		{"description": "Consulting", "quantity": 3, "unit_amount": 2500},
+		{"description": "Handbook", "unit_amount": 1000, "tax_code": "reduced"},
+	}
+	inv := draftInvoice(t, r, map[string]interface{}{"tax_behavior": "exclusive", "lines": lines})
+	if inv.Subtotal != 8500 || inv.Tax != 1495 || inv.Total != 9995 {
+		t.Fatalf("expected 1425 + 70 tax, got %+v", inv)
+	}
+
+	inv = invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inv.ID+"/finalize", nil, http.StatusOK)
+	if inv.Status != "paid" || inv.AmountPaid != 9995 {
+		t.Fatalf("expected the invoice to be paid, got %+v", inv)
+	}
+	recorded := listTaxLines(t, r, "?invoice="+inv.ID)
+	if len(recorded) != 2 || recorded[0].TaxAmount+recorded[1].TaxAmount != 1495 {
+		t.Fatalf("expected a tax line per invoice line, got %+v", recorded)
+	}
+
+	inclusive := draftInvoice(t, r, map[string]interface{}{"tax_behavior": "inclusive", "collection_method": "send_invoice", "lines": lines})
+	if inclusive.Tax != 1197+65 || inclusive.Total != 8500 {
+		t.Fatalf("expected tax included in the total, got %+v", inclusive)
+	}
+	invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inclusive.ID+"/finalize", nil, http.StatusOK)
+	invoiceRequest(t, r, "POST", "/api/v1/invoices/"+inclusive.ID+"/void", nil, http.StatusOK)
+	var net int64
+	for _, l := range listTaxLines(t, r, "?invoice="+inclusive.ID) {
+		net += l.TaxAmount
+	}
+	if net != 0 {
+		t.Fatalf("expected voiding to reverse the tax, %d left", net)
+	}
+
+	w := doJSON(r, "POST", "/api/v1/invoices", map[string]interface{}{
+		"customer": "cus_acme", "currency": "eur", "tax_behavior": "exclusive",
+		"lines": []map[string]interface{}{{"description": "Consulting", "unit_amount": 2500, "tax_rate_bps": 1000}},
+	})
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Param != "lines[0][tax_rate_bps]" {
+		t.Fatalf("expected tax_rate_bps to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTaxReport(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	setupTaxRates(t, r)
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_sf", "country": "US", "region": "CA", "postal_code": "94103"})
+	taxCustomer(t, r, map[string]interface{}{"id": "cus_la", "country": "US", "region": "CA", "postal_code": "90012"})
+
+	taxedCharge(t, r, 1000, "cus_sf", map[string]interface{}{"behavior": "exclusive"})
+	taxedCharge(t, r, 2000, "cus_sf", map[string]interface{}{"behavior": "exclusive"})
+	refunded := taxedCharge(t, r, 1000, "cus_la", map[string]interface{}{"behavior": "exclusive"})
+	if w := doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": refunded.ID}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	w := doJSON(r, "GET", "/api/v1/tax_report?from="+today+"&to="+today, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Rows []struct {
+			Jurisdiction  string `json:"jurisdiction"`
+			Count         int64  `json:"count"`
+			TaxableAmount int64  `json:"taxable_amount"`
+			TaxAmount     int64  `json:"tax_amount"`
+		} `json:"rows"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	totals := map[string][3]int64{}
+	for _, row := range report.Rows {
+		totals[row.Jurisdiction] = [3]int64{row.Count, row.TaxableAmount, row.TaxAmount}
+	}
+	if totals["San Francisco"] != [3]int64{2, 3000, 86 + 173} || totals["California"] != [3]int64{2, 0, 0} {
+		t.Fatalf("unexpected report %s", w.Body.String())
+	}
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	w = doJSON(r, "GET", "/api/v1/tax_report?from="+yesterday+"&to="+yesterday, nil)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if len(report.Rows) != 0 {
+		t.Fatalf("expected an empty report for yesterday, got %s", w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/tax_report?from="+today+"&to="+yesterday, nil)
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Param != "to" {
+		t.Fatalf("expected an invalid period, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/tax_report/csv?from="+today+"&to="+today, nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected a CSV download, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "tax_report_") {
+		t.Fatalf("expected an attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %v", err)
+	}
+	if len(records) != 5 || records[0][0] != "date" || records[4][1] != "refund" || records[4][18] != "-73" {
+		t.Fatalf("unexpected CSV %v", records)
+	}
+}
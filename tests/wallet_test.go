@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/ledger"
+)
+
+type walletResp struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"`
+}
+
+type walletTxnResp struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Amount             int64  `json:"amount"`
+	BalanceAfter       int64  `json:"balance_after"`
+	Source             string `json:"source"`
+	BalanceTransaction string `json:"balance_transaction"`
+}
+
+// walletFor creates a customer on kycTier with a usd wallet.
+func walletFor(t *testing.T, r *gin.Engine, customer, kycTier string) string {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/customers", map[string]interface{}{"id": customer, "kyc_tier": kycTier})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/wallets", map[string]interface{}{"customer": customer, "currency": "usd"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var wallet walletResp
+	json.Unmarshal(w.Body.Bytes(), &wallet)
+	return wallet.ID
+}
+
+func topUp(t *testing.T, r *gin.Engine, walletID string, amount int64) {
+	t.Helper()
+	w := doJSON(r, "POST", "/api/v1/wallets/"+walletID+"/top_up", map[string]interface{}{"amount": amount})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// walletBalance checks that the wallet's balance matches its ledger account
+// and returns it.
+func walletBalance(t *testing.T, r *gin.Engine, walletID string) int64 {
+	t.Helper()
+	w := doJSON(r, "GET", "/api/v1/wallets/"+walletID, nil)
+	var wallet walletResp
+	json.Unmarshal(w.Body.Bytes(), &wallet)
+	booked, _ := ledger.Balance(config.DB, ledger.WalletAccount(walletID), "usd")
+	if booked != wallet.Balance {
+		t.Fatalf("wallet %s shows %d but its ledger account holds %d", walletID, wallet.Balance, booked)
+	}
+	return wallet.Balance
+}
+
+func TestWalletTopUpAndPay(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	wallet := walletFor(t, r, "cus_alice", "basic")
+
+	w := doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/top_up", map[string]interface{}{"amount": 5000})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var charge struct {
+		ID     string `json:"id"`
+		Fee    int64  `json:"fee"`
+		Wallet string `json:"wallet"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &charge)
+	if charge.Wallet != wallet || charge.Fee != 0 {
+		t.Fatalf("expected a fee-free top-up charge, got %s", w.Body.String())
+	}
+	if got := walletBalance(t, r, wallet); got != 5000 {
+		t.Fatalf("expected 5000 after the top-up, got %d", got)
+	}
+	if bts := listBalanceTransactions(t, r, "?source="+charge.ID); len(bts) != 0 {
+		t.Fatalf("expected the top-up not to pay the merchant, got %+v", bts)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/pay", map[string]interface{}{"amount": 2000, "description": "Order 42"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var payment walletTxnResp
+	json.Unmarshal(w.Body.Bytes(), &payment)
+	if payment.Type != "payment" || payment.Amount != -2000 || payment.BalanceAfter != 3000 {
+		t.Fatalf("unexpected payment %s", w.Body.String())
+	}
+	bts := listBalanceTransactions(t, r, "?type=wallet_payment")
+	if len(bts) != 1 || bts[0].ID != payment.BalanceTransaction || bts[0].Amount != 2000 || bts[0].Fee != 0 {
+		t.Fatalf("expected the merchant to be credited, got %+v", bts)
+	}
+	if got := walletBalance(t, r, wallet); got != 3000 {
+		t.Fatalf("expected 3000 after paying, got %d", got)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/pay", map[string]interface{}{"amount": 3001})
+	if e := decodeError(t, w); w.Code != http.StatusPaymentRequired || e.Error.Code != "balance_insufficient" {
+		t.Fatalf("expected an overdraft to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doJSON(r, "POST", "/api/v1/refunds", map[string]interface{}{"transaction_id": charge.ID})
+	if e := decodeError(t, w); w.Code != http.StatusConflict || e.Error.Code != "charge_not_refundable" {
+		t.Fatalf("expected top-ups not to be refundable, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "GET", "/api/v1/wallets/"+wallet+"/transactions", nil)
+	var list struct {
+		Data []walletTxnResp `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 2 {
+		t.Fatalf("expected the top-up and the payment, got %s", w.Body.String())
+	}
+}
+
+func TestWalletConcurrentTransfers(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	alice := walletFor(t, r, "cus_alice", "verified")
+	bob := walletFor(t, r, "cus_bob", "verified")
+	topUp(t, r, alice, 1000)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sent, refused := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doJSON(r, "POST", "/api/v1/wallet_transfers", map[string]interface{}{
+				"from_wallet": alice, "to_customer": "cus_bob", "amount": 300,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch w.Code {
+			case http.StatusCreated:
+				sent++
+			case http.StatusPaymentRequired:
+				refused++
+			default:
+				t.Errorf("unexpected status %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if sent != 3 || refused != 7 {
+		t.Fatalf("expected exactly 3 transfers, got %d (%d refused)", sent, refused)
+	}
+	if got := walletBalance(t, r, alice); got != 100 {
+		t.Fatalf("expected 100 left, got %d", got)
+	}
+	if got := walletBalance(t, r, bob); got != 900 {
+		t.Fatalf("expected 900 received, got %d", got)
+	}
+}
+
+func TestWalletKYCLimits(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestRouter()
+	anon := walletFor(t, r, "cus_anon", "none")
+	rich := walletFor(t, r, "cus_rich", "verified")
+	topUp(t, r, rich, 50_000)
+
+	w := doJSON(r, "POST", "/api/v1/wallets/"+anon+"/top_up", map[string]interface{}{"amount": 20_000})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Code != "wallet_limit_exceeded" {
+		t.Fatalf("expected the per-transaction limit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// An unverified wallet can hold at most 250.00.
+	w = doJSON(r, "POST", "/api/v1/wallet_transfers", map[string]interface{}{"from_wallet": rich, "to_wallet": anon, "amount": 30_000})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Code != "wallet_limit_exceeded" {
+		t.Fatalf("expected the receiving balance limit, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := walletBalance(t, r, rich); got != 50_000 {
+		t.Fatalf("expected the refused transfer to leave the sender untouched, got %d", got)
+	}
+
+	topUp(t, r, anon, 10_000)
+	w = doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency": "usd", "country": "US", "account_holder_name": "Anon",
+		"routing_number": "110000000", "account_number": "000123456789", "customer": "cus_anon",
+	})
+	var ba struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &ba)
+	w = doJSON(r, "POST", "/api/v1/wallets/"+anon+"/withdraw", map[string]interface{}{"amount": 1000, "bank_account": ba.ID})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Code != "wallet_limit_exceeded" {
+		t.Fatalf("expected unverified customers not to withdraw, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := walletBalance(t, r, anon); got != 10_000 {
+		t.Fatalf("expected the refused withdrawal to leave the wallet untouched, got %d", got)
+	}
+}
+
+func TestWalletWithdrawalReturnedOnFailure(t *testing.T) {
+	setupTestDB(t)
+	r := setupTestHelpersRouter(t)
+	wallet := walletFor(t, r, "cus_alice", "basic")
+	topUp(t, r, wallet, 5000)
+
+	w := doJSON(r, "POST", "/api/v1/bank_accounts", map[string]interface{}{
+		"currency": "usd", "country": "US", "account_holder_name": "Alice",
+		"routing_number": "110000000", "account_number": "000123456789", "customer": "cus_alice",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var ba struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &ba)
+
+	w = doJSON(r, "POST", "/api/v1/payouts", map[string]interface{}{"amount": 100, "currency": "usd", "bank_account": ba.ID})
+	if e := decodeError(t, w); w.Code != http.StatusBadRequest || e.Error.Param != "bank_account" {
+		t.Fatalf("expected merchant payouts to refuse a customer's account, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, "POST", "/api/v1/wallets/"+wallet+"/withdraw", map[string]interface{}{"amount": 1500, "bank_account": ba.ID})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var payout struct {
+		ID     string `json:"id"`
+		Wallet string `json:"wallet"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &payout)
+	if payout.Wallet != wallet {
+		t.Fatalf("expected the payout to name the wallet, got %s", w.Body.String())
+	}
+	if got := walletBalance(t, r, wallet); got != 3500 {
+		t.Fatalf("expected 3500 after withdrawing, got %d", got)
+	}
+
+	w = doJSON(r, "POST", "/api/v1/test_helpers/payouts/"+payout.ID+"/fail", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := walletBalance(t, r, wallet); got != 5000 {
+		t.Fatalf("expected the failed withdrawal back in the wallet, got %d", got)
+	}
+	if bts := listBalanceTransactions(t, r, "?source="+payout.ID); len(bts) != 0 {
+		t.Fatalf("expected the merchant balance not to be touched, got %+v", bts)
+	}
+	if transit, _ := ledger.Balance(config.DB, ledger.AccountPayoutsInTransit, "usd"); transit != 0 {
+		t.Fatalf("expected nothing left in transit, got %d", transit)
+	}
+}
@@ -0,0 +1,139 @@
+// Package transfers splits a platform's charges among its connected
+// accounts. Each connected account is sent its share of a succeeded charge
+// by a transfer, and refunds of the charge take a proportional share of
+// every transfer back.
+package transfers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/events"
+	"github.com/vaidikcode/minipay/fx"
+	"github.com/vaidikcode/minipay/models"
+)
+
+// Split sends Amount of a charge, in the charge's currency, to the connected
+// account Destination.
+type Split = models.TransferSplit
+
+var (
+	ErrSplitsExceedAmount   = errors.New("transfers: splits exceed the charge amount")
+	ErrApplicationFeeAmount = errors.New("transfers: application fee leaves nothing to transfer")
+)
+
+// Resolve works out the splits of a charge of amount. A single split without
+// an Amount is sent what is left after applicationFee; otherwise the
+// platform keeps what the splits leave, and applicationFee must be zero. It
+// returns the splits with their amounts and what the platform keeps.
+func Resolve(amount, applicationFee int64, splits []Split) ([]Split, int64, error) {
+	if len(splits) == 1 && splits[0].Amount == 0 {
+		if applicationFee >= amount {
+			return nil, 0, ErrApplicationFeeAmount
+		}
+		return []Split{{Destination: splits[0].Destination, Amount: amount - applicationFee}}, applicationFee, nil
+	}
+	if applicationFee != 0 {
+		return nil, 0, ErrApplicationFeeAmount
+	}
+	var total int64
+	for _, s := range splits {
+		total += s.Amount
+	}
+	if total > amount {
+		return nil, 0, ErrSplitsExceedAmount
+	}
+	return splits, amount - total, nil
+}
+
+func Payload(t models.Transfer) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              t.ID,
+		"charge":          t.TransactionID,
+		"platform":        t.PlatformID,
+		"destination":     t.DestinationID,
+		"amount":          t.Amount,
+		"currency":        t.Currency,
+		"amount_reversed": t.AmountReversed,
+	}
+}
+
+// Create sends each connected account its split of the succeeded charge
+// txn. A converted charge's splits are sent in its settlement currency. The
+// funds follow the charge's and become available on availableOn.
+func Create(tx *gorm.DB, txn *models.Transaction, splits []Split, availableOn time.Time) ([]models.Transfer, error) {
+	created := make([]models.Transfer, 0, len(splits))
+	for _, s := range splits {
+		if s.Amount <= 0 {
+			continue
+		}
+		t := models.Transfer{
+			ID:            "tr_" + uuid.NewString(),
+			TransactionID: txn.ID,
+			PlatformID:    txn.MerchantID,
+			DestinationID: s.Destination,
+			Amount:        fx.Settled(*txn, s.Amount),
+			Currency:      fx.SettlementCurrency(*txn),
+		}
+		if err := tx.Create(&t).Error; err != nil {
+			return nil, err
+		}
+		if _, err := balance.RecordTransfer(tx, &t, availableOn); err != nil {
+			return nil, err
+		}
+		if err := events.Enqueue(tx, t.ID, "transfer.created", Payload(t)); err != nil {
+			return nil, err
+		}
+		created = append(created, t)
+	}
+	return created, nil
+}
+
+// Reverse takes back from every transfer of txn the share refund makes of
+// the charge. A refund of the whole charge reverses whatever is left.
+func Reverse(tx *gorm.DB, txn *models.Transaction, refund *models.Refund) error {
+	var list []models.Transfer
+	if err := tx.Where("transaction_id = ?", txn.ID).Order("created_at").Find(&list).Error; err != nil {
+		return err
+	}
+	for i := range list {
+		t := &list[i]
+		remaining := t.Amount - t.AmountReversed
+		amount := t.Amount * refund.Amount / txn.Amount
+		if refund.Amount >= txn.Amount || amount > remaining {
+			amount = remaining
+		}
+		if amount <= 0 {
+			continue
+		}
+
+		err := tx.Model(&models.Transfer{}).Where("id = ?", t.ID).
+			Update("amount_reversed", gorm.Expr("amount_reversed + ?", amount)).Error
+		if err != nil {
+			return err
+		}
+		t.AmountReversed += amount
+
+		r := &models.TransferReversal{
+			ID:         "trr_" + uuid.NewString(),
+			TransferID: t.ID,
+			RefundID:   refund.ID,
+			Amount:     amount,
+			Currency:   t.Currency,
+		}
+		if err := tx.Create(r).Error; err != nil {
+			return err
+		}
+		if _, err := balance.RecordTransferReversal(tx, t, r); err != nil {
+			return err
+		}
+		if err := events.Enqueue(tx, t.ID, "transfer.reversed", Payload(*t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
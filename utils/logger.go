@@ -8,6 +8,7 @@ import (
 
 type metricCollector struct {
 	totalCharges    int64
+	failedCharges   int64
 	totalRefunds    int64
 	pendingWebhooks int64
 	webhookRetries  int64
@@ -21,6 +22,10 @@ func (m *metricCollector) IncCharges() {
 	atomic.AddInt64(&m.totalCharges, 1)
 }
 
+func (m *metricCollector) IncFailedCharges() {
+	atomic.AddInt64(&m.failedCharges, 1)
+}
+
 func (m *metricCollector) IncRefunds() {
 	atomic.AddInt64(&m.totalRefunds, 1)
 }
@@ -29,6 +34,10 @@ func (m *metricCollector) TotalCharges() int64 {
 	return atomic.LoadInt64(&m.totalCharges)
 }
 
+func (m *metricCollector) FailedCharges() int64 {
+	return atomic.LoadInt64(&m.failedCharges)
+}
+
 func (m *metricCollector) TotalRefunds() int64 {
 	return atomic.LoadInt64(&m.totalRefunds)
 }
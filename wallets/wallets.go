@@ -0,0 +1,293 @@
+// Package wallets keeps customers' stored value. Every movement changes a
+// wallet's balance and posts a ledger journal to its account in the same
+// database transaction. The balance only changes through a conditional
+// update, so concurrent debits can never take a wallet below zero.
+package wallets
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/vaidikcode/minipay/balance"
+	"github.com/vaidikcode/minipay/ledger"
+	"github.com/vaidikcode/minipay/models"
+)
+
+const (
+	TypeTopUp              = "top_up"
+	TypePayment            = "payment"
+	TypeTransferIn         = "transfer_in"
+	TypeTransferOut        = "transfer_out"
+	TypeWithdrawal         = "withdrawal"
+	TypeWithdrawalReversal = "withdrawal_reversal"
+)
+
+// KYC tiers, from an unverified customer to one whose identity and address
+// have been checked.
+const (
+	TierNone     = "none"
+	TierBasic    = "basic"
+	TierVerified = "verified"
+)
+
+// Limit caps a customer's wallets. Amounts are in minor units of the
+// wallet's currency. DailyOutflow covers payments, transfers out and
+// withdrawals over the last 24 hours.
+type Limit struct {
+	MaxBalance     int64
+	MaxTransaction int64
+	DailyOutflow   int64
+	Withdrawals    bool
+}
+
+// Limits is keyed by KYC tier.
+var Limits = map[string]Limit{
+	TierNone:     {MaxBalance: 25_000, MaxTransaction: 10_000, DailyOutflow: 25_000},
+	TierBasic:    {MaxBalance: 200_000, MaxTransaction: 100_000, DailyOutflow: 200_000, Withdrawals: true},
+	TierVerified: {MaxBalance: 5_000_000, MaxTransaction: 1_000_000, DailyOutflow: 2_500_000, Withdrawals: true},
+}
+
+func ValidTier(tier string) bool {
+	_, ok := Limits[tier]
+	return ok
+}
+
+var (
+	ErrInsufficientFunds = errors.New("wallets: insufficient wallet balance")
+	ErrCurrencyMismatch  = errors.New("wallets: wallets are in different currencies")
+	ErrSameWallet        = errors.New("wallets: cannot transfer to the same wallet")
+)
+
+// LimitError is a movement refused by the KYC limits of the wallet's
+// customer.
+type LimitError struct {
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return "wallets: " + e.Message
+}
+
+// LimitFor is the limit of the KYC tier of the customer with id customerID.
+func LimitFor(db *gorm.DB, customerID string) (Limit, error) {
+	var c models.Customer
+	err := db.Select("kyc_tier").Where("id = ?", customerID).Limit(1).Find(&c).Error
+	if err != nil {
+		return Limit{}, err
+	}
+	if l, ok := Limits[c.KYCTier]; ok {
+		return l, nil
+	}
+	return Limits[TierNone], nil
+}
+
+// CheckTopUp reports whether topping w up by amount fits its customer's
+// limits. It is checked before the card is charged, so that a top-up never
+// has to be turned down after the money was taken.
+func CheckTopUp(db *gorm.DB, w *models.Wallet, amount int64) error {
+	limit, err := LimitFor(db, w.CustomerID)
+	if err != nil {
+		return err
+	}
+	if amount > limit.MaxTransaction {
+		return &LimitError{"amount exceeds the per-transaction limit of " + strconv.FormatInt(limit.MaxTransaction, 10)}
+	}
+	if w.Balance+amount > limit.MaxBalance {
+		return &LimitError{"the wallet balance would exceed its limit of " + strconv.FormatInt(limit.MaxBalance, 10)}
+	}
+	return nil
+}
+
+// TopUp credits w with the succeeded charge txn, which the processor owes.
+func TopUp(tx *gorm.DB, w *models.Wallet, txn *models.Transaction) (*models.WalletTransaction, error) {
+	if err := move(tx, w, txn.Amount, 0); err != nil {
+		return nil, err
+	}
+	description := "Top-up " + txn.ID
+	_, err := ledger.Transfer(tx, "charge", txn.ID, description,
+		ledger.AccountProcessorClearing, ledger.WalletAccount(w.ID), w.Currency, txn.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return entry(tx, w, TypeTopUp, txn.Amount, "charge", txn.ID, description)
+}
+
+// Pay moves amount from w to the merchant with id merchantID, whose balance
+// is credited at once.
+func Pay(tx *gorm.DB, w *models.Wallet, merchantID string, amount int64, description string) (*models.WalletTransaction, *models.BalanceTransaction, error) {
+	if _, err := debit(tx, w, amount); err != nil {
+		return nil, nil, err
+	}
+	if description == "" {
+		description = "Payment to " + merchantID
+	}
+	wt, err := entry(tx, w, TypePayment, -amount, "merchant", merchantID, description)
+	if err != nil {
+		return nil, nil, err
+	}
+	bt, err := balance.RecordWalletPayment(tx, merchantID, wt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wt, bt, nil
+}
+
+// Transfer moves amount from one customer's wallet to another's. Both sides
+// move in one journal, or neither does.
+func Transfer(tx *gorm.DB, from, to *models.Wallet, amount int64, description string, metadata models.Metadata) (*models.WalletTransfer, error) {
+	if from.ID == to.ID {
+		return nil, ErrSameWallet
+	}
+	if from.Currency != to.Currency {
+		return nil, ErrCurrencyMismatch
+	}
+	if _, err := debit(tx, from, amount); err != nil {
+		return nil, err
+	}
+	toLimit, err := LimitFor(tx, to.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := move(tx, to, amount, toLimit.MaxBalance); err != nil {
+		return nil, err
+	}
+
+	t := &models.WalletTransfer{
+		ID:           "wtr_" + uuid.NewString(),
+		FromWalletID: from.ID,
+		ToWalletID:   to.ID,
+		Amount:       amount,
+		Currency:     from.Currency,
+		Description:  description,
+		Metadata:     metadata,
+	}
+	if err := tx.Create(t).Error; err != nil {
+		return nil, err
+	}
+	_, err = ledger.Transfer(tx, "wallet_transfer", t.ID, "wallet transfer",
+		ledger.WalletAccount(from.ID), ledger.WalletAccount(to.ID), t.Currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := entry(tx, from, TypeTransferOut, -amount, "wallet_transfer", t.ID, "Transfer to "+to.ID); err != nil {
+		return nil, err
+	}
+	if _, err := entry(tx, to, TypeTransferIn, amount, "wallet_transfer", t.ID, "Transfer from "+from.ID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Withdraw takes the amount of payout p out of w into payouts in transit.
+// Customers whose KYC tier does not allow withdrawals are refused.
+func Withdraw(tx *gorm.DB, w *models.Wallet, p *models.Payout) (*models.WalletTransaction, error) {
+	limit, err := debit(tx, w, p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if !limit.Withdrawals {
+		return nil, &LimitError{"withdrawals need KYC tier basic or above"}
+	}
+	description := "Withdrawal " + p.ID
+	_, err = ledger.Transfer(tx, "payout", p.ID, description,
+		ledger.WalletAccount(w.ID), ledger.AccountPayoutsInTransit, w.Currency, p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return entry(tx, w, TypeWithdrawal, -p.Amount, "payout", p.ID, description)
+}
+
+// ReverseWithdrawal returns the amount of a canceled or failed payout to the
+// wallet it was withdrawn from. The wallet's balance limit does not apply:
+// the money was the customer's already.
+func ReverseWithdrawal(tx *gorm.DB, p *models.Payout) (*models.WalletTransaction, error) {
+	var w models.Wallet
+	if err := tx.First(&w, "id = ?", p.WalletID).Error; err != nil {
+		return nil, err
+	}
+	if err := move(tx, &w, p.Amount, 0); err != nil {
+		return nil, err
+	}
+	description := "Withdrawal " + p.ID + " returned"
+	_, err := ledger.Transfer(tx, "payout", p.ID, description,
+		ledger.AccountPayoutsInTransit, ledger.WalletAccount(w.ID), w.Currency, p.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return entry(tx, &w, TypeWithdrawalReversal, p.Amount, "payout", p.ID, description)
+}
+
+// debit takes amount out of w within its customer's limits, which it
+// returns. The balance is taken first, so that the database holds the write
+// lock while the limits are read and concurrent debits are checked one after
+// another; the caller's transaction rolls the debit back if they are broken.
+func debit(tx *gorm.DB, w *models.Wallet, amount int64) (Limit, error) {
+	if err := move(tx, w, -amount, 0); err != nil {
+		return Limit{}, err
+	}
+	limit, err := LimitFor(tx, w.CustomerID)
+	if err != nil {
+		return limit, err
+	}
+	if amount > limit.MaxTransaction {
+		return limit, &LimitError{"amount exceeds the per-transaction limit of " + strconv.FormatInt(limit.MaxTransaction, 10)}
+	}
+
+	var outflow int64
+	err = tx.Model(&models.WalletTransaction{}).
+		Where("wallet_id = ? AND type IN ? AND created_at > ?", w.ID,
+			[]string{TypePayment, TypeTransferOut, TypeWithdrawal}, time.Now().Add(-24*time.Hour)).
+		Select("COALESCE(-SUM(amount), 0)").
+		Scan(&outflow).Error
+	if err != nil {
+		return limit, err
+	}
+	if outflow+amount > limit.DailyOutflow {
+		return limit, &LimitError{"amount exceeds the daily limit of " + strconv.FormatInt(limit.DailyOutflow, 10)}
+	}
+	return limit, nil
+}
+
+// move adds amount to w's balance, which is negative for a debit. It fails
+// rather than take the balance below zero or, with maxBalance set, above
+// maxBalance.
+func move(tx *gorm.DB, w *models.Wallet, amount, maxBalance int64) error {
+	query := tx.Model(&models.Wallet{}).Where("id = ?", w.ID)
+	if amount < 0 {
+		query = query.Where("balance >= ?", -amount)
+	}
+	if maxBalance > 0 {
+		query = query.Where("balance + ? <= ?", amount, maxBalance)
+	}
+	res := query.Update("balance", gorm.Expr("balance + ?", amount))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		if amount < 0 {
+			return ErrInsufficientFunds
+		}
+		return &LimitError{"the wallet balance would exceed its limit of " + strconv.FormatInt(maxBalance, 10)}
+	}
+	return tx.Select("balance").First(w, "id = ?", w.ID).Error
+}
+
+// entry records a movement of w, whose balance has already been updated.
+func entry(tx *gorm.DB, w *models.Wallet, typ string, amount int64, sourceType, sourceID, description string) (*models.WalletTransaction, error) {
+	wt := &models.WalletTransaction{
+		ID:           "wtxn_" + uuid.NewString(),
+		WalletID:     w.ID,
+		Type:         typ,
+		Amount:       amount,
+		Currency:     w.Currency,
+		BalanceAfter: w.Balance,
+		SourceType:   sourceType,
+		SourceID:     sourceID,
+		Description:  description,
+	}
+	return wt, tx.Create(wt).Error
+}
@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/payments"
+)
+
+func StartAuthenticationWorker(pollInterval time.Duration) {
+	for {
+		if n, err := payments.ExpireChallenges(config.DB, time.Now()); err != nil {
+			log.Printf("authentication worker: %v", err)
+		} else if n > 0 {
+			log.Printf("authentication worker: %d expired challenges canceled", n)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/billing"
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/invoices"
+)
+
+func StartBillingWorker(pollInterval time.Duration) {
+	for {
+		if n, err := billing.Renew(config.DB, time.Now()); err != nil {
+			log.Printf("billing worker: %v", err)
+		} else if n > 0 {
+			log.Printf("billing worker: %d subscriptions billed", n)
+		}
+		if n, err := invoices.MarkOverdue(config.DB, time.Now()); err != nil {
+			log.Printf("billing worker: %v", err)
+		} else if n > 0 {
+			log.Printf("billing worker: %d invoices overdue", n)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/disputes"
+)
+
+func StartDisputeWorker(pollInterval time.Duration) {
+	for {
+		if n, err := disputes.ExpireOverdue(config.DB, time.Now()); err != nil {
+			log.Printf("dispute worker: %v", err)
+		} else if n > 0 {
+			log.Printf("dispute worker: %d overdue disputes lost", n)
+		}
+		time.Sleep(pollInterval)
+	}
+}
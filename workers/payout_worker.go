@@ -0,0 +1,24 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/payouts"
+)
+
+func StartPayoutWorker(pollInterval time.Duration) {
+	for {
+		now := time.Now()
+		if created, err := payouts.RunSchedule(config.DB, now); err != nil {
+			log.Printf("payout worker: %v", err)
+		} else if len(created) > 0 {
+			log.Printf("payout worker: %d automatic payouts created", len(created))
+		}
+		if err := payouts.Process(config.DB, now); err != nil {
+			log.Printf("payout worker: %v", err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
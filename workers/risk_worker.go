@@ -0,0 +1,26 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/reviews"
+	"github.com/vaidikcode/minipay/risk"
+)
+
+func StartRiskWorker(pollInterval time.Duration) {
+	for {
+		if n, err := reviews.ExpireOverdue(config.DB, time.Now()); err != nil {
+			log.Printf("risk worker: %v", err)
+		} else if n > 0 {
+			log.Printf("risk worker: %d expired reviews closed", n)
+		}
+		if n, err := risk.PruneVelocity(config.DB, time.Now()); err != nil {
+			log.Printf("risk worker: %v", err)
+		} else if n > 0 {
+			log.Printf("risk worker: %d velocity events pruned", n)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,20 @@
+package workers
+
+import (
+	"log"
+	"time"
+
+	"github.com/vaidikcode/minipay/config"
+	"github.com/vaidikcode/minipay/reserves"
+)
+
+func StartSettlementWorker(pollInterval time.Duration) {
+	for {
+		if n, err := reserves.Settle(config.DB, time.Now()); err != nil {
+			log.Printf("settlement worker: %v", err)
+		} else if n > 0 {
+			log.Printf("settlement worker: %d balance transactions available", n)
+		}
+		time.Sleep(pollInterval)
+	}
+}